@@ -5,6 +5,7 @@ package nvr
 import (
 	"context"
 	stdLog "log"
+	"nvr/pkg/metrics"
 	"nvr/pkg/monitor"
 	"nvr/pkg/storage"
 	"nvr/pkg/web"
@@ -25,7 +26,11 @@ type hookList struct {
 	monitorRecSave      []monitor.RecSaveHook
 	monitorRecSaved     []monitor.RecSavedHook
 	migrationMonitor    []monitor.MigationHook
+	monitorCrashLoop    []monitor.CrashLoopHook
+	monitorInputKeys    []string
+	monitorFields       []monitor.Field
 	logSource           []string
+	metricsCollector    []metrics.Collector
 }
 
 var hooks = &hookList{}
@@ -90,11 +95,34 @@ func RegisterMigrationMonitorHook(h monitor.MigationHook) {
 	hooks.migrationMonitor = append(hooks.migrationMonitor, h)
 }
 
+// RegisterMonitorCrashLoopHook registers hook that's called
+// when a monitor process starts crash-looping.
+func RegisterMonitorCrashLoopHook(h monitor.CrashLoopHook) {
+	hooks.monitorCrashLoop = append(hooks.monitorCrashLoop, h)
+}
+
+// RegisterMonitorInputKeys registers addon config keys that are read
+// when the input processes start. The input processes are
+// restarted when one of these keys are changed.
+func RegisterMonitorInputKeys(keys []string) {
+	hooks.monitorInputKeys = append(hooks.monitorInputKeys, keys...)
+}
+
+// RegisterMonitorFields adds fields to the monitor config schema.
+func RegisterMonitorFields(fields []monitor.Field) {
+	hooks.monitorFields = append(hooks.monitorFields, fields...)
+}
+
 // RegisterLogSource adds log source.
 func RegisterLogSource(s []string) {
 	hooks.logSource = append(hooks.logSource, s...)
 }
 
+// RegisterMetricsCollector adds collector to the metrics endpoint.
+func RegisterMetricsCollector(c metrics.Collector) {
+	hooks.metricsCollector = append(hooks.metricsCollector, c)
+}
+
 func (h *hookList) appRun(ctx context.Context, app *App) error {
 	for _, hook := range h.onAppRun {
 		if err := hook(ctx, app); err != nil {
@@ -163,6 +191,11 @@ func (h *hookList) monitor() *monitor.Hooks {
 		}
 		return nil
 	}
+	crashLoopHook := func(c monitor.CrashLoop) {
+		for _, hook := range h.monitorCrashLoop {
+			hook(c)
+		}
+	}
 
 	return &monitor.Hooks{
 		Start:      startHook,
@@ -171,5 +204,8 @@ func (h *hookList) monitor() *monitor.Hooks {
 		RecSave:    recSaveHook,
 		RecSaved:   recSavedHook,
 		Migrate:    migrateHook,
+		CrashLoop:  crashLoopHook,
+		InputKeys:  h.monitorInputKeys,
+		Fields:     h.monitorFields,
 	}
 }
@@ -16,8 +16,13 @@ import (
 // Hook Alert hook.
 type Hook func(*monitor.Recorder, *storage.Event, []byte)
 
+// CrashLoopHook is called when a process of a
+// monitor with alerts enabled starts crash-looping.
+type CrashLoopHook func(monitor.CrashLoop)
+
 var addon struct {
-	hooks []Hook
+	hooks          []Hook
+	crashLoopHooks []CrashLoopHook
 }
 
 // RegisterAlertHook registers hook that's called on alerts.
@@ -25,12 +30,25 @@ func RegisterAlertHook(hook Hook) {
 	addon.hooks = append(addon.hooks, hook)
 }
 
+// RegisterCrashLoopHook registers hook that's called on crash-loop alerts.
+func RegisterCrashLoopHook(hook CrashLoopHook) {
+	addon.crashLoopHooks = append(addon.crashLoopHooks, hook)
+}
+
 func init() {
 	RegisterAlertHook(logAlert)
+	RegisterCrashLoopHook(logCrashLoop)
 	a := newAlerter(addon.hooks)
+	a.crashLoopHooks = addon.crashLoopHooks
 
 	nvr.RegisterLogSource([]string{"alert"})
 	nvr.RegisterMonitorEventHook(a.onEvent)
+	nvr.RegisterMonitorCrashLoopHook(a.onCrashLoop)
+	nvr.RegisterMonitorFields([]monitor.Field{{
+		Key:      "alert",
+		Type:     monitor.FieldJSON,
+		Validate: validateConfig,
+	}})
 }
 
 func newAlerter(alertHooks []Hook) *alerter {
@@ -41,8 +59,9 @@ func newAlerter(alertHooks []Hook) *alerter {
 }
 
 type alerter struct {
-	alertHooks []Hook
-	prevAlerts map[string]time.Time // map[monitorID]prevAlert.
+	alertHooks     []Hook
+	crashLoopHooks []CrashLoopHook
+	prevAlerts     map[string]time.Time // map[monitorID]prevAlert.
 }
 
 func (a *alerter) onEvent(r *monitor.Recorder, event *storage.Event) {
@@ -112,6 +131,42 @@ func (a *alerter) processEvent(
 	return nil
 }
 
+// onCrashLoop alerts without a cooldown, the crash loop
+// hook is only called once per streak of failures.
+func (a *alerter) onCrashLoop(c monitor.CrashLoop) {
+	err := a.processCrashLoop(c, c.Config.Get("alert"))
+	if err != nil {
+		c.Logger.Log(log.Entry{
+			Level:     log.LevelError,
+			Src:       "alert",
+			MonitorID: c.MonitorID,
+			Msg:       err.Error(),
+		})
+	}
+}
+
+func (a *alerter) processCrashLoop(c monitor.CrashLoop, rawConfig string) error {
+	if rawConfig == "" {
+		return nil
+	}
+
+	var config Config
+	err := json.Unmarshal([]byte(rawConfig), &config)
+	if err != nil {
+		return fmt.Errorf("could not unmarshal config: %w", err)
+	}
+	config.fillMissing()
+
+	if config.Enable != "true" {
+		return nil
+	}
+
+	for _, hook := range a.crashLoopHooks {
+		hook(c)
+	}
+	return nil
+}
+
 // Config is a monitor alert config.
 type Config struct {
 	Enable    string `json:"enable"`
@@ -131,6 +186,24 @@ func (c *Config) fillMissing() {
 	}
 }
 
+// validateConfig validates the raw "alert" monitor config value.
+func validateConfig(rawConfig string) error {
+	var config Config
+	err := json.Unmarshal([]byte(rawConfig), &config)
+	if err != nil {
+		return fmt.Errorf("unmarshal config: %w", err)
+	}
+	config.fillMissing()
+
+	if _, err := strconv.ParseFloat(config.Threshold, 64); err != nil {
+		return fmt.Errorf("parse threshold: %w", err)
+	}
+	if _, err := strconv.ParseFloat(config.Cooldown, 64); err != nil {
+		return fmt.Errorf("parse cooldown: %w", err)
+	}
+	return nil
+}
+
 func bestDetection(e storage.Event) storage.Detection {
 	var best storage.Detection
 	for _, d := range e.Detections {
@@ -151,3 +224,13 @@ func logAlert(r *monitor.Recorder, event *storage.Event, _ []byte) {
 		Msg:       fmt.Sprintf("label:%v score:%v", d.Label, d.Score),
 	})
 }
+
+func logCrashLoop(c monitor.CrashLoop) {
+	c.Logger.Log(log.Entry{
+		Level:     log.LevelError,
+		Src:       "alert",
+		MonitorID: c.MonitorID,
+		Msg: fmt.Sprintf("%v process is crash-looping after %v failures: %v",
+			c.Process, c.Failures, c.Err),
+	})
+}
@@ -162,3 +162,42 @@ func TestProcessEvent(t *testing.T) {
 		require.Equal(t, outEvent, event2)
 	})
 }
+
+func TestProcessCrashLoop(t *testing.T) {
+	cases := map[string]struct {
+		config string
+		alert  bool
+		err    bool
+	}{
+		"ok":           {`{"enable":"true"}`, true, false},
+		"nilConfig":    {"", false, false},
+		"disable":      {`{"enable":"false"}`, false, false},
+		"unmarshalErr": {"{", false, true},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			var alerts []monitor.CrashLoop
+			a := newAlerter(nil)
+			a.crashLoopHooks = []CrashLoopHook{func(c monitor.CrashLoop) {
+				alerts = append(alerts, c)
+			}}
+
+			c := monitor.CrashLoop{MonitorID: "1", Process: "main", Failures: 10}
+			err := a.processCrashLoop(c, tc.config)
+			require.Equal(t, tc.err, err != nil)
+
+			if tc.alert {
+				require.Equal(t, []monitor.CrashLoop{c}, alerts)
+			} else {
+				require.Empty(t, alerts)
+			}
+		})
+	}
+}
+
+func TestValidateConfig(t *testing.T) {
+	require.NoError(t, validateConfig(`{"enable":"true","threshold":"50"}`))
+	require.Error(t, validateConfig(`{"threshold":"abc"}`))
+	require.Error(t, validateConfig(`{"cooldown":"abc"}`))
+	require.Error(t, validateConfig(`{`))
+}
@@ -10,10 +10,10 @@ import (
 	"io"
 	"net/http"
 	"nvr"
+	"nvr/pkg/configstore"
 	"nvr/pkg/log"
 	"nvr/pkg/storage"
 	"nvr/pkg/web/auth"
-	"os"
 	"path/filepath"
 	"strings"
 	"sync"
@@ -33,7 +33,8 @@ type Authenticator struct {
 
 	hashCost int
 
-	logger *log.Logger
+	logger  log.ILogger
+	limiter *auth.LoginLimiter
 
 	// hashLock limits concurrent hashing operations
 	// to mitigate denial of service attacks.
@@ -51,18 +52,14 @@ func NewBasicAuthenticator(env storage.ConfigEnv, logger *log.Logger) (auth.Auth
 
 		hashCost: auth.DefaultBcryptHashCost,
 		logger:   logger,
+		limiter:  auth.NewLoginLimiter(logger),
 	}
 
-	file, err := os.ReadFile(path)
+	err := configstore.ReadJSON(path, &a.accounts)
 	if err != nil {
 		return nil, fmt.Errorf("read accounts file: %w", err)
 	}
 
-	err = json.Unmarshal(file, &a.accounts)
-	if err != nil {
-		return nil, fmt.Errorf("unmarshal accounts: %w", err)
-	}
-
 	for id, account := range a.accounts {
 		account.Username = strings.ToLower(account.Username)
 		a.accounts[id] = account
@@ -91,6 +88,11 @@ func (a *Authenticator) ValidateRequest(r *http.Request) auth.ValidateResponse {
 	user, found := a.userByNameUnsafe(name)
 	a.mu.Unlock()
 
+	// Locked out requests are rejected without checking the password.
+	if !a.limiter.Allowed(r, name) {
+		return auth.ValidateResponse{}
+	}
+
 	a.hashLock.Lock()
 	defer a.hashLock.Unlock()
 	if !found || name != user.Username {
@@ -103,6 +105,8 @@ func (a *Authenticator) ValidateRequest(r *http.Request) auth.ValidateResponse {
 		res := auth.ValidateResponse{IsValid: true, User: user}
 		a.authCache[req] = res // Only cache valid requests.
 		a.mu.Unlock()
+		a.limiter.Succeeded(user.Username)
+		auth.LogLogin(a.logger, r, user.Username)
 		return res
 	}
 	return auth.ValidateResponse{}
@@ -170,6 +174,7 @@ func (a *Authenticator) UsersList() map[string]auth.AccountObfuscated {
 			ID:       user.ID,
 			Username: user.Username,
 			IsAdmin:  user.IsAdmin,
+			Access:   user.Access,
 		}
 	}
 	return list
@@ -207,6 +212,7 @@ func (a *Authenticator) UserSet(req auth.SetUserRequest) error {
 	user.ID = req.ID
 	user.Username = req.Username
 	user.IsAdmin = req.IsAdmin
+	user.Access = req.Access
 	if req.PlainPassword != "" {
 		hashedNewPassword, err := bcrypt.GenerateFromPassword([]byte(req.PlainPassword), a.hashCost)
 		if err != nil {
@@ -254,7 +260,7 @@ func (a *Authenticator) saveToFile() error {
 		return fmt.Errorf("marshal accounts: %w", err)
 	}
 
-	err = os.WriteFile(a.path, users, 0o600)
+	err = configstore.WriteFile(a.path, users)
 	if err != nil {
 		return err
 	}
@@ -267,10 +273,7 @@ func (a *Authenticator) User(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		res := a.ValidateRequest(r)
 		if !res.IsValid {
-			if r.Header.Get("Authorization") != "" {
-				username, _ := parseBasicAuth(r.Header.Get("Authorization"))
-				auth.LogFailedLogin(a.logger, r, username)
-			}
+			a.loginFailed(r)
 			w.Header().Set("WWW-Authenticate", `Basic realm=""`)
 			w.WriteHeader(http.StatusUnauthorized)
 			return
@@ -280,15 +283,26 @@ func (a *Authenticator) User(next http.Handler) http.Handler {
 	})
 }
 
+// loginFailed logs and counts failed login attempts.
+func (a *Authenticator) loginFailed(r *http.Request) {
+	if r.Header.Get("Authorization") == "" {
+		return
+	}
+	username, _ := parseBasicAuth(r.Header.Get("Authorization"))
+	auth.LogFailedLogin(a.logger, r, username)
+	a.limiter.Failed(r, username)
+}
+
 // Admin blocks requests from non-admin users.
 func (a *Authenticator) Admin(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		res := a.ValidateRequest(r)
 
 		if !res.IsValid || !res.User.IsAdmin {
-			if r.Header.Get("Authorization") != "" {
-				username, _ := parseBasicAuth(r.Header.Get("Authorization"))
-				auth.LogFailedLogin(a.logger, r, username)
+			if !res.IsValid {
+				a.loginFailed(r)
+			} else {
+				auth.LogFailedLogin(a.logger, r, res.User.Username)
 			}
 
 			w.Header().Set("WWW-Authenticate", `Basic realm="NVR"`)
@@ -7,6 +7,7 @@ import (
 	"encoding/json"
 	"io/fs"
 	"net/http"
+	"net/http/httptest"
 	"os"
 	"testing"
 
@@ -59,7 +60,8 @@ func newTestAuth(t *testing.T) (string, *Authenticator, func()) {
 		authCache: make(map[string]auth.ValidateResponse),
 
 		hashCost: bcrypt.MinCost,
-		logger:   &log.Logger{},
+		logger:   log.NewDummyLogger(),
+		limiter:  auth.NewLoginLimiter(log.NewDummyLogger()),
 	}
 	return tempDir, &auth, cancelFunc
 }
@@ -338,4 +340,26 @@ func TestBasicAuthenticator(t *testing.T) {
 		response2 := a.ValidateRequest(req)
 		require.True(t, response2.IsValid)
 	})
+
+	t.Run("lockout", func(t *testing.T) {
+		_, a, cancel := newTestAuth(t)
+		defer cancel()
+
+		wrongPass := base64.StdEncoding.EncodeToString([]byte("admin:wrong"))
+		handler := a.User(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+		for i := 0; i < auth.DefaultMaxUserFailures; i++ {
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, authHeader("Basic "+wrongPass))
+			require.Equal(t, http.StatusUnauthorized, w.Code)
+		}
+
+		correctPass := base64.StdEncoding.EncodeToString([]byte("admin:pass1"))
+		response := a.ValidateRequest(authHeader("Basic " + correctPass))
+		require.False(t, response.IsValid)
+
+		// Other users aren't affected.
+		userPass := base64.StdEncoding.EncodeToString([]byte("user:pass2"))
+		response = a.ValidateRequest(authHeader("Basic " + userPass))
+		require.True(t, response.IsValid)
+	})
 }
@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"net/http"
 	"nvr"
+	"nvr/pkg/configstore"
 	"nvr/pkg/log"
 	"nvr/pkg/storage"
 	"nvr/pkg/web/auth"
@@ -54,7 +55,7 @@ func NewAuthenticator(env storage.ConfigEnv, logger *log.Logger) (auth.Authentic
 		token: auth.GenToken(),
 	}
 
-	file, err := os.ReadFile(path)
+	err := configstore.ReadJSON(path, &a.accounts)
 	if err != nil {
 		if errors.Is(err, os.ErrNotExist) {
 			a.accounts = make(map[string]auth.Account)
@@ -63,11 +64,6 @@ func NewAuthenticator(env storage.ConfigEnv, logger *log.Logger) (auth.Authentic
 		return nil, err
 	}
 
-	err = json.Unmarshal(file, &a.accounts)
-	if err != nil {
-		return nil, err
-	}
-
 	return &a, nil
 }
 
@@ -100,6 +96,7 @@ func (a *Authenticator) UsersList() map[string]auth.AccountObfuscated {
 			ID:       user.ID,
 			Username: user.Username,
 			IsAdmin:  user.IsAdmin,
+			Access:   user.Access,
 		}
 	}
 	return list
@@ -137,6 +134,7 @@ func (a *Authenticator) UserSet(req auth.SetUserRequest) error {
 	user.ID = req.ID
 	user.Username = req.Username
 	user.IsAdmin = req.IsAdmin
+	user.Access = req.Access
 	if req.PlainPassword != "" {
 		hashedNewPassword, _ := bcrypt.GenerateFromPassword([]byte(req.PlainPassword), a.hashCost)
 		user.Password = hashedNewPassword
@@ -217,7 +215,7 @@ func (a *Authenticator) Logout() http.Handler {
 func (a *Authenticator) SaveUsersToFile() error {
 	users, _ := json.MarshalIndent(a.accounts, "", "  ")
 
-	err := os.WriteFile(a.path, users, 0o600)
+	err := configstore.WriteFile(a.path, users)
 	if err != nil {
 		return err
 	}
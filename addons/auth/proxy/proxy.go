@@ -0,0 +1,406 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+// Package proxy authenticates users by headers set by a trusted
+// reverse proxy, for example Authelia, Authentik or oauth2-proxy.
+package proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"nvr"
+	"nvr/pkg/backup"
+	"nvr/pkg/configstore"
+	"nvr/pkg/log"
+	"nvr/pkg/storage"
+	"nvr/pkg/web/auth"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+func init() {
+	nvr.SetAuthenticator(NewAuthenticator)
+	backup.RegisterConfigFile("proxy.json")
+}
+
+// Config proxy authentication config, stored in "proxy.json".
+type Config struct {
+	// Header containing the username.
+	UserHeader string `json:"userHeader"`
+
+	// Optional header containing comma separated groups.
+	GroupsHeader string `json:"groupsHeader"`
+
+	// Requests are only trusted from these CIDRs.
+	TrustedProxies []string `json:"trustedProxies"`
+
+	// Members of these groups are admins.
+	AdminGroups []string `json:"adminGroups"`
+
+	// Users in this list are always admins.
+	AdminUsers []string `json:"adminUsers"`
+
+	// If set, users must be a member of one of these or the admin groups.
+	UserGroups []string `json:"userGroups"`
+
+	// Redirect to the logout page of the proxy.
+	LogoutURL string `json:"logoutUrl"`
+}
+
+// DefaultConfig is written to "proxy.json" if it doesn't exist.
+var DefaultConfig = Config{
+	UserHeader:     "Remote-User",
+	GroupsHeader:   "Remote-Groups",
+	TrustedProxies: []string{"127.0.0.1/32", "::1/128"},
+	AdminGroups:    []string{"admins"},
+	AdminUsers:     []string{},
+	UserGroups:     []string{},
+	LogoutURL:      "",
+}
+
+// Authenticator implements auth.Authenticator.
+type Authenticator struct {
+	config  Config
+	proxies []*net.IPNet
+
+	path     string // Path to save user information.
+	accounts map[string]auth.Account
+	tokens   map[string]string // CSRF tokens by user ID.
+
+	logger log.ILogger
+	mu     sync.Mutex
+}
+
+// NewAuthenticator creates proxy authenticator.
+func NewAuthenticator(env storage.ConfigEnv, logger *log.Logger) (auth.Authenticator, error) {
+	config, err := readConfig(filepath.Join(env.ConfigDir, "proxy.json"))
+	if err != nil {
+		return nil, err
+	}
+	return newAuthenticator(config, filepath.Join(env.ConfigDir, "users.json"), logger)
+}
+
+func readConfig(path string) (Config, error) {
+	var config Config
+	err := configstore.ReadJSON(path, &config)
+	if errors.Is(err, os.ErrNotExist) {
+		if err := configstore.WriteJSON(path, DefaultConfig); err != nil {
+			return Config{}, fmt.Errorf("write default config: %w", err)
+		}
+		return DefaultConfig, nil
+	}
+	if err != nil {
+		return Config{}, fmt.Errorf("read config: %w", err)
+	}
+	return config, nil
+}
+
+// Errors.
+var (
+	ErrUserHeaderMissing = errors.New("userHeader is required")
+	ErrInvalidCIDR       = errors.New("invalid trusted proxy CIDR")
+)
+
+func newAuthenticator(config Config, usersPath string, logger log.ILogger) (*Authenticator, error) {
+	if config.UserHeader == "" {
+		return nil, ErrUserHeaderMissing
+	}
+	var proxies []*net.IPNet
+	for _, cidr := range config.TrustedProxies {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidCIDR, cidr)
+		}
+		proxies = append(proxies, ipNet)
+	}
+
+	a := &Authenticator{
+		config:   config,
+		proxies:  proxies,
+		path:     usersPath,
+		accounts: make(map[string]auth.Account),
+		tokens:   make(map[string]string),
+		logger:   logger,
+	}
+	err := configstore.ReadJSON(usersPath, &a.accounts)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("read accounts file: %w", err)
+	}
+	for id, account := range a.accounts {
+		account.Username = strings.ToLower(account.Username)
+		a.accounts[id] = account
+	}
+	return a, nil
+}
+
+// trusted returns true if the request comes from a trusted proxy.
+func (a *Authenticator) trusted(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, proxy := range a.proxies {
+		if proxy.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *Authenticator) groups(r *http.Request) []string {
+	if a.config.GroupsHeader == "" {
+		return nil
+	}
+	var groups []string
+	for _, group := range strings.Split(r.Header.Get(a.config.GroupsHeader), ",") {
+		if group = strings.TrimSpace(group); group != "" {
+			groups = append(groups, group)
+		}
+	}
+	return groups
+}
+
+func containsAny(list []string, values []string) bool {
+	for _, v := range values {
+		for _, l := range list {
+			if v == l {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ValidateRequest validates the headers of requests from trusted proxies.
+func (a *Authenticator) ValidateRequest(r *http.Request) auth.ValidateResponse {
+	username := strings.ToLower(strings.TrimSpace(r.Header.Get(a.config.UserHeader)))
+	if username == "" || !a.trusted(r) {
+		return auth.ValidateResponse{}
+	}
+
+	groups := a.groups(r)
+	isAdmin := containsAny(a.config.AdminGroups, groups) ||
+		containsAny(a.config.AdminUsers, []string{username})
+	if !isAdmin && len(a.config.UserGroups) != 0 &&
+		!containsAny(a.config.UserGroups, groups) {
+		return auth.ValidateResponse{}
+	}
+
+	user, err := a.account(username)
+	if err != nil {
+		a.logger.Log(log.Entry{
+			Level: log.LevelError,
+			Src:   "app",
+			Msg:   fmt.Sprintf("proxy auth: %v", err),
+		})
+		return auth.ValidateResponse{}
+	}
+	user.IsAdmin = user.IsAdmin || isAdmin
+	return auth.ValidateResponse{IsValid: true, User: user}
+}
+
+// account returns the account of username and creates
+// it on the first request. Access restrictions are set
+// by admins on the created account.
+func (a *Authenticator) account(username string) (auth.Account, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	user, exists := a.userByNameUnsafe(username)
+	if !exists {
+		user = auth.Account{ID: username, Username: username}
+		a.accounts[user.ID] = user
+		if err := a.saveToFile(); err != nil {
+			delete(a.accounts, user.ID)
+			return auth.Account{}, fmt.Errorf("save users to file: %w", err)
+		}
+	}
+
+	token, exists := a.tokens[user.ID]
+	if !exists {
+		token = auth.GenToken()
+		a.tokens[user.ID] = token
+	}
+	user.Token = token
+	return user, nil
+}
+
+func (a *Authenticator) userByNameUnsafe(name string) (auth.Account, bool) {
+	for _, u := range a.accounts {
+		if u.Username == name {
+			return u, true
+		}
+	}
+	return auth.Account{}, false
+}
+
+// AuthDisabled False.
+func (a *Authenticator) AuthDisabled() bool {
+	return false
+}
+
+// UsersList returns a obfuscated user list.
+func (a *Authenticator) UsersList() map[string]auth.AccountObfuscated {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	list := make(map[string]auth.AccountObfuscated)
+	for id, user := range a.accounts {
+		list[id] = auth.AccountObfuscated{
+			ID:       user.ID,
+			Username: user.Username,
+			IsAdmin:  user.IsAdmin,
+			Access:   user.Access,
+		}
+	}
+	return list
+}
+
+// Errors.
+var (
+	ErrIDMissing       = errors.New("missing ID")
+	ErrUsernameMissing = errors.New("missing username")
+	ErrUserNotExist    = errors.New("user does not exist")
+)
+
+// UserSet set user details. Passwords are ignored, the proxy
+// authenticates the users. IsAdmin makes the user an admin
+// regardless of the groups of the user.
+func (a *Authenticator) UserSet(req auth.SetUserRequest) error {
+	if req.ID == "" {
+		return ErrIDMissing
+	}
+	if req.Username == "" {
+		return ErrUsernameMissing
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	user := a.accounts[req.ID]
+	user.ID = req.ID
+	user.Username = strings.ToLower(req.Username)
+	user.IsAdmin = req.IsAdmin
+	user.Access = req.Access
+	a.accounts[user.ID] = user
+
+	if err := a.saveToFile(); err != nil {
+		return fmt.Errorf("save users to file: %w", err)
+	}
+	return nil
+}
+
+// UserDelete deletes user by id. The account is
+// created again if the user makes another request.
+func (a *Authenticator) UserDelete(id string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, exists := a.accounts[id]; !exists {
+		return ErrUserNotExist
+	}
+	delete(a.accounts, id)
+	delete(a.tokens, id)
+	return a.saveToFile()
+}
+
+func (a *Authenticator) saveToFile() error {
+	users, err := json.MarshalIndent(a.accounts, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal accounts: %w", err)
+	}
+	return configstore.WriteFile(a.path, users)
+}
+
+// unauthorized logs requests that have the user header
+// but were rejected, it may be a misconfigured proxy.
+func (a *Authenticator) unauthorized(w http.ResponseWriter, r *http.Request) {
+	if username := r.Header.Get(a.config.UserHeader); username != "" {
+		target := "untrusted-proxy"
+		if a.trusted(r) {
+			target = "groups"
+		}
+		auth.LogAudit(a.logger, r, auth.Audit{
+			Level:  log.LevelWarning,
+			User:   username,
+			Action: "login-failed",
+			Target: target,
+		})
+	}
+	http.Error(w, "Unauthorized.", http.StatusUnauthorized)
+}
+
+// User blocks unauthorized requests.
+func (a *Authenticator) User(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !a.ValidateRequest(r).IsValid {
+			a.unauthorized(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Admin blocks requests from non-admin users.
+func (a *Authenticator) Admin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		res := a.ValidateRequest(r)
+		if !res.IsValid {
+			a.unauthorized(w, r)
+			return
+		}
+		if !res.User.IsAdmin {
+			auth.LogFailedLogin(a.logger, r, res.User.Username)
+			http.Error(w, "Unauthorized.", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// CSRF blocks invalid Cross-site request forgery tokens.
+// Each user has a unique token. The request needs to
+// have a matching token in the "X-CSRF-TOKEN" header.
+func (a *Authenticator) CSRF(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		res := a.ValidateRequest(r)
+		token := r.Header.Get("X-CSRF-TOKEN")
+		if !res.IsValid || token != res.User.Token {
+			http.Error(w, "Invalid CSRF-token.", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// MyToken return CSRF token for requesting user.
+func (a *Authenticator) MyToken() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := a.ValidateRequest(r).User.Token
+		if token == "" {
+			http.Error(w, "token does not exist", http.StatusInternalServerError)
+			return
+		}
+		if _, err := w.Write([]byte(token)); err != nil {
+			http.Error(w, "could not write", http.StatusInternalServerError)
+			return
+		}
+	})
+}
+
+// Logout redirects to the logout page of the proxy.
+func (a *Authenticator) Logout() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if a.config.LogoutURL == "" {
+			http.Error(w, "logout is handled by the proxy", http.StatusNotFound)
+			return
+		}
+		http.Redirect(w, r, a.config.LogoutURL, http.StatusSeeOther)
+	})
+}
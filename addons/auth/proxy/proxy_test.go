@@ -0,0 +1,144 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"nvr/pkg/log"
+	"nvr/pkg/web/auth"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newTestAuth(t *testing.T) *Authenticator {
+	t.Helper()
+	config := Config{
+		UserHeader:     "Remote-User",
+		GroupsHeader:   "Remote-Groups",
+		TrustedProxies: []string{"10.0.0.0/8", "::1/128"},
+		AdminGroups:    []string{"admins"},
+		AdminUsers:     []string{"root"},
+		UserGroups:     []string{"nvr"},
+	}
+	a, err := newAuthenticator(
+		config, filepath.Join(t.TempDir(), "users.json"), log.NewDummyLogger())
+	require.NoError(t, err)
+	return a
+}
+
+func newRequest(remoteAddr, user, groups string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = remoteAddr
+	if user != "" {
+		r.Header.Set("Remote-User", user)
+	}
+	if groups != "" {
+		r.Header.Set("Remote-Groups", groups)
+	}
+	return r
+}
+
+func TestValidateRequest(t *testing.T) {
+	cases := []struct {
+		name    string
+		addr    string
+		user    string
+		groups  string
+		valid   bool
+		isAdmin bool
+	}{
+		{"user", "10.1.2.3:1", "Bob", "nvr", true, false},
+		{"admin", "10.1.2.3:1", "bob", "x, admins", true, true},
+		{"adminUser", "10.1.2.3:1", "root", "", true, true},
+		{"ipv6", "[::1]:1", "bob", "nvr", true, false},
+		{"untrusted", "192.168.1.2:1", "bob", "admins", false, false},
+		{"noUser", "10.1.2.3:1", "", "admins", false, false},
+		{"notInGroup", "10.1.2.3:1", "bob", "other", false, false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			a := newTestAuth(t)
+			res := a.ValidateRequest(newRequest(tc.addr, tc.user, tc.groups))
+			require.Equal(t, tc.valid, res.IsValid)
+			require.Equal(t, tc.isAdmin, res.User.IsAdmin)
+			if tc.valid {
+				require.NotEmpty(t, res.User.Token)
+			}
+		})
+	}
+}
+
+func TestAccounts(t *testing.T) {
+	a := newTestAuth(t)
+	r := newRequest("10.1.2.3:1", "Bob", "nvr")
+	res := a.ValidateRequest(r)
+	require.True(t, res.IsValid)
+	require.Equal(t, "bob", res.User.ID)
+
+	// Admins can restrict auto created accounts.
+	access := auth.Access{Restricted: true, Monitors: []string{"m1"}}
+	err := a.UserSet(auth.SetUserRequest{ID: "bob", Username: "bob", Access: access})
+	require.NoError(t, err)
+
+	a2, err := newAuthenticator(a.config, a.path, log.NewDummyLogger())
+	require.NoError(t, err)
+	res2 := a2.ValidateRequest(r)
+	require.True(t, res2.IsValid)
+	require.Equal(t, access, res2.User.Access)
+	require.Contains(t, a2.UsersList(), "bob")
+}
+
+func TestHandlers(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	t.Run("admin", func(t *testing.T) {
+		a := newTestAuth(t)
+		w := httptest.NewRecorder()
+		a.Admin(ok).ServeHTTP(w, newRequest("10.1.2.3:1", "bob", "nvr"))
+		require.Equal(t, http.StatusUnauthorized, w.Code)
+
+		w = httptest.NewRecorder()
+		a.Admin(ok).ServeHTTP(w, newRequest("10.1.2.3:1", "bob", "admins"))
+		require.Equal(t, http.StatusOK, w.Code)
+	})
+	t.Run("csrf", func(t *testing.T) {
+		a := newTestAuth(t)
+		r := newRequest("10.1.2.3:1", "bob", "nvr")
+		token := a.ValidateRequest(r).User.Token
+
+		w := httptest.NewRecorder()
+		a.CSRF(ok).ServeHTTP(w, r)
+		require.Equal(t, http.StatusUnauthorized, w.Code)
+
+		r.Header.Set("X-CSRF-TOKEN", token)
+		w = httptest.NewRecorder()
+		a.CSRF(ok).ServeHTTP(w, r)
+		require.Equal(t, http.StatusOK, w.Code)
+	})
+}
+
+func TestNewAuthenticatorErrors(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.json")
+	_, err := newAuthenticator(Config{}, path, log.NewDummyLogger())
+	require.ErrorIs(t, err, ErrUserHeaderMissing)
+
+	config := DefaultConfig
+	config.TrustedProxies = []string{"10.0.0.1"}
+	_, err = newAuthenticator(config, path, log.NewDummyLogger())
+	require.ErrorIs(t, err, ErrInvalidCIDR)
+}
+
+func TestReadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "proxy.json")
+	config, err := readConfig(path)
+	require.NoError(t, err)
+	require.Equal(t, DefaultConfig, config)
+
+	// Default config is written to disk.
+	config, err = readConfig(path)
+	require.NoError(t, err)
+	require.Equal(t, DefaultConfig, config)
+}
@@ -0,0 +1,162 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package session
+
+import (
+	"encoding/json"
+	"errors"
+	"html/template"
+	"net/http"
+	"nvr/pkg/web/auth"
+)
+
+var loginTemplate = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+	<meta charset="utf-8" />
+	<meta name="viewport" content="width=device-width, initial-scale=1" />
+	<title>Login</title>
+	<style>
+		body {
+			display: flex;
+			justify-content: center;
+			align-items: center;
+			height: 100vh;
+			margin: 0;
+			font-family: sans-serif;
+			background: #0b0b0f;
+			color: #f0f0f0;
+		}
+		form { display: flex; flex-direction: column; width: 16rem; }
+		input, button { margin-bottom: 0.5rem; padding: 0.5rem; font-size: 1rem; }
+		.error { color: #ff6060; margin-bottom: 0.5rem; }
+	</style>
+</head>
+<body>
+	<form method="post" action="/login">
+		{{ if .Error }}<div class="error">{{ .Error }}</div>{{ end }}
+		<input name="username" placeholder="Username" autocomplete="username" autofocus required />
+		<input name="password" type="password" placeholder="Password" autocomplete="current-password" required />
+		<input name="code" placeholder="Two-factor code, if enabled" autocomplete="one-time-code" inputmode="numeric" />
+		<button type="submit">Login</button>
+	</form>
+</body>
+</html>
+`))
+
+func renderLogin(w http.ResponseWriter, status int, loginErr string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	loginTemplate.Execute(w, map[string]string{"Error": loginErr}) //nolint:errcheck
+}
+
+// Login serves the login page and creates a session on a successful login.
+func (a *Authenticator) Login() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			if a.ValidateRequest(r).IsValid {
+				http.Redirect(w, r, "/live", http.StatusSeeOther)
+				return
+			}
+			renderLogin(w, http.StatusOK, "")
+			return
+		case http.MethodPost:
+		default:
+			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
+			return
+		}
+
+		user, err := a.login(
+			r,
+			r.PostFormValue("username"),
+			r.PostFormValue("password"),
+			r.PostFormValue("code"),
+		)
+		if errors.Is(err, ErrLockedOut) {
+			renderLogin(w, http.StatusTooManyRequests, err.Error())
+			return
+		}
+		if err != nil {
+			renderLogin(w, http.StatusUnauthorized, err.Error())
+			return
+		}
+
+		token, _ := a.sessions.create(user.ID, r)
+		http.SetCookie(w, &http.Cookie{
+			Name:     cookieName,
+			Value:    token,
+			Path:     "/",
+			MaxAge:   int(absoluteTimeout.Seconds()),
+			HttpOnly: true,
+			Secure:   isHTTPS(r),
+			SameSite: http.SameSiteLaxMode,
+		})
+		http.Redirect(w, r, "/live", http.StatusSeeOther)
+	})
+}
+
+// Sessions lists the sessions of the requesting user.
+// Admins can list the sessions of all users with "all=true".
+// The user is validated by app, which may accept API keys.
+func (a *Authenticator) Sessions(app auth.Authenticator) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
+			return
+		}
+
+		user := app.ValidateRequest(r).User
+		if user.ID == "" {
+			http.Error(w, "Unauthorized.", http.StatusUnauthorized)
+			return
+		}
+		userID := user.ID
+		if r.URL.Query().Get("all") == "true" {
+			if !user.IsAdmin {
+				http.Error(w, "admin required", http.StatusForbidden)
+				return
+			}
+			userID = ""
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(a.sessions.list(userID)); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	})
+}
+
+// SessionRevoke revokes a session. Users can only revoke
+// their own sessions, admins can revoke any session.
+func (a *Authenticator) SessionRevoke(app auth.Authenticator) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete {
+			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
+			return
+		}
+
+		user := app.ValidateRequest(r).User
+		if user.ID == "" {
+			http.Error(w, "Unauthorized.", http.StatusUnauthorized)
+			return
+		}
+		id := r.URL.Query().Get("id")
+		owned := false
+		for _, session := range a.sessions.list(user.ID) {
+			if session.ID == id {
+				owned = true
+			}
+		}
+		if !owned && !user.IsAdmin {
+			http.Error(w, ErrSessionNotExist.Error(), http.StatusNotFound)
+			return
+		}
+
+		if err := a.sessions.revoke(id); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+	})
+}
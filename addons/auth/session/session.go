@@ -0,0 +1,419 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+// Package session authenticates users with a login page and session cookies.
+package session
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"nvr"
+	"nvr/pkg/configstore"
+	"nvr/pkg/log"
+	"nvr/pkg/storage"
+	"nvr/pkg/web/auth"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func init() {
+	nvr.SetAuthenticator(NewAuthenticator)
+
+	nvr.RegisterAppRunHook(func(_ context.Context, app *nvr.App) error {
+		a := instance
+		app.Router.Handle("/login", a.Login())
+		app.Router.Handle("/api/sessions", app.Auth.User(a.Sessions(app.Auth)))
+		app.Router.Handle("/api/session/revoke", app.Auth.User(app.Auth.CSRF(
+			a.SessionRevoke(app.Auth))))
+		return nil
+	})
+}
+
+// Session timeouts.
+const (
+	idleTimeout     = 12 * time.Hour
+	absoluteTimeout = 7 * 24 * time.Hour
+)
+
+const cookieName = "nvr_session"
+
+// basicLoginTTL how long a successful basic auth login is cached.
+const basicLoginTTL = 1 * time.Minute
+
+// The authenticator is needed by the app run hook.
+var instance *Authenticator
+
+// Authenticator implements auth.Authenticator.
+type Authenticator struct {
+	path     string // Path to save user information.
+	accounts map[string]auth.Account
+	sessions *sessions
+
+	hashCost int
+
+	logger  log.ILogger
+	limiter *auth.LoginLimiter
+	totp    *auth.TOTP
+
+	// HLS clients send the credentials with every
+	// request, checking the hash each time is too slow.
+	basicLogins map[string]basicLogin
+
+	// hashLock limits concurrent hashing operations
+	// to mitigate denial of service attacks.
+	hashLock sync.Mutex
+	mu       sync.Mutex
+}
+
+// NewAuthenticator creates session authenticator.
+func NewAuthenticator(env storage.ConfigEnv, logger *log.Logger) (auth.Authenticator, error) {
+	path := filepath.Join(env.ConfigDir, "users.json")
+	a := &Authenticator{
+		path:     path,
+		accounts: make(map[string]auth.Account),
+		sessions: newSessions(idleTimeout, absoluteTimeout),
+
+		hashCost:    auth.DefaultBcryptHashCost,
+		logger:      logger,
+		limiter:     auth.NewLoginLimiter(logger),
+		basicLogins: make(map[string]basicLogin),
+	}
+
+	err := configstore.ReadJSON(path, &a.accounts)
+	if err != nil {
+		return nil, fmt.Errorf("read accounts file: %w", err)
+	}
+
+	for id, account := range a.accounts {
+		account.Username = strings.ToLower(account.Username)
+		a.accounts[id] = account
+	}
+
+	instance = a
+	return a, nil
+}
+
+// ValidateRequest validates the session cookie.
+func (a *Authenticator) ValidateRequest(r *http.Request) auth.ValidateResponse {
+	cookie, err := r.Cookie(cookieName)
+	if err != nil {
+		return auth.ValidateResponse{}
+	}
+	session, valid := a.sessions.get(cookie.Value)
+	if !valid {
+		return auth.ValidateResponse{}
+	}
+
+	a.mu.Lock()
+	user, exists := a.accounts[session.UserID]
+	a.mu.Unlock()
+	if !exists {
+		return auth.ValidateResponse{}
+	}
+	user.Token = session.csrfToken
+	return auth.ValidateResponse{IsValid: true, User: user}
+}
+
+type basicLogin struct {
+	userID   string
+	passHash [sha256.Size]byte
+	expires  time.Time
+}
+
+// ValidateBasicAuth implements auth.BasicLogin. The username and password
+// are checked like a login, users with two-factor authentication enabled
+// must use an API key instead since the code can't be sent.
+func (a *Authenticator) ValidateBasicAuth(r *http.Request) auth.ValidateResponse {
+	name, pass, ok := r.BasicAuth()
+	if !ok {
+		return auth.ValidateResponse{}
+	}
+	name = strings.ToLower(name)
+	passHash := sha256.Sum256([]byte(pass))
+
+	a.mu.Lock()
+	cached, exists := a.basicLogins[name]
+	user, userExists := a.accounts[cached.userID]
+	a.mu.Unlock()
+
+	if exists && userExists && time.Now().Before(cached.expires) &&
+		subtle.ConstantTimeCompare(cached.passHash[:], passHash[:]) == 1 &&
+		(a.totp == nil || !a.totp.Enabled(user.ID)) {
+		return auth.ValidateResponse{IsValid: true, User: user}
+	}
+
+	user, err := a.login(r, name, pass, "")
+	if err != nil {
+		return auth.ValidateResponse{}
+	}
+
+	a.mu.Lock()
+	a.basicLogins[name] = basicLogin{
+		userID:   user.ID,
+		passHash: passHash,
+		expires:  time.Now().Add(basicLoginTTL),
+	}
+	a.mu.Unlock()
+	return auth.ValidateResponse{IsValid: true, User: user}
+}
+
+// SetTOTP implements auth.TOTPLogin.
+func (a *Authenticator) SetTOTP(totp *auth.TOTP) {
+	a.totp = totp
+}
+
+// Errors.
+var (
+	ErrInvalidLogin = errors.New("invalid username, password or two-factor code")
+	ErrLockedOut    = errors.New("too many failed login attempts, try again later")
+)
+
+// login checks the username, password and the two-factor code if
+// enabled. Should always take the same amount of time to check the
+// password, even when the username is invalid. A wrong code returns
+// the same error as a wrong password, otherwise the response would
+// reveal that the password is correct.
+func (a *Authenticator) login(r *http.Request, name, pass, code string) (auth.Account, error) {
+	name = strings.ToLower(name)
+	if !a.limiter.Allowed(r, name) {
+		return auth.Account{}, ErrLockedOut
+	}
+
+	a.mu.Lock()
+	user, found := a.userByNameUnsafe(name)
+	a.mu.Unlock()
+
+	a.hashLock.Lock()
+	defer a.hashLock.Unlock()
+	if !found {
+		// Generate fake hash to prevent timing based attacks.
+		bcrypt.GenerateFromPassword([]byte(name), a.hashCost) //nolint:errcheck
+	}
+	if !found || bcrypt.CompareHashAndPassword(user.Password, []byte(pass)) != nil {
+		auth.LogFailedLogin(a.logger, r, name)
+		a.limiter.Failed(r, name)
+		return auth.Account{}, ErrInvalidLogin
+	}
+
+	if a.totp != nil && a.totp.Enabled(user.ID) {
+		if err := a.totp.Verify(user.ID, code); err != nil {
+			auth.LogFailedLogin(a.logger, r, name)
+			a.limiter.Failed(r, name)
+			return auth.Account{}, ErrInvalidLogin
+		}
+	}
+
+	a.limiter.Succeeded(name)
+	auth.LogLogin(a.logger, r, user.Username)
+	return user, nil
+}
+
+func (a *Authenticator) userByNameUnsafe(name string) (auth.Account, bool) {
+	for _, u := range a.accounts {
+		if u.Username == name {
+			return u, true
+		}
+	}
+	return auth.Account{}, false
+}
+
+// AuthDisabled False.
+func (a *Authenticator) AuthDisabled() bool {
+	return false
+}
+
+// UsersList returns a obfuscated user list.
+func (a *Authenticator) UsersList() map[string]auth.AccountObfuscated {
+	defer a.mu.Unlock()
+	a.mu.Lock()
+
+	list := make(map[string]auth.AccountObfuscated)
+	for id, user := range a.accounts {
+		list[id] = auth.AccountObfuscated{
+			ID:       user.ID,
+			Username: user.Username,
+			IsAdmin:  user.IsAdmin,
+			Access:   user.Access,
+		}
+	}
+	return list
+}
+
+// Errors.
+var (
+	ErrIDMissing       = errors.New("missing ID")
+	ErrUsernameMissing = errors.New("missing username")
+	ErrPasswordMissing = errors.New("password is required for new users")
+	ErrUserNotExist    = errors.New("user does not exist")
+)
+
+// UserSet set user details. The sessions of the
+// user are revoked if the password is changed.
+func (a *Authenticator) UserSet(req auth.SetUserRequest) error {
+	if req.ID == "" {
+		return ErrIDMissing
+	}
+	if req.Username == "" {
+		return ErrUsernameMissing
+	}
+
+	a.mu.Lock()
+	user, exists := a.accounts[req.ID]
+	a.mu.Unlock()
+	if !exists && req.PlainPassword == "" {
+		return ErrPasswordMissing
+	}
+
+	user.ID = req.ID
+	user.Username = strings.ToLower(req.Username)
+	user.IsAdmin = req.IsAdmin
+	user.Access = req.Access
+	if req.PlainPassword != "" {
+		hashedNewPassword, err := bcrypt.GenerateFromPassword([]byte(req.PlainPassword), a.hashCost)
+		if err != nil {
+			return fmt.Errorf("hash password: %w", err)
+		}
+		user.Password = hashedNewPassword
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.accounts[user.ID] = user
+	if req.PlainPassword != "" {
+		a.sessions.revokeUser(user.ID)
+	}
+	a.basicLogins = make(map[string]basicLogin)
+
+	if err := a.saveToFile(); err != nil {
+		return fmt.Errorf("save users to file: %w", err)
+	}
+	return nil
+}
+
+// UserDelete deletes user by id and revokes the sessions of the user.
+func (a *Authenticator) UserDelete(id string) error {
+	defer a.mu.Unlock()
+	a.mu.Lock()
+	if _, exists := a.accounts[id]; !exists {
+		return ErrUserNotExist
+	}
+	delete(a.accounts, id)
+	a.sessions.revokeUser(id)
+	a.basicLogins = make(map[string]basicLogin)
+
+	return a.saveToFile()
+}
+
+func (a *Authenticator) saveToFile() error {
+	users, err := json.MarshalIndent(a.accounts, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal accounts: %w", err)
+	}
+	return configstore.WriteFile(a.path, users)
+}
+
+// wantsHTML returns true if the request is a page load
+// that should be redirected to the login page.
+func wantsHTML(r *http.Request) bool {
+	return r.Method == http.MethodGet &&
+		strings.Contains(r.Header.Get("Accept"), "text/html")
+}
+
+func unauthorized(w http.ResponseWriter, r *http.Request) {
+	if wantsHTML(r) {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	http.Error(w, "Unauthorized.", http.StatusUnauthorized)
+}
+
+// User blocks unauthorized requests and redirects to the login page.
+func (a *Authenticator) User(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !a.ValidateRequest(r).IsValid {
+			unauthorized(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Admin blocks requests from non-admin users.
+func (a *Authenticator) Admin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		res := a.ValidateRequest(r)
+		if !res.IsValid {
+			unauthorized(w, r)
+			return
+		}
+		if !res.User.IsAdmin {
+			auth.LogFailedLogin(a.logger, r, res.User.Username)
+			http.Error(w, "Unauthorized.", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// CSRF blocks invalid Cross-site request forgery tokens.
+// Each session has a unique token. The request needs to
+// have a matching token in the "X-CSRF-TOKEN" header.
+func (a *Authenticator) CSRF(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		res := a.ValidateRequest(r)
+		token := r.Header.Get("X-CSRF-TOKEN")
+		if !res.IsValid || token != res.User.Token {
+			http.Error(w, "Invalid CSRF-token.", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// MyToken return CSRF token for requesting session.
+func (a *Authenticator) MyToken() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := a.ValidateRequest(r).User.Token
+		if token == "" {
+			http.Error(w, "token does not exist", http.StatusInternalServerError)
+			return
+		}
+		if _, err := w.Write([]byte(token)); err != nil {
+			http.Error(w, "could not write", http.StatusInternalServerError)
+			return
+		}
+	})
+}
+
+// Logout revokes the session and redirects to the login page.
+func (a *Authenticator) Logout() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if cookie, err := r.Cookie(cookieName); err == nil {
+			a.sessions.revokeToken(cookie.Value)
+		}
+		http.SetCookie(w, &http.Cookie{
+			Name:     cookieName,
+			Path:     "/",
+			MaxAge:   -1,
+			HttpOnly: true,
+			Secure:   isHTTPS(r),
+			SameSite: http.SameSiteLaxMode,
+		})
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+	})
+}
+
+// isHTTPS returns true if the request was made over HTTPS,
+// directly or through a reverse proxy. Secure cookies
+// aren't sent by browsers over plain HTTP.
+func isHTTPS(r *http.Request) bool {
+	return r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https"
+}
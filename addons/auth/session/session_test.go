@@ -0,0 +1,273 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package session
+
+import (
+	"crypto/hmac"
+	"crypto/sha1" //nolint:gosec
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"nvr/pkg/log"
+	"nvr/pkg/web/auth"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var pass1 = []byte("$2a$04$M0InS5zIFKk.xmjtcabjrudhKhukxJo6cnhJBq9I.J/slbgWE0F.S")
+
+func newTestAuth(t *testing.T) *Authenticator {
+	t.Helper()
+	tempDir := t.TempDir()
+
+	return &Authenticator{
+		path: tempDir + "/users.json",
+		accounts: map[string]auth.Account{
+			"1": {
+				ID:       "1",
+				Username: "admin",
+				Password: pass1,
+				IsAdmin:  true,
+			},
+		},
+		sessions:    newSessions(idleTimeout, absoluteTimeout),
+		hashCost:    bcrypt.MinCost,
+		logger:      log.NewDummyLogger(),
+		limiter:     auth.NewLoginLimiter(log.NewDummyLogger()),
+		basicLogins: make(map[string]basicLogin),
+	}
+}
+
+func login(a *Authenticator, username, password string) *http.Response {
+	return loginWithCode(a, username, password, "")
+}
+
+func loginWithCode(a *Authenticator, username, password, code string) *http.Response {
+	form := url.Values{"username": {username}, "password": {password}, "code": {code}}
+	r := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	a.Login().ServeHTTP(w, r)
+	return w.Result()
+}
+
+func TestLogin(t *testing.T) {
+	t.Run("ok", func(t *testing.T) {
+		a := newTestAuth(t)
+		res := login(a, "Admin", "pass1")
+		defer res.Body.Close()
+		require.Equal(t, http.StatusSeeOther, res.StatusCode)
+
+		cookies := res.Cookies()
+		require.Len(t, cookies, 1)
+		cookie := cookies[0]
+		require.Equal(t, cookieName, cookie.Name)
+		require.True(t, cookie.HttpOnly)
+		require.Equal(t, http.SameSiteLaxMode, cookie.SameSite)
+
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.AddCookie(cookie)
+		res2 := a.ValidateRequest(r)
+		require.True(t, res2.IsValid)
+		require.Equal(t, "admin", res2.User.Username)
+		require.NotEmpty(t, res2.User.Token)
+
+		w := httptest.NewRecorder()
+		a.Logout().ServeHTTP(w, r)
+		require.False(t, a.ValidateRequest(r).IsValid)
+	})
+	t.Run("invalid", func(t *testing.T) {
+		a := newTestAuth(t)
+		res := login(a, "admin", "nil")
+		defer res.Body.Close()
+		require.Equal(t, http.StatusUnauthorized, res.StatusCode)
+		require.Empty(t, res.Cookies())
+	})
+	t.Run("lockout", func(t *testing.T) {
+		a := newTestAuth(t)
+		for i := 0; i < auth.DefaultMaxUserFailures; i++ {
+			res := login(a, "admin", "nil")
+			res.Body.Close()
+			require.Equal(t, http.StatusUnauthorized, res.StatusCode)
+		}
+		// Locked out even with the correct password.
+		res := login(a, "admin", "pass1")
+		defer res.Body.Close()
+		require.Equal(t, http.StatusTooManyRequests, res.StatusCode)
+	})
+	t.Run("totp", func(t *testing.T) {
+		a := newTestAuth(t)
+		totp, err := auth.NewTOTP(filepath.Join(t.TempDir(), "totp.json"))
+		require.NoError(t, err)
+		a.SetTOTP(totp)
+
+		enrollment, err := totp.Enroll(a.accounts["1"])
+		require.NoError(t, err)
+		recoveryCodes, err := totp.Confirm("1", totpCode(t, enrollment.Secret))
+		require.NoError(t, err)
+
+		res := login(a, "admin", "pass1")
+		wrongCode, err := io.ReadAll(res.Body)
+		require.NoError(t, err)
+		res.Body.Close()
+		require.Equal(t, http.StatusUnauthorized, res.StatusCode)
+		require.Empty(t, res.Cookies())
+
+		// A wrong code doesn't reveal that the password is correct.
+		res = login(a, "admin", "wrong")
+		wrongPass, err := io.ReadAll(res.Body)
+		require.NoError(t, err)
+		res.Body.Close()
+		require.Equal(t, wrongPass, wrongCode)
+
+		res = loginWithCode(a, "admin", "pass1", recoveryCodes[0])
+		res.Body.Close()
+		require.Equal(t, http.StatusSeeOther, res.StatusCode)
+		require.Len(t, res.Cookies(), 1)
+	})
+	t.Run("redirect", func(t *testing.T) {
+		a := newTestAuth(t)
+		r := httptest.NewRequest(http.MethodGet, "/live", nil)
+		r.Header.Set("Accept", "text/html")
+		w := httptest.NewRecorder()
+		a.User(nil).ServeHTTP(w, r)
+		require.Equal(t, http.StatusSeeOther, w.Code)
+		require.Equal(t, "/login", w.Header().Get("Location"))
+
+		r = httptest.NewRequest(http.MethodGet, "/api/x", nil)
+		w = httptest.NewRecorder()
+		a.User(nil).ServeHTTP(w, r)
+		require.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+}
+
+func TestValidateBasicAuth(t *testing.T) {
+	basic := func(username, password string) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.SetBasicAuth(username, password)
+		return r
+	}
+	t.Run("ok", func(t *testing.T) {
+		a := newTestAuth(t)
+		res := a.ValidateBasicAuth(basic("Admin", "pass1"))
+		require.True(t, res.IsValid)
+		require.Equal(t, "1", res.User.ID)
+		require.Contains(t, a.basicLogins, "admin")
+
+		// The cookie isn't set, other endpoints don't accept basic auth.
+		require.False(t, a.ValidateRequest(basic("admin", "pass1")).IsValid)
+	})
+	t.Run("invalid", func(t *testing.T) {
+		a := newTestAuth(t)
+		require.True(t, a.ValidateBasicAuth(basic("admin", "pass1")).IsValid)
+		require.False(t, a.ValidateBasicAuth(basic("admin", "nil")).IsValid)
+		require.False(t, a.ValidateBasicAuth(basic("nil", "pass1")).IsValid)
+		require.False(t, a.ValidateBasicAuth(httptest.NewRequest(http.MethodGet, "/", nil)).IsValid)
+	})
+	t.Run("passwordChanged", func(t *testing.T) {
+		a := newTestAuth(t)
+		require.True(t, a.ValidateBasicAuth(basic("admin", "pass1")).IsValid)
+		err := a.UserSet(auth.SetUserRequest{ID: "1", Username: "admin", PlainPassword: "pass2"})
+		require.NoError(t, err)
+		require.False(t, a.ValidateBasicAuth(basic("admin", "pass1")).IsValid)
+		require.True(t, a.ValidateBasicAuth(basic("admin", "pass2")).IsValid)
+	})
+	t.Run("totp", func(t *testing.T) {
+		a := newTestAuth(t)
+		totp, err := auth.NewTOTP(filepath.Join(t.TempDir(), "totp.json"))
+		require.NoError(t, err)
+		a.SetTOTP(totp)
+		require.True(t, a.ValidateBasicAuth(basic("admin", "pass1")).IsValid)
+
+		enrollment, err := totp.Enroll(a.accounts["1"])
+		require.NoError(t, err)
+		_, err = totp.Confirm("1", totpCode(t, enrollment.Secret))
+		require.NoError(t, err)
+
+		// The code can't be sent, the cached login is also rejected.
+		require.False(t, a.ValidateBasicAuth(basic("admin", "pass1")).IsValid)
+	})
+}
+
+func TestSessions(t *testing.T) {
+	newTestSessions := func() (*sessions, *time.Time) {
+		s := newSessions(time.Hour, 3*time.Hour)
+		now := time.Unix(1000, 0)
+		s.now = func() time.Time { return now }
+		return s, &now
+	}
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	t.Run("idleTimeout", func(t *testing.T) {
+		s, now := newTestSessions()
+		token, _ := s.create("1", r)
+		*now = now.Add(59 * time.Minute)
+		_, valid := s.get(token)
+		require.True(t, valid)
+
+		*now = now.Add(59 * time.Minute)
+		_, valid = s.get(token)
+		require.True(t, valid)
+
+		*now = now.Add(61 * time.Minute)
+		_, valid = s.get(token)
+		require.False(t, valid)
+	})
+	t.Run("absoluteTimeout", func(t *testing.T) {
+		s, now := newTestSessions()
+		token, _ := s.create("1", r)
+		for i := 0; i < 3; i++ {
+			*now = now.Add(59 * time.Minute)
+			_, valid := s.get(token)
+			require.True(t, valid)
+		}
+		*now = now.Add(4 * time.Minute)
+		_, valid := s.get(token)
+		require.False(t, valid)
+	})
+	t.Run("revoke", func(t *testing.T) {
+		s, _ := newTestSessions()
+		token1, session1 := s.create("1", r)
+		token2, _ := s.create("1", r)
+		token3, _ := s.create("2", r)
+		require.Len(t, s.list(""), 3)
+		require.Len(t, s.list("1"), 2)
+
+		require.NoError(t, s.revoke(session1.ID))
+		require.ErrorIs(t, s.revoke(session1.ID), ErrSessionNotExist)
+		_, valid := s.get(token1)
+		require.False(t, valid)
+
+		s.revokeUser("1")
+		_, valid = s.get(token2)
+		require.False(t, valid)
+		_, valid = s.get(token3)
+		require.True(t, valid)
+	})
+}
+
+// totpCode returns the current RFC 6238 code of secret.
+func totpCode(t *testing.T, secret string) string {
+	t.Helper()
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+	require.NoError(t, err)
+
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(time.Now().Unix()/30))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%06d", value%1000000)
+}
@@ -0,0 +1,155 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package session
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"nvr/pkg/web/auth"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Session logged in browser session.
+type Session struct {
+	ID        string    `json:"id"`
+	UserID    string    `json:"userId"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"userAgent"`
+	Created   time.Time `json:"created"`
+	LastSeen  time.Time `json:"lastSeen"`
+
+	csrfToken string
+}
+
+// sessions server side session store. Sessions are kept in memory
+// and are indexed by the hash of the token stored in the cookie.
+type sessions struct {
+	idleTimeout     time.Duration
+	absoluteTimeout time.Duration
+
+	byToken map[string]*Session
+	now     func() time.Time
+	mu      sync.Mutex
+}
+
+func newSessions(idleTimeout, absoluteTimeout time.Duration) *sessions {
+	return &sessions{
+		idleTimeout:     idleTimeout,
+		absoluteTimeout: absoluteTimeout,
+		byToken:         make(map[string]*Session),
+		now:             time.Now,
+	}
+}
+
+func hashToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+func (s *sessions) expired(session *Session, now time.Time) bool {
+	return now.Sub(session.LastSeen) > s.idleTimeout ||
+		now.Sub(session.Created) > s.absoluteTimeout
+}
+
+// create creates a session for user and returns the token.
+func (s *sessions) create(userID string, r *http.Request) (string, Session) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	for hash, session := range s.byToken {
+		if s.expired(session, now) {
+			delete(s.byToken, hash)
+		}
+	}
+
+	token := auth.GenToken()
+	session := &Session{
+		ID:        auth.GenToken()[:16],
+		UserID:    userID,
+		IP:        auth.ClientIP(r),
+		UserAgent: r.UserAgent(),
+		Created:   now,
+		LastSeen:  now,
+		csrfToken: auth.GenToken(),
+	}
+	s.byToken[hashToken(token)] = session
+	return token, *session
+}
+
+// get returns the session of token and updates the last seen time.
+func (s *sessions) get(token string) (Session, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	hash := hashToken(token)
+	session, exists := s.byToken[hash]
+	if !exists {
+		return Session{}, false
+	}
+	now := s.now()
+	if s.expired(session, now) {
+		delete(s.byToken, hash)
+		return Session{}, false
+	}
+	session.LastSeen = now
+	return *session, true
+}
+
+// list returns the sessions of a user or all sessions if
+// userID is empty, sorted by creation time.
+func (s *sessions) list(userID string) []Session {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	list := []Session{}
+	for _, session := range s.byToken {
+		if s.expired(session, now) || (userID != "" && session.UserID != userID) {
+			continue
+		}
+		list = append(list, *session)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Created.Before(list[j].Created)
+	})
+	return list
+}
+
+// ErrSessionNotExist session does not exist.
+var ErrSessionNotExist = errors.New("session does not exist")
+
+// revoke revokes a session by its public ID.
+func (s *sessions) revoke(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for hash, session := range s.byToken {
+		if session.ID == id {
+			delete(s.byToken, hash)
+			return nil
+		}
+	}
+	return ErrSessionNotExist
+}
+
+// revokeToken revokes the session of token.
+func (s *sessions) revokeToken(token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.byToken, hashToken(token))
+}
+
+// revokeUser revokes all sessions of a user.
+func (s *sessions) revokeUser(userID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for hash, session := range s.byToken {
+		if session.UserID == userID {
+			delete(s.byToken, hash)
+		}
+	}
+}
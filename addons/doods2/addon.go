@@ -11,7 +11,10 @@ import (
 	stdlog "log"
 	"net/http"
 	"nvr"
+	"nvr/pkg/backup"
+	"nvr/pkg/configstore"
 	"nvr/pkg/log"
+	"nvr/pkg/metrics"
 	"nvr/pkg/storage"
 	"os"
 	"strconv"
@@ -27,14 +30,23 @@ var addon = struct {
 	detectorList detectors
 	previewCache *previewCache
 
-	sendRequest sendRequestFunc
+	sendRequest     sendRequestFunc
+	requestDuration *metrics.Histogram
 
 	logger *log.Logger
 }{}
 
 func init() {
 	nvr.RegisterLogSource([]string{"doods"})
+	backup.RegisterConfigFile("doods.json")
 	addon.previewCache = newPreviewCache()
+	addon.requestDuration = metrics.NewHistogram(
+		[]float64{0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5})
+
+	nvr.RegisterMetricsCollector(func(e *metrics.Encoder) {
+		e.Histogram("nvr_doods_request_duration_seconds",
+			"Latency of successful detection requests.", nil, addon.requestDuration)
+	})
 
 	nvr.RegisterAppRunHook(func(ctx context.Context, app *nvr.App) error {
 		addon.logger = app.Logger
@@ -95,22 +107,19 @@ type Config struct {
 }
 
 func readConfig(configPath string) (string, error) {
-	if !dirExist(configPath) {
-		if err := genConfig(configPath); err != nil {
+	var config Config
+	err := configstore.ReadJSON(configPath, &config)
+	if errors.Is(err, os.ErrNotExist) {
+		data, _ := json.Marshal(defaultConfig)
+		if err := configstore.WriteFile(configPath, data); err != nil {
 			return "", fmt.Errorf("generate config: %w", err)
 		}
+		return defaultConfig.IP, nil
 	}
-
-	file, err := os.ReadFile(configPath)
 	if err != nil {
 		return "", fmt.Errorf("read config: %w", err)
 	}
 
-	var config Config
-	if err := json.Unmarshal(file, &config); err != nil {
-		return "", fmt.Errorf("unmarshal config: %w", err)
-	}
-
 	return config.IP, nil
 }
 
@@ -118,14 +127,6 @@ var defaultConfig = Config{
 	IP: "127.0.0.1:8080",
 }
 
-func genConfig(path string) error {
-	data, _ := json.Marshal(defaultConfig)
-	if err := os.WriteFile(path, data, 0o600); err != nil {
-		return err
-	}
-	return nil
-}
-
 func newFetcher(ip string) *fetcher {
 	return &fetcher{
 		url: "http://" + ip + "/detectors",
@@ -348,6 +349,7 @@ type sendRequestFunc func(context.Context, detectRequest) (*detections, error)
 var errDoods = errors.New("doods error")
 
 func (c *client) sendRequest(ctx context.Context, request detectRequest) (*detections, error) {
+	startTime := time.Now()
 	res := make(chan detectResponse)
 	req := clientRequest{
 		request:  request,
@@ -373,6 +375,7 @@ func (c *client) sendRequest(ctx context.Context, request detectRequest) (*detec
 		if response.ServerError != "" {
 			return nil, fmt.Errorf("%w: %v", errDoods, response.ServerError)
 		}
+		addon.requestDuration.Observe(time.Since(startTime).Seconds())
 		return &response.Detections, nil
 	}
 }
@@ -382,16 +385,6 @@ type clientRequest struct {
 	response chan detectResponse
 }
 
-func dirExist(path string) bool {
-	if _, err := os.Stat(path); err != nil {
-		if os.IsNotExist(err) {
-			return false
-		}
-		return false
-	}
-	return true
-}
-
 type previewCache struct {
 	monitors map[string][]byte
 	mu       *sync.Mutex
@@ -11,6 +11,7 @@ import (
 	"image/png"
 	"io"
 	"nvr"
+	"nvr/pkg/backoff"
 	"nvr/pkg/ffmpeg"
 	"nvr/pkg/log"
 	"nvr/pkg/monitor"
@@ -24,6 +25,12 @@ import (
 
 func init() {
 	nvr.RegisterMonitorInputProcessHook(onInputProcessStart)
+	nvr.RegisterMonitorInputKeys([]string{"doods", "timestampOffset"})
+	nvr.RegisterMonitorFields([]monitor.Field{{
+		Key:      "doods",
+		Type:     monitor.FieldJSON,
+		Validate: validateConfig,
+	}})
 }
 
 func onInputProcessStart(ctx context.Context, i *monitor.InputProcess, _ *[]string) {
@@ -123,6 +130,10 @@ type instance struct {
 	logf      log.Func
 	sendEvent monitor.SendEventFunc
 
+	// Report the detector process state to the monitor status.
+	reportCrash   func(err error, b *backoff.Backoff)
+	reportRunning func(b *backoff.Backoff) func()
+
 	outputs       outputs
 	ffArgs        []string
 	reverseValues reverseValues
@@ -151,6 +162,13 @@ func newInstance(
 		logf:      logf,
 		sendEvent: i.SendEvent,
 
+		reportCrash: func(err error, b *backoff.Backoff) {
+			i.ReportCrash("doods", err, b)
+		},
+		reportRunning: func(b *backoff.Backoff) func() {
+			return i.ReportRunning("doods", b)
+		},
+
 		newProcess:  ffmpeg.NewProcess,
 		startReader: startReader,
 		sendRequest: sendRequest,
@@ -320,9 +338,13 @@ func generateFFmpegArgs(
 func (i *instance) startProcess(parentCtx context.Context) {
 	defer i.wg.Done()
 
+	b := backoff.New(i.env.RestartBackoff)
 	for {
+		startTime := time.Now()
 		ctx, cancel := context.WithCancel(parentCtx)
+		stopped := i.reportRunning(b)
 		err := i.runProcess(ctx, cancel)
+		stopped()
 		if err != nil && !errors.Is(err, context.Canceled) {
 			i.logf(log.LevelError, "detector crashed: %v", err)
 		} else {
@@ -330,10 +352,18 @@ func (i *instance) startProcess(parentCtx context.Context) {
 		}
 		cancel()
 
+		delay := b.Failure(time.Since(startTime))
+		if err != nil {
+			if b.CrashLoopDetected() {
+				i.logf(log.LevelError, "detector crash-looping after %v consecutive failures", b.Failures())
+			}
+			i.reportCrash(err, b)
+		}
+
 		select {
 		case <-parentCtx.Done():
 			return
-		case <-time.After(3 * time.Second):
+		case <-time.After(delay):
 		}
 	}
 }
@@ -14,6 +14,7 @@ import (
 	"testing"
 	"time"
 
+	"nvr/pkg/backoff"
 	"nvr/pkg/ffmpeg"
 	"nvr/pkg/ffmpeg/ffmock"
 	"nvr/pkg/log"
@@ -163,7 +164,9 @@ func TestGenerateArgs(t *testing.T) {
 
 func newTestInstance(logs chan string) *instance {
 	return &instance{
-		env: storage.ConfigEnv{},
+		env:           storage.ConfigEnv{},
+		reportCrash:   func(error, *backoff.Backoff) {},
+		reportRunning: func(*backoff.Backoff) func() { return func() {} },
 		c: config{
 			feedRate:    2,
 			recDuration: 3,
@@ -230,6 +230,16 @@ func (c *config) validate() error {
 	return nil
 }
 
+// validateConfig validates the raw "doods" monitor config value.
+func validateConfig(rawDoods string) error {
+	config, enable, err := parseConfig(monitor.NewConfig(monitor.RawConfig{"doods": rawDoods}))
+	if err != nil || !enable {
+		return err
+	}
+	config.fillMissing()
+	return config.validate()
+}
+
 func init() {
 	nvr.RegisterMigrationMonitorHook(migrate)
 }
@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"io"
 	"nvr"
+	"nvr/pkg/backoff"
 	"nvr/pkg/ffmpeg"
 	"nvr/pkg/log"
 	"nvr/pkg/monitor"
@@ -21,6 +22,12 @@ import (
 
 func init() {
 	nvr.RegisterMonitorInputProcessHook(onInputProcessStart)
+	nvr.RegisterMonitorInputKeys([]string{"motion", "timestampOffset"})
+	nvr.RegisterMonitorFields([]monitor.Field{{
+		Key:      "motion",
+		Type:     monitor.FieldJSON,
+		Validate: validateConfig,
+	}})
 	nvr.RegisterLogSource([]string{"motion"})
 
 	nvr.RegisterTplHook(modifyTemplates)
@@ -73,19 +80,32 @@ func start(
 		return
 	}
 
+	b := backoff.New(i.Env.RestartBackoff)
 	for {
 		if ctx.Err() != nil {
 			return
 		}
 
+		startTime := time.Now()
 		ctx2, cancel := context.WithCancel(ctx)
 
-		if err := run(ctx2, cancel, i, config, logf); err != nil {
+		stopped := i.ReportRunning("motion", b)
+		err := run(ctx2, cancel, i, config, logf)
+		stopped()
+		if err != nil {
 			logf(log.LevelError, "%v", err)
 		}
 
+		delay := b.Failure(time.Since(startTime))
+		if err != nil {
+			if b.CrashLoopDetected() {
+				logf(log.LevelError, "crash-looping after %v consecutive failures", b.Failures())
+			}
+			i.ReportCrash("motion", err, b)
+		}
+
 		select {
-		case <-time.After(3 * time.Second):
+		case <-time.After(delay):
 		case <-ctx.Done():
 			return
 		}
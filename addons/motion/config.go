@@ -79,6 +79,12 @@ func parseConfig(c monitor.Config) (*config, bool, error) {
 	}, enable, nil
 }
 
+// validateConfig validates the raw "motion" monitor config value.
+func validateConfig(rawMotion string) error {
+	_, _, err := parseConfig(monitor.NewConfig(monitor.RawConfig{"motion": rawMotion}))
+	return err
+}
+
 func parseTimestampOffset(rawOffset string) (time.Duration, error) {
 	if rawOffset == "" {
 		return 0, nil
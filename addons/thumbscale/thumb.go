@@ -12,8 +12,19 @@ import (
 func init() {
 	nvr.RegisterTplHook(modifyTemplates)
 	nvr.RegisterMonitorRecSaveHook(onRecSave)
+	nvr.RegisterMonitorFields([]monitor.Field{{
+		Key:     "thumbScale",
+		Type:    monitor.FieldEnum,
+		Default: "full",
+		Options: scales,
+
+		// ffmpeg.ParseScaleString ignores case.
+		IgnoreCase: true,
+	}})
 }
 
+var scales = []string{"full", "half", "third", "quarter", "sixth", "eighth"}
+
 func modifyTemplates(pageFiles map[string]string) error {
 	js, exists := pageFiles["settings.js"]
 	if !exists {
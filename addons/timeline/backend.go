@@ -23,6 +23,14 @@ func init() {
 	nvr.RegisterLogSource([]string{"timeline"})
 	nvr.RegisterMonitorRecSavedHook(onRecSaved)
 	nvr.RegisterMigrationMonitorHook(migrate)
+	nvr.RegisterMonitorFields([]monitor.Field{{
+		Key:  "timeline",
+		Type: monitor.FieldJSON,
+		Validate: func(rawTimeline string) error {
+			_, err := parseConfig(monitor.NewConfig(monitor.RawConfig{"timeline": rawTimeline}))
+			return err
+		},
+	}})
 
 	nvr.RegisterTplSubHook(modifySubTemplates)
 	nvr.RegisterTplHook(modifyTemplates)
@@ -30,7 +38,8 @@ func init() {
 	nvr.RegisterAppRunHook(func(_ context.Context, app *nvr.App) error {
 		app.Router.Handle(
 			"/api/recording/timeline/",
-			app.Auth.User(handleTimeline(app.Env.RecordingsDir())),
+			app.Auth.User(app.ACL.Recording(
+				"/api/recording/timeline/", handleTimeline(app.Env.RecordingsDir()))),
 		)
 		app.Router.Handle(
 			"/timeline",
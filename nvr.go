@@ -4,13 +4,19 @@ package nvr
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"html/template"
 	"net/http"
+	"nvr/pkg/backup"
+	"nvr/pkg/certs"
 	"nvr/pkg/group"
+	"nvr/pkg/history"
 	"nvr/pkg/log"
+	"nvr/pkg/metrics"
 	"nvr/pkg/monitor"
 	"nvr/pkg/storage"
 	"nvr/pkg/system"
@@ -29,6 +35,9 @@ import (
 // Run .
 func Run() error {
 	envFlag := flag.String("env", "", "path to env.yaml")
+	backupFlag := flag.String("backup", "", "write config backup to file and exit")
+	restoreFlag := flag.String("restore", "", "restore config backup from file and exit")
+	dryRunFlag := flag.Bool("dry-run", false, "only print the changes -restore would make")
 	flag.Parse()
 
 	if *envFlag == "" {
@@ -41,11 +50,29 @@ func Run() error {
 		return fmt.Errorf("could not get absolute path of env.yaml: %w", err)
 	}
 
+	configDir := filepath.Dir(envPath)
+	switch {
+	case *backupFlag != "":
+		return backupToFile(configDir, *backupFlag)
+	case *restoreFlag != "":
+		return restoreFromFile(configDir, *restoreFlag, *dryRunFlag)
+	}
+
+	// Backups that were restored from the web
+	// interface are applied before anything is loaded.
+	restored, err := backup.RestorePending(configDir)
+	if err != nil {
+		return fmt.Errorf("could not restore scheduled backup: %w", err)
+	}
+
 	wg := &sync.WaitGroup{}
 	app, err := newApp(envPath, wg, hooks)
 	if err != nil {
 		return err
 	}
+	if restored {
+		app.logf(log.LevelInfo, "restored scheduled backup")
+	}
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -79,19 +106,133 @@ func Run() error {
 	return app.server.Shutdown(ctx2)
 }
 
+func backupToFile(configDir string, path string) error {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0o600)
+	if err != nil {
+		return fmt.Errorf("could not create backup file: %w", err)
+	}
+	defer file.Close()
+
+	if err := backup.Create(file, configDir); err != nil {
+		return fmt.Errorf("could not create backup: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	fmt.Printf("backup saved to %v\n", path)
+	return nil
+}
+
+// The app should be stopped before restoring.
+func restoreFromFile(configDir string, path string, dryRun bool) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("could not open backup file: %w", err)
+	}
+	defer file.Close()
+
+	files, err := backup.Read(file)
+	if err != nil {
+		return fmt.Errorf("could not read backup: %w", err)
+	}
+	if err := files.Validate(migrateFunc(hooks.monitor().Migrate)); err != nil {
+		return fmt.Errorf("invalid backup: %w", err)
+	}
+
+	changes, err := files.Diff(configDir)
+	if err != nil {
+		return err
+	}
+	for _, change := range changes {
+		fmt.Printf("%-6v %v\n", change.Action, change.Path)
+	}
+	if len(changes) == 0 {
+		fmt.Println("no changes")
+		return nil
+	}
+	if dryRun {
+		return nil
+	}
+
+	if err := files.Restore(configDir); err != nil {
+		return fmt.Errorf("could not restore backup: %w", err)
+	}
+	fmt.Println("backup restored")
+	return nil
+}
+
+func migrateFunc(hook monitor.MigationHook) backup.MigrateFunc {
+	return func(c map[string]string) error {
+		return hook(c)
+	}
+}
+
+func rollbackFuncs(
+	monitorManager *monitor.Manager,
+	groupManager *group.Manager,
+	general *storage.ConfigGeneral,
+	a auth.Authenticator,
+) map[string]web.RollbackFunc {
+	return map[string]web.RollbackFunc{
+		"monitor": func(id string, conf map[string]string) error {
+			if conf == nil {
+				err := monitorManager.MonitorDelete(id)
+				if errors.Is(err, monitor.ErrNotExist) {
+					return nil
+				}
+				return err
+			}
+			if errs := monitorManager.ValidateConfig(conf); len(errs) != 0 {
+				return errs
+			}
+			_, err := monitorManager.MonitorSet(id, conf)
+			return err
+		},
+		"group": func(id string, conf map[string]string) error {
+			if conf == nil {
+				return groupManager.GroupDelete(id)
+			}
+			return groupManager.GroupSet(id, conf)
+		},
+		"general": func(_ string, conf map[string]string) error {
+			return general.Set(conf)
+		},
+		"user": func(id string, conf map[string]string) error {
+			if conf == nil {
+				return a.UserDelete(id)
+			}
+			var access auth.Access
+			if conf["access"] != "" {
+				if err := json.Unmarshal([]byte(conf["access"]), &access); err != nil {
+					return fmt.Errorf("unmarshal access: %w", err)
+				}
+			}
+			return a.UserSet(auth.SetUserRequest{
+				ID:       id,
+				Username: conf["username"],
+				IsAdmin:  conf["isAdmin"] == "true",
+				Access:   access,
+			})
+		},
+	}
+}
+
 // App is the main application.
 type App struct {
 	WG             *sync.WaitGroup
 	Logger         *log.Logger
 	logStore       *log.Store
+	auditStore     *log.Store
 	Env            storage.ConfigEnv
 	monitorManager *monitor.Manager
 	Auth           auth.Authenticator
+	ACL            *web.ACL
 	Storage        *storage.Manager
 	videoServer    *video.Server
 	Templater      *web.Templater
 	Router         *http.ServeMux
 	server         *http.Server
+	certManager    *certs.Manager
 }
 
 func newApp(envPath string, wg *sync.WaitGroup, hooks *hookList) (*App, error) { //nolint:funlen
@@ -118,18 +259,38 @@ func newApp(envPath string, wg *sync.WaitGroup, hooks *hookList) (*App, error) {
 	if err != nil {
 		return nil, fmt.Errorf("could not create log store: %w", err)
 	}
+	auditDir := filepath.Join(logDir, "audit")
+	auditStore, err := log.NewAuditStore(auditDir, wg, env.AuditRetention)
+	if err != nil {
+		return nil, fmt.Errorf("could not create audit log store: %w", err)
+	}
+
+	// TLS.
+	var certManager *certs.Manager
+	var tlsConfig *tls.Config
+	if env.TLS.Enable {
+		logf := func(level log.Level, format string, a ...interface{}) {
+			logger.Log(log.Entry{Level: level, Src: "app", Msg: fmt.Sprintf(format, a...)})
+		}
+		certManager, err = certs.NewManager(env.TLS, env.ConfigDir, logf)
+		if err != nil {
+			return nil, fmt.Errorf("could not create certificate manager: %w", err)
+		}
+		tlsConfig = certManager.TLSConfig()
+	}
 
 	// Video server.
-	videoServer := video.NewServer(logger, wg, *env)
+	videoServer := video.NewServer(logger, wg, *env, tlsConfig)
 
 	// Monitors.
+	monitorHooks := hooks.monitor()
 	monitorConfigDir := filepath.Join(env.ConfigDir, "monitors")
 	monitorManager, err := monitor.NewManager(
 		monitorConfigDir,
 		*env,
 		logger,
 		videoServer,
-		hooks.monitor(),
+		monitorHooks,
 	)
 	if err != nil {
 		return nil, fmt.Errorf("could not create monitor manager: %w", err)
@@ -152,6 +313,48 @@ func newApp(envPath string, wg *sync.WaitGroup, hooks *hookList) (*App, error) {
 	if err != nil {
 		return nil, fmt.Errorf("could not create authenticator: %w", err)
 	}
+	apiKeys, err := auth.NewAPIKeys(filepath.Join(env.ConfigDir, "apikeys.json"))
+	if err != nil {
+		return nil, fmt.Errorf("could not create api key store: %w", err)
+	}
+	totp, err := auth.NewTOTP(filepath.Join(env.ConfigDir, "totp.json"))
+	if err != nil {
+		return nil, fmt.Errorf("could not create totp store: %w", err)
+	}
+	a = auth.WithTOTP(a, totp, logger)
+	a = auth.WithAPIKeys(a, apiKeys, logger)
+
+	// Config history.
+	historyStore, err := history.NewStore(filepath.Join(env.StorageDir, "history"))
+	if err != nil {
+		return nil, err
+	}
+	username := func(r *http.Request) string {
+		return a.ValidateRequest(r).User.Username
+	}
+	auditor := web.NewAuditor(logger, username)
+	acl := web.NewACL(a, groupManager.Configs)
+	videoServer.SetAuth(acl.Stream)
+	recordRevision := func(r *http.Request, object string, oldConf, newConf map[string]string) {
+		var monitorID string
+		if kind, id, _ := history.SplitObject(object); kind == "monitor" {
+			monitorID = id
+		}
+		if newConf == nil {
+			auditor.Log(r, "config-delete", object, monitorID)
+		} else {
+			auditor.Log(r, "config-set", object, monitorID)
+		}
+
+		err := historyStore.Record(object, username(r), oldConf, newConf)
+		if err != nil {
+			logger.Log(log.Entry{
+				Level: log.LevelError,
+				Src:   "app",
+				Msg:   fmt.Sprintf("could not record config revision: %v: %v", object, err),
+			})
+		}
+	}
 
 	// Storage.
 	storageManager := storage.NewManager(env.StorageDir, general, logger)
@@ -177,11 +380,13 @@ func newApp(envPath string, wg *sync.WaitGroup, hooks *hookList) (*App, error) {
 			data["tz"] = timeZone
 		},
 		func(data template.FuncMap, page string) {
-			groups, _ := json.Marshal(groupManager.Configs())
+			user, _ := data["user"].(auth.Account)
+			groups, _ := json.Marshal(acl.FilterGroups(user, groupManager.Configs()))
 			data["groups"] = string(groups)
 		},
 		func(data template.FuncMap, page string) {
-			monitors, _ := json.Marshal(monitorManager.MonitorsInfo())
+			user, _ := data["user"].(auth.Account)
+			monitors, _ := json.Marshal(acl.FilterMonitors(user, monitorManager.MonitorsInfo()))
 			data["monitors"] = string(monitors)
 		},
 		func(data template.FuncMap, page string) {
@@ -201,49 +406,129 @@ func newApp(envPath string, wg *sync.WaitGroup, hooks *hookList) (*App, error) {
 	router.Handle("/debug", a.Admin(t.Render("debug.tpl")))
 
 	router.Handle("/static/", a.User(web.Static()))
-	router.Handle("/hls/", a.User(videoServer.HandleHLS()))
+	router.Handle("/hls/", a.User(acl.Monitor(web.HLSMonitorID, videoServer.HandleHLS())))
+	router.Handle("/api/webrtc/whep/", auth.AllowScope(auth.ScopeReadOnly,
+		a.User(a.CSRF(acl.Monitor(web.WHEPMonitorID, videoServer.HandleWHEP())))))
 
 	router.Handle("/api/system/time-zone", a.User(web.TimeZone(timeZone)))
 
 	router.Handle("/api/general", a.Admin(web.General(general)))
-	router.Handle("/api/general/set", a.Admin(a.CSRF(web.GeneralSet(general))))
+	router.Handle("/api/general/set", a.Admin(a.CSRF(web.GeneralSet(general, recordRevision))))
+
+	router.Handle("/api/backup", a.Admin(auditor.Handler(
+		"backup-download", web.AuditNoTarget, web.Backup(env.ConfigDir))))
+	router.Handle("/api/backup/restore", a.Admin(a.CSRF(auditor.Handler(
+		"backup-restore",
+		func(r *http.Request) (string, string, bool) {
+			return "", "", r.URL.Query().Get("dryRun") != "true"
+		},
+		web.BackupRestore(env.ConfigDir, migrateFunc(monitorHooks.Migrate))))))
 
 	router.Handle("/api/users", a.Admin(web.Users(a)))
-	router.Handle("/api/user/set", a.Admin(a.CSRF(web.UserSet(a))))
-	router.Handle("/api/user/delete", a.Admin(a.CSRF(web.UserDelete(a))))
+	router.Handle("/api/user/set", a.Admin(a.CSRF(web.UserSet(a, recordRevision))))
+	router.Handle("/api/user/delete", a.Admin(a.CSRF(web.UserDelete(a, recordRevision))))
 	router.Handle("/api/user/my-token", a.Admin(a.MyToken()))
 	router.Handle("/logout", a.Logout())
 
+	router.Handle("/api/totp", a.User(web.TOTPStatus(totp, a)))
+	router.Handle("/api/totp/enroll", a.User(a.CSRF(web.TOTPEnroll(totp, a))))
+	router.Handle("/api/totp/confirm", a.User(a.CSRF(auditor.Handler(
+		"totp-enable", web.AuditNoTarget, web.TOTPConfirm(totp, a)))))
+	router.Handle("/api/totp/disable", a.User(a.CSRF(auditor.Handler(
+		"totp-disable", web.AuditNoTarget, web.TOTPDisable(totp, a)))))
+	router.Handle("/api/totp/reset", a.Admin(a.CSRF(auditor.Handler(
+		"totp-reset",
+		func(r *http.Request) (string, string, bool) {
+			return r.URL.Query().Get("id"), "", true
+		},
+		web.TOTPReset(totp)))))
+
+	router.Handle("/api/apikeys", a.User(web.APIKeys(apiKeys, a)))
+	router.Handle("/api/apikey/create", a.User(a.CSRF(auditor.Handler(
+		"apikey-create", web.AuditNoTarget, web.APIKeyCreate(apiKeys, a)))))
+	router.Handle("/api/apikey/delete", a.User(a.CSRF(auditor.Handler(
+		"apikey-delete",
+		func(r *http.Request) (string, string, bool) {
+			return r.URL.Query().Get("id"), "", true
+		},
+		web.APIKeyDelete(apiKeys, a)))))
+
+	router.Handle("/api/monitor/config", a.Admin(
+		web.MonitorConfig(monitorManager, a.CSRF, recordRevision)))
 	router.Handle("/api/monitor/configs", a.Admin(web.MonitorConfigs(monitorManager)))
-	router.Handle("/api/monitor/delete", a.Admin(a.CSRF(web.MonitorDelete(monitorManager))))
-	router.Handle("/api/monitor/list", a.User(web.MonitorList(monitorManager.MonitorsInfo)))
-	router.Handle("/api/monitor/restart", a.Admin(a.CSRF(web.MonitorRestart(monitorManager))))
-	router.Handle("/api/monitor/set", a.Admin(a.CSRF(web.MonitorSet(monitorManager))))
-
-	router.Handle("/api/group/configs", a.User(web.GroupConfigs(groupManager)))
-	router.Handle("/api/group/set", a.Admin(a.CSRF(web.GroupSet(groupManager))))
-	router.Handle("/api/group/delete", a.Admin(a.CSRF(web.GroupDelete(groupManager))))
-
-	router.Handle("/api/recording/delete/", a.Admin(a.CSRF(web.RecordingDelete(env.RecordingsDir()))))
-	router.Handle("/api/recording/thumbnail/", a.User(web.RecordingThumbnail(env.RecordingsDir())))
-	router.Handle("/api/recording/video/", a.User(web.RecordingVideo(logger, env.RecordingsDir())))
-	router.Handle("/api/recording/query", a.User(web.RecordingQuery(crawler, logger)))
-
-	router.Handle("/api/log/feed", a.Admin(web.LogFeed(logger, a)))
-	router.Handle("/api/log/query", a.Admin(web.LogQuery(logStore)))
+	router.Handle("/api/monitor/delete", a.Admin(a.CSRF(
+		web.MonitorDelete(monitorManager, recordRevision))))
+	router.Handle("/api/monitor/event", auth.AllowScope(auth.ScopeEventsIn,
+		a.Admin(a.CSRF(web.MonitorEvent(monitorManager)))))
+	router.Handle("/api/monitor/list", a.User(web.MonitorList(monitorManager.MonitorsInfo, acl)))
+	router.Handle("/api/monitor/mjpeg", a.User(acl.Monitor(
+		web.QueryMonitorID, web.MonitorMJPEG(monitorManager.MJPEGSubscribe, acl, 10*time.Second))))
+	router.Handle("/api/monitor/snapshot", a.User(acl.Monitor(
+		web.QueryMonitorID, web.MonitorSnapshot(monitorManager.Snapshot))))
+	router.Handle("/api/monitor/restart", a.Admin(a.CSRF(auditor.Handler(
+		"monitor-restart", web.AuditQueryID, web.MonitorRestart(monitorManager)))))
+	router.Handle("/api/monitor/set", a.Admin(a.CSRF(web.MonitorSet(monitorManager, recordRevision))))
+	router.Handle("/api/monitor/schema", a.Admin(web.MonitorSchema(monitorManager)))
+	router.Handle("/api/monitor/status", a.User(web.MonitorStatus(monitorManager.MonitorsStatus, acl)))
+	router.Handle("/api/monitor/status/feed", a.User(
+		web.MonitorStatusFeed(monitorManager.MonitorsStatus, acl, 1*time.Second)))
+
+	router.Handle("/api/group/configs", a.User(web.GroupConfigs(groupManager, acl)))
+	router.Handle("/api/group/set", a.Admin(a.CSRF(web.GroupSet(groupManager, recordRevision))))
+	router.Handle("/api/group/delete", a.Admin(a.CSRF(web.GroupDelete(groupManager, recordRevision))))
+
+	router.Handle("/api/history", a.Admin(web.History(historyStore)))
+	router.Handle("/api/history/rollback", a.Admin(a.CSRF(auditor.Handler(
+		"config-rollback",
+		web.AuditQueryObject,
+		web.HistoryRollback(
+			historyStore,
+			username,
+			rollbackFuncs(monitorManager, groupManager, general, a),
+		)))))
+
+	router.Handle("/api/recording/delete/", a.User(a.CSRF(acl.RecordingDelete(
+		"/api/recording/delete/",
+		auditor.Handler(
+			"recording-delete",
+			web.AuditRecording("/api/recording/delete/"),
+			web.RecordingDelete(env.RecordingsDir()))))))
+	router.Handle("/api/recording/thumbnail/", a.User(acl.Recording(
+		"/api/recording/thumbnail/", web.RecordingThumbnail(env.RecordingsDir()))))
+	router.Handle("/api/recording/video/", a.User(acl.RecordingVideo(
+		"/api/recording/video/", auditor.RecordingVideo(
+			"/api/recording/video/", web.RecordingVideo(logger, env.RecordingsDir())))))
+	router.Handle("/api/recording/query", a.User(web.RecordingQuery(crawler, logger, acl)))
+
+	router.Handle("/api/log/feed", a.Admin(auditor.Handler(
+		"log-feed", web.AuditNoTarget, web.LogFeed(logger, a))))
+	router.Handle("/api/log/query", a.Admin(auditor.Handler(
+		"log-query", web.AuditNoTarget, web.LogQuery(logStore, auditStore))))
 	router.Handle("/api/log/sources", a.Admin(web.LogSources(logger)))
 
+	metricsCollectors := append([]metrics.Collector{
+		monitorManager.CollectMetrics,
+		videoServer.CollectMetrics,
+		storageManager.CollectMetrics,
+		logger.CollectMetrics,
+	}, hooks.metricsCollector...)
+	router.Handle("/metrics", auth.AllowScope(auth.ScopeReadOnly,
+		a.Admin(web.Metrics(metricsCollectors))))
+
 	return &App{
 		WG:             wg,
 		Logger:         logger,
 		logStore:       logStore,
+		auditStore:     auditStore,
 		Env:            *env,
 		monitorManager: monitorManager,
 		Auth:           a,
+		ACL:            acl,
 		Storage:        storageManager,
 		videoServer:    videoServer,
 		Templater:      t,
 		Router:         router,
+		certManager:    certManager,
 	}, nil
 }
 
@@ -259,6 +544,8 @@ func (app *App) run(ctx context.Context) error {
 	app.Logger.LogToWriter(ctx, os.Stdout)
 	app.logStore.SaveLogs(ctx, app.Logger)
 	app.logStore.PurgeLoop(ctx, app.Logger)
+	app.auditStore.SaveLogs(ctx, app.Logger)
+	app.auditStore.PurgeLoop(ctx, app.Logger)
 	time.Sleep(10 * time.Millisecond)
 
 	if err := hooks.appRun(ctx, app); err != nil {
@@ -278,9 +565,36 @@ func (app *App) run(ctx context.Context) error {
 	app.monitorManager.StartMonitors()
 
 	go app.Storage.PurgeLoop(ctx, 10*time.Minute)
+	go backup.Loop(ctx, app.Env.Backup, app.Env.ConfigDir, app.Env.BackupsDir(), app.logf)
+
+	if app.certManager == nil {
+		app.logf(log.LevelInfo, "Serving app on port %v", app.Env.Port)
+		return app.server.ListenAndServe()
+	}
+
+	go app.certManager.Run(ctx, time.Minute)
+	app.server.TLSConfig = app.certManager.TLSConfig()
+
+	if port := app.Env.TLS.RedirectPort; port != 0 {
+		redirect := &http.Server{
+			Addr:    ":" + strconv.Itoa(port),
+			Handler: certs.RedirectHandler(app.Env.Port),
+		}
+		go func() {
+			<-ctx.Done()
+			redirect.Close()
+		}()
+		go func() {
+			app.logf(log.LevelInfo, "Redirecting HTTP on port %v to HTTPS", port)
+			err := redirect.ListenAndServe()
+			if err != nil && !errors.Is(err, http.ErrServerClosed) {
+				app.logf(log.LevelError, "redirect server stopped: %v", err)
+			}
+		}()
+	}
 
-	app.logf(log.LevelInfo, "Serving app on port %v", app.Env.Port)
-	return app.server.ListenAndServe()
+	app.logf(log.LevelInfo, "Serving app over HTTPS on port %v", app.Env.Port)
+	return app.server.ListenAndServeTLS("", "")
 }
 
 func (app *App) logf(level log.Level, format string, a ...interface{}) {
@@ -0,0 +1,115 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package backoff
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+// Config exponential backoff configuration.
+type Config struct {
+	// Delay after the first failure.
+	Initial time.Duration `yaml:"initial"`
+
+	// Maximum delay.
+	Max time.Duration `yaml:"max"`
+
+	// Multiplier applied to the delay after each consecutive failure.
+	Multiplier float64 `yaml:"multiplier"`
+
+	// Randomization factor between 0 and 1. A jitter of 0.2
+	// will randomize the delay between 80% and 120%.
+	// A negative value disables jitter.
+	Jitter float64 `yaml:"jitter"`
+
+	// The failure count is reset if a run lasted longer than this.
+	ResetAfter time.Duration `yaml:"resetAfter"`
+
+	// Number of consecutive failures before the process is
+	// considered to be crash-looping.
+	CrashLoopThreshold int `yaml:"crashLoopThreshold"`
+}
+
+// FillMissing sets default values for unset fields.
+func (c *Config) FillMissing() {
+	if c.Initial == 0 {
+		c.Initial = 1 * time.Second
+	}
+	if c.Max == 0 {
+		c.Max = 2 * time.Minute
+	}
+	if c.Multiplier == 0 {
+		c.Multiplier = 2
+	}
+	if c.Jitter == 0 {
+		c.Jitter = 0.2
+	}
+	if c.ResetAfter == 0 {
+		c.ResetAfter = 1 * time.Minute
+	}
+	if c.CrashLoopThreshold == 0 {
+		c.CrashLoopThreshold = 10
+	}
+}
+
+// Backoff keeps track of consecutive failures in a restart loop.
+// Not safe for concurrent use.
+type Backoff struct {
+	config   Config
+	failures int
+
+	randFloat func() float64
+}
+
+// New returns a new Backoff. Unset config fields are set to default values.
+func New(config Config) *Backoff {
+	config.FillMissing()
+	return &Backoff{
+		config:    config,
+		randFloat: rand.Float64, //nolint:gosec
+	}
+}
+
+// Failure records a failed run that lasted runTime and returns
+// the delay before the next attempt. The failure count is reset
+// first if the run lasted longer than ResetAfter.
+func (b *Backoff) Failure(runTime time.Duration) time.Duration {
+	if runTime > b.config.ResetAfter {
+		b.failures = 0
+	}
+	b.failures++
+
+	c := b.config
+	delay := float64(c.Initial) * math.Pow(c.Multiplier, float64(b.failures-1))
+	if c.Jitter > 0 {
+		delay *= 1 + c.Jitter*(2*b.randFloat()-1)
+	}
+
+	if delay > float64(c.Max) || delay < 0 || math.IsInf(delay, 0) {
+		return c.Max
+	}
+	return time.Duration(delay)
+}
+
+// Failures returns the number of consecutive failures.
+func (b *Backoff) Failures() int {
+	return b.failures
+}
+
+// CrashLoopDetected returns true if the last failure
+// caused the failure count to reach the threshold.
+func (b *Backoff) CrashLoopDetected() bool {
+	return b.failures == b.config.CrashLoopThreshold
+}
+
+// ResetAfter returns the run time after which the failure count is reset.
+func (b *Backoff) ResetAfter() time.Duration {
+	return b.config.ResetAfter
+}
+
+// CrashLooping returns true if the failure count is at or above the threshold.
+func (b *Backoff) CrashLooping() bool {
+	return b.failures >= b.config.CrashLoopThreshold
+}
@@ -0,0 +1,90 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package backoff
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newTestBackoff(random float64) *Backoff {
+	b := New(Config{
+		Initial:            1 * time.Second,
+		Max:                10 * time.Second,
+		Multiplier:         2,
+		Jitter:             0.5,
+		ResetAfter:         1 * time.Minute,
+		CrashLoopThreshold: 3,
+	})
+	b.randFloat = func() float64 { return random }
+	return b
+}
+
+func TestBackoff(t *testing.T) {
+	t.Run("exponential", func(t *testing.T) {
+		b := newTestBackoff(0.5)
+
+		require.Equal(t, 1*time.Second, b.Failure(0))
+		require.Equal(t, 2*time.Second, b.Failure(0))
+		require.Equal(t, 4*time.Second, b.Failure(0))
+		require.Equal(t, 8*time.Second, b.Failure(0))
+		require.Equal(t, 10*time.Second, b.Failure(0))
+		require.Equal(t, 10*time.Second, b.Failure(0))
+		require.Equal(t, 6, b.Failures())
+	})
+	t.Run("jitter", func(t *testing.T) {
+		require.Equal(t, 500*time.Millisecond, newTestBackoff(0).Failure(0))
+		require.Equal(t, 1500*time.Millisecond, newTestBackoff(1).Failure(0))
+	})
+	t.Run("jitterDisabled", func(t *testing.T) {
+		b := New(Config{Initial: 1 * time.Second, Jitter: -1})
+		b.randFloat = func() float64 { return 0 }
+		require.Equal(t, 1*time.Second, b.Failure(0))
+		require.Equal(t, 2*time.Second, b.Failure(0))
+	})
+	t.Run("reset", func(t *testing.T) {
+		b := newTestBackoff(0.5)
+		b.Failure(0)
+		b.Failure(0)
+		require.Equal(t, 1*time.Second, b.Failure(2*time.Minute))
+		require.Equal(t, 1, b.Failures())
+	})
+	t.Run("crashLoop", func(t *testing.T) {
+		b := newTestBackoff(0.5)
+		b.Failure(0)
+		b.Failure(0)
+		require.False(t, b.CrashLoopDetected())
+		require.False(t, b.CrashLooping())
+
+		b.Failure(0)
+		require.True(t, b.CrashLoopDetected())
+		require.True(t, b.CrashLooping())
+
+		b.Failure(0)
+		require.False(t, b.CrashLoopDetected())
+		require.True(t, b.CrashLooping())
+	})
+	t.Run("overflow", func(t *testing.T) {
+		b := newTestBackoff(0.5)
+		for i := 0; i < 2000; i++ {
+			b.Failure(0)
+		}
+		require.Equal(t, 10*time.Second, b.Failure(0))
+	})
+}
+
+func TestFillMissing(t *testing.T) {
+	c := Config{Initial: 5 * time.Second}
+	c.FillMissing()
+	expected := Config{
+		Initial:            5 * time.Second,
+		Max:                2 * time.Minute,
+		Multiplier:         2,
+		Jitter:             0.2,
+		ResetAfter:         1 * time.Minute,
+		CrashLoopThreshold: 10,
+	}
+	require.Equal(t, expected, c)
+}
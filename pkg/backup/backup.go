@@ -0,0 +1,482 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+// Package backup creates and restores configuration archives.
+//
+// An archive is a gzipped tarball with the following files, paths are
+// relative to the config directory. Monitors and groups are restored as
+// a whole, monitor or group configs that aren't in the archive are removed.
+// The previous version of each restored file is kept as "<file>.bak".
+//
+//	env.yaml
+//	general.json
+//	users.json
+//	doods.json  (addon configs, see RegisterConfigFile)
+//	monitors/*.json
+//	groups/*.json
+//
+// Other files in the config directory are never archived or restored.
+// This keeps secrets like TOTP keys and API keys out of the archives.
+package backup
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"nvr/pkg/configstore"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Files archive content by path.
+type Files map[string][]byte
+
+// Directories that are restored as a whole.
+var dirs = []string{"monitors", "groups"}
+
+// Files in the root of the config directory that are backed up.
+var configFiles = map[string]bool{
+	envFile:        true,
+	"general.json": true,
+	"users.json":   true,
+}
+
+// RegisterConfigFile adds a file in the root of the config directory
+// to the archives. Addons should call this from their init function.
+func RegisterConfigFile(name string) {
+	configFiles[name] = true
+}
+
+const (
+	envFile = "env.yaml"
+
+	// Archive that is restored on the next start, see Schedule.
+	pendingFile = ".pending-restore.tar.gz"
+
+	backupSuffix = configstore.BackupSuffix
+)
+
+// Limits when reading archives.
+const (
+	maxFileSize  = 10 * 1000 * 1000
+	maxFileCount = 10000
+)
+
+// Errors.
+var (
+	ErrInvalidPath   = errors.New("invalid path")
+	ErrFileTooLarge  = errors.New("file too large")
+	ErrTooManyFiles  = errors.New("too many files")
+	ErrMissingEnv    = errors.New("env.yaml missing")
+	ErrIDMismatch    = errors.New("id does not match file name")
+	ErrEmptyArchive  = errors.New("empty archive")
+	ErrDuplicateFile = errors.New("duplicate file")
+)
+
+// validPath returns true if the path should be included in the archive.
+func validPath(p string) bool {
+	if p != path.Clean(p) || strings.HasPrefix(p, "/") || strings.Contains(p, "..") {
+		return false
+	}
+	dir, name := path.Split(p)
+	if strings.HasPrefix(name, ".") {
+		return false
+	}
+	switch dir {
+	case "":
+		return configFiles[name]
+	case "monitors/", "groups/":
+		return path.Ext(name) == ".json"
+	}
+	return false
+}
+
+// Collect reads the files that should be backed up from the config directory.
+func Collect(configDir string) (Files, error) {
+	files := make(Files)
+	fileSystem := os.DirFS(configDir)
+	walkFunc := func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if p != "." && !contains(dirs, p) {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if !validPath(p) {
+			return nil
+		}
+		file, err := fs.ReadFile(fileSystem, p)
+		if err != nil {
+			return fmt.Errorf("read file: %v %w", p, err)
+		}
+		files[p] = file
+		return nil
+	}
+	if err := fs.WalkDir(fileSystem, ".", walkFunc); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// Create writes an archive of the config directory to w.
+func Create(w io.Writer, configDir string) error {
+	files, err := Collect(configDir)
+	if err != nil {
+		return fmt.Errorf("collect files: %w", err)
+	}
+	return files.write(w)
+}
+
+func (files Files) write(w io.Writer) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	now := time.Now()
+	for _, p := range files.paths() {
+		header := &tar.Header{
+			Name:    p,
+			Mode:    0o600,
+			Size:    int64(len(files[p])),
+			ModTime: now,
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return fmt.Errorf("write header: %w", err)
+		}
+		if _, err := tw.Write(files[p]); err != nil {
+			return fmt.Errorf("write file: %w", err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// Read reads and verifies the paths of an archive.
+func Read(r io.Reader) (Files, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("gzip: %w", err)
+	}
+	defer gz.Close()
+
+	files := make(Files)
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("tar: %w", err)
+		}
+		if header.Typeflag == tar.TypeDir {
+			continue
+		}
+		if header.Typeflag != tar.TypeReg || !validPath(header.Name) {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidPath, header.Name)
+		}
+		if header.Size > maxFileSize {
+			return nil, fmt.Errorf("%w: %v", ErrFileTooLarge, header.Name)
+		}
+		if len(files) >= maxFileCount {
+			return nil, ErrTooManyFiles
+		}
+		if _, exists := files[header.Name]; exists {
+			return nil, fmt.Errorf("%w: %v", ErrDuplicateFile, header.Name)
+		}
+
+		file, err := io.ReadAll(io.LimitReader(tr, maxFileSize))
+		if err != nil {
+			return nil, fmt.Errorf("read %v: %w", header.Name, err)
+		}
+		files[header.Name] = file
+	}
+	if len(files) == 0 {
+		return nil, ErrEmptyArchive
+	}
+	return files, nil
+}
+
+// MigrateFunc monitor config migration hook.
+type MigrateFunc func(map[string]string) error
+
+// Validate checks that the files can be parsed and
+// that the monitor configs can be migrated.
+func (files Files) Validate(migrate MigrateFunc) error {
+	env, exists := files[envFile]
+	if !exists {
+		return ErrMissingEnv
+	}
+	var envMap map[string]interface{}
+	if err := yaml.Unmarshal(env, &envMap); err != nil {
+		return fmt.Errorf("env.yaml: %w", err)
+	}
+
+	for _, p := range files.paths() {
+		if path.Ext(p) != ".json" {
+			continue
+		}
+		dir, name := path.Split(p)
+		id := strings.TrimSuffix(name, ".json")
+		switch dir {
+		case "monitors/":
+			var c map[string]string
+			if err := json.Unmarshal(files[p], &c); err != nil {
+				return fmt.Errorf("%v: %w", p, err)
+			}
+			if c["id"] != id {
+				return fmt.Errorf("%v: %w", p, ErrIDMismatch)
+			}
+			if err := migrate(c); err != nil {
+				return fmt.Errorf("%v: migrate: %w", p, err)
+			}
+		case "groups/":
+			var c map[string]string
+			if err := json.Unmarshal(files[p], &c); err != nil {
+				return fmt.Errorf("%v: %w", p, err)
+			}
+			if c["id"] != id {
+				return fmt.Errorf("%v: %w", p, ErrIDMismatch)
+			}
+		default:
+			if !json.Valid(files[p]) {
+				return fmt.Errorf("%v: invalid json", p) //nolint:goerr113
+			}
+		}
+	}
+	return nil
+}
+
+func (files Files) paths() []string {
+	paths := make([]string, 0, len(files))
+	for p := range files {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// Change actions.
+const (
+	ActionAdd    = "add"
+	ActionModify = "modify"
+	ActionDelete = "delete"
+)
+
+// Change to a single file.
+type Change struct {
+	Path   string `json:"path"`
+	Action string `json:"action"`
+}
+
+// Diff returns the changes that restoring the files would make.
+func (files Files) Diff(configDir string) ([]Change, error) {
+	current, err := Collect(configDir)
+	if err != nil {
+		return nil, fmt.Errorf("collect files: %w", err)
+	}
+
+	var changes []Change
+	for _, p := range files.paths() {
+		old, exists := current[p]
+		switch {
+		case !exists:
+			changes = append(changes, Change{Path: p, Action: ActionAdd})
+		case !bytes.Equal(old, files[p]):
+			changes = append(changes, Change{Path: p, Action: ActionModify})
+		}
+	}
+	for _, p := range current.paths() {
+		if _, exists := files[p]; exists || !inDirs(p) {
+			continue
+		}
+		changes = append(changes, Change{Path: p, Action: ActionDelete})
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Path < changes[j].Path
+	})
+	return changes, nil
+}
+
+func inDirs(p string) bool {
+	dir := path.Dir(p)
+	return contains(dirs, dir)
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// WithoutEnv returns the files without env.yaml. The env sets the paths of
+// the executables that the app runs, it's only restored from the command line.
+func (files Files) WithoutEnv() Files {
+	files2 := make(Files, len(files))
+	for p, file := range files {
+		if p != envFile {
+			files2[p] = file
+		}
+	}
+	return files2
+}
+
+// Schedule saves the files to be restored by RestorePending on the next
+// start. The managers of a running app keep their configs in memory and
+// would overwrite the restored files. A previously scheduled restore is
+// replaced.
+func (files Files) Schedule(configDir string) error {
+	tmp, err := os.CreateTemp(configDir, pendingFile+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("create temporary file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := files.write(tmp); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write archive: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("sync archive: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(configDir, pendingFile))
+}
+
+// RestorePending restores the files that were scheduled by Schedule and
+// returns true if there were any. The scheduled archive is removed even
+// if the restore fails, the app would otherwise be unable to start.
+func RestorePending(configDir string) (bool, error) {
+	pendingPath := filepath.Join(configDir, pendingFile)
+	file, err := os.Open(pendingPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer os.Remove(pendingPath)
+	defer file.Close()
+
+	files, err := Read(file)
+	if err != nil {
+		return false, fmt.Errorf("read archive: %w", err)
+	}
+	if err := files.Restore(configDir); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Restore writes the files to the config directory. All files are written
+// to a staging directory first and are then moved into place. If moving
+// any file fails, the previous files are put back.
+func (files Files) Restore(configDir string) error {
+	staging, err := os.MkdirTemp(configDir, ".restore-")
+	if err != nil {
+		return fmt.Errorf("create staging dir: %w", err)
+	}
+	defer os.RemoveAll(staging)
+
+	// Stage files.
+	for _, dir := range dirs {
+		if err := os.Mkdir(filepath.Join(staging, dir), 0o700); err != nil {
+			return fmt.Errorf("create staging dir: %w", err)
+		}
+	}
+	backups := make(map[string]struct{})
+	for p, file := range files {
+		err := os.WriteFile(filepath.Join(staging, filepath.FromSlash(p)), file, 0o600)
+		if err != nil {
+			return fmt.Errorf("stage file: %w", err)
+		}
+
+		// The current version becomes the backup, or the current backup
+		// is kept. Deleted configs don't get a backup, configstore.List
+		// would otherwise bring them back.
+		backup, err := os.ReadFile(filepath.Join(configDir, filepath.FromSlash(p)))
+		if errors.Is(err, os.ErrNotExist) {
+			backup, err = os.ReadFile(filepath.Join(configDir, filepath.FromSlash(p+backupSuffix)))
+		}
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("read current file: %w", err)
+		}
+		err = os.WriteFile(filepath.Join(staging, filepath.FromSlash(p+backupSuffix)), backup, 0o600)
+		if err != nil {
+			return fmt.Errorf("stage backup: %w", err)
+		}
+		backups[p+backupSuffix] = struct{}{}
+	}
+
+	// Top level files and whole directories are swapped.
+	targets := append([]string{}, dirs...)
+	for p := range files {
+		if !inDirs(p) {
+			targets = append(targets, p)
+		}
+	}
+	for p := range backups {
+		if !inDirs(p) {
+			targets = append(targets, p)
+		}
+	}
+	sort.Strings(targets)
+
+	old := filepath.Join(staging, ".old")
+	if err := os.Mkdir(old, 0o700); err != nil {
+		return fmt.Errorf("create old dir: %w", err)
+	}
+
+	type move struct{ from, to string }
+	var done []move
+	rollback := func() {
+		for i := len(done) - 1; i >= 0; i-- {
+			os.Rename(done[i].to, done[i].from) //nolint:errcheck
+		}
+	}
+	rename := func(from, to string) error {
+		if err := os.Rename(from, to); err != nil {
+			return err
+		}
+		done = append(done, move{from, to})
+		return nil
+	}
+
+	for _, target := range targets {
+		targetPath := filepath.Join(configDir, target)
+		err := rename(targetPath, filepath.Join(old, target))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			rollback()
+			return fmt.Errorf("move old file: %w", err)
+		}
+		err = rename(filepath.Join(staging, target), targetPath)
+		if err != nil {
+			rollback()
+			return fmt.Errorf("move new file: %w", err)
+		}
+	}
+	return nil
+}
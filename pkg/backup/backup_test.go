@@ -0,0 +1,266 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package backup
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func init() {
+	RegisterConfigFile("doods.json")
+}
+
+func writeFiles(t *testing.T, dir string, files Files) {
+	t.Helper()
+	for p, file := range files {
+		path := filepath.Join(dir, p)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o700))
+		require.NoError(t, os.WriteFile(path, file, 0o600))
+	}
+}
+
+func testFiles() Files {
+	return Files{
+		"env.yaml":        []byte("port: 2020\n"),
+		"general.json":    []byte(`{"theme":"default"}`),
+		"users.json":      []byte(`{}`),
+		"doods.json":      []byte(`{}`),
+		"monitors/1.json": []byte(`{"id":"1"}`),
+		"monitors/2.json": []byte(`{"id":"2"}`),
+		"groups/a.json":   []byte(`{"id":"a"}`),
+	}
+}
+
+func newTarGz(t *testing.T, files map[string]string) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for name, content := range files {
+		require.NoError(t, tw.WriteHeader(&tar.Header{
+			Name: name,
+			Mode: 0o600,
+			Size: int64(len(content)),
+		}))
+		_, err := tw.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	require.NoError(t, gz.Close())
+	return &buf
+}
+
+func TestCreateRead(t *testing.T) {
+	configDir := t.TempDir()
+	writeFiles(t, configDir, testFiles())
+	writeFiles(t, configDir, Files{
+		"other.txt":          []byte("x"),
+		"totp.json":          []byte("x"),
+		"apikeys.json":       []byte("x"),
+		"storage/x.json":     []byte("x"),
+		".restore-1/a.json":  []byte("x"),
+		"monitors/sub/.json": []byte("x"),
+	})
+
+	var buf bytes.Buffer
+	require.NoError(t, Create(&buf, configDir))
+
+	files, err := Read(&buf)
+	require.NoError(t, err)
+	require.Equal(t, testFiles(), files)
+}
+
+func TestRead(t *testing.T) {
+	cases := map[string]map[string]string{
+		"dotDot":   {"../env.yaml": ""},
+		"absolute": {"/env.yaml": ""},
+		"subDir":   {"monitors/a/1.json": ""},
+		"unknown":  {"storage/1.json": ""},
+		"notJSON":  {"monitors/1.txt": ""},
+		"secrets":  {"totp.json": ""},
+	}
+	for name, files := range cases {
+		t.Run(name, func(t *testing.T) {
+			_, err := Read(newTarGz(t, files))
+			require.ErrorIs(t, err, ErrInvalidPath)
+		})
+	}
+	t.Run("empty", func(t *testing.T) {
+		_, err := Read(newTarGz(t, nil))
+		require.ErrorIs(t, err, ErrEmptyArchive)
+	})
+	t.Run("notGzip", func(t *testing.T) {
+		_, err := Read(bytes.NewReader([]byte("x")))
+		require.Error(t, err)
+	})
+}
+
+func TestValidate(t *testing.T) {
+	noop := func(map[string]string) error { return nil }
+	errMigrate := errors.New("mock")
+
+	t.Run("ok", func(t *testing.T) {
+		migrated := 0
+		err := testFiles().Validate(func(map[string]string) error {
+			migrated++
+			return nil
+		})
+		require.NoError(t, err)
+		require.Equal(t, 2, migrated)
+	})
+	t.Run("missingEnv", func(t *testing.T) {
+		files := testFiles()
+		delete(files, "env.yaml")
+		require.ErrorIs(t, files.Validate(noop), ErrMissingEnv)
+	})
+	t.Run("idMismatch", func(t *testing.T) {
+		files := testFiles()
+		files["monitors/3.json"] = []byte(`{"id":"1"}`)
+		require.ErrorIs(t, files.Validate(noop), ErrIDMismatch)
+	})
+	t.Run("invalidJSON", func(t *testing.T) {
+		files := testFiles()
+		files["general.json"] = []byte(`{`)
+		require.Error(t, files.Validate(noop))
+	})
+	t.Run("migrateErr", func(t *testing.T) {
+		err := testFiles().Validate(func(map[string]string) error {
+			return errMigrate
+		})
+		require.ErrorIs(t, err, errMigrate)
+	})
+}
+
+func TestDiffRestore(t *testing.T) {
+	configDir := t.TempDir()
+	writeFiles(t, configDir, Files{
+		"env.yaml":        []byte("port: 2020\n"),
+		"general.json":    []byte(`{"theme":"dark"}`),
+		"users.json":      []byte(`{}`),
+		"monitors/1.json": []byte(`{"id":"1"}`),
+		"monitors/3.json": []byte(`{"id":"3"}`),
+		"totp.json":       []byte(`{}`),
+	})
+
+	files := testFiles()
+	changes, err := files.Diff(configDir)
+	require.NoError(t, err)
+	expected := []Change{
+		{Path: "doods.json", Action: ActionAdd},
+		{Path: "general.json", Action: ActionModify},
+		{Path: "groups/a.json", Action: ActionAdd},
+		{Path: "monitors/2.json", Action: ActionAdd},
+		{Path: "monitors/3.json", Action: ActionDelete},
+	}
+	require.Equal(t, expected, changes)
+
+	require.NoError(t, files.Restore(configDir))
+
+	restored, err := Collect(configDir)
+	require.NoError(t, err)
+	require.Equal(t, testFiles(), restored)
+
+	// Files that aren't backed up are kept.
+	totp, err := os.ReadFile(filepath.Join(configDir, "totp.json"))
+	require.NoError(t, err)
+	require.Equal(t, []byte(`{}`), totp)
+
+	changes, err = files.Diff(configDir)
+	require.NoError(t, err)
+	require.Empty(t, changes)
+
+	// The previous versions are kept as backups.
+	backup, err := os.ReadFile(filepath.Join(configDir, "general.json.bak"))
+	require.NoError(t, err)
+	require.Equal(t, `{"theme":"dark"}`, string(backup))
+	require.FileExists(t, filepath.Join(configDir, "monitors", "1.json.bak"))
+	require.NoFileExists(t, filepath.Join(configDir, "monitors", "3.json.bak"))
+
+	// Staging directory was removed.
+	entries, err := os.ReadDir(configDir)
+	require.NoError(t, err)
+	for _, entry := range entries {
+		require.NotContains(t, entry.Name(), ".restore")
+	}
+}
+
+func TestDiffRestoreKeepBackup(t *testing.T) {
+	configDir := t.TempDir()
+	writeFiles(t, configDir, Files{
+		"monitors/1.json.bak": []byte(`{"id":"1","name":"old"}`),
+	})
+
+	require.NoError(t, Files{"monitors/1.json": []byte(`{"id":"1"}`)}.Restore(configDir))
+
+	backup, err := os.ReadFile(filepath.Join(configDir, "monitors", "1.json.bak"))
+	require.NoError(t, err)
+	require.Equal(t, `{"id":"1","name":"old"}`, string(backup))
+}
+
+func TestSchedule(t *testing.T) {
+	configDir := t.TempDir()
+	writeFiles(t, configDir, Files{
+		"env.yaml":        []byte("port: 2021\n"),
+		"monitors/3.json": []byte(`{"id":"3"}`),
+	})
+
+	files := testFiles().WithoutEnv()
+	require.NotContains(t, files, "env.yaml")
+	require.NoError(t, files.Schedule(configDir))
+
+	// Nothing is restored until the next start.
+	changes, err := files.Diff(configDir)
+	require.NoError(t, err)
+	require.NotEmpty(t, changes)
+
+	restored, err := RestorePending(configDir)
+	require.NoError(t, err)
+	require.True(t, restored)
+
+	changes, err = files.Diff(configDir)
+	require.NoError(t, err)
+	require.Empty(t, changes)
+
+	env, err := os.ReadFile(filepath.Join(configDir, "env.yaml"))
+	require.NoError(t, err)
+	require.Equal(t, "port: 2021\n", string(env))
+
+	restored, err = RestorePending(configDir)
+	require.NoError(t, err)
+	require.False(t, restored)
+}
+
+func TestRotate(t *testing.T) {
+	configDir := t.TempDir()
+	writeFiles(t, configDir, testFiles())
+	backupDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(backupDir, "other"), nil, 0o600))
+
+	start := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 4; i++ {
+		now := start.Add(time.Duration(i) * time.Hour)
+		require.NoError(t, save(Config{Keep: 2}, configDir, backupDir, now))
+	}
+
+	entries, err := os.ReadDir(backupDir)
+	require.NoError(t, err)
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	expected := []string{
+		"nvr-backup_2000-01-01_02-00-00.tar.gz",
+		"nvr-backup_2000-01-01_03-00-00.tar.gz",
+		"other",
+	}
+	require.Equal(t, expected, names)
+}
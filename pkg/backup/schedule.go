@@ -0,0 +1,117 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package backup
+
+import (
+	"context"
+	"fmt"
+	"nvr/pkg/log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Config automatic backup configuration.
+type Config struct {
+	// Time between automatic backups. Zero disables automatic backups.
+	Interval time.Duration `yaml:"interval"`
+
+	// Number of automatic backups to keep.
+	Keep int `yaml:"keep"`
+}
+
+// FillMissing sets default values for unset fields.
+func (c *Config) FillMissing() {
+	if c.Keep == 0 {
+		c.Keep = 7
+	}
+}
+
+const (
+	filePrefix = "nvr-backup_"
+	fileSuffix = ".tar.gz"
+	timeFormat = "2006-01-02_15-04-05"
+)
+
+// FileName returns the file name of a backup created at t.
+func FileName(t time.Time) string {
+	return filePrefix + t.UTC().Format(timeFormat) + fileSuffix
+}
+
+// Loop creates a backup in backupDir every interval and removes
+// old backups, until the context is canceled.
+func Loop(ctx context.Context, c Config, configDir, backupDir string, logf log.Func) {
+	if c.Interval == 0 {
+		return
+	}
+	ticker := time.NewTicker(c.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := save(c, configDir, backupDir, time.Now()); err != nil {
+				logf(log.LevelError, "automatic backup failed: %v", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func save(c Config, configDir, backupDir string, now time.Time) error {
+	if err := os.MkdirAll(backupDir, 0o700); err != nil {
+		return fmt.Errorf("create backup dir: %w", err)
+	}
+
+	// Write to a temporary file so that a failed
+	// backup doesn't leave a partial archive.
+	path := filepath.Join(backupDir, FileName(now))
+	tmpPath := path + ".tmp"
+	file, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	if err := Create(file, configDir); err != nil {
+		file.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return err
+	}
+
+	return rotate(backupDir, c.Keep)
+}
+
+// rotate removes the oldest backups until keep are left.
+func rotate(backupDir string, keep int) error {
+	entries, err := os.ReadDir(backupDir)
+	if err != nil {
+		return err
+	}
+	var backups []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, filePrefix) && strings.HasSuffix(name, fileSuffix) {
+			backups = append(backups, name)
+		}
+	}
+	if len(backups) <= keep {
+		return nil
+	}
+
+	// The timestamp format sorts chronologically.
+	sort.Strings(backups)
+	for _, name := range backups[:len(backups)-keep] {
+		if err := os.Remove(filepath.Join(backupDir, name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
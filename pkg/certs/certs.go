@@ -0,0 +1,243 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+// Package certs manages the TLS certificate of the HTTP servers.
+package certs
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"nvr/pkg/log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Config TLS configuration.
+type Config struct {
+	// Serve the app over HTTPS.
+	Enable bool `yaml:"enable"`
+
+	// PEM encoded certificate and private key. A self-signed
+	// certificate is generated in "<configDir>/tls" if unset.
+	CertFile string `yaml:"certFile"`
+	KeyFile  string `yaml:"keyFile"`
+
+	// Port of the optional listener that redirects HTTP to HTTPS. Zero disables.
+	RedirectPort int `yaml:"redirectPort"`
+
+	// Also serve HLS over TLS.
+	HLS bool `yaml:"hls"`
+}
+
+// ErrKeyFileMissing only one of certFile and keyFile is set.
+var ErrKeyFileMissing = errors.New("certFile and keyFile must both be set")
+
+// Validate returns an error if the config is invalid.
+func (c Config) Validate() error {
+	if (c.CertFile == "") != (c.KeyFile == "") {
+		return ErrKeyFileMissing
+	}
+	return nil
+}
+
+// Manager loads the certificate and reloads it when the files change.
+type Manager struct {
+	certFile string
+	keyFile  string
+	logf     log.Func
+
+	cert    *tls.Certificate
+	modTime time.Time
+	mu      sync.Mutex
+}
+
+// NewManager loads the certificate, a self-signed
+// certificate is generated if no files are configured.
+func NewManager(c Config, configDir string, logf log.Func) (*Manager, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	m := &Manager{certFile: c.CertFile, keyFile: c.KeyFile, logf: logf}
+	if m.certFile == "" {
+		dir := filepath.Join(configDir, "tls")
+		m.certFile = filepath.Join(dir, "cert.pem")
+		m.keyFile = filepath.Join(dir, "key.pem")
+		if err := generateIfMissing(m.certFile, m.keyFile); err != nil {
+			return nil, fmt.Errorf("generate self-signed certificate: %w", err)
+		}
+	}
+	if _, err := m.Reload(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// TLSConfig returns a server config that uses the current certificate.
+func (m *Manager) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: m.getCertificate,
+	}
+}
+
+func (m *Manager) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.cert, nil
+}
+
+// Reload loads the certificate if the files have been modified
+// since the last load. Returns true if the certificate was loaded.
+// The current certificate is kept if the new one is invalid.
+func (m *Manager) Reload() (bool, error) {
+	modTime, err := m.lastModified()
+	if err != nil {
+		return false, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.cert != nil && modTime.Equal(m.modTime) {
+		return false, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(m.certFile, m.keyFile)
+	if err != nil {
+		return false, fmt.Errorf("load certificate: %w", err)
+	}
+	m.cert = &cert
+	m.modTime = modTime
+	return true, nil
+}
+
+// lastModified returns the latest modification time of the files.
+func (m *Manager) lastModified() (time.Time, error) {
+	var latest time.Time
+	for _, path := range []string{m.certFile, m.keyFile} {
+		info, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("stat certificate: %w", err)
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// Run reloads the certificate every interval if it has
+// changed, or on SIGHUP, until the context is canceled.
+func (m *Manager) Run(ctx context.Context, interval time.Duration) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-hup:
+			m.logf(log.LevelInfo, "tls: received SIGHUP, reloading certificate")
+			// Force reload even if the modification time is unchanged.
+			m.mu.Lock()
+			m.modTime = time.Time{}
+			m.mu.Unlock()
+		case <-ctx.Done():
+			return
+		}
+		reloaded, err := m.Reload()
+		if err != nil {
+			m.logf(log.LevelError, "tls: could not reload certificate: %v", err)
+			continue
+		}
+		if reloaded {
+			m.logf(log.LevelInfo, "tls: certificate reloaded")
+		}
+	}
+}
+
+const selfSignedValidity = 10 * 365 * 24 * time.Hour
+
+func generateIfMissing(certFile, keyFile string) error {
+	_, certErr := os.Stat(certFile)
+	_, keyErr := os.Stat(keyFile)
+	if certErr == nil && keyErr == nil {
+		return nil
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return fmt.Errorf("generate key: %w", err)
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return fmt.Errorf("generate serial number: %w", err)
+	}
+
+	hostname, _ := os.Hostname()
+	dnsNames := []string{"localhost"}
+	if hostname != "" && hostname != "localhost" {
+		dnsNames = append(dnsNames, hostname)
+	}
+
+	now := time.Now()
+	template := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"OS-NVR"}, CommonName: "OS-NVR"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(selfSignedValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		DNSNames:              dnsNames,
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return fmt.Errorf("create certificate: %w", err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return fmt.Errorf("marshal key: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(certFile), 0o700); err != nil {
+		return err
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+	if err := os.WriteFile(keyFile, keyPEM, 0o600); err != nil {
+		return err
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	return os.WriteFile(certFile, certPEM, 0o600)
+}
+
+// RedirectHandler redirects HTTP requests to HTTPS on port.
+func RedirectHandler(port int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = r.Host
+		}
+		u := *r.URL
+		u.Scheme = "https"
+		u.Host = net.JoinHostPort(host, strconv.Itoa(port))
+		http.Redirect(w, r, u.String(), http.StatusMovedPermanently)
+	})
+}
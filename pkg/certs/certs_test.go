@@ -0,0 +1,92 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package certs
+
+import (
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"nvr/pkg/log"
+
+	"github.com/stretchr/testify/require"
+)
+
+func nopLogf(log.Level, string, ...interface{}) {}
+
+func leaf(t *testing.T, m *Manager) *x509.Certificate {
+	t.Helper()
+	cert, err := m.TLSConfig().GetCertificate(nil)
+	require.NoError(t, err)
+	parsed, err := x509.ParseCertificate(cert.Certificate[0])
+	require.NoError(t, err)
+	return parsed
+}
+
+func TestManager(t *testing.T) {
+	t.Run("selfSigned", func(t *testing.T) {
+		configDir := t.TempDir()
+		m, err := NewManager(Config{Enable: true}, configDir, nopLogf)
+		require.NoError(t, err)
+		cert := leaf(t, m)
+		require.Contains(t, cert.DNSNames, "localhost")
+		require.FileExists(t, filepath.Join(configDir, "tls", "cert.pem"))
+
+		// The generated certificate is reused.
+		m2, err := NewManager(Config{Enable: true}, configDir, nopLogf)
+		require.NoError(t, err)
+		require.Equal(t, cert.SerialNumber, leaf(t, m2).SerialNumber)
+	})
+	t.Run("reload", func(t *testing.T) {
+		dir := t.TempDir()
+		certFile := filepath.Join(dir, "cert.pem")
+		keyFile := filepath.Join(dir, "key.pem")
+		require.NoError(t, generateIfMissing(certFile, keyFile))
+
+		c := Config{Enable: true, CertFile: certFile, KeyFile: keyFile}
+		m, err := NewManager(c, "", nopLogf)
+		require.NoError(t, err)
+		serial := leaf(t, m).SerialNumber
+
+		reloaded, err := m.Reload()
+		require.NoError(t, err)
+		require.False(t, reloaded)
+
+		// Replace the certificate.
+		require.NoError(t, os.Remove(certFile))
+		require.NoError(t, os.Remove(keyFile))
+		require.NoError(t, generateIfMissing(certFile, keyFile))
+		future := time.Now().Add(time.Hour)
+		require.NoError(t, os.Chtimes(certFile, future, future))
+
+		reloaded, err = m.Reload()
+		require.NoError(t, err)
+		require.True(t, reloaded)
+		require.NotEqual(t, serial, leaf(t, m).SerialNumber)
+		serial = leaf(t, m).SerialNumber
+
+		// Invalid certificates are ignored.
+		require.NoError(t, os.WriteFile(certFile, []byte("x"), 0o600))
+		future = future.Add(time.Hour)
+		require.NoError(t, os.Chtimes(certFile, future, future))
+		_, err = m.Reload()
+		require.Error(t, err)
+		require.Equal(t, serial, leaf(t, m).SerialNumber)
+	})
+	t.Run("keyMissing", func(t *testing.T) {
+		_, err := NewManager(Config{CertFile: "cert.pem"}, t.TempDir(), nopLogf)
+		require.ErrorIs(t, err, ErrKeyFileMissing)
+	})
+}
+
+func TestRedirectHandler(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "http://nvr.lan:80/live?a=b", nil)
+	w := httptest.NewRecorder()
+	RedirectHandler(2020).ServeHTTP(w, r)
+	require.Equal(t, http.StatusMovedPermanently, w.Code)
+	require.Equal(t, "https://nvr.lan:2020/live?a=b", w.Header().Get("Location"))
+}
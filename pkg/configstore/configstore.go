@@ -0,0 +1,206 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+// Package configstore reads and writes JSON config files without
+// leaving a partially written file behind if the system crashes.
+//
+// Files are written to a temporary file that is synced to disk and
+// then renamed over the old file, the file is never missing. The
+// previous version is kept as "<file>.bak" and is used if the file
+// is missing or can't be read.
+package configstore
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// BackupSuffix is appended to the path of the previous version of a file.
+const BackupSuffix = ".bak"
+
+// WriteFile atomically replaces the file at path with data.
+// The current file, if any, is kept as a backup.
+func WriteFile(path string, data []byte) error {
+	return write(path, data, true)
+}
+
+// WriteJSON marshals v and writes it to path.
+func WriteJSON(path string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "    ")
+	if err != nil {
+		return fmt.Errorf("marshal: %w", err)
+	}
+	return WriteFile(path, data)
+}
+
+func write(path string, data []byte, keepBackup bool) error {
+	dir, name := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+
+	// Temporary files are hidden so that they are
+	// ignored by the directory readers.
+	tmp, err := os.CreateTemp(dir, "."+name+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("create temporary file: %w", err)
+	}
+	tmpPath := tmp.Name()
+	defer os.Remove(tmpPath)
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write temporary file: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("sync temporary file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("close temporary file: %w", err)
+	}
+
+	if keepBackup {
+		if err := backupFile(dir, name); err != nil {
+			return fmt.Errorf("backup old file: %w", err)
+		}
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("rename temporary file: %w", err)
+	}
+	return syncDir(dir)
+}
+
+// backupFile replaces the backup with a hard link to the current file.
+// The file is copied if the file system doesn't support hard links.
+func backupFile(dir string, name string) error {
+	path := filepath.Join(dir, name)
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	tmp, err := os.CreateTemp(dir, "."+name+BackupSuffix+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+	tmp.Close()
+	defer os.Remove(tmpPath)
+
+	// The link can't replace an existing file.
+	if err := os.Remove(tmpPath); err != nil {
+		return err
+	}
+	if err := os.Link(path, tmpPath); err != nil {
+		if err := copyFile(path, tmpPath); err != nil {
+			return err
+		}
+	}
+	return os.Rename(tmpPath, path+BackupSuffix)
+}
+
+func copyFile(src string, dst string) error {
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// syncDir makes the renames durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("sync directory: %w", err)
+	}
+	return nil
+}
+
+// ReadJSON reads the file at path and unmarshals it into v. The backup
+// is used and restored if the file is missing or can't be unmarshaled.
+// The error from the original file is returned if both fail.
+func ReadJSON(path string, v interface{}) error {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		err = json.Unmarshal(data, v)
+		if err == nil {
+			return nil
+		}
+		err = fmt.Errorf("unmarshal %v: %w", path, err)
+	}
+
+	backup, err2 := os.ReadFile(path + BackupSuffix)
+	if err2 != nil {
+		return err
+	}
+	if err2 := json.Unmarshal(backup, v); err2 != nil {
+		return err
+	}
+
+	// Restore the backup without overwriting it with the broken file.
+	if err := write(path, backup, false); err != nil {
+		return fmt.Errorf("restore backup: %w", err)
+	}
+	return nil
+}
+
+// Remove removes the file at path and its backup.
+func Remove(path string) error {
+	err := os.Remove(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	err2 := os.Remove(path + BackupSuffix)
+	if err2 != nil && !errors.Is(err2, os.ErrNotExist) {
+		return err2
+	}
+	return err
+}
+
+// List returns the sorted paths of the JSON files in dir. Files that
+// only have a backup are included, ReadJSON will restore them.
+func List(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[string]struct{})
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || strings.HasPrefix(name, ".") {
+			continue
+		}
+		name = strings.TrimSuffix(name, BackupSuffix)
+		if filepath.Ext(name) != ".json" {
+			continue
+		}
+		names[name] = struct{}{}
+	}
+
+	paths := make([]string, 0, len(names))
+	for name := range names {
+		paths = append(paths, filepath.Join(dir, name))
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
@@ -0,0 +1,162 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package configstore
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func listFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	return names
+}
+
+func TestWriteFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "x.json")
+
+	require.NoError(t, WriteFile(path, []byte("1")))
+	require.Equal(t, []string{"x.json"}, listFiles(t, dir))
+
+	require.NoError(t, WriteFile(path, []byte("2")))
+	require.Equal(t, []string{"x.json", "x.json.bak"}, listFiles(t, dir))
+
+	require.NoError(t, WriteFile(path, []byte("3")))
+	file, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, "3", string(file))
+
+	backup, err := os.ReadFile(path + BackupSuffix)
+	require.NoError(t, err)
+	require.Equal(t, "2", string(backup))
+	require.Equal(t, []string{"x.json", "x.json.bak"}, listFiles(t, dir))
+}
+
+func TestWriteFileNeverMissing(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "x.json")
+	require.NoError(t, WriteFile(path, []byte("0")))
+
+	done := make(chan struct{})
+	missing := make(chan struct{}, 1)
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			if _, err := os.ReadFile(path); errors.Is(err, os.ErrNotExist) {
+				select {
+				case missing <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}()
+	for i := 0; i < 200; i++ {
+		require.NoError(t, WriteFile(path, []byte("1")))
+	}
+	close(done)
+	require.Empty(t, missing)
+}
+
+func TestCopyFile(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "a")
+	dst := filepath.Join(dir, "b")
+	require.NoError(t, os.WriteFile(src, []byte("x"), 0o600))
+	require.NoError(t, copyFile(src, dst))
+
+	file, err := os.ReadFile(dst)
+	require.NoError(t, err)
+	require.Equal(t, "x", string(file))
+}
+
+func TestReadJSON(t *testing.T) {
+	t.Run("ok", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "x.json")
+		require.NoError(t, WriteJSON(path, map[string]string{"a": "1"}))
+
+		var v map[string]string
+		require.NoError(t, ReadJSON(path, &v))
+		require.Equal(t, map[string]string{"a": "1"}, v)
+	})
+	t.Run("corrupted", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "x.json")
+		require.NoError(t, WriteJSON(path, map[string]string{"a": "1"}))
+		require.NoError(t, WriteFile(path, []byte(`{"a":`)))
+
+		var v map[string]string
+		require.NoError(t, ReadJSON(path, &v))
+		require.Equal(t, map[string]string{"a": "1"}, v)
+
+		// The backup should be restored and kept.
+		var restored map[string]string
+		require.NoError(t, ReadJSON(path, &restored))
+		require.Equal(t, v, restored)
+		require.NoError(t, WriteJSON(path, map[string]string{"a": "2"}))
+		backup, err := os.ReadFile(path + BackupSuffix)
+		require.NoError(t, err)
+		require.JSONEq(t, `{"a":"1"}`, string(backup))
+	})
+	t.Run("missing", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "x.json")
+		require.NoError(t, os.WriteFile(path+BackupSuffix, []byte(`{"a":"1"}`), 0o600))
+
+		var v map[string]string
+		require.NoError(t, ReadJSON(path, &v))
+		require.Equal(t, map[string]string{"a": "1"}, v)
+		require.FileExists(t, path)
+	})
+	t.Run("bothCorrupted", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "x.json")
+		require.NoError(t, os.WriteFile(path, []byte(`{`), 0o600))
+		require.NoError(t, os.WriteFile(path+BackupSuffix, []byte(`{`), 0o600))
+
+		var v map[string]string
+		require.Error(t, ReadJSON(path, &v))
+	})
+	t.Run("notExist", func(t *testing.T) {
+		var v map[string]string
+		err := ReadJSON(filepath.Join(t.TempDir(), "x.json"), &v)
+		require.ErrorIs(t, err, os.ErrNotExist)
+	})
+}
+
+func TestRemove(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "x.json")
+	require.NoError(t, WriteFile(path, []byte("1")))
+	require.NoError(t, WriteFile(path, []byte("2")))
+
+	require.NoError(t, Remove(path))
+	require.Empty(t, listFiles(t, dir))
+	require.ErrorIs(t, Remove(path), os.ErrNotExist)
+}
+
+func TestList(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{
+		"a.json", "a.json.bak", "b.json.bak", ".c.json.tmp-1", "d.txt",
+	} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), nil, 0o600))
+	}
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "e.json"), 0o700))
+
+	paths, err := List(dir)
+	require.NoError(t, err)
+	expected := []string{filepath.Join(dir, "a.json"), filepath.Join(dir, "b.json")}
+	require.Equal(t, expected, paths)
+}
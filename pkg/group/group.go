@@ -1,12 +1,10 @@
 package group
 
 import (
-	"encoding/json"
 	"errors"
 	"fmt"
-	"io/fs"
+	"nvr/pkg/configstore"
 	"os"
-	"strings"
 	"sync"
 )
 
@@ -36,9 +34,9 @@ func NewManager(configPath string) (*Manager, error) {
 		return nil, fmt.Errorf("create groups directory: %w", err)
 	}
 
-	configFiles, err := readConfigs(configPath)
+	configFiles, err := configstore.List(configPath)
 	if err != nil {
-		return nil, fmt.Errorf("read configuration files: %w", err)
+		return nil, fmt.Errorf("list configuration files: %w", err)
 	}
 
 	manager := &Manager{path: configPath}
@@ -46,8 +44,8 @@ func NewManager(configPath string) (*Manager, error) {
 	groups := make(groups)
 	for _, file := range configFiles {
 		var config Config
-		if err := json.Unmarshal(file, &config); err != nil {
-			return nil, fmt.Errorf("unmarshal config: %w: %v", err, file)
+		if err := configstore.ReadJSON(file, &config); err != nil {
+			return nil, fmt.Errorf("read config: %w", err)
 		}
 		groups[config["id"]] = manager.newGroup(config)
 	}
@@ -56,27 +54,6 @@ func NewManager(configPath string) (*Manager, error) {
 	return manager, nil
 }
 
-func readConfigs(path string) ([][]byte, error) {
-	var files [][]byte
-
-	fileSystem := os.DirFS(path)
-	err := fs.WalkDir(fileSystem, ".", func(path string, d fs.DirEntry, err error) error {
-		if err != nil {
-			return err
-		}
-		if !strings.Contains(path, ".json") {
-			return nil
-		}
-		file, err := fs.ReadFile(fileSystem, path)
-		if err != nil {
-			return fmt.Errorf("read file: %v %w", path, err)
-		}
-		files = append(files, file)
-		return nil
-	})
-	return files, err
-}
-
 // GroupSet sets config for specified group.
 func (m *Manager) GroupSet(id string, c Config) error {
 	defer m.mu.Unlock()
@@ -94,13 +71,11 @@ func (m *Manager) GroupSet(id string, c Config) error {
 
 	// Update file.
 	group.mu.Lock()
-	config, _ := json.MarshalIndent(group.Config, "", "    ")
-
-	err := os.WriteFile(m.configPath(id), config, 0o600)
+	defer group.mu.Unlock()
+	err := configstore.WriteJSON(m.configPath(id), group.Config)
 	if err != nil {
 		return fmt.Errorf("write file: %w", err)
 	}
-	group.mu.Unlock()
 
 	return nil
 }
@@ -121,7 +96,7 @@ func (m *Manager) GroupDelete(id string) error {
 
 	delete(m.Groups, id)
 
-	if err := os.Remove(m.configPath(id)); err != nil {
+	if err := configstore.Remove(m.configPath(id)); err != nil {
 		return err
 	}
 
@@ -0,0 +1,243 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+// Package history stores config revisions.
+//
+// An object is identified by its kind and id, for example "monitor/1",
+// or only by its kind if there is a single object of that kind, "general".
+package history
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"nvr/pkg/configstore"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Revision of a single object.
+type Revision struct {
+	ID   int       `json:"id"`
+	Time time.Time `json:"time"`
+	User string    `json:"user"`
+
+	// Revision ID that was rolled back to, if any.
+	Rollback int `json:"rollback,omitempty"`
+
+	// Changes compared to the previous revision.
+	Changes []Change `json:"changes"`
+
+	// Config after the change, nil if the object was deleted.
+	Config map[string]string `json:"config"`
+}
+
+// Change to a single config key.
+type Change struct {
+	Key string `json:"key"`
+	Old string `json:"old"`
+	New string `json:"new"`
+}
+
+// Deleted returns true if the object was deleted in this revision.
+func (r Revision) Deleted() bool {
+	return r.Config == nil
+}
+
+// Number of revisions to keep per object.
+const maxRevisions = 100
+
+// Store stores revisions in a directory, one file per object.
+type Store struct {
+	dir string
+	mu  sync.Mutex
+}
+
+// NewStore creates a store in dir.
+func NewStore(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("create history directory: %w", err)
+	}
+	return &Store{dir: dir}, nil
+}
+
+// Errors.
+var (
+	ErrInvalidObject    = errors.New("invalid object")
+	ErrRevisionNotExist = errors.New("revision does not exist")
+)
+
+// Object returns the object name for kind and id.
+func Object(kind string, id string) string {
+	if id == "" {
+		return kind
+	}
+	return kind + "/" + id
+}
+
+// SplitObject returns the kind and id of an object.
+func SplitObject(object string) (string, string, error) {
+	kind, id, _ := strings.Cut(object, "/")
+	if !validName(kind) || (id != "" && !validName(id)) {
+		return "", "", fmt.Errorf("%w: %q", ErrInvalidObject, object)
+	}
+	return kind, id, nil
+}
+
+func validName(s string) bool {
+	return s != "" && !strings.HasPrefix(s, ".") &&
+		!strings.ContainsAny(s, `/\`) && !strings.Contains(s, "..")
+}
+
+func (s *Store) path(object string) (string, error) {
+	kind, id, err := SplitObject(object)
+	if err != nil {
+		return "", err
+	}
+	if id == "" {
+		return filepath.Join(s.dir, kind+".json"), nil
+	}
+	return filepath.Join(s.dir, kind, id+".json"), nil
+}
+
+// Record stores a new revision if newConf differs from the latest revision.
+// oldConf is stored as the initial revision if the object doesn't have any
+// revisions yet, so that the first recorded change can be rolled back.
+// newConf is nil if the object was deleted.
+func (s *Store) Record(object, user string, oldConf, newConf map[string]string) error {
+	return s.record(object, user, oldConf, newConf, 0)
+}
+
+// RecordRollback stores a revision that rolled back to revision id.
+func (s *Store) RecordRollback(object, user string, id int, conf map[string]string) error {
+	return s.record(object, user, nil, conf, id)
+}
+
+func (s *Store) record(
+	object string,
+	user string,
+	oldConf map[string]string,
+	newConf map[string]string,
+	rollback int,
+) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	path, err := s.path(object)
+	if err != nil {
+		return err
+	}
+	revisions, err := readRevisions(path)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	if len(revisions) == 0 && oldConf != nil {
+		revisions = append(revisions, Revision{
+			ID:      1,
+			Time:    now,
+			Changes: diff(nil, oldConf),
+			Config:  oldConf,
+		})
+	}
+
+	var prevID int
+	var prevConf map[string]string
+	if len(revisions) != 0 {
+		prev := revisions[len(revisions)-1]
+		prevID = prev.ID
+		prevConf = prev.Config
+	}
+
+	changes := diff(prevConf, newConf)
+	deleted := newConf == nil && prevConf != nil
+	if len(changes) == 0 && !deleted && rollback == 0 {
+		return nil
+	}
+	revisions = append(revisions, Revision{
+		ID:       prevID + 1,
+		Time:     now,
+		User:     user,
+		Rollback: rollback,
+		Changes:  changes,
+		Config:   newConf,
+	})
+	if len(revisions) > maxRevisions {
+		revisions = revisions[len(revisions)-maxRevisions:]
+	}
+
+	return writeRevisions(path, revisions)
+}
+
+// Revisions returns all stored revisions of an object, oldest first.
+func (s *Store) Revisions(object string) ([]Revision, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	path, err := s.path(object)
+	if err != nil {
+		return nil, err
+	}
+	return readRevisions(path)
+}
+
+// Revision returns a single revision of an object.
+func (s *Store) Revision(object string, id int) (*Revision, error) {
+	revisions, err := s.Revisions(object)
+	if err != nil {
+		return nil, err
+	}
+	for _, revision := range revisions {
+		if revision.ID == id {
+			return &revision, nil
+		}
+	}
+	return nil, ErrRevisionNotExist
+}
+
+func readRevisions(path string) ([]Revision, error) {
+	var revisions []Revision
+	err := configstore.ReadJSON(path, &revisions)
+	if errors.Is(err, os.ErrNotExist) {
+		return []Revision{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read revisions: %w", err)
+	}
+	return revisions, nil
+}
+
+func writeRevisions(path string, revisions []Revision) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	file, err := json.MarshalIndent(revisions, "", "    ")
+	if err != nil {
+		return err
+	}
+	return configstore.WriteFile(path, file)
+}
+
+// diff returns the changed keys sorted by key.
+func diff(oldConf, newConf map[string]string) []Change {
+	changes := []Change{}
+	for key, newValue := range newConf {
+		oldValue, exists := oldConf[key]
+		if !exists || oldValue != newValue {
+			changes = append(changes, Change{Key: key, Old: oldValue, New: newValue})
+		}
+	}
+	for key, oldValue := range oldConf {
+		if _, exists := newConf[key]; !exists {
+			changes = append(changes, Change{Key: key, Old: oldValue})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Key < changes[j].Key
+	})
+	return changes
+}
@@ -0,0 +1,109 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package history
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newTestStore(t *testing.T) *Store {
+	t.Helper()
+	store, err := NewStore(t.TempDir())
+	require.NoError(t, err)
+	return store
+}
+
+func TestRecord(t *testing.T) {
+	t.Run("baseline", func(t *testing.T) {
+		store := newTestStore(t)
+		oldConf := map[string]string{"id": "1", "a": "1"}
+		newConf := map[string]string{"id": "1", "a": "2", "b": "3"}
+		require.NoError(t, store.Record("monitor/1", "admin", oldConf, newConf))
+
+		revisions, err := store.Revisions("monitor/1")
+		require.NoError(t, err)
+		require.Len(t, revisions, 2)
+
+		require.Equal(t, 1, revisions[0].ID)
+		require.Equal(t, "", revisions[0].User)
+		require.Equal(t, oldConf, revisions[0].Config)
+
+		require.Equal(t, 2, revisions[1].ID)
+		require.Equal(t, "admin", revisions[1].User)
+		require.Equal(t, newConf, revisions[1].Config)
+		expected := []Change{
+			{Key: "a", Old: "1", New: "2"},
+			{Key: "b", Old: "", New: "3"},
+		}
+		require.Equal(t, expected, revisions[1].Changes)
+	})
+	t.Run("unchanged", func(t *testing.T) {
+		store := newTestStore(t)
+		conf := map[string]string{"a": "1"}
+		require.NoError(t, store.Record("general", "admin", nil, conf))
+		require.NoError(t, store.Record("general", "admin", conf, conf))
+
+		revisions, err := store.Revisions("general")
+		require.NoError(t, err)
+		require.Len(t, revisions, 1)
+	})
+	t.Run("delete", func(t *testing.T) {
+		store := newTestStore(t)
+		conf := map[string]string{"a": "1"}
+		require.NoError(t, store.Record("group/x", "admin", nil, conf))
+		require.NoError(t, store.Record("group/x", "admin", conf, nil))
+		require.NoError(t, store.Record("group/x", "admin", nil, nil))
+
+		revisions, err := store.Revisions("group/x")
+		require.NoError(t, err)
+		require.Len(t, revisions, 2)
+		require.True(t, revisions[1].Deleted())
+		require.Equal(t, []Change{{Key: "a", Old: "1"}}, revisions[1].Changes)
+	})
+	t.Run("rollback", func(t *testing.T) {
+		store := newTestStore(t)
+		conf1 := map[string]string{"a": "1"}
+		conf2 := map[string]string{"a": "2"}
+		require.NoError(t, store.Record("monitor/1", "a", nil, conf1))
+		require.NoError(t, store.Record("monitor/1", "a", conf1, conf2))
+		require.NoError(t, store.RecordRollback("monitor/1", "b", 1, conf1))
+
+		revision, err := store.Revision("monitor/1", 3)
+		require.NoError(t, err)
+		require.Equal(t, 1, revision.Rollback)
+		require.Equal(t, "b", revision.User)
+		require.Equal(t, conf1, revision.Config)
+		require.Equal(t, []Change{{Key: "a", Old: "2", New: "1"}}, revision.Changes)
+	})
+	t.Run("maxRevisions", func(t *testing.T) {
+		store := newTestStore(t)
+		for i := 0; i < maxRevisions+5; i++ {
+			conf := map[string]string{"a": string(rune('a' + i%26)), "i": string(rune(i))}
+			require.NoError(t, store.Record("general", "", nil, conf))
+		}
+		revisions, err := store.Revisions("general")
+		require.NoError(t, err)
+		require.Len(t, revisions, maxRevisions)
+		require.Equal(t, 6, revisions[0].ID)
+		require.Equal(t, maxRevisions+5, revisions[maxRevisions-1].ID)
+	})
+	t.Run("invalidObject", func(t *testing.T) {
+		store := newTestStore(t)
+		for _, object := range []string{"", "monitor/../x", "../x", "monitor/a/b", ".x"} {
+			err := store.Record(object, "", nil, map[string]string{})
+			require.ErrorIs(t, err, ErrInvalidObject, object)
+		}
+	})
+}
+
+func TestRevision(t *testing.T) {
+	store := newTestStore(t)
+	_, err := store.Revision("monitor/1", 1)
+	require.ErrorIs(t, err, ErrRevisionNotExist)
+
+	revisions, err := store.Revisions("monitor/1")
+	require.NoError(t, err)
+	require.Empty(t, revisions)
+}
@@ -8,6 +8,9 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"nvr/pkg/metrics"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -102,9 +105,20 @@ type Logger struct {
 	wg      *sync.WaitGroup
 	Ctx     context.Context
 	sources []string
+
+	counts   map[countKey]uint64
+	countsMu sync.Mutex
+}
+
+type countKey struct {
+	level Level
+	src   string
 }
 
-var defaultSources = []string{"app", "auth", "monitor", "recorder"}
+// SrcAudit log source of user actions.
+const SrcAudit = "audit"
+
+var defaultSources = []string{"app", "audit", "auth", "monitor", "recorder"}
 
 // NewLogger starts and returns Logger.
 func NewLogger(wg *sync.WaitGroup, addonSources []string) *Logger {
@@ -133,6 +147,13 @@ func (l *Logger) Log(log Entry) {
 
 	log.Time = UnixMicro(time.Now().UnixMicro())
 
+	l.countsMu.Lock()
+	if l.counts == nil {
+		l.counts = make(map[countKey]uint64)
+	}
+	l.counts[countKey{level: log.Level, src: log.Src}]++
+	l.countsMu.Unlock()
+
 	select {
 	case <-l.Ctx.Done():
 	case l.feed <- log:
@@ -144,6 +165,43 @@ func (l *Logger) Sources() []string {
 	return l.sources
 }
 
+// String returns the level name in lower case.
+func (l Level) String() string {
+	switch l {
+	case LevelError:
+		return "error"
+	case LevelWarning:
+		return "warning"
+	case LevelInfo:
+		return "info"
+	case LevelDebug:
+		return "debug"
+	}
+	return strconv.Itoa(int(l))
+}
+
+// CollectMetrics writes the number of log entries by level and source.
+func (l *Logger) CollectMetrics(e *metrics.Encoder) {
+	l.countsMu.Lock()
+	samples := make([]metrics.Sample, 0, len(l.counts))
+	for key, count := range l.counts {
+		samples = append(samples, metrics.Sample{
+			Labels: metrics.Labels{"level": key.level.String(), "src": key.src},
+			Value:  float64(count),
+		})
+	}
+	l.countsMu.Unlock()
+
+	sort.Slice(samples, func(i, j int) bool {
+		a, b := samples[i].Labels, samples[j].Labels
+		if a["src"] != b["src"] {
+			return a["src"] < b["src"]
+		}
+		return a["level"] < b["level"]
+	})
+	e.Counter("nvr_log_entries_total", "Number of log entries by level and source.", samples...)
+}
+
 // Start logger.
 func (l *Logger) Start(ctx context.Context) error {
 	l.Ctx = ctx
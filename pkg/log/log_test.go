@@ -3,12 +3,15 @@
 package log
 
 import (
+	"bytes"
 	"context"
 	"io"
 	"sync"
 	"testing"
 	"time"
 
+	"nvr/pkg/metrics"
+
 	"github.com/stretchr/testify/require"
 )
 
@@ -180,6 +183,32 @@ func TestLogger(t *testing.T) {
 	})
 }
 
+func TestLoggerCollectMetrics(t *testing.T) {
+	cancel, logger := newTestLogger(t)
+	defer cancel()
+
+	feed, cancel2 := logger.Subscribe()
+	defer cancel2()
+
+	go func() {
+		logger.Log(Entry{Level: LevelInfo, Src: "b", Msg: "1"})
+		logger.Log(Entry{Level: LevelInfo, Src: "b", Msg: "2"})
+		logger.Log(Entry{Level: LevelError, Src: "a", Msg: "3"})
+	}()
+	<-feed
+	<-feed
+	<-feed
+
+	var b bytes.Buffer
+	logger.CollectMetrics(metrics.NewEncoder(&b))
+
+	expected := "# HELP nvr_log_entries_total Number of log entries by level and source.\n" +
+		"# TYPE nvr_log_entries_total counter\n" +
+		"nvr_log_entries_total{level=\"error\",src=\"a\"} 1\n" +
+		"nvr_log_entries_total{level=\"info\",src=\"b\"} 2\n"
+	require.Equal(t, expected, b.String())
+}
+
 type mockWriter struct {
 	writes chan string
 }
@@ -11,6 +11,7 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -71,6 +72,13 @@ type Store struct {
 
 	getDiskSpace getDiskSpaceFunc
 	minDiskUsage int64
+
+	// Only entries that match are saved, all entries if nil.
+	include func(Entry) bool
+
+	// Chunks older than this are purged. If zero,
+	// chunks are purged based on disk usage instead.
+	maxAge time.Duration
 }
 
 const (
@@ -102,9 +110,26 @@ func NewStore(
 		logf:         logf,
 		getDiskSpace: getDiskSpace,
 		minDiskUsage: 100 * megabyte,
+		include: func(e Entry) bool {
+			return e.Src != SrcAudit
+		},
 	}, nil
 }
 
+// NewAuditStore new log store for the audit log source.
+// Audit logs are kept for the retention duration.
+func NewAuditStore(logDir string, wg *sync.WaitGroup, retention time.Duration) (*Store, error) {
+	s, err := NewStore(logDir, wg, nil)
+	if err != nil {
+		return nil, err
+	}
+	s.include = func(e Entry) bool {
+		return e.Src == SrcAudit
+	}
+	s.maxAge = retention
+	return s, nil
+}
+
 // SaveLogs saves logs from the logger into the database.
 func (s *Store) SaveLogs(ctx context.Context, logger *Logger) {
 	s.wg.Add(1)
@@ -121,6 +146,9 @@ func (s *Store) SaveLogs(ctx context.Context, logger *Logger) {
 				s.wg.Done()
 				return
 			case log := <-feed:
+				if s.include != nil && !s.include(log) {
+					continue
+				}
 				err := s.saveLog(log)
 				if err != nil {
 					fmt.Printf("could not save log: %v %v\n", log.Msg, err)
@@ -298,6 +326,10 @@ func (s *Store) listChunks() ([]string, error) {
 
 // purges a single chunk if needed.
 func (s *Store) purge() error {
+	if s.maxAge != 0 {
+		return s.purgeOld(time.Now())
+	}
+
 	dirSize, err := dirSize(s.logDir)
 	if err != nil {
 		return fmt.Errorf("dir size: %w", err)
@@ -334,6 +366,53 @@ func (s *Store) purge() error {
 	return nil
 }
 
+// purgeOld purges all chunks that only contain entries older than maxAge.
+func (s *Store) purgeOld(now time.Time) error {
+	chunks, err := s.listChunks()
+	if err != nil {
+		return fmt.Errorf("list chunks: %w", err)
+	}
+
+	cutoff := UnixMicro(now.Add(-s.maxAge).UnixMicro())
+	for _, chunk := range chunks {
+		id, err := strconv.ParseUint(chunk, 10, 64)
+		if err != nil {
+			continue
+		}
+		chunkEnd := UnixMicro((id + 1) * chunkDuration)
+		if chunkEnd > cutoff {
+			// Chunks are sorted.
+			return nil
+		}
+
+		dataPath, msgPath := chunkIDToPaths(s.logDir, chunk)
+		if err := os.Remove(dataPath); err != nil {
+			return fmt.Errorf("remove %q %w", dataPath, err)
+		}
+		os.Remove(msgPath)
+	}
+	return nil
+}
+
+// QueryStores queries multiple stores and merges the entries, newest first.
+func QueryStores(q Query, stores ...*Store) ([]Entry, error) {
+	var entries []Entry
+	for _, s := range stores {
+		e, err := s.Query(q)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, e...)
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Time > entries[j].Time
+	})
+	if q.Limit != 0 && len(entries) > q.Limit {
+		entries = entries[:q.Limit]
+	}
+	return entries, nil
+}
+
 func dirSize(path string) (int64, error) {
 	files, err := os.ReadDir(path)
 	if err != nil {
@@ -588,6 +588,52 @@ func TestPurge(t *testing.T) {
 	})
 }
 
+func TestPurgeOld(t *testing.T) {
+	logDir := t.TempDir()
+	s := Store{
+		logDir: logDir,
+		maxAge: 2 * time.Hour,
+	}
+
+	writeTestChunk(t, logDir, "00000")
+	writeTestChunk(t, logDir, "00001")
+	writeTestChunk(t, logDir, "00002")
+
+	// The second chunk ends at 2*chunkDuration.
+	now := time.UnixMicro(2 * chunkDuration).Add(2 * time.Hour)
+	require.NoError(t, s.purgeOld(now))
+
+	files := listFiles(t, logDir)
+	expected := []string{"00002.data", "00002.msg"}
+	require.Equal(t, expected, files)
+}
+
+func TestAuditStore(t *testing.T) {
+	logDir := t.TempDir()
+	appStore := newTestStore(t, logDir)
+	auditStore, err := NewAuditStore(
+		filepath.Join(logDir, "audit"), &sync.WaitGroup{}, time.Hour)
+	require.NoError(t, err)
+
+	appEntry := Entry{Time: 1, Src: "app"}
+	auditEntry := Entry{Time: 2, Src: SrcAudit}
+	require.True(t, appStore.include(appEntry))
+	require.False(t, appStore.include(auditEntry))
+	require.False(t, auditStore.include(appEntry))
+	require.True(t, auditStore.include(auditEntry))
+
+	require.NoError(t, appStore.saveLog(appEntry))
+	require.NoError(t, auditStore.saveLog(auditEntry))
+
+	entries, err := QueryStores(Query{}, appStore, auditStore)
+	require.NoError(t, err)
+	require.Equal(t, []Entry{auditEntry, appEntry}, entries)
+
+	entries, err = QueryStores(Query{Limit: 1}, appStore, auditStore)
+	require.NoError(t, err)
+	require.Equal(t, []Entry{auditEntry}, entries)
+}
+
 // Each chunk is 100 bytes.
 func writeTestChunk(t *testing.T, logDir, chunkID string) {
 	t.Helper()
@@ -0,0 +1,190 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+// Package metrics implements a minimal encoder
+// for the Prometheus text exposition format.
+package metrics
+
+import (
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Collector writes metrics to the encoder.
+type Collector func(*Encoder)
+
+// Labels metric labels.
+type Labels map[string]string
+
+// Sample is a single metric value.
+type Sample struct {
+	Labels Labels
+	Value  float64
+}
+
+// Encoder writes metrics in the Prometheus text format.
+type Encoder struct {
+	w   io.Writer
+	err error
+}
+
+// NewEncoder returns a new encoder that writes to w.
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w}
+}
+
+// Err returns the first write error, if any.
+func (e *Encoder) Err() error {
+	return e.err
+}
+
+// Gauge writes a gauge metric family.
+func (e *Encoder) Gauge(name, help string, samples ...Sample) {
+	e.family(name, help, "gauge", samples)
+}
+
+// Counter writes a counter metric family.
+func (e *Encoder) Counter(name, help string, samples ...Sample) {
+	e.family(name, help, "counter", samples)
+}
+
+func (e *Encoder) family(name, help, typ string, samples []Sample) {
+	if len(samples) == 0 {
+		return
+	}
+	e.header(name, help, typ)
+	for _, s := range samples {
+		e.sample(name, s.Labels, "", "", s.Value)
+	}
+}
+
+// Histogram writes a histogram metric family.
+func (e *Encoder) Histogram(name, help string, labels Labels, h *Histogram) {
+	buckets, counts, sum, count := h.snapshot()
+
+	e.header(name, help, "histogram")
+	var cumulative uint64
+	for i, bucket := range buckets {
+		cumulative += counts[i]
+		e.sample(name+"_bucket", labels, "le", formatFloat(bucket), float64(cumulative))
+	}
+	e.sample(name+"_bucket", labels, "le", "+Inf", float64(count))
+	e.sample(name+"_sum", labels, "", "", sum)
+	e.sample(name+"_count", labels, "", "", float64(count))
+}
+
+func (e *Encoder) header(name, help, typ string) {
+	e.write("# HELP " + name + " " + helpEscaper.Replace(help) + "\n")
+	e.write("# TYPE " + name + " " + typ + "\n")
+}
+
+// sample writes a single line, extraName and extraValue
+// are appended to the labels if extraName is not empty.
+func (e *Encoder) sample(name string, labels Labels, extraName, extraValue string, value float64) {
+	var b strings.Builder
+	b.WriteString(name)
+
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	if len(names) != 0 || extraName != "" {
+		b.WriteByte('{')
+		for i, name := range names {
+			if i != 0 {
+				b.WriteByte(',')
+			}
+			writeLabel(&b, name, labels[name])
+		}
+		if extraName != "" {
+			if len(names) != 0 {
+				b.WriteByte(',')
+			}
+			writeLabel(&b, extraName, extraValue)
+		}
+		b.WriteByte('}')
+	}
+
+	b.WriteByte(' ')
+	b.WriteString(formatFloat(value))
+	b.WriteByte('\n')
+	e.write(b.String())
+}
+
+func (e *Encoder) write(s string) {
+	if e.err != nil {
+		return
+	}
+	_, e.err = io.WriteString(e.w, s)
+}
+
+func writeLabel(b *strings.Builder, name, value string) {
+	b.WriteString(name)
+	b.WriteString(`="`)
+	b.WriteString(labelEscaper.Replace(value))
+	b.WriteByte('"')
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// Histogram counts observations in buckets.
+type Histogram struct {
+	buckets []float64
+	counts  []uint64
+	sum     float64
+	count   uint64
+	mu      sync.Mutex
+}
+
+// NewHistogram returns a histogram with the given upper bounds.
+func NewHistogram(buckets []float64) *Histogram {
+	sorted := append([]float64{}, buckets...)
+	sort.Float64s(sorted)
+	return &Histogram{
+		buckets: sorted,
+		counts:  make([]uint64, len(sorted)),
+	}
+}
+
+// Observe adds a single observation.
+func (h *Histogram) Observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for i, bucket := range h.buckets {
+		if v <= bucket {
+			h.counts[i]++
+			break
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+func (h *Histogram) snapshot() ([]float64, []uint64, float64, uint64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	counts := append([]uint64{}, h.counts...)
+	return h.buckets, counts, h.sum, h.count
+}
@@ -0,0 +1,79 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package metrics
+
+import (
+	"bytes"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncoder(t *testing.T) {
+	t.Run("gauge", func(t *testing.T) {
+		var b bytes.Buffer
+		e := NewEncoder(&b)
+		e.Gauge("a", "help\nline",
+			Sample{Value: 1.5},
+			Sample{Labels: Labels{"y": "2", "x": `"1"\`}, Value: 2},
+		)
+		require.NoError(t, e.Err())
+
+		expected := "# HELP a help\\nline\n" +
+			"# TYPE a gauge\n" +
+			"a 1.5\n" +
+			"a{x=\"\\\"1\\\"\\\\\",y=\"2\"} 2\n"
+		require.Equal(t, expected, b.String())
+	})
+	t.Run("counter", func(t *testing.T) {
+		var b bytes.Buffer
+		e := NewEncoder(&b)
+		e.Counter("b_total", "x", Sample{Value: 123456789})
+
+		expected := "# HELP b_total x\n" +
+			"# TYPE b_total counter\n" +
+			"b_total 1.23456789e+08\n"
+		require.Equal(t, expected, b.String())
+	})
+	t.Run("empty", func(t *testing.T) {
+		var b bytes.Buffer
+		NewEncoder(&b).Gauge("a", "x")
+		require.Empty(t, b.String())
+	})
+	t.Run("special", func(t *testing.T) {
+		var b bytes.Buffer
+		e := NewEncoder(&b)
+		e.Gauge("a", "x",
+			Sample{Value: math.Inf(1)},
+			Sample{Value: math.Inf(-1)},
+			Sample{Value: math.NaN()},
+		)
+		expected := "# HELP a x\n" +
+			"# TYPE a gauge\n" +
+			"a +Inf\n" +
+			"a -Inf\n" +
+			"a NaN\n"
+		require.Equal(t, expected, b.String())
+	})
+}
+
+func TestHistogram(t *testing.T) {
+	h := NewHistogram([]float64{1, 0.5})
+	h.Observe(0.1)
+	h.Observe(0.7)
+	h.Observe(0.8)
+	h.Observe(2)
+
+	var b bytes.Buffer
+	NewEncoder(&b).Histogram("h", "x", Labels{"a": "b"}, h)
+
+	expected := "# HELP h x\n" +
+		"# TYPE h histogram\n" +
+		"h_bucket{a=\"b\",le=\"0.5\"} 1\n" +
+		"h_bucket{a=\"b\",le=\"1\"} 3\n" +
+		"h_bucket{a=\"b\",le=\"+Inf\"} 4\n" +
+		"h_sum{a=\"b\"} 3.6\n" +
+		"h_count{a=\"b\"} 4\n"
+	require.Equal(t, expected, b.String())
+}
@@ -0,0 +1,92 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package monitor
+
+// ApplyAction action taken to apply a monitor config.
+type ApplyAction string
+
+// Apply actions.
+const (
+	// Nothing changed.
+	ApplyNone ApplyAction = "none"
+
+	// Changes were applied without restarting any process.
+	ApplyHot ApplyAction = "hot"
+
+	// Only the main or sub input process was restarted.
+	ApplyRestartMain ApplyAction = "restartMain"
+	ApplyRestartSub  ApplyAction = "restartSub"
+
+	// Both input processes were restarted.
+	ApplyRestartInputs ApplyAction = "restartInputs"
+
+	// The whole monitor was started or restarted.
+	ApplyRestartMonitor ApplyAction = "restartMonitor"
+)
+
+// Config keys that require the whole monitor to restart.
+var monitorKeys = []string{"id", "enable", "alwaysRecord"}
+
+// Config keys that are read by both input processes when they start.
+var inputKeys = []string{
+	"inputOptions", "hwaccel", "videoEncoder", "audioEncoder", "logLevel", "multicast",
+	"dvrWindow",
+}
+
+// diffConfig returns the action needed to apply newConf.
+// addonInputKeys are addon config keys that
+// are read when the input processes start.
+func diffConfig(oldConf, newConf RawConfig, addonInputKeys []string) ApplyAction {
+	changed := func(key string) bool {
+		oldValue, oldExist := oldConf[key]
+		newValue, newExist := newConf[key]
+		return oldValue != newValue || oldExist != newExist
+	}
+	anyChanged := func(keys []string) bool {
+		for _, key := range keys {
+			if changed(key) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if anyChanged(monitorKeys) {
+		return ApplyRestartMonitor
+	}
+
+	// Starting or stopping the sub input requires a monitor restart.
+	oldSub := NewConfig(oldConf).SubInputEnabled()
+	newSub := NewConfig(newConf).SubInputEnabled()
+	if oldSub != newSub {
+		return ApplyRestartMonitor
+	}
+
+	restartMain := changed("mainInput")
+	restartSub := changed("subInput")
+	if anyChanged(inputKeys) || anyChanged(addonInputKeys) {
+		restartMain = true
+		restartSub = newSub
+	}
+
+	switch {
+	case restartMain && restartSub:
+		return ApplyRestartInputs
+	case restartMain:
+		return ApplyRestartMain
+	case restartSub:
+		return ApplyRestartSub
+	}
+
+	for key := range oldConf {
+		if changed(key) {
+			return ApplyHot
+		}
+	}
+	for key := range newConf {
+		if changed(key) {
+			return ApplyHot
+		}
+	}
+	return ApplyNone
+}
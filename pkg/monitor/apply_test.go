@@ -0,0 +1,59 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package monitor
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiffConfig(t *testing.T) {
+	base := func() RawConfig {
+		return RawConfig{
+			"id":        "1",
+			"enable":    "true",
+			"mainInput": "a",
+			"subInput":  "b",
+			"alert":     "x",
+			"doods":     "x",
+		}
+	}
+	cases := map[string]struct {
+		key      string
+		value    string
+		expected ApplyAction
+	}{
+		"none":       {"id", "1", ApplyNone},
+		"hot":        {"alert", "y", ApplyHot},
+		"newKey":     {"timeline", "y", ApplyHot},
+		"enable":     {"enable", "false", ApplyRestartMonitor},
+		"record":     {"alwaysRecord", "true", ApplyRestartMonitor},
+		"main":       {"mainInput", "c", ApplyRestartMain},
+		"sub":        {"subInput", "c", ApplyRestartSub},
+		"subDisable": {"subInput", "", ApplyRestartMonitor},
+		"encoder":    {"videoEncoder", "x", ApplyRestartInputs},
+		"addonInput": {"doods", "y", ApplyRestartInputs},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			newConf := base()
+			newConf[tc.key] = tc.value
+			actual := diffConfig(base(), newConf, []string{"doods"})
+			require.Equal(t, tc.expected, actual)
+		})
+	}
+	t.Run("removedKey", func(t *testing.T) {
+		newConf := base()
+		delete(newConf, "alert")
+		require.Equal(t, ApplyHot, diffConfig(base(), newConf, nil))
+	})
+	t.Run("noSubInput", func(t *testing.T) {
+		oldConf := base()
+		delete(oldConf, "subInput")
+		newConf := base()
+		delete(newConf, "subInput")
+		newConf["hwaccel"] = "x"
+		require.Equal(t, ApplyRestartMain, diffConfig(oldConf, newConf, nil))
+	})
+}
@@ -2,7 +2,13 @@
 
 package monitor
 
-import "strings"
+import (
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
 
 // RawConfigs map of RawConfig.
 type RawConfigs map[string]RawConfig
@@ -13,42 +19,61 @@ type RawConfig map[string]string
 // Configs Monitor configurations.
 type Configs map[string]Config
 
-// Config Monitor configuration.
+// Config Monitor configuration. Copies of a config share
+// the same values, changes made by the manager are
+// visible to all copies.
 type Config struct {
-	v map[string]string
+	v *configValues
 }
 
-// NewConfig creates a new immutable monitor config.
+type configValues struct {
+	m  map[string]string
+	mu sync.RWMutex
+}
+
+// NewConfig creates a new monitor config.
 func NewConfig(c RawConfig) Config {
-	return Config{v: c}
+	return Config{v: &configValues{m: c}}
 }
 
 // Get config value by key.
 func (c Config) Get(key string) string {
-	return c.v[key]
+	if c.v == nil {
+		return ""
+	}
+	c.v.mu.RLock()
+	defer c.v.mu.RUnlock()
+	return c.v.m[key]
+}
+
+// set replaces the config values.
+func (c Config) set(rawConf RawConfig) {
+	c.v.mu.Lock()
+	c.v.m = rawConf
+	c.v.mu.Unlock()
 }
 
 func (c Config) enabled() bool {
-	return c.v["enable"] == "true"
+	return c.Get("enable") == "true"
 }
 
 // ID returns the monitor ID.
 func (c Config) ID() string {
-	return c.v["id"]
+	return c.Get("id")
 }
 
 // Name returns the monitor name.
 func (c Config) Name() string {
-	return c.v["name"]
+	return c.Get("name")
 }
 
 // InputOpts returns the monitor input options.
 func (c Config) InputOpts() string {
-	return c.v["inputOptions"]
+	return c.Get("inputOptions")
 }
 
 func (c Config) audioEnabled() bool {
-	switch c.v["audioEncoder"] {
+	switch c.Get("audioEncoder") {
 	case "":
 		return false
 	case "none":
@@ -59,22 +84,22 @@ func (c Config) audioEnabled() bool {
 
 // AudioEncoder returns the monitor audio encoder.
 func (c Config) AudioEncoder() string {
-	return c.v["audioEncoder"]
+	return c.Get("audioEncoder")
 }
 
 // VideoEncoder returns the monitor audio encoder.
 func (c Config) VideoEncoder() string {
-	return c.v["videoEncoder"]
+	return c.Get("videoEncoder")
 }
 
 // MainInput returns the main input url.
 func (c Config) MainInput() string {
-	return c.v["mainInput"]
+	return c.Get("mainInput")
 }
 
 // SubInput returns the sub input url.
 func (c Config) SubInput() string {
-	return c.v["subInput"]
+	return c.Get("subInput")
 }
 
 // SubInputEnabled if sub input is available.
@@ -84,26 +109,62 @@ func (c Config) SubInputEnabled() bool {
 
 // video length is seconds.
 func (c Config) videoLength() string {
-	return c.v["videoLength"]
+	return c.Get("videoLength")
 }
 
 func (c Config) alwaysRecord() bool {
-	return c.v["alwaysRecord"] == "true"
+	return c.Get("alwaysRecord") == "true"
 }
 
 // TimestampOffset returns the timestamp offset.
 func (c Config) TimestampOffset() string {
-	return c.v["timestampOffset"]
+	return c.Get("timestampOffset")
 }
 
 // LogLevel returns the ffmpeg log level.
 func (c Config) LogLevel() string {
-	return c.v["logLevel"]
+	return c.Get("logLevel")
 }
 
 // Hwaccel returns the ffmpeg hwaccel.
 func (c Config) Hwaccel() string {
-	return c.v["hwaccel"]
+	return c.Get("hwaccel")
+}
+
+// Multicast returns the multicast group and RTP port of an input, the group
+// is nil if multicast is disabled. The main input uses the configured port
+// and the next three, the sub input uses the four ports after them.
+func (c Config) Multicast(isSubInput bool) (net.IP, int) {
+	group, port, err := parseMulticast(c.Get("multicast"))
+	if err != nil {
+		return nil, 0
+	}
+	if isSubInput {
+		port += 4
+	}
+	return group, port
+}
+
+// DVRWindow returns the length of the DVR playlist
+// of the live stream, zero if it's disabled.
+func (c Config) DVRWindow() time.Duration {
+	minutes, err := strconv.Atoi(c.Get("dvrWindow"))
+	if err != nil || minutes <= 0 {
+		return 0
+	}
+	return time.Duration(minutes) * time.Minute
+}
+
+func parseMulticast(s string) (net.IP, int, error) {
+	host, rawPort, err := net.SplitHostPort(s)
+	if err != nil {
+		return nil, 0, err
+	}
+	port, err := strconv.Atoi(rawPort)
+	if err != nil {
+		return nil, 0, err
+	}
+	return net.ParseIP(host), port, nil
 }
 
 // CensorLog replaces sensitive monitor config values.
@@ -2,6 +2,7 @@ package monitor
 
 import (
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
@@ -35,3 +36,24 @@ func TestCensorLog(t *testing.T) {
 		})
 	}
 }
+
+func TestConfigMulticast(t *testing.T) {
+	c := NewConfig(RawConfig{"multicast": "239.0.0.1:5000"})
+
+	group, port := c.Multicast(false)
+	require.Equal(t, "239.0.0.1", group.String())
+	require.Equal(t, 5000, port)
+
+	group, port = c.Multicast(true)
+	require.Equal(t, "239.0.0.1", group.String())
+	require.Equal(t, 5004, port)
+
+	group, _ = NewConfig(RawConfig{}).Multicast(false)
+	require.Nil(t, group)
+}
+
+func TestConfigDVRWindow(t *testing.T) {
+	require.Equal(t, 10*time.Minute, NewConfig(RawConfig{"dvrWindow": "10"}).DVRWindow())
+	require.Equal(t, time.Duration(0), NewConfig(RawConfig{"dvrWindow": "0"}).DVRWindow())
+	require.Equal(t, time.Duration(0), NewConfig(RawConfig{}).DVRWindow())
+}
@@ -0,0 +1,161 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package monitor
+
+import (
+	"nvr/pkg/metrics"
+	"nvr/pkg/storage"
+	"os"
+	"sort"
+	"sync"
+)
+
+// RecorderStats recorder counters.
+type RecorderStats struct {
+	BytesWritten    uint64
+	RecordingsSaved uint64
+	Events          map[string]uint64 // Number of detections by label.
+}
+
+type recorderStats struct {
+	bytesWritten    uint64
+	recordingsSaved uint64
+	events          map[string]uint64
+	mu              sync.Mutex
+}
+
+func (s *recorderStats) onEvent(event storage.Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.events == nil {
+		s.events = make(map[string]uint64)
+	}
+	for _, d := range event.Detections {
+		s.events[d.Label]++
+	}
+}
+
+func (s *recorderStats) onWritten(n int64) {
+	s.mu.Lock()
+	s.bytesWritten += uint64(n)
+	s.mu.Unlock()
+}
+
+func (s *recorderStats) onSaved() {
+	s.mu.Lock()
+	s.recordingsSaved++
+	s.mu.Unlock()
+}
+
+func (s *recorderStats) get() RecorderStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	events := make(map[string]uint64, len(s.events))
+	for label, count := range s.events {
+		events[label] = count
+	}
+	return RecorderStats{
+		BytesWritten:    s.bytesWritten,
+		RecordingsSaved: s.recordingsSaved,
+		Events:          events,
+	}
+}
+
+// Stats returns the recorder counters.
+func (r *Recorder) Stats() RecorderStats {
+	return r.stats.get()
+}
+
+func fileSize(path string) int64 {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
+
+// CollectMetrics writes metrics for all running monitors.
+// Counters are reset when the monitor restarts.
+func (m *Manager) CollectMetrics(e *metrics.Encoder) { //nolint:funlen
+	m.mu.Lock()
+	ids := make([]string, 0, len(m.runningMonitors))
+	for id, monitor := range m.runningMonitors {
+		if monitor.Config.enabled() {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+
+	type monitorMetrics struct {
+		id     string
+		status Status
+		stats  RecorderStats
+	}
+	monitors := make([]monitorMetrics, 0, len(ids))
+	for _, id := range ids {
+		monitor := m.runningMonitors[id]
+		monitors = append(monitors, monitorMetrics{
+			id:     id,
+			status: monitor.Status(),
+			stats:  monitor.recorder.Stats(),
+		})
+	}
+	m.mu.Unlock()
+
+	var fps, bitrate, segments, restarts []metrics.Sample
+	addInput := func(id string, input string, s *InputStatus) {
+		if s == nil {
+			return
+		}
+		labels := metrics.Labels{"monitor": id, "input": input}
+		fps = append(fps, metrics.Sample{Labels: labels, Value: s.FPS})
+		bitrate = append(bitrate, metrics.Sample{Labels: labels, Value: float64(s.Bitrate)})
+		segments = append(segments, metrics.Sample{Labels: labels, Value: float64(s.SegmentCount)})
+		restarts = append(restarts, metrics.Sample{Labels: labels, Value: float64(s.CrashCount)})
+	}
+
+	var bytesWritten, recordingsSaved, events []metrics.Sample
+	for _, monitor := range monitors {
+		addInput(monitor.id, "main", monitor.status.Main)
+		addInput(monitor.id, "sub", monitor.status.Sub)
+
+		labels := metrics.Labels{"monitor": monitor.id}
+		bytesWritten = append(bytesWritten, metrics.Sample{
+			Labels: labels,
+			Value:  float64(monitor.stats.BytesWritten),
+		})
+		recordingsSaved = append(recordingsSaved, metrics.Sample{
+			Labels: labels,
+			Value:  float64(monitor.stats.RecordingsSaved),
+		})
+
+		labelNames := make([]string, 0, len(monitor.stats.Events))
+		for label := range monitor.stats.Events {
+			labelNames = append(labelNames, label)
+		}
+		sort.Strings(labelNames)
+		for _, label := range labelNames {
+			events = append(events, metrics.Sample{
+				Labels: metrics.Labels{"monitor": monitor.id, "label": label},
+				Value:  float64(monitor.stats.Events[label]),
+			})
+		}
+	}
+
+	e.Gauge("nvr_monitor_input_fps",
+		"Frames per second of the last segment.", fps...)
+	e.Gauge("nvr_monitor_input_bits_per_second",
+		"Bits per second of the last segment.", bitrate...)
+	e.Counter("nvr_monitor_input_segments_total",
+		"Number of finalized segments.", segments...)
+	e.Counter("nvr_monitor_input_restarts_total",
+		"Number of times the input process have crashed.", restarts...)
+	e.Counter("nvr_monitor_recording_bytes_total",
+		"Number of bytes written to recordings.", bytesWritten...)
+	e.Counter("nvr_monitor_recordings_saved_total",
+		"Number of saved recordings.", recordingsSaved...)
+	e.Counter("nvr_monitor_events_total",
+		"Number of detections by label.", events...)
+}
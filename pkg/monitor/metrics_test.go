@@ -0,0 +1,83 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package monitor
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"nvr/pkg/metrics"
+	"nvr/pkg/storage"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecorderStats(t *testing.T) {
+	r := &Recorder{}
+	r.stats.onEvent(storage.Event{
+		Detections: []storage.Detection{{Label: "a"}, {Label: "b"}},
+	})
+	r.stats.onEvent(storage.Event{
+		Detections: []storage.Detection{{Label: "a"}},
+	})
+	r.stats.onWritten(10)
+	r.stats.onWritten(5)
+	r.stats.onSaved()
+
+	expected := RecorderStats{
+		BytesWritten:    15,
+		RecordingsSaved: 1,
+		Events:          map[string]uint64{"a": 2, "b": 1},
+	}
+	require.Equal(t, expected, r.Stats())
+}
+
+func TestManagerCollectMetrics(t *testing.T) {
+	m := &Monitor{
+		Config: NewConfig(RawConfig{"id": "x", "enable": "true"}),
+		mainInput: &InputProcess{
+			status: newInputStatus(func(msg string) string { return msg }),
+		},
+		processes: newProcessStatus(func(msg string) string { return msg }),
+		recorder:  &Recorder{},
+	}
+	m.mainInput.status.onSegment(newTestSegment(), time.Now())
+	m.mainInput.status.onCrash(errors.New("x"), false)
+	m.recorder.stats.onEvent(storage.Event{
+		Detections: []storage.Detection{{Label: "a"}},
+	})
+	m.recorder.stats.onWritten(10)
+	m.recorder.stats.onSaved()
+
+	disabled := &Monitor{Config: NewConfig(RawConfig{"id": "y"})}
+
+	manager := &Manager{runningMonitors: monitors{"x": m, "y": disabled}}
+
+	var b bytes.Buffer
+	manager.CollectMetrics(metrics.NewEncoder(&b))
+
+	expected := "# HELP nvr_monitor_input_fps Frames per second of the last segment.\n" +
+		"# TYPE nvr_monitor_input_fps gauge\n" +
+		"nvr_monitor_input_fps{input=\"main\",monitor=\"x\"} 0\n" +
+		"# HELP nvr_monitor_input_bits_per_second Bits per second of the last segment.\n" +
+		"# TYPE nvr_monitor_input_bits_per_second gauge\n" +
+		"nvr_monitor_input_bits_per_second{input=\"main\",monitor=\"x\"} 0\n" +
+		"# HELP nvr_monitor_input_segments_total Number of finalized segments.\n" +
+		"# TYPE nvr_monitor_input_segments_total counter\n" +
+		"nvr_monitor_input_segments_total{input=\"main\",monitor=\"x\"} 1\n" +
+		"# HELP nvr_monitor_input_restarts_total Number of times the input process have crashed.\n" +
+		"# TYPE nvr_monitor_input_restarts_total counter\n" +
+		"nvr_monitor_input_restarts_total{input=\"main\",monitor=\"x\"} 1\n" +
+		"# HELP nvr_monitor_recording_bytes_total Number of bytes written to recordings.\n" +
+		"# TYPE nvr_monitor_recording_bytes_total counter\n" +
+		"nvr_monitor_recording_bytes_total{monitor=\"x\"} 10\n" +
+		"# HELP nvr_monitor_recordings_saved_total Number of saved recordings.\n" +
+		"# TYPE nvr_monitor_recordings_saved_total counter\n" +
+		"nvr_monitor_recordings_saved_total{monitor=\"x\"} 1\n" +
+		"# HELP nvr_monitor_events_total Number of detections by label.\n" +
+		"# TYPE nvr_monitor_events_total counter\n" +
+		"nvr_monitor_events_total{label=\"a\",monitor=\"x\"} 1\n"
+	require.Equal(t, expected, b.String())
+}
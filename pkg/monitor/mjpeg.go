@@ -0,0 +1,498 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package monitor
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"nvr/pkg/backoff"
+	"nvr/pkg/log"
+)
+
+// MJPEGOptions options of a MJPEG stream.
+type MJPEGOptions struct {
+	FPS int
+
+	// Divisor of the frame size, see ffmpeg.ParseScaleString.
+	Scale string
+}
+
+// MJPEGSubscribe subscribes to the JPEG frames of the main input of a
+// monitor. All viewers share a single decoder that is started by the
+// first viewer and stopped when the last one unsubscribes.
+// The channel is closed if the monitor is stopped.
+func (m *Manager) MJPEGSubscribe(id string, opts MJPEGOptions) (<-chan []byte, func(), error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	monitor, exists := m.runningMonitors[id]
+	if !exists {
+		return nil, nil, ErrMonitorNotExist
+	}
+	if monitor.ctx == nil {
+		return nil, nil, ErrMonitorDisabled
+	}
+	frames, unsubscribe := monitor.mjpeg.subscribe(monitor.ctx, opts)
+	return frames, unsubscribe, nil
+}
+
+// mjpegHub keeps track of the MJPEG decoder of a monitor. The decoder
+// is a single process with one output per scale that has viewers,
+// ffmpeg.ParseScaleString limits the number of outputs. The decoder
+// runs at the highest frame rate of its viewers, frames are skipped
+// for slower viewers. It's restarted when the frame rate or the
+// scales change.
+type mjpegHub struct {
+	monitor    *Monitor
+	runDecoder runMJPEGDecoderFunc
+	now        func() time.Time
+
+	// Nil if there are no viewers.
+	decoder *mjpegDecoder
+	mu      sync.Mutex
+}
+
+type runMJPEGDecoderFunc func(context.Context, *mjpegDecoder) error
+
+func newMJPEGHub(m *Monitor) *mjpegHub {
+	return &mjpegHub{
+		monitor:    m,
+		runDecoder: runMJPEGDecoder,
+		now:        time.Now,
+	}
+}
+
+// mjpegDecoderOptions options of the decoder process.
+type mjpegDecoderOptions struct {
+	FPS int
+
+	// Sorted scales, one output per scale.
+	Scales []string
+}
+
+func (o mjpegDecoderOptions) equal(o2 mjpegDecoderOptions) bool {
+	if o.FPS != o2.FPS || len(o.Scales) != len(o2.Scales) {
+		return false
+	}
+	for i := range o.Scales {
+		if o.Scales[i] != o2.Scales[i] {
+			return false
+		}
+	}
+	return true
+}
+
+type mjpegDecoder struct {
+	hub     *mjpegHub
+	opts    mjpegDecoderOptions
+	cancel  func()
+	viewers map[*mjpegViewer]struct{}
+
+	// Cancels the current process, the
+	// decoder is restarted if restart is set.
+	cancelProcess func()
+	restart       bool
+}
+
+type mjpegViewer struct {
+	frames    chan []byte
+	fps       int
+	scale     string
+	lastFrame time.Time
+}
+
+func (h *mjpegHub) subscribe(ctx context.Context, opts MJPEGOptions) (<-chan []byte, func()) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	d := h.decoder
+	if d == nil {
+		ctx2, cancel := context.WithCancel(ctx)
+		d = &mjpegDecoder{
+			hub:           h,
+			cancel:        cancel,
+			viewers:       make(map[*mjpegViewer]struct{}),
+			cancelProcess: func() {},
+		}
+		h.decoder = d
+
+		h.monitor.WG.Add(1)
+		go h.start(ctx2, d)
+	}
+
+	scale := opts.Scale
+	if scale == "" {
+		scale = "1"
+	}
+
+	// Only the latest frame is buffered, slow viewers skip frames.
+	v := &mjpegViewer{
+		frames: make(chan []byte, 1),
+		fps:    opts.FPS,
+		scale:  scale,
+	}
+	d.viewers[v] = struct{}{}
+	d.update()
+
+	unsubscribe := func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+
+		if _, exists := d.viewers[v]; !exists {
+			return
+		}
+		delete(d.viewers, v)
+		if len(d.viewers) == 0 && h.decoder == d {
+			h.decoder = nil
+			d.cancel()
+			return
+		}
+		d.update()
+	}
+	return v.frames, unsubscribe
+}
+
+// update restarts the decoder if the highest frame rate or
+// the scales of the viewers changed. Hub must be locked.
+func (d *mjpegDecoder) update() {
+	opts := mjpegDecoderOptions{}
+	scales := make(map[string]struct{})
+	for v := range d.viewers {
+		if v.fps > opts.FPS {
+			opts.FPS = v.fps
+		}
+		if _, exists := scales[v.scale]; !exists {
+			scales[v.scale] = struct{}{}
+			opts.Scales = append(opts.Scales, v.scale)
+		}
+	}
+	sort.Strings(opts.Scales)
+
+	if opts.FPS == 0 || opts.equal(d.opts) {
+		return
+	}
+	d.opts = opts
+	d.restart = true
+	d.cancelProcess()
+}
+
+// options returns the current options of the decoder.
+func (d *mjpegDecoder) options() mjpegDecoderOptions {
+	d.hub.mu.Lock()
+	defer d.hub.mu.Unlock()
+	return d.opts
+}
+
+// viewerCount returns the number of viewers.
+func (h *mjpegHub) viewerCount() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.decoder == nil {
+		return 0
+	}
+	return len(h.decoder.viewers)
+}
+
+// start runs the decoder until the context is canceled. The
+// viewers are disconnected when the decoder stops.
+func (h *mjpegHub) start(ctx context.Context, d *mjpegDecoder) {
+	defer h.monitor.WG.Done()
+
+	m := h.monitor
+	b := backoff.New(m.Env.RestartBackoff)
+	for ctx.Err() == nil {
+		processCtx, cancelProcess := context.WithCancel(ctx)
+		h.mu.Lock()
+		d.cancelProcess = cancelProcess
+		d.restart = false
+		h.mu.Unlock()
+
+		startTime := time.Now()
+		err := h.runDecoder(processCtx, d)
+		cancelProcess()
+
+		h.mu.Lock()
+		restart := d.restart
+		h.mu.Unlock()
+		if restart {
+			continue
+		}
+
+		if err != nil && ctx.Err() == nil {
+			m.logf(log.LevelError, "mjpeg process: crashed: %v", err)
+		}
+
+		select {
+		case <-time.After(b.Failure(time.Since(startTime))):
+		case <-ctx.Done():
+		}
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.decoder == d {
+		h.decoder = nil
+	}
+	for v := range d.viewers {
+		close(v.frames)
+	}
+	d.viewers = nil
+	d.cancel()
+}
+
+// broadcast sends a frame to all viewers of the scale, it never blocks.
+// Frames are skipped for viewers with a lower frame rate than the decoder.
+func (d *mjpegDecoder) broadcast(scale string, frame []byte) {
+	d.hub.mu.Lock()
+	defer d.hub.mu.Unlock()
+
+	now := d.hub.now()
+	frameInterval := time.Second / time.Duration(d.opts.FPS)
+	for v := range d.viewers {
+		if v.scale != scale {
+			continue
+		}
+		if v.fps < d.opts.FPS {
+			// Allow half a decoder frame of jitter.
+			interval := time.Second/time.Duration(v.fps) - frameInterval/2
+			if now.Sub(v.lastFrame) < interval {
+				continue
+			}
+		}
+		v.lastFrame = now
+
+		select {
+		case v.frames <- frame:
+			continue
+		default:
+		}
+		// Replace the buffered frame.
+		select {
+		case <-v.frames:
+		default:
+		}
+		v.frames <- frame
+	}
+}
+
+// mjpegFirstFD is the file descriptor of the first
+// output pipe, 0-2 are stdin, stdout and stderr.
+const mjpegFirstFD = 3
+
+func runMJPEGDecoder(ctx context.Context, d *mjpegDecoder) error {
+	m := d.hub.monitor
+	i := m.mainInput
+
+	// The stream may take a few seconds to become
+	// available after the monitor started.
+	if _, err := i.HLSMuxer(ctx); err != nil {
+		return fmt.Errorf("get muxer: %w", err)
+	}
+
+	opts := d.options()
+	args := d.generateArgs(opts, i.RTSPprotocol(), i.RTSPaddress())
+	cmd := exec.Command(m.Env.FFmpegBin, args...)
+
+	// Each output is written to its own pipe.
+	var readers, writers []*os.File
+	defer func() {
+		for _, f := range append(readers, writers...) {
+			f.Close()
+		}
+	}()
+	for range opts.Scales {
+		r, w, err := os.Pipe()
+		if err != nil {
+			return fmt.Errorf("pipe: %w", err)
+		}
+		readers = append(readers, r)
+		writers = append(writers, w)
+	}
+	cmd.ExtraFiles = writers
+
+	ffLogLevel := log.FFmpegLevel(m.Config.LogLevel())
+	logFunc := func(msg string) {
+		m.logf(ffLogLevel, "mjpeg process: %v", msg)
+	}
+	process := m.NewProcess(cmd).
+		StderrLogger(logFunc)
+
+	ctx2, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	readerDone := make(chan error, len(readers))
+	for i, r := range readers {
+		go func(scale string, r io.Reader) {
+			readerDone <- d.readFrames(scale, r)
+			cancel()
+		}(opts.Scales[i], r)
+	}
+
+	m.logf(log.LevelDebug, "starting mjpeg process: %v", cmd)
+
+	processErr := process.Start(ctx2)
+
+	// The readers get EOF when the last write end is closed.
+	for _, w := range writers {
+		w.Close()
+	}
+	writers = nil
+
+	var readerErr error
+	for range readers {
+		if err := <-readerDone; !errors.Is(err, io.EOF) && readerErr == nil {
+			readerErr = err
+		}
+	}
+	if processErr != nil {
+		return fmt.Errorf("process: %w", processErr)
+	}
+	if readerErr != nil {
+		return fmt.Errorf("frame reader: %w", readerErr)
+	}
+	return nil
+}
+
+func (d *mjpegDecoder) generateArgs(
+	opts mjpegDecoderOptions,
+	rtspProtocol string,
+	rtspAddress string,
+) []string {
+	// OUTPUT
+	// -threads 1 -loglevel error -hwaccel x -rtsp_transport tcp -i rtsp://x
+	// -filter_complex [0:v]fps=2,split=2[s0][s1];[s0]null[o0];[s1]scale=iw/2:ih/2[o1]
+	// -map [o0] -q:v 7 -f mjpeg pipe:3 -map [o1] -q:v 7 -f mjpeg pipe:4
+
+	c := d.hub.monitor.Config
+	args := []string{"-threads", "1", "-loglevel", c.LogLevel()}
+	if c.Hwaccel() != "" {
+		args = append(args, "-hwaccel", c.Hwaccel())
+	}
+	args = append(args, "-rtsp_transport", rtspProtocol, "-i", rtspAddress)
+
+	filter := "[0:v]fps=" + strconv.Itoa(opts.FPS) + ",split=" + strconv.Itoa(len(opts.Scales))
+	for i := range opts.Scales {
+		filter += "[s" + strconv.Itoa(i) + "]"
+	}
+	var outputs []string
+	for i, scale := range opts.Scales {
+		n := strconv.Itoa(i)
+		if scale == "1" {
+			filter += ";[s" + n + "]null[o" + n + "]"
+		} else {
+			filter += ";[s" + n + "]scale=iw/" + scale + ":ih/" + scale + "[o" + n + "]"
+		}
+		outputs = append(outputs,
+			"-map", "[o"+n+"]", "-q:v", "7", "-f", "mjpeg",
+			"pipe:"+strconv.Itoa(mjpegFirstFD+i))
+	}
+	args = append(args, "-filter_complex", filter)
+	return append(args, outputs...)
+}
+
+func (d *mjpegDecoder) readFrames(scale string, r io.Reader) error {
+	br := bufio.NewReader(r)
+	for {
+		frame, err := readJPEG(br)
+		if err != nil {
+			return err
+		}
+		d.broadcast(scale, frame)
+	}
+}
+
+// JPEG markers.
+const (
+	jpegSOI = 0xD8 // Start of image.
+	jpegEOI = 0xD9 // End of image.
+	jpegSOS = 0xDA // Start of scan.
+)
+
+// ErrInvalidJPEG invalid JPEG.
+var ErrInvalidJPEG = errors.New("invalid jpeg")
+
+// readJPEG reads a single image from a stream of concatenated JPEG images.
+// Returns io.EOF if the stream ended between images.
+func readJPEG(r *bufio.Reader) ([]byte, error) {
+	var buf bytes.Buffer
+
+	soi := make([]byte, 2)
+	if _, err := io.ReadFull(r, soi); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, ErrInvalidJPEG
+		}
+		return nil, err
+	}
+	if soi[0] != 0xFF || soi[1] != jpegSOI {
+		return nil, fmt.Errorf("%w: missing start of image", ErrInvalidJPEG)
+	}
+	buf.Write(soi)
+
+	readByte := func() (byte, error) {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, fmt.Errorf("%w: %v", ErrInvalidJPEG, err)
+		}
+		buf.WriteByte(b)
+		return b, nil
+	}
+
+	inScan := false
+	for {
+		b, err := readByte()
+		if err != nil {
+			return nil, err
+		}
+		if b != 0xFF {
+			if inScan {
+				continue
+			}
+			return nil, fmt.Errorf("%w: expected marker", ErrInvalidJPEG)
+		}
+
+		marker, err := readByte()
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case marker == 0x00 || marker == 0xFF:
+			// Stuffed byte or fill byte in the entropy-coded data.
+			if marker == 0xFF {
+				r.UnreadByte() //nolint:errcheck
+				buf.Truncate(buf.Len() - 1)
+			}
+			continue
+		case marker >= 0xD0 && marker <= 0xD7:
+			// Restart markers don't have a payload.
+			continue
+		case marker == jpegEOI:
+			return buf.Bytes(), nil
+		}
+
+		// Marker segment, the length includes itself.
+		lenBytes := make([]byte, 2)
+		if _, err := io.ReadFull(r, lenBytes); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidJPEG, err)
+		}
+		buf.Write(lenBytes)
+		length := int(lenBytes[0])<<8 | int(lenBytes[1])
+		if length < 2 {
+			return nil, fmt.Errorf("%w: segment length", ErrInvalidJPEG)
+		}
+		if _, err := io.CopyN(&buf, r, int64(length-2)); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidJPEG, err)
+		}
+		inScan = marker == jpegSOS
+	}
+}
@@ -0,0 +1,257 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package monitor
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"testing"
+	"time"
+
+	"nvr/pkg/log"
+
+	"github.com/stretchr/testify/require"
+)
+
+func encodeTestJPEG(t *testing.T, seed uint8) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, 32, 16))
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 32; x++ {
+			v := uint8(x*y) + seed
+			img.Set(x, y, color.RGBA{v, 255 - v, v ^ 0xFF, 255})
+		}
+	}
+	var buf bytes.Buffer
+	require.NoError(t, jpeg.Encode(&buf, img, &jpeg.Options{Quality: 100}))
+	return buf.Bytes()
+}
+
+func TestReadJPEG(t *testing.T) {
+	t.Run("ok", func(t *testing.T) {
+		img1 := encodeTestJPEG(t, 0)
+		img2 := encodeTestJPEG(t, 100)
+
+		r := bufio.NewReader(bytes.NewReader(append(append([]byte{}, img1...), img2...)))
+		frame, err := readJPEG(r)
+		require.NoError(t, err)
+		require.Equal(t, img1, frame)
+
+		frame, err = readJPEG(r)
+		require.NoError(t, err)
+		require.Equal(t, img2, frame)
+
+		_, err = readJPEG(r)
+		require.ErrorIs(t, err, io.EOF)
+	})
+	t.Run("truncated", func(t *testing.T) {
+		img := encodeTestJPEG(t, 0)
+		r := bufio.NewReader(bytes.NewReader(img[:len(img)-1]))
+		_, err := readJPEG(r)
+		require.ErrorIs(t, err, ErrInvalidJPEG)
+	})
+	t.Run("noSOI", func(t *testing.T) {
+		r := bufio.NewReader(bytes.NewReader([]byte{0xFF, 0xD9}))
+		_, err := readJPEG(r)
+		require.ErrorIs(t, err, ErrInvalidJPEG)
+	})
+}
+
+func newTestMJPEGHub(runDecoder runMJPEGDecoderFunc) *mjpegHub {
+	m := &Monitor{
+		Config: NewConfig(RawConfig{"id": "1"}),
+		logf:   func(log.Level, string, ...interface{}) {},
+	}
+	h := newMJPEGHub(m)
+	h.runDecoder = runDecoder
+	return h
+}
+
+func TestMJPEGHub(t *testing.T) {
+	t.Run("shared", func(t *testing.T) {
+		started := make(chan mjpegDecoderOptions, 10)
+		stopped := make(chan mjpegDecoderOptions, 10)
+		h := newTestMJPEGHub(func(ctx context.Context, d *mjpegDecoder) error {
+			started <- d.options()
+			d.broadcast("2", []byte{1})
+			<-ctx.Done()
+			stopped <- d.options()
+			return nil
+		})
+
+		opts := MJPEGOptions{FPS: 2, Scale: "2"}
+		expected := mjpegDecoderOptions{FPS: 2, Scales: []string{"2"}}
+		frames1, unsubscribe1 := h.subscribe(context.Background(), opts)
+		require.Equal(t, expected, <-started)
+		require.Equal(t, []byte{1}, <-frames1)
+
+		// The second viewer shares the decoder.
+		_, unsubscribe2 := h.subscribe(context.Background(), opts)
+		require.Equal(t, 2, h.viewerCount())
+
+		unsubscribe1()
+		unsubscribe1()
+		require.Equal(t, 1, h.viewerCount())
+
+		unsubscribe2()
+		require.Equal(t, expected, <-stopped)
+		h.monitor.WG.Wait()
+		require.Equal(t, 0, h.viewerCount())
+		require.Empty(t, started)
+	})
+	t.Run("restart", func(t *testing.T) {
+		started := make(chan mjpegDecoderOptions, 10)
+		h := newTestMJPEGHub(func(ctx context.Context, d *mjpegDecoder) error {
+			started <- d.options()
+			<-ctx.Done()
+			return nil
+		})
+
+		_, unsubscribe1 := h.subscribe(context.Background(), MJPEGOptions{FPS: 2, Scale: "1"})
+		require.Equal(t, mjpegDecoderOptions{FPS: 2, Scales: []string{"1"}}, <-started)
+
+		// A faster viewer restarts the decoder.
+		_, unsubscribe2 := h.subscribe(context.Background(), MJPEGOptions{FPS: 5, Scale: "1"})
+		require.Equal(t, mjpegDecoderOptions{FPS: 5, Scales: []string{"1"}}, <-started)
+
+		// Other scales are added as outputs of the same decoder.
+		_, unsubscribe3 := h.subscribe(context.Background(), MJPEGOptions{FPS: 1, Scale: "2"})
+		require.Equal(t, mjpegDecoderOptions{FPS: 5, Scales: []string{"1", "2"}}, <-started)
+
+		// Same options, no restart.
+		_, unsubscribe4 := h.subscribe(context.Background(), MJPEGOptions{FPS: 1, Scale: "1"})
+
+		unsubscribe2()
+		require.Equal(t, mjpegDecoderOptions{FPS: 2, Scales: []string{"1", "2"}}, <-started)
+
+		unsubscribe3()
+		require.Equal(t, mjpegDecoderOptions{FPS: 2, Scales: []string{"1"}}, <-started)
+
+		unsubscribe1()
+		require.Equal(t, mjpegDecoderOptions{FPS: 1, Scales: []string{"1"}}, <-started)
+
+		unsubscribe4()
+		h.monitor.WG.Wait()
+		require.Empty(t, started)
+	})
+	t.Run("skipFrames", func(t *testing.T) {
+		ready := make(chan *mjpegDecoder)
+		h := newTestMJPEGHub(func(ctx context.Context, d *mjpegDecoder) error {
+			if d.options().FPS == 10 {
+				ready <- d
+			}
+			<-ctx.Done()
+			return nil
+		})
+		now := time.Unix(0, 0)
+		h.now = func() time.Time { return now }
+
+		slow, unsubscribe1 := h.subscribe(context.Background(), MJPEGOptions{FPS: 2, Scale: "1"})
+		fast, unsubscribe2 := h.subscribe(context.Background(), MJPEGOptions{FPS: 10, Scale: "1"})
+		d := <-ready
+
+		received := func(frames <-chan []byte) int {
+			select {
+			case <-frames:
+				return 1
+			default:
+				return 0
+			}
+		}
+		slowCount, fastCount := 0, 0
+		for i := 0; i < 10; i++ {
+			d.broadcast("1", []byte{byte(i)})
+			slowCount += received(slow)
+			fastCount += received(fast)
+			now = now.Add(100 * time.Millisecond)
+		}
+		require.Equal(t, 2, slowCount)
+		require.Equal(t, 10, fastCount)
+
+		unsubscribe1()
+		unsubscribe2()
+		h.monitor.WG.Wait()
+	})
+	t.Run("scales", func(t *testing.T) {
+		ready := make(chan *mjpegDecoder)
+		h := newTestMJPEGHub(func(ctx context.Context, d *mjpegDecoder) error {
+			if len(d.options().Scales) == 2 {
+				ready <- d
+			}
+			<-ctx.Done()
+			return nil
+		})
+
+		full, unsubscribe1 := h.subscribe(context.Background(), MJPEGOptions{FPS: 1, Scale: "1"})
+		half, unsubscribe2 := h.subscribe(context.Background(), MJPEGOptions{FPS: 1, Scale: "2"})
+		d := <-ready
+
+		d.broadcast("1", []byte{1})
+		d.broadcast("2", []byte{2})
+		require.Equal(t, []byte{1}, <-full)
+		require.Equal(t, []byte{2}, <-half)
+		require.Empty(t, full)
+		require.Empty(t, half)
+
+		unsubscribe1()
+		unsubscribe2()
+		h.monitor.WG.Wait()
+	})
+	t.Run("monitorStopped", func(t *testing.T) {
+		h := newTestMJPEGHub(func(ctx context.Context, d *mjpegDecoder) error {
+			<-ctx.Done()
+			return nil
+		})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		frames, unsubscribe := h.subscribe(ctx, MJPEGOptions{FPS: 1, Scale: "1"})
+		defer unsubscribe()
+
+		cancel()
+		select {
+		case _, ok := <-frames:
+			require.False(t, ok)
+		case <-time.After(time.Second):
+			t.Fatal("timeout")
+		}
+		h.monitor.WG.Wait()
+	})
+	t.Run("latestFrame", func(t *testing.T) {
+		broadcast := make(chan struct{})
+		h := newTestMJPEGHub(func(ctx context.Context, d *mjpegDecoder) error {
+			d.broadcast("1", []byte{1})
+			d.broadcast("1", []byte{2})
+			close(broadcast)
+			<-ctx.Done()
+			return nil
+		})
+
+		frames, unsubscribe := h.subscribe(context.Background(), MJPEGOptions{FPS: 1})
+		<-broadcast
+		require.Equal(t, []byte{2}, <-frames)
+		unsubscribe()
+		h.monitor.WG.Wait()
+	})
+}
+
+func TestMJPEGGenerateArgs(t *testing.T) {
+	h := newTestMJPEGHub(nil)
+	h.monitor.Config = NewConfig(RawConfig{"logLevel": "error", "hwaccel": "x"})
+	d := &mjpegDecoder{hub: h}
+
+	opts := mjpegDecoderOptions{FPS: 2, Scales: []string{"1", "2"}}
+	actual := d.generateArgs(opts, "tcp", "rtsp://x")
+	expected := []string{
+		"-threads", "1", "-loglevel", "error", "-hwaccel", "x",
+		"-rtsp_transport", "tcp", "-i", "rtsp://x",
+		"-filter_complex", "[0:v]fps=2,split=2[s0][s1];[s0]null[o0];[s1]scale=iw/2:ih/2[o1]",
+		"-map", "[o0]", "-q:v", "7", "-f", "mjpeg", "pipe:3",
+		"-map", "[o1]", "-q:v", "7", "-f", "mjpeg", "pipe:4",
+	}
+	require.Equal(t, expected, actual)
+}
@@ -4,10 +4,10 @@ package monitor
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
-	"io/fs"
+	"nvr/pkg/backoff"
+	"nvr/pkg/configstore"
 	"nvr/pkg/ffmpeg"
 	"nvr/pkg/log"
 	"nvr/pkg/storage"
@@ -16,7 +16,6 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
-	"strings"
 	"sync"
 	"time"
 )
@@ -39,6 +38,21 @@ type RecSavedHook func(*Recorder, string, storage.RecordingData)
 // MigationHook is called when each monitor config is loaded.
 type MigationHook func(RawConfig) error
 
+// CrashLoopHook is called when a process starts crash-looping.
+type CrashLoopHook func(CrashLoop)
+
+// CrashLoop is sent to the crash loop hook when a
+// process have failed too many times in a row.
+type CrashLoop struct {
+	MonitorID string
+	Process   string // "main", "sub", "recorder", "motion", "doods".
+	Failures  int
+	Err       error
+
+	Config Config
+	Logger log.ILogger
+}
+
 // Hooks monitor hooks.
 type Hooks struct {
 	Start      StartHook
@@ -47,6 +61,14 @@ type Hooks struct {
 	RecSave    RecSaveHook
 	RecSaved   RecSavedHook
 	Migrate    MigationHook
+	CrashLoop  CrashLoopHook
+
+	// Addon config keys that are read when the input
+	// processes start. Changing them restarts the inputs.
+	InputKeys []string
+
+	// Addon config fields.
+	Fields []Field
 }
 
 // Manager for the monitors.
@@ -59,6 +81,7 @@ type Manager struct {
 	videoServer *video.Server
 	path        string
 	hooks       Hooks
+	schema      Schema
 	mu          sync.Mutex
 }
 
@@ -74,29 +97,29 @@ func NewManager(
 		return nil, fmt.Errorf("create monitors directory: %w", err)
 	}
 
-	configFS := os.DirFS(configPath)
-	configFiles, err := readConfigs(configFS)
+	configFiles, err := configstore.List(configPath)
 	if err != nil {
-		return nil, fmt.Errorf("read config files: %w", err)
+		return nil, fmt.Errorf("list config files: %w", err)
 	}
 
 	rawConfigs := make(RawConfigs)
 	for _, file := range configFiles {
 		var rawConf RawConfig
-		if err := json.Unmarshal(file, &rawConf); err != nil {
-			return nil, fmt.Errorf("unmarshal config: %w: %v", err, string(file))
+		if err := configstore.ReadJSON(file, &rawConf); err != nil {
+			return nil, fmt.Errorf("read config: %w", err)
 		}
+		oldETag := ConfigETag(rawConf)
 		if err := hooks.Migrate(rawConf); err != nil {
 			return nil, fmt.Errorf("migration failed: %w", err)
 		}
 
 		id := rawConf["id"]
-		configPath := monitorConfigPath(configPath, id)
-
-		jsonConf, _ := json.MarshalIndent(rawConf, "", "    ")
-		err := os.WriteFile(configPath, jsonConf, 0o600)
-		if err != nil {
-			return nil, fmt.Errorf("write migrated config: %w", err)
+		// Only write migrated configs, writing replaces the backup.
+		if ConfigETag(rawConf) != oldETag {
+			err := configstore.WriteJSON(monitorConfigPath(configPath, id), rawConf)
+			if err != nil {
+				return nil, fmt.Errorf("write migrated config: %w", err)
+			}
 		}
 
 		rawConfigs[id] = rawConf
@@ -111,29 +134,10 @@ func NewManager(
 		videoServer: videoServer,
 		path:        configPath,
 		hooks:       *hooks,
+		schema:      newSchema(hooks.Fields),
 	}, nil
 }
 
-func readConfigs(fileSystem fs.FS) ([][]byte, error) {
-	var files [][]byte
-	walkFunc := func(path string, d fs.DirEntry, err error) error {
-		if err != nil {
-			return err
-		}
-		if d.IsDir() || !strings.Contains(path, ".json") {
-			return nil
-		}
-		file, err := fs.ReadFile(fileSystem, path)
-		if err != nil {
-			return fmt.Errorf("read file: %v %w", path, err)
-		}
-		files = append(files, file)
-		return nil
-	}
-	err := fs.WalkDir(fileSystem, ".", walkFunc)
-	return files, err
-}
-
 func (m *Manager) unsafeStartMonitor(id string) {
 	rawConf := m.rawConfigs[id]
 	monitor := m.newMonitor(NewConfig(rawConf))
@@ -183,24 +187,76 @@ func (m *Manager) RestartMonitor(id string) error {
 	return nil
 }
 
-// MonitorSet sets config for specified monitor.
-// Changes are not applied until the montior restarts.
-func (m *Manager) MonitorSet(id string, rawConf RawConfig) error {
+// ErrMonitorDisabled monitor is disabled.
+var ErrMonitorDisabled = errors.New("monitor is disabled")
+
+// SendEvent sends an event to the recorder of a monitor.
+func (m *Manager) SendEvent(id string, event storage.Event) error {
+	m.mu.Lock()
+	monitor, exists := m.runningMonitors[id]
+	enabled := exists && monitor.ctx != nil
+	m.mu.Unlock()
+	if !exists {
+		return ErrMonitorNotExist
+	}
+	if !enabled {
+		return ErrMonitorDisabled
+	}
+
+	// Sending blocks until the recorder receives the event.
+	return monitor.SendEvent(event)
+}
+
+// MonitorSet sets config for specified monitor and applies the
+// changes. Only the processes affected by the change are restarted.
+func (m *Manager) MonitorSet(id string, rawConf RawConfig) (ApplyAction, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
+	return m.unsafeMonitorSet(id, rawConf)
+}
 
+func (m *Manager) unsafeMonitorSet(id string, rawConf RawConfig) (ApplyAction, error) {
 	// Write config to file.
-	configJSON, err := json.MarshalIndent(rawConf, "", "    ")
-	if err != nil {
-		return fmt.Errorf("marshal config file: %w", err)
-	}
-	err = os.WriteFile(m.configPath(id), configJSON, 0o600)
+	err := configstore.WriteJSON(m.configPath(id), rawConf)
 	if err != nil {
-		return fmt.Errorf("write config file: %w", err)
+		return "", fmt.Errorf("write config file: %w", err)
 	}
 
+	oldConf := m.rawConfigs[id]
 	m.rawConfigs[id] = rawConf
-	return nil
+
+	monitor, running := m.runningMonitors[id]
+	if !running {
+		m.unsafeStartMonitor(id)
+		return ApplyRestartMonitor, nil
+	}
+
+	action := diffConfig(oldConf, rawConf, m.hooks.InputKeys)
+	if action == ApplyRestartMonitor {
+		m.unsafeStopMonitor(id)
+		m.unsafeStartMonitor(id)
+		return action, nil
+	}
+
+	monitor.Config.set(rawConf)
+	if action == ApplyNone {
+		return action, nil
+	}
+	if !monitor.Config.enabled() {
+		// Nothing is running.
+		return ApplyHot, nil
+	}
+
+	switch action {
+	case ApplyRestartMain:
+		monitor.mainInput.restart()
+	case ApplyRestartSub:
+		monitor.subInput.restart()
+	case ApplyRestartInputs:
+		monitor.mainInput.restart()
+		monitor.subInput.restart()
+	}
+	return action, nil
 }
 
 // ErrNotExist monitor does not exist.
@@ -220,7 +276,7 @@ func (m *Manager) MonitorDelete(id string) error {
 	delete(m.runningMonitors, id)
 	delete(m.rawConfigs, id)
 
-	if err := os.Remove(m.configPath(id)); err != nil {
+	if err := configstore.Remove(m.configPath(id)); err != nil {
 		return err
 	}
 
@@ -297,7 +353,10 @@ type Monitor struct {
 
 	mainInput *InputProcess
 	subInput  *InputProcess
+	processes *processStatus
 	recorder  *Recorder
+	mjpeg     *mjpegHub
+	snapshots *snapshotCache
 	Recorder
 	hooks      Hooks
 	NewProcess ffmpeg.NewProcessFunc
@@ -333,9 +392,14 @@ func (m *Manager) newMonitor(config Config) *Monitor {
 		NewProcess: ffmpeg.NewProcess,
 		logf:       logf,
 	}
+	monitor.processes = newProcessStatus(func(msg string) string {
+		return m.env.CensorLog(config.CensorLog(msg))
+	})
 	monitor.mainInput = newInputProcess(monitor, false)
 	monitor.subInput = newInputProcess(monitor, true)
 	monitor.recorder = newRecorder(monitor)
+	monitor.mjpeg = newMJPEGHub(monitor)
+	monitor.snapshots = newSnapshotCache(monitor.generateSnapshot)
 
 	return monitor
 }
@@ -406,7 +470,11 @@ type InputProcess struct {
 	serverPath video.ServerPath
 	isSubInput bool
 
-	cancel func()
+	cancel     func()
+	restarting bool // Set when the process is restarted to apply a new config.
+	mu         sync.Mutex
+	status     *inputStatus
+	processes  *processStatus
 
 	hooks     Hooks
 	Env       storage.ConfigEnv
@@ -429,6 +497,11 @@ func newInputProcess(m *Monitor, isSubInput bool) *InputProcess {
 		Config:     m.Config,
 		isSubInput: isSubInput,
 
+		status: newInputStatus(func(msg string) string {
+			return m.Env.CensorLog(m.Config.CensorLog(msg))
+		}),
+		processes: m.processes,
+
 		hooks:     m.hooks,
 		Env:       m.Env,
 		Logger:    m.Logger,
@@ -449,26 +522,33 @@ func (i *InputProcess) IsSubInput() bool {
 	return i.isSubInput
 }
 
+// path returns the server path, it's set when the process starts.
+func (i *InputProcess) path() video.ServerPath {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.serverPath
+}
+
 // HLSaddress internal HLS address.
 func (i *InputProcess) HLSaddress() string {
-	return i.serverPath.HlsAddress
+	return i.path().HlsAddress
 }
 
 // RTSPaddress internal RTSP address.
 func (i *InputProcess) RTSPaddress() string {
-	return i.serverPath.RtspAddress
+	return i.path().RtspAddress
 }
 
 // RTSPprotocol protocol used by RTSP address.
 func (i *InputProcess) RTSPprotocol() string {
-	return i.serverPath.RtspProtocol
+	return i.path().RtspProtocol
 }
 
 // VideoTrack returns the stream video track.
 func (i *InputProcess) VideoTrack(ctx context.Context) (*gortsplib.TrackH264, error) {
 	// It may take a few seconds for the stream to
 	// become available after the monitor started.
-	muxer, err := i.serverPath.HLSMuxer(ctx)
+	muxer, err := i.HLSMuxer(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("get muxer: %w", err)
 	}
@@ -479,16 +559,23 @@ func (i *InputProcess) VideoTrack(ctx context.Context) (*gortsplib.TrackH264, er
 func (i *InputProcess) AudioTrack(ctx context.Context) (*gortsplib.TrackMPEG4Audio, error) {
 	// It may take a few seconds for the stream to
 	// become available after the monitor started.
-	muxer, err := i.serverPath.HLSMuxer(ctx)
+	muxer, err := i.HLSMuxer(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("get muxer: %w", err)
 	}
 	return muxer.AudioTrack(), nil
 }
 
+// ErrInputNotStarted input process has not started.
+var ErrInputNotStarted = errors.New("input process has not started")
+
 // HLSMuxer returns the HLS muxer for this input.
 func (i *InputProcess) HLSMuxer(ctx context.Context) (video.IHLSMuxer, error) {
-	return i.serverPath.HLSMuxer(ctx)
+	hlsMuxer := i.path().HLSMuxer
+	if hlsMuxer == nil {
+		return nil, ErrInputNotStarted
+	}
+	return hlsMuxer(ctx)
 }
 
 // ProcessName name of process "main" or "sub".
@@ -515,10 +602,65 @@ func (i *InputProcess) rtspPathName() string {
 
 // Cancel process context.
 func (i *InputProcess) Cancel() {
-	i.cancel()
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.cancel != nil {
+		i.cancel()
+	}
+}
+
+// restart the process without counting it as a crash.
+func (i *InputProcess) restart() {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.cancel != nil {
+		i.restarting = true
+		i.cancel()
+	}
+}
+
+// wasRestarted returns true once if the process was restarted.
+func (i *InputProcess) wasRestarted() bool {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	restarting := i.restarting
+	i.restarting = false
+	return restarting
+}
+
+// ReportCrash updates the monitor status after the process crashed and
+// calls the crash loop hook when a crash loop is detected. Addons use
+// this to report crashes of their processes, b is the restart backoff.
+func (i *InputProcess) ReportCrash(process string, err error, b *backoff.Backoff) {
+	i.processes.onCrash(process, err, b.CrashLooping())
+	if b.CrashLoopDetected() {
+		i.reportCrashLoop(process, b.Failures(), err)
+	}
+}
+
+// ReportRunning is called when the process is started. The process is
+// no longer crash-looping once it has run longer than the backoff reset
+// time. Returns a function that must be called when the process exits.
+func (i *InputProcess) ReportRunning(process string, b *backoff.Backoff) func() {
+	timer := time.AfterFunc(b.ResetAfter(), func() {
+		i.processes.onRecovered(process)
+	})
+	return func() { timer.Stop() }
+}
+
+func (i *InputProcess) reportCrashLoop(process string, failures int, err error) {
+	i.hooks.CrashLoop(CrashLoop{
+		MonitorID: i.Config.ID(),
+		Process:   process,
+		Failures:  failures,
+		Err:       err,
+		Config:    i.Config,
+		Logger:    i.Logger,
+	})
 }
 
 func (i *InputProcess) start(ctx context.Context) {
+	b := backoff.New(i.Env.RestartBackoff)
 	for {
 		if ctx.Err() != nil {
 			i.logf(log.LevelInfo, "%v process: stopped", i.ProcessName())
@@ -526,11 +668,27 @@ func (i *InputProcess) start(ctx context.Context) {
 			return
 		}
 
-		if err := i.runInputProcess(ctx, i); err != nil {
+		i.status.setState(StateConnecting)
+		startTime := time.Now()
+		err := i.runInputProcess(ctx, i)
+		if i.wasRestarted() {
+			i.logf(log.LevelInfo, "%v process: restarting to apply new config", i.ProcessName())
+			continue
+		}
+		if err != nil {
+			delay := b.Failure(time.Since(startTime))
+			i.status.onCrash(err, b.CrashLooping())
 			i.logf(log.LevelError, "%v process: crashed: %v", i.ProcessName(), err)
+
+			if b.CrashLoopDetected() {
+				i.logf(log.LevelError, "%v process: crash-looping after %v consecutive failures",
+					i.ProcessName(), b.Failures())
+				i.reportCrashLoop(i.ProcessName(), b.Failures(), err)
+			}
+
 			select {
 			case <-ctx.Done():
-			case <-time.After(1 * time.Second):
+			case <-time.After(delay):
 			}
 			continue
 		}
@@ -539,15 +697,28 @@ func (i *InputProcess) start(ctx context.Context) {
 
 func runInputProcess(ctx context.Context, i *InputProcess) error {
 	processCTX, cancel2 := context.WithCancel(ctx)
+	i.mu.Lock()
 	i.cancel = cancel2
-	defer cancel2()
+	i.mu.Unlock()
+	defer func() {
+		i.mu.Lock()
+		i.cancel = nil
+		i.mu.Unlock()
+		cancel2()
+	}()
 
 	pathConf := video.PathConf{MonitorID: i.Config.ID(), IsSub: i.IsSubInput()}
+	pathConf.MulticastGroup, pathConf.MulticastPort = i.Config.Multicast(i.IsSubInput())
+	pathConf.DVRWindow = i.Config.DVRWindow()
 	serverPath, err := i.newVideoServerPath(processCTX, i.rtspPathName(), pathConf)
 	if err != nil {
 		return fmt.Errorf("add path to RTSP server: %w", err)
 	}
+	i.mu.Lock()
 	i.serverPath = *serverPath
+	i.mu.Unlock()
+
+	go i.trackSegments(processCTX)
 
 	logLevel := log.FFmpegLevel(i.Config.LogLevel())
 	args := ffmpeg.ParseArgs(i.generateArgs())
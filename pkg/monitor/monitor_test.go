@@ -132,6 +132,27 @@ func TestNewManager(t *testing.T) {
 }`
 		require.Equal(t, expected2, string(actual2))
 	})
+	t.Run("unchanged", func(t *testing.T) {
+		configDir := prepareDir(t)
+
+		data := []byte(`{"id":"x"}`)
+		configPath := configDir + "/x.json"
+		require.NoError(t, os.WriteFile(configPath, data, 0o600))
+
+		_, err := NewManager(
+			configDir,
+			storage.ConfigEnv{},
+			&log.Logger{},
+			&video.Server{},
+			&Hooks{Migrate: func(RawConfig) error { return nil }},
+		)
+		require.NoError(t, err)
+
+		// The file wasn't rewritten.
+		actual, err := os.ReadFile(configPath)
+		require.NoError(t, err)
+		require.Equal(t, data, actual)
+	})
 	t.Run("mkDirErr", func(t *testing.T) {
 		_, err := NewManager("/dev/null/nil", storage.ConfigEnv{}, nil, nil, nil)
 		require.Error(t, err)
@@ -163,6 +184,27 @@ func TestNewManager(t *testing.T) {
 		var e *json.SyntaxError
 		require.ErrorAs(t, err, &e)
 	})
+	t.Run("backupFallback", func(t *testing.T) {
+		configDir := prepareDir(t)
+
+		configPath := configDir + "/1.json"
+		backup, err := os.ReadFile(configPath)
+		require.NoError(t, err)
+		err = os.WriteFile(configPath+".bak", backup, 0o600)
+		require.NoError(t, err)
+		err = os.WriteFile(configPath, []byte("{"), 0o600)
+		require.NoError(t, err)
+
+		manager, err := NewManager(
+			configDir,
+			storage.ConfigEnv{},
+			&log.Logger{},
+			&video.Server{},
+			&Hooks{Migrate: func(RawConfig) error { return nil }},
+		)
+		require.NoError(t, err)
+		require.Equal(t, readConfig(t, configPath), manager.rawConfigs["1"])
+	})
 	t.Run("migrationErr", func(t *testing.T) {
 		configDir := prepareDir(t)
 
@@ -190,8 +232,9 @@ func TestMonitorSet(t *testing.T) {
 		config := manager.rawConfigs["1"]
 		config["name"] = "new"
 
-		err := manager.MonitorSet("new", config)
+		action, err := manager.MonitorSet("new", config)
 		require.NoError(t, err)
+		require.Equal(t, ApplyRestartMonitor, action)
 
 		newName := manager.rawConfigs["new"]["name"]
 		require.Equal(t, newName, "new")
@@ -210,7 +253,7 @@ func TestMonitorSet(t *testing.T) {
 
 		config := RawConfig{"name": "two"}
 
-		err := manager.MonitorSet("1", config)
+		_, err := manager.MonitorSet("1", config)
 		require.NoError(t, err)
 
 		newName := manager.rawConfigs["1"]["name"]
@@ -224,9 +267,41 @@ func TestMonitorSet(t *testing.T) {
 		_, manager := newTestManager(t)
 		manager.path = "/dev/null"
 
-		err := manager.MonitorSet("1", RawConfig{})
+		_, err := manager.MonitorSet("1", RawConfig{})
 		require.Error(t, err)
 	})
+	t.Run("hot", func(t *testing.T) {
+		_, manager := newTestManager(t)
+		manager.StartMonitors()
+		monitor := manager.runningMonitors["1"]
+
+		config := RawConfig{}
+		for k, v := range manager.rawConfigs["1"] {
+			config[k] = v
+		}
+		config["name"] = "new"
+
+		action, err := manager.MonitorSet("1", config)
+		require.NoError(t, err)
+		require.Equal(t, ApplyHot, action)
+
+		// The monitor was not restarted.
+		require.Equal(t, monitor, manager.runningMonitors["1"])
+		require.Equal(t, "new", monitor.Config.Name())
+		require.Equal(t, "new", monitor.recorder.Config.Name())
+	})
+	t.Run("restartMonitor", func(t *testing.T) {
+		_, manager := newTestManager(t)
+		manager.StartMonitors()
+		monitor := manager.runningMonitors["1"]
+
+		config := RawConfig{"id": "1", "enable": "false", "alwaysRecord": "true"}
+		action, err := manager.MonitorSet("1", config)
+		require.NoError(t, err)
+		require.Equal(t, ApplyRestartMonitor, action)
+		require.NotEqual(t, fmt.Sprintf("%p", monitor),
+			fmt.Sprintf("%p", manager.runningMonitors["1"]))
+	})
 }
 
 func TestMonitorDelete(t *testing.T) {
@@ -352,7 +427,9 @@ func stubNewVideoServerPath(
 	if name == "" {
 		return nil, video.ErrEmptyPathName
 	}
-	return &video.ServerPath{}, nil
+	return &video.ServerPath{
+		HLSMuxer: newMockMuxerFunc(&mockMuxer{getMuxerErr: context.Canceled}),
+	}, nil
 }
 
 func newTestInputProcess() *InputProcess {
@@ -366,6 +443,9 @@ func newTestInputProcess() *InputProcess {
 			HlsAddress: "hls.m3u8",
 		},
 
+		status:    newInputStatus(func(msg string) string { return msg }),
+		processes: newProcessStatus(func(msg string) string { return msg }),
+
 		logf:  func(level log.Level, format string, a ...interface{}) {},
 		hooks: stubHooks(),
 		WG:    &sync.WaitGroup{},
@@ -383,6 +463,7 @@ func stubHooks() Hooks {
 		Event:      func(*Recorder, *storage.Event) {},
 		RecSave:    func(*Recorder, *string) {},
 		RecSaved:   func(*Recorder, string, storage.RecordingData) {},
+		CrashLoop:  func(CrashLoop) {},
 	}
 }
 
@@ -454,6 +535,39 @@ func TestStartInputProcess(t *testing.T) {
 		cancel()
 		<-logs
 	})
+	t.Run("restart", func(t *testing.T) {
+		logs := make(chan string)
+		defer close(logs)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		input := newTestInputProcess()
+
+		started := make(chan struct{})
+		input.runInputProcess = func(ctx context.Context, i *InputProcess) error {
+			processCtx, cancel2 := context.WithCancel(ctx)
+			i.mu.Lock()
+			i.cancel = cancel2
+			i.mu.Unlock()
+			started <- struct{}{}
+			<-processCtx.Done()
+			return processCtx.Err()
+		}
+		input.logf = func(level log.Level, format string, a ...interface{}) {
+			logs <- fmt.Sprintf(format, a...)
+		}
+		input.WG.Add(1)
+		go input.start(ctx)
+
+		<-started
+		input.restart()
+		require.Equal(t, "main process: restarting to apply new config", <-logs)
+		<-started
+		require.Equal(t, 0, input.Status().CrashCount)
+
+		cancel()
+		<-logs
+		<-logs
+	})
 }
 
 func TestRunInputProcess(t *testing.T) {
@@ -465,7 +579,7 @@ func TestRunInputProcess(t *testing.T) {
 	})
 	t.Run("rtspPathErr", func(t *testing.T) {
 		i := newTestInputProcess()
-		i.Config.v["id"] = ""
+		i.Config.v.m["id"] = ""
 		err := runInputProcess(context.Background(), i)
 		require.ErrorIs(t, err, video.ErrEmptyPathName)
 	})
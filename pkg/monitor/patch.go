@@ -0,0 +1,199 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package monitor
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ConfigETag returns a strong entity tag for the config.
+// The tag changes when any value changes.
+func ConfigETag(c RawConfig) string {
+	// Map keys are sorted by json.Marshal.
+	raw, _ := json.Marshal(c) //nolint:errchkjson
+	hash := sha256.Sum256(raw)
+	return `"` + hex.EncodeToString(hash[:16]) + `"`
+}
+
+// MatchETag returns true if the If-Match or If-None-Match header value
+// matches etag. The value is "*" or a comma separated list of tags.
+// Weak tags match their strong equivalent, proxies may weaken
+// the tag when they compress the response.
+func MatchETag(header string, etag string) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" {
+			return true
+		}
+		if strings.TrimPrefix(tag, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// MonitorConfig returns a copy of the config for a single monitor and its ETag.
+func (m *Manager) MonitorConfig(id string) (RawConfig, string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	rawConf, exists := m.rawConfigs[id]
+	if !exists {
+		return nil, "", ErrNotExist
+	}
+	conf := make(RawConfig, len(rawConf))
+	for key, value := range rawConf {
+		conf[key] = value
+	}
+	return conf, ConfigETag(rawConf), nil
+}
+
+// Patch errors.
+var (
+	ErrConfigChanged = errors.New("config was changed by someone else")
+	ErrInvalidPatch  = errors.New("invalid patch")
+)
+
+// MonitorPatch merges the patch into the config of an existing monitor,
+// validates and applies it. If ifMatch isn't empty, it must match the ETag
+// of the current config, otherwise ErrConfigChanged is returned.
+// Returns the action taken and the ETag of the new config.
+func (m *Manager) MonitorPatch(
+	id string,
+	ifMatch string,
+	patch map[string]json.RawMessage,
+) (ApplyAction, string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	oldConf, exists := m.rawConfigs[id]
+	if !exists {
+		return "", "", ErrNotExist
+	}
+	if ifMatch != "" && !MatchETag(ifMatch, ConfigETag(oldConf)) {
+		return "", "", ErrConfigChanged
+	}
+
+	newConf, err := mergeConfig(oldConf, patch)
+	if err != nil {
+		return "", "", fmt.Errorf("%w: %v", ErrInvalidPatch, err)
+	}
+	if newConf["id"] != id {
+		return "", "", fmt.Errorf("%w: id cannot be changed", ErrInvalidPatch)
+	}
+	if errs := m.schema.Validate(newConf); len(errs) != 0 {
+		return "", "", errs
+	}
+
+	action, err := m.unsafeMonitorSet(id, newConf)
+	if err != nil {
+		return "", "", err
+	}
+	return action, ConfigETag(newConf), nil
+}
+
+// MonitorSetIfMatch is MonitorSet with a precondition. If ifMatch isn't
+// empty, the monitor must exist and ifMatch must match the ETag of its
+// current config, otherwise ErrConfigChanged is returned.
+// Returns the action taken and the ETag of the new config.
+func (m *Manager) MonitorSetIfMatch(
+	id string,
+	ifMatch string,
+	rawConf RawConfig,
+) (ApplyAction, string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if ifMatch != "" {
+		oldConf, exists := m.rawConfigs[id]
+		if !exists || !MatchETag(ifMatch, ConfigETag(oldConf)) {
+			return "", "", ErrConfigChanged
+		}
+	}
+
+	action, err := m.unsafeMonitorSet(id, rawConf)
+	if err != nil {
+		return "", "", err
+	}
+	return action, ConfigETag(rawConf), nil
+}
+
+// mergeConfig returns a copy of the config with the patch applied.
+// String values replace the old value and null values remove the key.
+// Object values are merged into the old value, which must be
+// a JSON object, using JSON Merge Patch (RFC 7386).
+func mergeConfig(conf RawConfig, patch map[string]json.RawMessage) (RawConfig, error) {
+	newConf := make(RawConfig, len(conf))
+	for key, value := range conf {
+		newConf[key] = value
+	}
+
+	for key, rawValue := range patch {
+		var value interface{}
+		if err := json.Unmarshal(rawValue, &value); err != nil {
+			return nil, fmt.Errorf("%v: %w", key, err)
+		}
+
+		switch v := value.(type) {
+		case nil:
+			delete(newConf, key)
+		case string:
+			newConf[key] = v
+		case map[string]interface{}:
+			merged, err := mergeJSONValue(newConf[key], v)
+			if err != nil {
+				return nil, fmt.Errorf("%v: %w", key, err)
+			}
+			newConf[key] = merged
+		default:
+			return nil, fmt.Errorf("%v: %w", key, errPatchValue)
+		}
+	}
+	return newConf, nil
+}
+
+var (
+	errPatchValue = errors.New("value must be a string, object or null")
+	errNotObject  = errors.New("old value is not a json object")
+)
+
+func mergeJSONValue(oldValue string, patch map[string]interface{}) (string, error) {
+	target := map[string]interface{}{}
+	if oldValue != "" {
+		if err := json.Unmarshal([]byte(oldValue), &target); err != nil {
+			return "", errNotObject
+		}
+	}
+
+	merged, err := json.Marshal(mergePatch(target, patch))
+	if err != nil {
+		return "", err
+	}
+	return string(merged), nil
+}
+
+// mergePatch implements RFC 7386.
+func mergePatch(target interface{}, patch interface{}) interface{} {
+	patchObj, isObj := patch.(map[string]interface{})
+	if !isObj {
+		return patch
+	}
+
+	targetObj, isObj := target.(map[string]interface{})
+	if !isObj {
+		targetObj = map[string]interface{}{}
+	}
+	for key, value := range patchObj {
+		if value == nil {
+			delete(targetObj, key)
+			continue
+		}
+		targetObj[key] = mergePatch(targetObj[key], value)
+	}
+	return targetObj
+}
@@ -0,0 +1,194 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package monitor
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func rawPatch(t *testing.T, patch string) map[string]json.RawMessage {
+	t.Helper()
+	var p map[string]json.RawMessage
+	require.NoError(t, json.Unmarshal([]byte(patch), &p))
+	return p
+}
+
+func TestMergeConfig(t *testing.T) {
+	conf := RawConfig{
+		"a":      "1",
+		"b":      "2",
+		"motion": `{"enable":"true","zones":[1],"x":{"y":"1","z":"2"}}`,
+	}
+	cases := map[string]struct {
+		patch    string
+		expected RawConfig
+		err      error
+	}{
+		"string": {
+			`{"a":"3","c":"4"}`,
+			RawConfig{"a": "3", "b": "2", "c": "4", "motion": conf["motion"]},
+			nil,
+		},
+		"null": {
+			`{"b":null}`,
+			RawConfig{"a": "1", "motion": conf["motion"]},
+			nil,
+		},
+		"object": {
+			`{"motion":{"enable":"false","zones":null,"x":{"z":"3"}}}`,
+			RawConfig{
+				"a":      "1",
+				"b":      "2",
+				"motion": `{"enable":"false","x":{"y":"1","z":"3"}}`,
+			},
+			nil,
+		},
+		"newObject": {
+			`{"doods":{"enable":"true"}}`,
+			RawConfig{
+				"a":      "1",
+				"b":      "2",
+				"motion": conf["motion"],
+				"doods":  `{"enable":"true"}`,
+			},
+			nil,
+		},
+		"notObject": {`{"a":{"x":"1"}}`, nil, errNotObject},
+		"number":    {`{"a":1}`, nil, errPatchValue},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			actual, err := mergeConfig(conf, rawPatch(t, tc.patch))
+			require.ErrorIs(t, err, tc.err)
+			require.Equal(t, tc.expected, actual)
+		})
+	}
+	t.Run("notModified", func(t *testing.T) {
+		_, err := mergeConfig(conf, rawPatch(t, `{"a":"x","b":null}`))
+		require.NoError(t, err)
+		require.Equal(t, "1", conf["a"])
+		require.Equal(t, "2", conf["b"])
+	})
+}
+
+func TestConfigETag(t *testing.T) {
+	a := ConfigETag(RawConfig{"a": "1", "b": "2"})
+	b := ConfigETag(RawConfig{"b": "2", "a": "1"})
+	c := ConfigETag(RawConfig{"a": "1", "b": "3"})
+	require.Equal(t, a, b)
+	require.NotEqual(t, a, c)
+}
+
+func TestMatchETag(t *testing.T) {
+	etag := `"abc"`
+	cases := []struct {
+		header   string
+		expected bool
+	}{
+		{`"abc"`, true},
+		{`W/"abc"`, true},
+		{`"x", "abc"`, true},
+		{`"x",W/"abc"`, true},
+		{"*", true},
+		{`"x"`, false},
+		{`"abc`, false},
+		{"", false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.header, func(t *testing.T) {
+			require.Equal(t, tc.expected, MatchETag(tc.header, etag))
+		})
+	}
+}
+
+func TestMonitorSetIfMatch(t *testing.T) {
+	t.Run("ok", func(t *testing.T) {
+		_, manager := newTestManager(t)
+		conf, etag, err := manager.MonitorConfig("1")
+		require.NoError(t, err)
+		conf["name"] = "new"
+
+		_, newETag, err := manager.MonitorSetIfMatch("1", "W/"+etag, conf)
+		require.NoError(t, err)
+		require.Equal(t, ConfigETag(conf), newETag)
+		require.Equal(t, "new", manager.rawConfigs["1"]["name"])
+	})
+	t.Run("configChanged", func(t *testing.T) {
+		_, manager := newTestManager(t)
+		conf, etag, err := manager.MonitorConfig("1")
+		require.NoError(t, err)
+		conf["name"] = "new"
+
+		_, _, err = manager.MonitorSetIfMatch("1", etag, conf)
+		require.NoError(t, err)
+		_, _, err = manager.MonitorSetIfMatch("1", etag, conf)
+		require.ErrorIs(t, err, ErrConfigChanged)
+	})
+	t.Run("notExist", func(t *testing.T) {
+		_, manager := newTestManager(t)
+		_, _, err := manager.MonitorSetIfMatch("new", "*", RawConfig{"id": "new"})
+		require.ErrorIs(t, err, ErrConfigChanged)
+
+		_, _, err = manager.MonitorSetIfMatch("new", "", RawConfig{"id": "new"})
+		require.NoError(t, err)
+	})
+}
+
+func TestMonitorPatch(t *testing.T) {
+	validPatch := `{"name":"new","videoLength":"15","timestampOffset":"500"}`
+
+	t.Run("ok", func(t *testing.T) {
+		configDir, manager := newTestManager(t)
+		_, etag, err := manager.MonitorConfig("1")
+		require.NoError(t, err)
+
+		action, newETag, err := manager.MonitorPatch("1", etag, rawPatch(t, validPatch))
+		require.NoError(t, err)
+		require.Equal(t, ApplyRestartMonitor, action)
+		require.NotEqual(t, etag, newETag)
+
+		conf, etag, err := manager.MonitorConfig("1")
+		require.NoError(t, err)
+		require.Equal(t, newETag, etag)
+		require.Equal(t, "new", conf["name"])
+		require.Equal(t, "x1", conf["mainInput"])
+		require.Equal(t, conf, readConfig(t, configDir+"/1.json"))
+	})
+	t.Run("configChanged", func(t *testing.T) {
+		_, manager := newTestManager(t)
+		_, etag, err := manager.MonitorConfig("1")
+		require.NoError(t, err)
+
+		_, _, err = manager.MonitorPatch("1", etag, rawPatch(t, validPatch))
+		require.NoError(t, err)
+
+		_, _, err = manager.MonitorPatch("1", etag, rawPatch(t, validPatch))
+		require.ErrorIs(t, err, ErrConfigChanged)
+	})
+	t.Run("notExist", func(t *testing.T) {
+		_, manager := newTestManager(t)
+		_, _, err := manager.MonitorConfig("nil")
+		require.ErrorIs(t, err, ErrNotExist)
+
+		_, _, err = manager.MonitorPatch("nil", "", rawPatch(t, validPatch))
+		require.ErrorIs(t, err, ErrNotExist)
+	})
+	t.Run("changeID", func(t *testing.T) {
+		_, manager := newTestManager(t)
+		_, _, err := manager.MonitorPatch("1", "", rawPatch(t, `{"id":"2"}`))
+		require.ErrorIs(t, err, ErrInvalidPatch)
+	})
+	t.Run("invalid", func(t *testing.T) {
+		_, manager := newTestManager(t)
+		_, _, err := manager.MonitorPatch("1", "", rawPatch(t, `{"videoLength":"abc"}`))
+
+		var fieldErrs FieldErrors
+		require.True(t, errors.As(err, &fieldErrs))
+		require.Contains(t, fieldErrs, "videoLength")
+		require.Equal(t, "one", manager.rawConfigs["1"]["name"])
+	})
+}
@@ -8,6 +8,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"nvr/pkg/backoff"
 	"nvr/pkg/ffmpeg"
 	"nvr/pkg/log"
 	"nvr/pkg/storage"
@@ -41,8 +42,10 @@ type Recorder struct {
 	wg     *sync.WaitGroup
 	hooks  Hooks
 
-	sleep   time.Duration
+	backoff backoff.Config
 	prevSeg uint64
+
+	stats recorderStats
 }
 
 func newRecorder(m *Monitor) *Recorder {
@@ -73,7 +76,7 @@ func newRecorder(m *Monitor) *Recorder {
 		wg:     &m.WG,
 		hooks:  m.hooks,
 
-		sleep: 3 * time.Second,
+		backoff: m.Env.RestartBackoff,
 	}
 }
 
@@ -101,6 +104,7 @@ func (r *Recorder) start(ctx context.Context) {
 
 		case event := <-r.eventChan: // Incomming events.
 			r.hooks.Event(r, &event)
+			r.stats.onEvent(event)
 			r.eventsLock.Lock()
 			*r.events = append(*r.events, event)
 			r.eventsLock.Unlock()
@@ -139,17 +143,28 @@ func (r *Recorder) start(ctx context.Context) {
 
 func (r *Recorder) runRecordingSession(ctx context.Context) {
 	defer r.logf(log.LevelDebug, "session stopped")
+	b := backoff.New(r.backoff)
 	for {
+		startTime := time.Now()
+		stopped := r.input.ReportRunning("recorder", b)
 		err := r.runSession(ctx, r)
+		stopped()
 		if err != nil {
 			if !errors.Is(err, context.Canceled) {
 				r.logf(log.LevelError, "recording crashed: %v", err)
 			}
+
+			delay := b.Failure(time.Since(startTime))
+			if b.CrashLoopDetected() {
+				r.logf(log.LevelError, "crash-looping after %v consecutive failures", b.Failures())
+			}
+			r.input.ReportCrash("recorder", err, b)
+
 			select {
 			case <-ctx.Done():
 				// Session is canceled.
 				return
-			case <-time.After(r.sleep):
+			case <-time.After(delay):
 				r.logf(log.LevelDebug, "recovering after crash")
 			}
 		} else {
@@ -221,6 +236,7 @@ func runRecording(ctx context.Context, r *Recorder) error {
 		return fmt.Errorf("write video: %w", err)
 	}
 	r.prevSeg = prevSeg
+	r.stats.onWritten(fileSize(filePath+".meta") + fileSize(filePath+".mdat"))
 	r.logf(log.LevelInfo, "video generated: %v", basePath)
 
 	go r.saveRecording(filePath, startTime, *endTime)
@@ -390,6 +406,7 @@ func (r *Recorder) saveRecording(
 		r.logf(log.LevelError, "write event data: %v", err)
 		return
 	}
+	r.stats.onSaved()
 
 	go r.hooks.RecSaved(r, filePath, data)
 
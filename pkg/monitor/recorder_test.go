@@ -13,6 +13,7 @@ import (
 	"testing"
 	"time"
 
+	"nvr/pkg/backoff"
 	"nvr/pkg/ffmpeg"
 	"nvr/pkg/ffmpeg/ffmock"
 	"nvr/pkg/log"
@@ -48,6 +49,7 @@ func newTestRecorder(t *testing.T) *Recorder {
 
 		input: &InputProcess{
 			isSubInput: false,
+			processes:  newProcessStatus(func(msg string) string { return msg }),
 
 			serverPath: video.ServerPath{
 				HlsAddress: "hls.m3u8",
@@ -75,6 +77,7 @@ type mockMuxer struct {
 	audioTrack  *gortsplib.TrackMPEG4Audio
 	getMuxerErr error
 	segCount    int
+	latest      *hls.Segment
 }
 
 func newMockMuxerFunc(muxer *mockMuxer) func(context.Context) (video.IHLSMuxer, error) {
@@ -100,6 +103,13 @@ func (m *mockMuxer) NextSegment(prevID uint64) (*hls.Segment, error) {
 	return seg, nil
 }
 
+func (m *mockMuxer) LatestSegment() (*hls.Segment, error) {
+	if m.latest == nil {
+		return nil, hls.ErrNoSegments
+	}
+	return m.latest, nil
+}
+
 func (m *mockMuxer) WaitForSegFinalized() {}
 
 func TestStartRecorder(t *testing.T) {
@@ -117,7 +127,7 @@ func TestStartRecorder(t *testing.T) {
 		defer cancel()
 
 		r := newTestRecorder(t)
-		r.sleep = 1 * time.Hour
+		r.backoff = backoff.Config{Initial: 1 * time.Hour, Max: 1 * time.Hour}
 		r.wg.Add(1)
 		r.runSession = mockRunRecording
 		go r.start(ctx)
@@ -215,7 +225,7 @@ func TestStartRecorder(t *testing.T) {
 		defer cancel()
 
 		r := newTestRecorder(t)
-		r.sleep = 1 * time.Hour
+		r.backoff = backoff.Config{Initial: 1 * time.Hour, Max: 1 * time.Hour}
 		r.wg.Add(1)
 		r.runSession = mockRunRecording
 		go r.start(ctx)
@@ -331,14 +341,14 @@ func TestRunRecording(t *testing.T) {
 	})
 	t.Run("genArgsErr", func(t *testing.T) {
 		r := newTestRecorder(t)
-		r.Config.v["videoLength"] = ""
+		r.Config.v.m["videoLength"] = ""
 
 		err := runRecording(context.Background(), r)
 		require.ErrorIs(t, err, strconv.ErrSyntax)
 	})
 	t.Run("parseOffsetErr", func(t *testing.T) {
 		r := newTestRecorder(t)
-		r.Config.v["timestampOffset"] = ""
+		r.Config.v.m["timestampOffset"] = ""
 
 		err := runRecording(context.Background(), r)
 		require.ErrorIs(t, err, strconv.ErrSyntax)
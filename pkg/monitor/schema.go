@@ -0,0 +1,246 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package monitor
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// FieldType monitor config field type.
+type FieldType string
+
+// Field types.
+const (
+	FieldString FieldType = "string"
+	FieldInt    FieldType = "integer"
+	FieldFloat  FieldType = "float"
+	FieldBool   FieldType = "boolean"
+	FieldEnum   FieldType = "enum"
+	FieldJSON   FieldType = "json"
+)
+
+// Field describes a single monitor config field.
+type Field struct {
+	Key      string    `json:"key"`
+	Type     FieldType `json:"type"`
+	Default  string    `json:"default,omitempty"`
+	Required bool      `json:"required,omitempty"`
+	Min      *float64  `json:"min,omitempty"`
+	Max      *float64  `json:"max,omitempty"`
+	Options  []string  `json:"options,omitempty"` // Enum values.
+
+	// Match enum values case-insensitively.
+	IgnoreCase bool `json:"ignoreCase,omitempty"`
+
+	// Optional custom validation. Only called if the value isn't empty.
+	Validate func(string) error `json:"-"`
+}
+
+// Schema list of monitor config fields.
+type Schema []Field
+
+// FieldErrors validation errors by field key.
+type FieldErrors map[string]string
+
+func (e FieldErrors) Error() string {
+	keys := make([]string, 0, len(e))
+	for key := range e {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	msgs := make([]string, 0, len(e))
+	for _, key := range keys {
+		msgs = append(msgs, key+": "+e[key])
+	}
+	return "invalid config: " + strings.Join(msgs, ", ")
+}
+
+// Bound returns a pointer to v, used to set Field.Min and Field.Max.
+func Bound(v float64) *float64 {
+	return &v
+}
+
+// Errors.
+var (
+	ErrFieldRequired = errors.New("required")
+	ErrFieldInvalid  = errors.New("invalid value")
+	ErrFieldTooSmall = errors.New("value too small")
+	ErrFieldTooLarge = errors.New("value too large")
+
+	ErrContainsSpaces = errors.New("value cannot contain spaces")
+	ErrIDTooLong      = errors.New("id cannot be longer than 24 bytes")
+
+	ErrMulticastGroup = errors.New("expected an IPv4 multicast group")
+	ErrMulticastPort  = errors.New("expected an even port between 1024 and 65528")
+)
+
+func (f Field) validate(value string) error {
+	if value == "" {
+		if f.Required {
+			return ErrFieldRequired
+		}
+		return nil
+	}
+
+	switch f.Type {
+	case FieldInt, FieldFloat:
+		var n float64
+		var err error
+		if f.Type == FieldInt {
+			var i int
+			i, err = strconv.Atoi(value)
+			n = float64(i)
+		} else {
+			n, err = strconv.ParseFloat(value, 64)
+		}
+		if err != nil {
+			return fmt.Errorf("%w: expected %v: %q", ErrFieldInvalid, f.Type, value)
+		}
+		if f.Min != nil && n < *f.Min {
+			return fmt.Errorf("%w: min %v", ErrFieldTooSmall, *f.Min)
+		}
+		if f.Max != nil && n > *f.Max {
+			return fmt.Errorf("%w: max %v", ErrFieldTooLarge, *f.Max)
+		}
+	case FieldBool:
+		if value != "true" && value != "false" {
+			return fmt.Errorf("%w: expected true or false: %q", ErrFieldInvalid, value)
+		}
+	case FieldEnum:
+		if !f.containsOption(value) {
+			return fmt.Errorf("%w: expected one of [%v]: %q",
+				ErrFieldInvalid, strings.Join(f.Options, ", "), value)
+		}
+	case FieldJSON:
+		if !json.Valid([]byte(value)) {
+			return fmt.Errorf("%w: expected json", ErrFieldInvalid)
+		}
+	}
+
+	if f.Validate != nil {
+		return f.Validate(value)
+	}
+	return nil
+}
+
+func checkSpaces(s string) error {
+	if strings.Contains(s, " ") {
+		return ErrContainsSpaces
+	}
+	return nil
+}
+
+// validateMulticast validates a "group:port" multicast address.
+func validateMulticast(s string) error {
+	group, port, err := parseMulticast(s)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrFieldInvalid, err)
+	}
+	if group.To4() == nil || !group.IsMulticast() {
+		return ErrMulticastGroup
+	}
+	// The main and sub input use four ports each.
+	if port < 1024 || port > 65528 || port%2 != 0 {
+		return ErrMulticastPort
+	}
+	return nil
+}
+
+func (f Field) containsOption(s string) bool {
+	for _, v := range f.Options {
+		if v == s || (f.IgnoreCase && strings.EqualFold(v, s)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate returns the errors of all invalid fields.
+// Keys that aren't in the schema are ignored.
+func (s Schema) Validate(c RawConfig) FieldErrors {
+	errs := make(FieldErrors)
+	for _, field := range s {
+		if err := field.validate(c[field.Key]); err != nil {
+			errs[field.Key] = err.Error()
+		}
+	}
+	return errs
+}
+
+var coreSchema = Schema{
+	{
+		Key:      "id",
+		Type:     FieldString,
+		Required: true,
+		Validate: func(id string) error {
+			if len(id) > 24 {
+				return ErrIDTooLong
+			}
+			return checkSpaces(id)
+		},
+	},
+	{Key: "name", Type: FieldString, Required: true, Validate: checkSpaces},
+	{Key: "enable", Type: FieldBool, Default: "false"},
+	{Key: "inputOptions", Type: FieldString},
+	{Key: "mainInput", Type: FieldString, Required: true},
+	{Key: "subInput", Type: FieldString},
+	{Key: "hwaccel", Type: FieldString},
+	{Key: "videoEncoder", Type: FieldString, Default: "copy"},
+	{Key: "audioEncoder", Type: FieldString, Default: "none"},
+	{Key: "alwaysRecord", Type: FieldBool, Default: "false"},
+	{
+		Key:      "videoLength",
+		Type:     FieldFloat,
+		Default:  "15",
+		Required: true,
+		Min:      Bound(0.01),
+	},
+	{Key: "timestampOffset", Type: FieldInt, Default: "500", Required: true},
+	{Key: "multicast", Type: FieldString, Validate: validateMulticast},
+	{Key: "dvrWindow", Type: FieldInt, Min: Bound(0), Max: Bound(120)},
+	{
+		Key:     "logLevel",
+		Type:    FieldEnum,
+		Default: "fatal",
+		Options: []string{
+			"quiet", "panic", "fatal", "error", "warning",
+			"info", "verbose", "debug", "trace",
+		},
+	},
+}
+
+func newSchema(addonFields []Field) Schema {
+	schema := append(Schema{}, coreSchema...)
+	for _, field := range addonFields {
+		if schema.field(field.Key) != nil {
+			continue
+		}
+		schema = append(schema, field)
+	}
+	return schema
+}
+
+func (s Schema) field(key string) *Field {
+	for i := range s {
+		if s[i].Key == key {
+			return &s[i]
+		}
+	}
+	return nil
+}
+
+// Schema returns the combined config schema of core and addons.
+func (m *Manager) Schema() Schema {
+	return m.schema
+}
+
+// ValidateConfig validates config against the schema.
+func (m *Manager) ValidateConfig(c RawConfig) FieldErrors {
+	return m.schema.Validate(c)
+}
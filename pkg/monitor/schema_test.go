@@ -0,0 +1,88 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package monitor
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSchemaValidate(t *testing.T) {
+	validConfig := func() RawConfig {
+		return RawConfig{
+			"id":              "1",
+			"name":            "a",
+			"enable":          "true",
+			"mainInput":       "x",
+			"videoLength":     "15",
+			"timestampOffset": "500",
+			"logLevel":        "error",
+			"unknown":         "y",
+		}
+	}
+	cases := map[string]struct {
+		key      string
+		value    string
+		expected string
+	}{
+		"required": {"mainInput", "", "required"},
+		"bool":     {"enable", "yes", `invalid value: expected true or false: "yes"`},
+		"float":    {"videoLength", "abc", `invalid value: expected float: "abc"`},
+		"floatMin": {"videoLength", "0", "value too small: min 0.01"},
+		"integer":  {"timestampOffset", "1.5", `invalid value: expected integer: "1.5"`},
+		"enum": {"logLevel", "x", "invalid value: expected one of " +
+			`[quiet, panic, fatal, error, warning, info, verbose, debug, trace]: "x"`},
+		"optionalEmpty":  {"logLevel", "", ""},
+		"multicast":      {"multicast", "239.0.0.1:5000", ""},
+		"multicastAddr":  {"multicast", "239.0.0.1", "invalid value: address 239.0.0.1: missing port in address"},
+		"multicastGroup": {"multicast", "192.168.1.2:5000", "expected an IPv4 multicast group"},
+		"multicastPort":  {"multicast", "239.0.0.1:5001", "expected an even port between 1024 and 65528"},
+		"dvrWindow":      {"dvrWindow", "10", ""},
+		"dvrWindowMax":   {"dvrWindow", "121", "value too large: max 120"},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			c := validConfig()
+			c[tc.key] = tc.value
+			errs := coreSchema.Validate(c)
+			if tc.expected == "" {
+				require.Empty(t, errs)
+				return
+			}
+			require.Equal(t, FieldErrors{tc.key: tc.expected}, errs)
+		})
+	}
+	t.Run("ok", func(t *testing.T) {
+		require.Empty(t, coreSchema.Validate(validConfig()))
+	})
+	t.Run("addonField", func(t *testing.T) {
+		errTest := errors.New("test")
+		schema := newSchema([]Field{
+			{Key: "a", Type: FieldJSON},
+			{Key: "b", Type: FieldJSON, Validate: func(string) error { return errTest }},
+			{Key: "c", Type: FieldInt, Max: Bound(10)},
+			{Key: "d", Type: FieldEnum, Options: []string{"half"}, IgnoreCase: true},
+			{Key: "e", Type: FieldEnum, Options: []string{"half"}},
+		})
+		c := validConfig()
+		c["a"] = "{"
+		c["b"] = "{}"
+		c["c"] = "11"
+		c["d"] = "Half"
+		c["e"] = "Half"
+		expected := FieldErrors{
+			"a": "invalid value: expected json",
+			"b": "test",
+			"c": "value too large: max 10",
+			"e": `invalid value: expected one of [half]: "Half"`,
+		}
+		require.Equal(t, expected, schema.Validate(c))
+	})
+	t.Run("duplicateField", func(t *testing.T) {
+		schema := newSchema([]Field{{Key: "id", Type: FieldInt}})
+		require.Len(t, schema, len(coreSchema))
+		require.Equal(t, FieldString, schema.field("id").Type)
+	})
+}
@@ -0,0 +1,170 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package monitor
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"sync"
+	"time"
+
+	"nvr/pkg/ffmpeg"
+	"nvr/pkg/log"
+	"nvr/pkg/video/mp4muxer"
+)
+
+// SnapshotOptions options of a snapshot.
+type SnapshotOptions struct {
+	Sub bool
+
+	// Divisor of the frame size, see ffmpeg.ParseScaleString.
+	Scale string
+}
+
+// Snapshots are reused for this long.
+const snapshotTTL = 3 * time.Second
+
+// ErrSubInputDisabled sub input is disabled.
+var ErrSubInputDisabled = errors.New("sub input is disabled")
+
+// Snapshot returns a JPEG of the first frame in the most recent segment
+// of a monitor. Snapshots are cached and shared between callers.
+func (m *Manager) Snapshot(ctx context.Context, id string, opts SnapshotOptions) ([]byte, error) {
+	m.mu.Lock()
+	monitor, exists := m.runningMonitors[id]
+	enabled := exists && monitor.ctx != nil
+	m.mu.Unlock()
+	if !exists {
+		return nil, ErrMonitorNotExist
+	}
+	if !enabled {
+		return nil, ErrMonitorDisabled
+	}
+	if opts.Sub && !monitor.Config.SubInputEnabled() {
+		return nil, ErrSubInputDisabled
+	}
+	return monitor.snapshots.get(ctx, opts)
+}
+
+// snapshotCache caches snapshots by options. Concurrent
+// requests for the same snapshot wait for a single FFmpeg process.
+type snapshotCache struct {
+	generate generateSnapshotFunc
+
+	entries map[SnapshotOptions]*snapshotEntry
+	mu      sync.Mutex
+}
+
+type generateSnapshotFunc func(context.Context, SnapshotOptions) ([]byte, error)
+
+type snapshotEntry struct {
+	done    chan struct{}
+	jpeg    []byte
+	err     error
+	created time.Time
+}
+
+func newSnapshotCache(generate generateSnapshotFunc) *snapshotCache {
+	return &snapshotCache{
+		generate: generate,
+		entries:  make(map[SnapshotOptions]*snapshotEntry),
+	}
+}
+
+func (c *snapshotCache) get(ctx context.Context, opts SnapshotOptions) ([]byte, error) {
+	c.mu.Lock()
+	entry, exists := c.entries[opts]
+	if !exists || (entry.isDone() && time.Since(entry.created) > snapshotTTL) {
+		entry = &snapshotEntry{done: make(chan struct{})}
+		c.entries[opts] = entry
+		go c.fill(entry, opts)
+	}
+	c.mu.Unlock()
+
+	select {
+	case <-entry.done:
+		return entry.jpeg, entry.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// fill generates the snapshot without the context of the first caller
+// because other callers may be waiting for it. Errors aren't cached.
+func (c *snapshotCache) fill(entry *snapshotEntry, opts SnapshotOptions) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	jpeg, err := c.generate(ctx, opts)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry.jpeg, entry.err = jpeg, err
+	entry.created = time.Now()
+	close(entry.done)
+	if err != nil && c.entries[opts] == entry {
+		delete(c.entries, opts)
+	}
+}
+
+func (e *snapshotEntry) isDone() bool {
+	select {
+	case <-e.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// The first h264 frame in the latest segment is wrapped
+// in a mp4 container and converted to jpeg by FFmpeg.
+func (m *Monitor) generateSnapshot(ctx context.Context, opts SnapshotOptions) ([]byte, error) {
+	input := m.mainInput
+	if opts.Sub {
+		input = m.subInput
+	}
+
+	muxer, err := input.HLSMuxer(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("get muxer: %w", err)
+	}
+	segment, err := muxer.LatestSegment()
+	if err != nil {
+		return nil, fmt.Errorf("latest segment: %w", err)
+	}
+
+	videoBuffer := &bytes.Buffer{}
+	err = mp4muxer.GenerateThumbnailVideo(videoBuffer, segment, muxer.VideoTrack())
+	if err != nil {
+		return nil, fmt.Errorf("generate snapshot video: %w", err)
+	}
+
+	args := "-threads 1 -loglevel " + m.Config.LogLevel() +
+		" -i -" // Input.
+	if opts.Scale != "" && opts.Scale != "1" {
+		args += " -vf scale=iw/" + opts.Scale + ":ih/" + opts.Scale
+	}
+	args += " -frames:v 1 -f mjpeg -" // Output.
+
+	cmd := exec.Command(m.Env.FFmpegBin, ffmpeg.ParseArgs(args)...)
+	cmd.Stdin = videoBuffer
+	jpeg := &bytes.Buffer{}
+	cmd.Stdout = jpeg
+
+	ffLogLevel := log.FFmpegLevel(m.Config.LogLevel())
+	process := m.NewProcess(cmd).
+		StderrLogger(func(msg string) {
+			m.logf(ffLogLevel, "snapshot process: %v", msg)
+		})
+
+	if err := process.Start(ctx); err != nil {
+		return nil, fmt.Errorf("generate snapshot, args: %v error: %w", args, err)
+	}
+	if jpeg.Len() == 0 {
+		return nil, fmt.Errorf("%w: empty snapshot", ErrInvalidJPEG)
+	}
+	return jpeg.Bytes(), nil
+}
@@ -0,0 +1,130 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package monitor
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"nvr/pkg/ffmpeg/ffmock"
+	"nvr/pkg/log"
+	"nvr/pkg/video"
+	"nvr/pkg/video/hls"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshotCache(t *testing.T) {
+	t.Run("shared", func(t *testing.T) {
+		var calls int
+		var mu sync.Mutex
+		release := make(chan struct{})
+		c := newSnapshotCache(func(_ context.Context, opts SnapshotOptions) ([]byte, error) {
+			mu.Lock()
+			calls++
+			mu.Unlock()
+			<-release
+			return []byte(opts.Scale), nil
+		})
+
+		var wg sync.WaitGroup
+		for i := 0; i < 3; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				jpeg, err := c.get(context.Background(), SnapshotOptions{Scale: "2"})
+				require.NoError(t, err)
+				require.Equal(t, []byte("2"), jpeg)
+			}()
+		}
+		time.Sleep(10 * time.Millisecond)
+		close(release)
+		wg.Wait()
+
+		jpeg, err := c.get(context.Background(), SnapshotOptions{Scale: "2"})
+		require.NoError(t, err)
+		require.Equal(t, []byte("2"), jpeg)
+		require.Equal(t, 1, calls)
+
+		// Other options aren't shared.
+		jpeg, err = c.get(context.Background(), SnapshotOptions{Sub: true, Scale: "4"})
+		require.NoError(t, err)
+		require.Equal(t, []byte("4"), jpeg)
+		require.Equal(t, 2, calls)
+	})
+	t.Run("expired", func(t *testing.T) {
+		calls := 0
+		c := newSnapshotCache(func(context.Context, SnapshotOptions) ([]byte, error) {
+			calls++
+			return []byte{byte(calls)}, nil
+		})
+
+		jpeg, err := c.get(context.Background(), SnapshotOptions{})
+		require.NoError(t, err)
+		require.Equal(t, []byte{1}, jpeg)
+
+		c.entries[SnapshotOptions{}].created = time.Now().Add(-snapshotTTL - time.Second)
+		jpeg, err = c.get(context.Background(), SnapshotOptions{})
+		require.NoError(t, err)
+		require.Equal(t, []byte{2}, jpeg)
+	})
+	t.Run("errorNotCached", func(t *testing.T) {
+		calls := 0
+		c := newSnapshotCache(func(context.Context, SnapshotOptions) ([]byte, error) {
+			calls++
+			return nil, ffmock.ErrMock
+		})
+
+		_, err := c.get(context.Background(), SnapshotOptions{})
+		require.ErrorIs(t, err, ffmock.ErrMock)
+		_, err = c.get(context.Background(), SnapshotOptions{})
+		require.ErrorIs(t, err, ffmock.ErrMock)
+		require.Equal(t, 2, calls)
+	})
+	t.Run("canceled", func(t *testing.T) {
+		release := make(chan struct{})
+		defer close(release)
+		c := newSnapshotCache(func(context.Context, SnapshotOptions) ([]byte, error) {
+			<-release
+			return nil, nil
+		})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err := c.get(ctx, SnapshotOptions{})
+		require.ErrorIs(t, err, context.Canceled)
+	})
+}
+
+func TestGenerateSnapshot(t *testing.T) {
+	newTestMonitor := func(muxer *mockMuxer) *Monitor {
+		m := &Monitor{
+			Config:     NewConfig(RawConfig{"logLevel": "error"}),
+			logf:       func(log.Level, string, ...interface{}) {},
+			NewProcess: ffmock.NewProcessNil,
+		}
+		m.mainInput = &InputProcess{
+			serverPath: video.ServerPath{HLSMuxer: newMockMuxerFunc(muxer)},
+		}
+		m.subInput = &InputProcess{}
+		return m
+	}
+
+	t.Run("noSegments", func(t *testing.T) {
+		m := newTestMonitor(&mockMuxer{})
+		_, err := m.generateSnapshot(context.Background(), SnapshotOptions{})
+		require.ErrorIs(t, err, hls.ErrNoSegments)
+	})
+	t.Run("inputNotStarted", func(t *testing.T) {
+		m := newTestMonitor(&mockMuxer{})
+		_, err := m.generateSnapshot(context.Background(), SnapshotOptions{Sub: true})
+		require.ErrorIs(t, err, ErrInputNotStarted)
+	})
+	t.Run("emptySegment", func(t *testing.T) {
+		m := newTestMonitor(&mockMuxer{latest: &hls.Segment{}})
+		_, err := m.generateSnapshot(context.Background(), SnapshotOptions{})
+		require.Error(t, err)
+	})
+}
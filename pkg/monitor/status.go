@@ -0,0 +1,296 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package monitor
+
+import (
+	"context"
+	"nvr/pkg/video/hls"
+	"sync"
+	"time"
+)
+
+// State of a monitor or input process.
+type State string
+
+// States.
+const (
+	StateStarting   State = "starting"
+	StateConnecting State = "connecting"
+	StateStreaming  State = "streaming"
+	StateStalled    State = "stalled"
+	StateCrashed    State = "crashed"
+	StateDisabled   State = "disabled"
+
+	// The process have failed too many times in a row.
+	StateCrashLooping State = "crash-looping"
+)
+
+// severity is used to pick the worst state of the inputs.
+func (s State) severity() int {
+	switch s {
+	case StateStreaming:
+		return 1
+	case StateStarting:
+		return 2
+	case StateConnecting:
+		return 3
+	case StateStalled:
+		return 4
+	case StateCrashed:
+		return 5
+	case StateCrashLooping:
+		return 6
+	}
+	return 0
+}
+
+// Status of a monitor.
+type Status struct {
+	ID    string       `json:"id"`
+	Name  string       `json:"name"`
+	State State        `json:"state"`
+	Main  *InputStatus `json:"main,omitempty"`
+	Sub   *InputStatus `json:"sub,omitempty"`
+
+	// Last error of the other processes that
+	// are crash-looping, indexed by process name.
+	CrashLooping map[string]string `json:"crashLooping,omitempty"`
+}
+
+// StatusMap map of monitor statuses by ID.
+type StatusMap map[string]Status
+
+// InputStatus status of a input process.
+type InputStatus struct {
+	State        State      `json:"state"`
+	LastError    string     `json:"lastError"`
+	CrashCount   int        `json:"crashCount"`
+	Uptime       int64      `json:"uptime"` // Seconds.
+	LastSegment  *time.Time `json:"lastSegment"`
+	FPS          float64    `json:"fps"`
+	Bitrate      int64      `json:"bitrate"` // Bits per second.
+	SegmentCount uint64     `json:"segmentCount"`
+}
+
+// The input is considered stalled if no
+// segment has been finalized within this duration.
+const stallTimeout = 10 * time.Second
+
+type inputStatus struct {
+	state        State
+	crashLooping bool
+	lastError    string
+	crashCount   int
+	streamStart  time.Time
+	lastSegment  time.Time
+	fps          float64
+	bitrate      int64
+	segmentCount uint64
+
+	censor func(string) string
+	mu     sync.Mutex
+}
+
+func newInputStatus(censor func(string) string) *inputStatus {
+	return &inputStatus{
+		state:  StateStarting,
+		censor: censor,
+	}
+}
+
+func (s *inputStatus) setState(state State) {
+	s.mu.Lock()
+	s.state = state
+	s.mu.Unlock()
+}
+
+func (s *inputStatus) onCrash(err error, crashLooping bool) {
+	s.mu.Lock()
+	s.state = StateCrashed
+	s.crashLooping = crashLooping
+	s.lastError = s.censor(err.Error())
+	s.crashCount++
+	s.fps = 0
+	s.bitrate = 0
+	s.mu.Unlock()
+}
+
+func (s *inputStatus) onSegment(seg *hls.Segment, now time.Time) {
+	var frames int
+	var size int
+	for _, part := range seg.Parts {
+		frames += len(part.VideoSamples)
+		for _, sample := range part.VideoSamples {
+			size += len(sample.AVCC)
+		}
+		for _, sample := range part.AudioSamples {
+			size += len(sample.AU)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.state != StateStreaming && s.state != StateStalled {
+		s.streamStart = now
+	}
+	s.state = StateStreaming
+	s.crashLooping = false
+	s.lastSegment = now
+	s.segmentCount++
+
+	seconds := seg.RenderedDuration.Seconds()
+	if seconds > 0 {
+		s.fps = float64(frames) / seconds
+		s.bitrate = int64(float64(size*8) / seconds)
+	}
+}
+
+func (s *inputStatus) get(now time.Time) InputStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	status := InputStatus{
+		State:        s.state,
+		LastError:    s.lastError,
+		CrashCount:   s.crashCount,
+		FPS:          s.fps,
+		Bitrate:      s.bitrate,
+		SegmentCount: s.segmentCount,
+	}
+	if !s.lastSegment.IsZero() {
+		lastSegment := s.lastSegment
+		status.LastSegment = &lastSegment
+	}
+	if s.state == StateStreaming && now.Sub(s.lastSegment) > stallTimeout {
+		status.State = StateStalled
+	}
+	if s.crashLooping {
+		status.State = StateCrashLooping
+	}
+	if status.State == StateStreaming || status.State == StateStalled {
+		status.Uptime = int64(now.Sub(s.streamStart).Seconds())
+	}
+	return status
+}
+
+// processStatus tracks the processes that run alongside the inputs,
+// the recorder and the addon processes, that are crash-looping.
+type processStatus struct {
+	crashLooping map[string]string // Last error by process name.
+
+	censor func(string) string
+	mu     sync.Mutex
+}
+
+func newProcessStatus(censor func(string) string) *processStatus {
+	return &processStatus{
+		crashLooping: make(map[string]string),
+		censor:       censor,
+	}
+}
+
+func (s *processStatus) onCrash(process string, err error, crashLooping bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !crashLooping {
+		delete(s.crashLooping, process)
+		return
+	}
+	s.crashLooping[process] = s.censor(err.Error())
+}
+
+func (s *processStatus) onRecovered(process string) {
+	s.mu.Lock()
+	delete(s.crashLooping, process)
+	s.mu.Unlock()
+}
+
+func (s *processStatus) get() map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.crashLooping) == 0 {
+		return nil
+	}
+	crashLooping := make(map[string]string, len(s.crashLooping))
+	for process, lastError := range s.crashLooping {
+		crashLooping[process] = lastError
+	}
+	return crashLooping
+}
+
+// trackSegments updates the input status
+// on every new segment until ctx is canceled.
+func (i *InputProcess) trackSegments(ctx context.Context) {
+	muxer, err := i.HLSMuxer(ctx)
+	if err != nil {
+		return
+	}
+
+	var prevID uint64
+	for {
+		seg, err := muxer.NextSegment(prevID)
+		if err != nil || ctx.Err() != nil {
+			return
+		}
+		prevID = seg.ID
+		i.status.onSegment(seg, time.Now())
+	}
+}
+
+// Status returns the current input process status.
+func (i *InputProcess) Status() InputStatus {
+	return i.status.get(time.Now())
+}
+
+// Status returns the current monitor status.
+func (m *Monitor) Status() Status {
+	status := Status{
+		ID:   m.Config.ID(),
+		Name: m.Config.Name(),
+	}
+	if !m.Config.enabled() {
+		status.State = StateDisabled
+		return status
+	}
+
+	mainStatus := m.mainInput.Status()
+	status.Main = &mainStatus
+	status.State = mainStatus.State
+
+	if m.Config.SubInputEnabled() {
+		subStatus := m.subInput.Status()
+		status.Sub = &subStatus
+		if subStatus.State.severity() > status.State.severity() {
+			status.State = subStatus.State
+		}
+	}
+
+	status.CrashLooping = m.processes.get()
+	if len(status.CrashLooping) != 0 {
+		status.State = StateCrashLooping
+	}
+	return status
+}
+
+// MonitorsStatus returns the status of all monitors.
+func (m *Manager) MonitorsStatus() StatusMap {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	statuses := make(StatusMap)
+	for id, rawConf := range m.rawConfigs {
+		monitor, running := m.runningMonitors[id]
+		if !running {
+			c := NewConfig(rawConf)
+			statuses[id] = Status{
+				ID:    c.ID(),
+				Name:  c.Name(),
+				State: StateDisabled,
+			}
+			continue
+		}
+		statuses[id] = monitor.Status()
+	}
+	return statuses
+}
@@ -0,0 +1,178 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package monitor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"nvr/pkg/backoff"
+	"nvr/pkg/log"
+	"nvr/pkg/video/hls"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newTestSegment() *hls.Segment {
+	return &hls.Segment{
+		RenderedDuration: 1 * time.Second,
+		Parts: []*hls.MuxerPart{
+			{
+				VideoSamples: []*hls.VideoSample{
+					{AVCC: make([]byte, 100)},
+					{AVCC: make([]byte, 100)},
+				},
+				AudioSamples: []*hls.AudioSample{
+					{AU: make([]byte, 50)},
+				},
+			},
+		},
+	}
+}
+
+func TestInputStatus(t *testing.T) {
+	t.Run("starting", func(t *testing.T) {
+		s := newInputStatus(nil)
+		require.Equal(t, InputStatus{State: StateStarting}, s.get(time.Unix(0, 0)))
+	})
+	t.Run("crashed", func(t *testing.T) {
+		censor := func(msg string) string {
+			return msg + "2"
+		}
+		s := newInputStatus(censor)
+		s.onCrash(errors.New("1"), false)
+		s.onCrash(errors.New("a"), false)
+
+		expected := InputStatus{
+			State:      StateCrashed,
+			LastError:  "a2",
+			CrashCount: 2,
+		}
+		require.Equal(t, expected, s.get(time.Unix(0, 0)))
+	})
+	t.Run("streaming", func(t *testing.T) {
+		s := newInputStatus(nil)
+		s.onSegment(newTestSegment(), time.Unix(10, 0))
+		s.onSegment(newTestSegment(), time.Unix(15, 0))
+
+		lastSegment := time.Unix(15, 0)
+		expected := InputStatus{
+			State:        StateStreaming,
+			Uptime:       6,
+			LastSegment:  &lastSegment,
+			FPS:          2,
+			Bitrate:      2000,
+			SegmentCount: 2,
+		}
+		require.Equal(t, expected, s.get(time.Unix(16, 0)))
+	})
+	t.Run("stalled", func(t *testing.T) {
+		s := newInputStatus(nil)
+		s.onSegment(newTestSegment(), time.Unix(10, 0))
+
+		actual := s.get(time.Unix(30, 0))
+		require.Equal(t, StateStalled, actual.State)
+		require.Equal(t, int64(20), actual.Uptime)
+	})
+	t.Run("recovered", func(t *testing.T) {
+		s := newInputStatus(func(msg string) string { return msg })
+		s.onSegment(newTestSegment(), time.Unix(10, 0))
+		s.onCrash(errors.New("x"), true)
+
+		require.Equal(t, StateCrashLooping, s.get(time.Unix(15, 0)).State)
+
+		s.onSegment(newTestSegment(), time.Unix(20, 0))
+		actual := s.get(time.Unix(25, 0))
+		require.Equal(t, StateStreaming, actual.State)
+		require.Equal(t, int64(5), actual.Uptime)
+		require.Equal(t, 1, actual.CrashCount)
+	})
+}
+
+func TestInputProcessStatus(t *testing.T) {
+	logs := make(chan string)
+	defer close(logs)
+
+	stubRunInputProcess := func(context.Context, *InputProcess) error {
+		return errors.New("stub")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+
+	input := newTestInputProcess()
+	input.runInputProcess = stubRunInputProcess
+	input.logf = func(level log.Level, format string, a ...interface{}) {
+		logs <- fmt.Sprintf(format, a...)
+	}
+	input.WG.Add(1)
+	go input.start(ctx)
+
+	<-logs
+	actual := input.Status()
+	require.Equal(t, StateCrashed, actual.State)
+	require.Equal(t, "stub", actual.LastError)
+	cancel()
+	<-logs
+}
+
+func TestMonitorStatus(t *testing.T) {
+	t.Run("disabled", func(t *testing.T) {
+		m := &Monitor{Config: NewConfig(RawConfig{"id": "1", "name": "a"})}
+		expected := Status{ID: "1", Name: "a", State: StateDisabled}
+		require.Equal(t, expected, m.Status())
+	})
+	t.Run("worstInput", func(t *testing.T) {
+		m := &Monitor{
+			Config: NewConfig(RawConfig{
+				"id":       "1",
+				"enable":   "true",
+				"subInput": "x",
+			}),
+			mainInput: &InputProcess{status: newInputStatus(nil)},
+			subInput:  &InputProcess{status: newInputStatus(nil)},
+			processes: newProcessStatus(nil),
+		}
+		m.mainInput.status.onSegment(newTestSegment(), time.Now())
+		m.subInput.status.setState(StateConnecting)
+
+		actual := m.Status()
+		require.Equal(t, StateConnecting, actual.State)
+		require.Equal(t, StateStreaming, actual.Main.State)
+		require.Equal(t, StateConnecting, actual.Sub.State)
+	})
+	t.Run("processCrashLooping", func(t *testing.T) {
+		m := &Monitor{
+			Config:    NewConfig(RawConfig{"id": "1", "enable": "true"}),
+			mainInput: &InputProcess{status: newInputStatus(nil), hooks: stubHooks()},
+			processes: newProcessStatus(func(msg string) string { return msg }),
+		}
+		m.mainInput.status.onSegment(newTestSegment(), time.Now())
+		m.mainInput.processes = m.processes
+
+		b := backoff.New(backoff.Config{CrashLoopThreshold: 2, ResetAfter: time.Hour})
+		b.Failure(0)
+		m.mainInput.ReportCrash("recorder", errors.New("a"), b)
+		require.Equal(t, StateStreaming, m.Status().State)
+
+		b.Failure(0)
+		m.mainInput.ReportCrash("recorder", errors.New("b"), b)
+		actual := m.Status()
+		require.Equal(t, StateCrashLooping, actual.State)
+		require.Equal(t, map[string]string{"recorder": "b"}, actual.CrashLooping)
+
+		m.processes.onRecovered("recorder")
+		require.Equal(t, StateStreaming, m.Status().State)
+	})
+}
+
+func TestMonitorsStatus(t *testing.T) {
+	_, manager := newTestManager(t)
+
+	expected := StatusMap{
+		"1": {ID: "1", Name: "one", State: StateDisabled},
+		"2": {ID: "2", Name: "two", State: StateDisabled},
+	}
+	require.Equal(t, expected, manager.MonitorsStatus())
+}
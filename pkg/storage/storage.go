@@ -4,11 +4,16 @@ package storage
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"io/fs"
+	"nvr/pkg/backoff"
+	"nvr/pkg/backup"
+	"nvr/pkg/certs"
+	"nvr/pkg/configstore"
 	"nvr/pkg/log"
+	"nvr/pkg/metrics"
+	"nvr/pkg/video/hls"
 	"os"
 	"path/filepath"
 	"strconv"
@@ -58,6 +63,28 @@ func (s *Manager) DiskUsage(maxAge time.Duration) (DiskUsage, error) {
 	return s.disk.usage(maxAge)
 }
 
+// CollectMetrics writes the disk usage of the storage directory.
+func (s *Manager) CollectMetrics(e *metrics.Encoder) {
+	usage, err := s.DiskUsage(10 * time.Minute)
+	if err != nil {
+		return
+	}
+	maxBytes, err := s.disk.general.DiskSpace()
+	if err != nil {
+		return
+	}
+
+	e.Gauge("nvr_storage_used_bytes",
+		"Disk space used by the storage directory.",
+		metrics.Sample{Value: float64(usage.Used)})
+	e.Gauge("nvr_storage_max_bytes",
+		"Maximum allowed disk usage.",
+		metrics.Sample{Value: float64(maxBytes)})
+	e.Gauge("nvr_storage_used_percent",
+		"Disk usage in percent of the maximum allowed.",
+		metrics.Sample{Value: float64(usage.Percent)})
+}
+
 // prune checks if disk usage is above 99%,
 // if true deletes all files from the oldest day.
 func (s *Manager) prune() error {
@@ -277,20 +304,44 @@ type ConfigEnv struct {
 	Port           int    `yaml:"port"`
 	RTSPPort       int    `yaml:"rtspPort"`
 	RTSPPortExpose bool   `yaml:"rtspPortExpose"`
+	RTSPSPort      int    `yaml:"rtspsPort"` // Zero disables RTSPS.
 	HLSPort        int    `yaml:"hlsPort"`
 	HLSPortExpose  bool   `yaml:"hlsPortExpose"`
 	GoBin          string `yaml:"goBin"`
 	FFmpegBin      string `yaml:"ffmpegBin"`
 
+	// UDP port range for RTSP readers, two ports per track.
+	// Zero disables the UDP transport.
+	RTSPUDPPorts [2]int `yaml:"rtspUdpPorts"`
+
 	StorageDir string `yaml:"storageDir"`
 	TempDir    string
 
 	HomeDir   string `yaml:"homeDir"`
 	ConfigDir string
+
+	// Restart policy for crashed processes.
+	RestartBackoff backoff.Config `yaml:"restartBackoff"`
+
+	// Automatic configuration backups.
+	Backup backup.Config `yaml:"backup"`
+
+	// Audit logs older than this are deleted.
+	AuditRetention time.Duration `yaml:"auditRetention"`
+
+	// Built-in HTTPS.
+	TLS certs.Config `yaml:"tls"`
+
+	// Disk space of the DVR playlists.
+	DVR hls.DVRConfig `yaml:"dvr"`
 }
 
-// ErrPathNotAbsolute path is not absolute.
-var ErrPathNotAbsolute = errors.New("path is not absolute")
+// Errors.
+var (
+	ErrPathNotAbsolute  = errors.New("path is not absolute")
+	ErrRTSPSRequiresTLS = errors.New("rtspsPort requires tls to be enabled")
+	ErrRTSPUDPPorts     = errors.New("invalid rtspUdpPorts")
+)
 
 // NewConfigEnv return new environment configuration.
 func NewConfigEnv(envPath string, envYAML []byte) (*ConfigEnv, error) {
@@ -324,6 +375,15 @@ func NewConfigEnv(envPath string, envYAML []byte) (*ConfigEnv, error) {
 	if env.StorageDir == "" {
 		env.StorageDir = filepath.Join(env.HomeDir, "storage")
 	}
+	env.RestartBackoff.FillMissing()
+	env.Backup.FillMissing()
+	if env.DVR.Dir == "" {
+		env.DVR.Dir = filepath.Join(env.TempDir, "dvr")
+	}
+	env.DVR.FillMissing()
+	if env.AuditRetention == 0 {
+		env.AuditRetention = 365 * 24 * time.Hour
+	}
 
 	if !dirExist(env.GoBin) {
 		return nil, fmt.Errorf("goBin '%v': %w", env.GoBin, os.ErrNotExist)
@@ -344,10 +404,29 @@ func NewConfigEnv(envPath string, envYAML []byte) (*ConfigEnv, error) {
 	if !filepath.IsAbs(env.StorageDir) {
 		return nil, fmt.Errorf("StorageDir '%v': %w", env.StorageDir, ErrPathNotAbsolute)
 	}
+	if !filepath.IsAbs(env.DVR.Dir) {
+		return nil, fmt.Errorf("dvr dir '%v': %w", env.DVR.Dir, ErrPathNotAbsolute)
+	}
+	if err := env.TLS.Validate(); err != nil {
+		return nil, fmt.Errorf("tls: %w", err)
+	}
+	if env.RTSPSPort != 0 && !env.TLS.Enable {
+		return nil, ErrRTSPSRequiresTLS
+	}
+
+	if udp := env.RTSPUDPPorts; udp != [2]int{} &&
+		(udp[0] <= 0 || udp[1] > 65535 || udp[1]-udp[0] < 1) {
+		return nil, fmt.Errorf("%w: %d-%d", ErrRTSPUDPPorts, udp[0], udp[1])
+	}
 
 	return &env, nil
 }
 
+// BackupsDir return automatic backups directory.
+func (env ConfigEnv) BackupsDir() string {
+	return filepath.Join(env.StorageDir, "backups")
+}
+
 // RecordingsDir return recordings directory.
 func (env ConfigEnv) RecordingsDir() string {
 	return filepath.Join(env.StorageDir, "recordings")
@@ -400,18 +479,18 @@ func NewConfigGeneral(path string) (*ConfigGeneral, error) {
 		path:   path + "/general.json",
 	}
 
-	if !dirExist(general.path) {
-		if err := generateGeneralConfig(general.path); err != nil {
-			return &ConfigGeneral{}, fmt.Errorf("generate general.yaml: %w", err)
+	err := configstore.ReadJSON(general.path, &general.Config)
+	if errors.Is(err, os.ErrNotExist) {
+		general.Config = map[string]string{
+			"diskSpace": "5",
+			"theme":     "default",
 		}
+		err = configstore.WriteJSON(general.path, general.Config)
+		if err != nil {
+			return &ConfigGeneral{}, fmt.Errorf("generate general.json: %w", err)
+		}
+		return &general, nil
 	}
-
-	file, err := os.ReadFile(general.path)
-	if err != nil {
-		return &ConfigGeneral{}, err
-	}
-
-	err = json.Unmarshal(file, &general.Config)
 	if err != nil {
 		return &ConfigGeneral{}, err
 	}
@@ -419,16 +498,6 @@ func NewConfigGeneral(path string) (*ConfigGeneral, error) {
 	return &general, nil
 }
 
-func generateGeneralConfig(path string) error {
-	config := map[string]string{
-		"diskSpace": "5",
-		"theme":     "default",
-	}
-	c, _ := json.MarshalIndent(config, "", "    ")
-
-	return os.WriteFile(path, c, 0o600)
-}
-
 // Get returns general config.
 func (general *ConfigGeneral) Get() map[string]string {
 	defer general.mu.Unlock()
@@ -439,16 +508,13 @@ func (general *ConfigGeneral) Get() map[string]string {
 // Set sets config value and saves file.
 func (general *ConfigGeneral) Set(newConfig map[string]string) error {
 	general.mu.Lock()
+	defer general.mu.Unlock()
 
-	config, _ := json.MarshalIndent(newConfig, "", "    ")
-
-	if err := os.WriteFile(general.path, config, 0o600); err != nil {
+	if err := configstore.WriteJSON(general.path, newConfig); err != nil {
 		return err
 	}
 
 	general.Config = newConfig
-
-	general.mu.Unlock()
 	return nil
 }
 
@@ -16,6 +16,8 @@ import (
 	"time"
 
 	"nvr/pkg/log"
+	"nvr/pkg/metrics"
+	"nvr/pkg/video/hls"
 
 	"github.com/stretchr/testify/require"
 	"gopkg.in/yaml.v3"
@@ -155,6 +157,31 @@ var highUsage = func(fs.FS) int64 {
 	return 1000000000
 }
 
+func TestCollectMetrics(t *testing.T) {
+	s := &Manager{
+		disk: &disk{
+			general: &ConfigGeneral{
+				Config: map[string]string{"diskSpace": "0.1"},
+			},
+			diskUsageBytes: func(fs.FS) int64 { return 25 * int64(megabyte) },
+		},
+	}
+
+	var b bytes.Buffer
+	s.CollectMetrics(metrics.NewEncoder(&b))
+
+	expected := "# HELP nvr_storage_used_bytes Disk space used by the storage directory.\n" +
+		"# TYPE nvr_storage_used_bytes gauge\n" +
+		"nvr_storage_used_bytes 2.5e+07\n" +
+		"# HELP nvr_storage_max_bytes Maximum allowed disk usage.\n" +
+		"# TYPE nvr_storage_max_bytes gauge\n" +
+		"nvr_storage_max_bytes 1e+08\n" +
+		"# HELP nvr_storage_used_percent Disk usage in percent of the maximum allowed.\n" +
+		"# TYPE nvr_storage_used_percent gauge\n" +
+		"nvr_storage_used_percent 25\n"
+	require.Equal(t, expected, b.String())
+}
+
 func TestPurge(t *testing.T) {
 	t.Run("ok", func(t *testing.T) {
 		cases := map[string]struct {
@@ -379,7 +406,13 @@ func newTestEnv(t *testing.T) (string, *ConfigEnv, func()) {
 		TempDir:    filepath.Join(homeDir, "nvr"),
 		HomeDir:    homeDir,
 		ConfigDir:  configDir,
+
+		AuditRetention: 365 * 24 * time.Hour,
+		DVR:            hls.DVRConfig{Dir: filepath.Join(homeDir, "dvr")},
 	}
+	env.RestartBackoff.FillMissing()
+	env.Backup.FillMissing()
+	env.DVR.FillMissing()
 
 	return envPath, env, cancelFunc
 }
@@ -410,7 +443,13 @@ func TestNewConfigEnv(t *testing.T) {
 			TempDir:    env.TempDir,
 			HomeDir:    homeDir,
 			ConfigDir:  filepath.Join(homeDir, "configs"),
+
+			AuditRetention: 365 * 24 * time.Hour,
+			DVR:            hls.DVRConfig{Dir: filepath.Join(env.TempDir, "dvr")},
 		}
+		expected.RestartBackoff.FillMissing()
+		expected.Backup.FillMissing()
+		expected.DVR.FillMissing()
 		require.Equal(t, *env, expected)
 	})
 	t.Run("maximal", func(t *testing.T) {
@@ -502,6 +541,30 @@ func TestNewConfigEnv(t *testing.T) {
 		_, err = NewConfigEnv(envPath, envYAML)
 		require.ErrorIs(t, err, ErrPathNotAbsolute)
 	})
+	t.Run("rtspsRequiresTLS", func(t *testing.T) {
+		envPath, testEnv, cancel := newTestEnv(t)
+		defer cancel()
+
+		testEnv.RTSPSPort = 2023
+
+		envYAML, err := yaml.Marshal(testEnv)
+		require.NoError(t, err)
+
+		_, err = NewConfigEnv(envPath, envYAML)
+		require.ErrorIs(t, err, ErrRTSPSRequiresTLS)
+	})
+	t.Run("rtspUDPPorts", func(t *testing.T) {
+		envPath, testEnv, cancel := newTestEnv(t)
+		defer cancel()
+
+		testEnv.RTSPUDPPorts = [2]int{8000, 7000}
+
+		envYAML, err := yaml.Marshal(testEnv)
+		require.NoError(t, err)
+
+		_, err = NewConfigEnv(envPath, envYAML)
+		require.ErrorIs(t, err, ErrRTSPUDPPorts)
+	})
 	t.Run("CensorLog", func(t *testing.T) {
 		cases := map[string]struct {
 			env      ConfigEnv
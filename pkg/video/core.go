@@ -2,29 +2,46 @@ package video
 
 import (
 	"context"
+	"crypto/tls"
+	"net"
 	"net/http"
 	"nvr/pkg/log"
+	"nvr/pkg/metrics"
 	"nvr/pkg/storage"
 	"nvr/pkg/video/gortsplib"
 	"nvr/pkg/video/hls"
 	"strconv"
 	"sync"
+	"time"
 )
 
 // Server is an instance of rtsp-simple-server.
 type Server struct {
 	rtspAddress string
 	hlsAddress  string
+	hlsTLS      bool
 	pathManager *pathManager
 	rtspServer  *rtspServer
 	hlsServer   *hlsServer
+	webrtc      *webrtcServer
 	wg          *sync.WaitGroup
 }
 
 const readBufferCount = 2048
 
-// NewServer allocates a server.
-func NewServer(log *log.Logger, wg *sync.WaitGroup, env storage.ConfigEnv) *Server {
+// AuthFunc returns true if the request is allowed to read the path.
+// RTSP requests are converted to HTTP requests with the same
+// "Authorization" header, so that both servers share one check.
+type AuthFunc func(r *http.Request, pathName string) bool
+
+// NewServer allocates a server. tlsConfig is nil if TLS is disabled,
+// otherwise it's used for the RTSPS listener and optionally for HLS.
+func NewServer(
+	log *log.Logger,
+	wg *sync.WaitGroup,
+	env storage.ConfigEnv,
+	tlsConfig *tls.Config,
+) *Server {
 	rtspAddress := func() string {
 		if env.RTSPPortExpose {
 			return ":" + strconv.Itoa(env.RTSPPort)
@@ -38,16 +55,37 @@ func NewServer(log *log.Logger, wg *sync.WaitGroup, env storage.ConfigEnv) *Serv
 		return "127.0.0.1:" + strconv.Itoa(env.HLSPort)
 	}()
 
-	hlsServer := newHLSServer(wg, readBufferCount, log)
+	var hlsTLS, rtspsTLS *tls.Config
+	if tlsConfig != nil && env.TLS.HLS {
+		hlsTLS = tlsConfig
+	}
+	if tlsConfig != nil && env.RTSPSPort != 0 {
+		rtspsTLS = tlsConfig
+	}
+	rtspsAddress := ":" + strconv.Itoa(env.RTSPSPort)
+
+	dvrSpool := hls.NewDVRSpool(env.DVR)
+	hlsServer := newHLSServer(wg, readBufferCount, dvrSpool, hlsTLS, log)
 	pathManager := newPathManager(wg, log, hlsServer)
-	rtspServer := newRTSPServer(wg, rtspAddress, readBufferCount, pathManager, log)
+	rtspServer := newRTSPServer(
+		wg,
+		rtspAddress,
+		rtspsAddress,
+		rtspsTLS,
+		env.RTSPUDPPorts,
+		readBufferCount,
+		pathManager,
+		log,
+	)
 
 	return &Server{
 		rtspAddress: rtspAddress,
 		hlsAddress:  hlsAddress,
+		hlsTLS:      hlsTLS != nil,
 		pathManager: pathManager,
 		rtspServer:  rtspServer,
 		hlsServer:   hlsServer,
+		webrtc:      newWebRTCServer(pathManager),
 		wg:          wg,
 	}
 }
@@ -66,9 +104,34 @@ func (s *Server) Start(ctx context.Context) error {
 		cancel()
 		return err
 	}
+
+	if err := s.webrtc.start(ctx2); err != nil {
+		cancel()
+		return err
+	}
 	return nil
 }
 
+// SetAuth requires clients of the RTSP and HLS servers that don't connect
+// from the loopback interface to authenticate. Remote RTSP clients can only
+// read, publishing is restricted to local processes. Every loopback client is
+// trusted, including a reverse proxy on the same host, the ports must therefore
+// be exposed directly instead of through a local proxy. Must be called before Start.
+func (s *Server) SetAuth(auth AuthFunc) {
+	s.rtspServer.auth = auth
+	s.hlsServer.auth = auth
+}
+
+// isLoopback returns true if the address is on the loopback interface.
+func isLoopback(remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
 // CancelFunc .
 type CancelFunc func()
 
@@ -81,13 +144,14 @@ type IHLSMuxer interface {
 	AudioTrack() *gortsplib.TrackMPEG4Audio
 	WaitForSegFinalized()
 	NextSegment(prevID uint64) (*hls.Segment, error)
+	LatestSegment() (*hls.Segment, error)
 }
 
 // ServerPath .
 type ServerPath struct {
 	HlsAddress   string
 	RtspAddress  string
-	RtspProtocol string
+	RtspProtocol string // Transport used by internal readers.
 	HLSMuxer     HlsMuxerFunc
 }
 
@@ -98,8 +162,12 @@ func (s *Server) NewPath(ctx context.Context, name string, newConf PathConf) (*S
 		return nil, err
 	}
 
+	hlsScheme := "http://"
+	if s.hlsTLS {
+		hlsScheme = "https://"
+	}
 	return &ServerPath{
-		HlsAddress:   "http://" + s.hlsAddress + "/hls/" + name + "/index.m3u8",
+		HlsAddress:   hlsScheme + s.hlsAddress + "/hls/" + name + "/index.m3u8",
 		RtspAddress:  "rtsp://" + s.rtspAddress + "/" + name,
 		RtspProtocol: "tcp",
 		HLSMuxer:     hlsMuxer,
@@ -115,3 +183,39 @@ func (s *Server) PathExist(name string) bool {
 func (s *Server) HandleHLS() http.HandlerFunc {
 	return s.hlsServer.HandleRequest()
 }
+
+// HandleWHEP handles WebRTC WHEP requests.
+func (s *Server) HandleWHEP() http.HandlerFunc {
+	return s.webrtc.HandleWHEP()
+}
+
+// CollectMetrics writes the number of readers of each path.
+func (s *Server) CollectMetrics(e *metrics.Encoder) {
+	now := time.Now()
+	var rtspReaders []metrics.Sample
+	var hlsReaders []metrics.Sample
+	var webrtcReaders []metrics.Sample
+	for _, stats := range s.pathManager.stats() {
+		input := "main"
+		if stats.conf.IsSub {
+			input = "sub"
+		}
+		labels := metrics.Labels{"monitor": stats.conf.MonitorID, "input": input}
+
+		rtspReaders = append(rtspReaders, metrics.Sample{
+			Labels: labels,
+			Value:  float64(stats.rtspReaders),
+		})
+		hlsReaders = append(hlsReaders, metrics.Sample{
+			Labels: labels,
+			Value:  float64(s.hlsServer.clients.count(stats.name, now)),
+		})
+		webrtcReaders = append(webrtcReaders, metrics.Sample{
+			Labels: labels,
+			Value:  float64(stats.webrtcReaders),
+		})
+	}
+	e.Gauge("nvr_video_rtsp_readers", "Number of RTSP readers.", rtspReaders...)
+	e.Gauge("nvr_video_hls_readers", "Number of active HLS clients.", hlsReaders...)
+	e.Gauge("nvr_video_webrtc_readers", "Number of WebRTC sessions.", webrtcReaders...)
+}
@@ -1,12 +1,16 @@
 package video
 
 import (
+	"bytes"
 	"context"
+	"net/http"
+	"net/http/httptest"
 	"sync"
 	"testing"
 	"time"
 
 	"nvr/pkg/log"
+	"nvr/pkg/metrics"
 
 	"github.com/stretchr/testify/require"
 )
@@ -57,3 +61,47 @@ func TestNewPath(t *testing.T) {
 	time.Sleep(10 * time.Millisecond)
 	require.False(t, p.PathExist("mypath"))
 }
+
+func TestCollectMetrics(t *testing.T) {
+	s, cancel := newTestServer(t)
+	defer cancel()
+	s.hlsServer = &hlsServer{clients: newHLSClients()}
+
+	ctx, cancel2 := context.WithCancel(context.Background())
+	defer cancel2()
+
+	_, err := s.NewPath(ctx, "x", PathConf{MonitorID: "x"})
+	require.NoError(t, err)
+	_, err = s.NewPath(ctx, "x_sub", PathConf{MonitorID: "x", IsSub: true})
+	require.NoError(t, err)
+
+	request := func(remoteAddr, cookie string) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = remoteAddr
+		r.Header.Set("Cookie", cookie)
+		return r
+	}
+	// Same client on two connections, another client
+	// behind the same NAT and an inactive client.
+	s.hlsServer.clients.seen("x", request("1.2.3.4:1", "a"), time.Now())
+	s.hlsServer.clients.seen("x", request("1.2.3.4:2", "a"), time.Now())
+	s.hlsServer.clients.seen("x", request("1.2.3.4:3", "b"), time.Now())
+	s.hlsServer.clients.seen("x", request("5.6.7.8:1", "a"), time.Now().Add(-1*time.Minute))
+
+	var b bytes.Buffer
+	s.CollectMetrics(metrics.NewEncoder(&b))
+
+	expected := "# HELP nvr_video_rtsp_readers Number of RTSP readers.\n" +
+		"# TYPE nvr_video_rtsp_readers gauge\n" +
+		"nvr_video_rtsp_readers{input=\"main\",monitor=\"x\"} 0\n" +
+		"nvr_video_rtsp_readers{input=\"sub\",monitor=\"x\"} 0\n" +
+		"# HELP nvr_video_hls_readers Number of active HLS clients.\n" +
+		"# TYPE nvr_video_hls_readers gauge\n" +
+		"nvr_video_hls_readers{input=\"main\",monitor=\"x\"} 2\n" +
+		"nvr_video_hls_readers{input=\"sub\",monitor=\"x\"} 0\n" +
+		"# HELP nvr_video_webrtc_readers Number of WebRTC sessions.\n" +
+		"# TYPE nvr_video_webrtc_readers gauge\n" +
+		"nvr_video_webrtc_readers{input=\"main\",monitor=\"x\"} 0\n" +
+		"nvr_video_webrtc_readers{input=\"sub\",monitor=\"x\"} 0\n"
+	require.Equal(t, expected, b.String())
+}
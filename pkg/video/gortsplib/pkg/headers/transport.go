@@ -5,6 +5,7 @@ import (
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"net"
 	"nvr/pkg/video/gortsplib/pkg/base"
 	"strconv"
 	"strings"
@@ -21,11 +22,51 @@ const (
 	TransportModeRecord
 )
 
+// TransportProtocol is a transport protocol.
+type TransportProtocol int
+
+const (
+	// TransportProtocolTCP is the "RTP/AVP/TCP" protocol.
+	TransportProtocolTCP TransportProtocol = iota
+
+	// TransportProtocolUDP is the "RTP/AVP" or "RTP/AVP/UDP" protocol.
+	TransportProtocolUDP
+)
+
+// String implements fmt.Stringer.
+func (p TransportProtocol) String() string {
+	if p == TransportProtocolUDP {
+		return "UDP"
+	}
+	return "TCP"
+}
+
 // Transport is a Transport header.
 type Transport struct {
+	// protocol of the stream
+	Protocol TransportProtocol
+
+	// multicast delivery, UDP only
+	Multicast bool
+
+	// (optional) multicast group of the stream
+	Destination *net.IP
+
+	// (optional) multicast RTP and RTCP ports
+	Ports *[2]int
+
+	// (optional) multicast time-to-live
+	TTL *uint
+
 	// (optional) interleaved frame ids
 	InterleavedIDs *[2]int
 
+	// (optional) client RTP and RTCP ports, UDP only
+	ClientPorts *[2]int
+
+	// (optional) server RTP and RTCP ports, UDP only
+	ServerPorts *[2]int
+
 	// (optional) SSRC of the packets of the stream
 	SSRC *uint32
 
@@ -64,12 +105,18 @@ func parsePorts(val string) (*[2]int, error) {
 	return &[2]int{0, 0}, fmt.Errorf("%w (%v)", ErrPortsInvalid, val)
 }
 
+func marshalPorts(ports [2]int) string {
+	return strconv.FormatInt(int64(ports[0]), 10) + "-" + strconv.FormatInt(int64(ports[1]), 10)
+}
+
 // Transport errors.
 var (
-	ErrTransportValueMissing     = errors.New("value not provided")
-	ErrTransportMultipleValues   = errors.New("value provided multiple times")
-	ErrTransportInvalidMode      = errors.New("invalid transport mode")
-	ErrTransportProtocolNotFound = errors.New("protocol not found")
+	ErrTransportValueMissing       = errors.New("value not provided")
+	ErrTransportMultipleValues     = errors.New("value provided multiple times")
+	ErrTransportInvalidMode        = errors.New("invalid transport mode")
+	ErrTransportProtocolNotFound   = errors.New("protocol not found")
+	ErrTransportInvalidDestination = errors.New("invalid destination")
+	ErrTransportInvalidTTL         = errors.New("invalid ttl")
 )
 
 // Unmarshal decodes a Transport header.
@@ -96,8 +143,38 @@ func (h *Transport) Unmarshal(v base.HeaderValue) error { //nolint:funlen
 
 		switch k {
 		case "RTP/AVP/TCP":
+			h.Protocol = TransportProtocolTCP
 			protocolFound = true
 
+		case "RTP/AVP", "RTP/AVP/UDP":
+			h.Protocol = TransportProtocolUDP
+			protocolFound = true
+
+		case "multicast":
+			h.Multicast = true
+
+		case "destination":
+			ip := net.ParseIP(v)
+			if ip == nil {
+				return fmt.Errorf("%w (%v)", ErrTransportInvalidDestination, v)
+			}
+			h.Destination = &ip
+
+		case "port":
+			ports, err := parsePorts(v)
+			if err != nil {
+				return err
+			}
+			h.Ports = ports
+
+		case "ttl":
+			tmp, err := strconv.ParseUint(v, 10, 8)
+			if err != nil {
+				return fmt.Errorf("%w (%v)", ErrTransportInvalidTTL, v)
+			}
+			ttl := uint(tmp)
+			h.TTL = &ttl
+
 		case "interleaved":
 			ports, err := parsePorts(v)
 			if err != nil {
@@ -105,6 +182,20 @@ func (h *Transport) Unmarshal(v base.HeaderValue) error { //nolint:funlen
 			}
 			h.InterleavedIDs = ports
 
+		case "client_port":
+			ports, err := parsePorts(v)
+			if err != nil {
+				return err
+			}
+			h.ClientPorts = ports
+
+		case "server_port":
+			ports, err := parsePorts(v)
+			if err != nil {
+				return err
+			}
+			h.ServerPorts = ports
+
 		case "ssrc":
 			v = strings.TrimLeft(v, " ")
 
@@ -155,11 +246,37 @@ func (h *Transport) Unmarshal(v base.HeaderValue) error { //nolint:funlen
 func (h Transport) Marshal() base.HeaderValue {
 	var rets []string
 
-	rets = append(rets, "RTP/AVP/TCP")
+	switch {
+	case h.Protocol == TransportProtocolUDP && h.Multicast:
+		rets = append(rets, "RTP/AVP", "multicast")
+	case h.Protocol == TransportProtocolUDP:
+		rets = append(rets, "RTP/AVP", "unicast")
+	default:
+		rets = append(rets, "RTP/AVP/TCP")
+	}
+
+	if h.Destination != nil {
+		rets = append(rets, "destination="+h.Destination.String())
+	}
+
+	if h.Ports != nil {
+		rets = append(rets, "port="+marshalPorts(*h.Ports))
+	}
+
+	if h.TTL != nil {
+		rets = append(rets, "ttl="+strconv.FormatUint(uint64(*h.TTL), 10))
+	}
 
 	if h.InterleavedIDs != nil {
-		rets = append(rets, "interleaved="+strconv.FormatInt(int64(h.InterleavedIDs[0]), 10)+
-			"-"+strconv.FormatInt(int64(h.InterleavedIDs[1]), 10))
+		rets = append(rets, "interleaved="+marshalPorts(*h.InterleavedIDs))
+	}
+
+	if h.ClientPorts != nil {
+		rets = append(rets, "client_port="+marshalPorts(*h.ClientPorts))
+	}
+
+	if h.ServerPorts != nil {
+		rets = append(rets, "server_port="+marshalPorts(*h.ServerPorts))
 	}
 
 	if h.SSRC != nil {
@@ -1,6 +1,7 @@
 package headers
 
 import (
+	"net"
 	"testing"
 
 	"nvr/pkg/video/gortsplib/pkg/base"
@@ -22,6 +23,60 @@ var casesTransport = []struct {
 			InterleavedIDs: &[2]int{0, 1},
 		},
 	},
+	{
+		"udp play request",
+		base.HeaderValue{`RTP/AVP;unicast;client_port=3456-3457;mode="PLAY"`},
+		base.HeaderValue{`RTP/AVP;unicast;client_port=3456-3457;mode=play`},
+		Transport{
+			Protocol:    TransportProtocolUDP,
+			ClientPorts: &[2]int{3456, 3457},
+			Mode: func() *TransportMode {
+				v := TransportModePlay
+				return &v
+			}(),
+		},
+	},
+	{
+		"udp play response",
+		base.HeaderValue{`RTP/AVP/UDP;unicast;client_port=3056-3057;server_port=5000-5001`},
+		base.HeaderValue{`RTP/AVP;unicast;client_port=3056-3057;server_port=5000-5001`},
+		Transport{
+			Protocol:    TransportProtocolUDP,
+			ClientPorts: &[2]int{3056, 3057},
+			ServerPorts: &[2]int{5000, 5001},
+		},
+	},
+	{
+		"udp multicast request",
+		base.HeaderValue{`RTP/AVP;multicast;mode=play`},
+		base.HeaderValue{`RTP/AVP;multicast;mode=play`},
+		Transport{
+			Protocol:  TransportProtocolUDP,
+			Multicast: true,
+			Mode: func() *TransportMode {
+				v := TransportModePlay
+				return &v
+			}(),
+		},
+	},
+	{
+		"udp multicast response",
+		base.HeaderValue{`RTP/AVP;multicast;destination=239.0.0.1;port=5000-5001;ttl=1`},
+		base.HeaderValue{`RTP/AVP;multicast;destination=239.0.0.1;port=5000-5001;ttl=1`},
+		Transport{
+			Protocol:  TransportProtocolUDP,
+			Multicast: true,
+			Destination: func() *net.IP {
+				v := net.ParseIP("239.0.0.1")
+				return &v
+			}(),
+			Ports: &[2]int{5000, 5001},
+			TTL: func() *uint {
+				v := uint(1)
+				return &v
+			}(),
+		},
+	},
 	{
 		"dahua rtsp server ssrc with initial spaces",
 		base.HeaderValue{`RTP/AVP/TCP;interleaved=0-1;ssrc=     D93FF`},
@@ -73,6 +128,26 @@ func TestTransportUnmarshalErrors(t *testing.T) {
 			base.HeaderValue{`RTP/AVP;unicast;interleaved=aa-14187`},
 			"invalid ports (aa-14187)",
 		},
+		{
+			"invalid client port",
+			base.HeaderValue{`RTP/AVP;unicast;client_port=aa-14187`},
+			"invalid ports (aa-14187)",
+		},
+		{
+			"invalid destination",
+			base.HeaderValue{`RTP/AVP;multicast;destination=aa`},
+			"invalid destination (aa)",
+		},
+		{
+			"invalid ttl",
+			base.HeaderValue{`RTP/AVP;multicast;ttl=300`},
+			"invalid ttl (300)",
+		},
+		{
+			"protocol not found",
+			base.HeaderValue{`RTP/SAVP;unicast;client_port=1000-1001`},
+			"protocol not found (RTP/SAVP;unicast;client_port=1000-1001)",
+		},
 		{
 			"invalid mode",
 			base.HeaderValue{`RTP/AVP;unicast;mode=aa`},
@@ -142,3 +142,14 @@ var ErrServerSessionNotInUse = errors.New("not in use")
 
 // ErrServerUnexpectedFrame received unexpected interleaved frame.
 var ErrServerUnexpectedFrame = errors.New("received unexpected interleaved frame")
+
+// ErrServerTransportHeaderNoClientPorts client ports are missing.
+var ErrServerTransportHeaderNoClientPorts = errors.New(
+	"transport header does not contain client ports")
+
+// ErrServerTransportHeaderInvalidClientPorts invalid client ports.
+var ErrServerTransportHeaderInvalidClientPorts = errors.New("invalid client ports")
+
+// ErrServerNoUDPPacketsInAWhile no UDP packets or requests have been received for too long.
+var ErrServerNoUDPPacketsInAWhile = errors.New(
+	"no UDP packets or RTSP requests received recently")
@@ -81,6 +81,14 @@ func (d *Decoder) Init() {
 	d.timeDecoder = rtptimedec.New(rtpClockRate)
 }
 
+// Reset discards pending fragmented packets. It must be called when packets
+// are lost, otherwise fragments of different NALUs could be merged together.
+// Decoding resumes from the next starting packet.
+func (d *Decoder) Reset() {
+	d.fragments = d.fragments[:0]
+	d.firstPacketReceived = false
+}
+
 // Decode decodes NALUs from a RTP/H264 packet.
 func (d *Decoder) Decode(pkt *rtp.Packet) ([][]byte, time.Duration, error) { //nolint:funlen,gocognit
 	if d.PacketizationMode >= 2 {
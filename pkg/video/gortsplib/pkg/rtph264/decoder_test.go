@@ -361,6 +361,53 @@ func TestDecodeCorruptedFragment(t *testing.T) {
 	require.Equal(t, [][]byte{{0x01, 0x00}}, nalus)
 }
 
+func TestDecodeReset(t *testing.T) {
+	d := &Decoder{}
+	d.Init()
+
+	_, _, err := d.Decode(&rtp.Packet{
+		Header: rtp.Header{
+			Version:        2,
+			PayloadType:    96,
+			SequenceNumber: 17645,
+			Timestamp:      2289527317,
+			SSRC:           0x9dbb7812,
+		},
+		Payload: []byte{0x1c, 0x85, 0x01, 0x02},
+	})
+	require.Equal(t, ErrMorePacketsNeeded, err)
+
+	// Packet 17646 was lost.
+	d.Reset()
+
+	_, _, err = d.Decode(&rtp.Packet{
+		Header: rtp.Header{
+			Version:        2,
+			Marker:         true,
+			PayloadType:    96,
+			SequenceNumber: 17647,
+			Timestamp:      2289527317,
+			SSRC:           0x9dbb7812,
+		},
+		Payload: []byte{0x1c, 0x45, 0x05, 0x06},
+	})
+	require.ErrorIs(t, err, ErrNonStartingPacketAndNoPrevious)
+
+	nalus, _, err := d.Decode(&rtp.Packet{
+		Header: rtp.Header{
+			Version:        2,
+			Marker:         true,
+			PayloadType:    96,
+			SequenceNumber: 17648,
+			Timestamp:      2289530317,
+			SSRC:           0x9dbb7812,
+		},
+		Payload: []byte{0x01, 0x00},
+	})
+	require.NoError(t, err)
+	require.Equal(t, [][]byte{{0x01, 0x00}}, nalus)
+}
+
 func TestDecodeSTAPAWithPadding(t *testing.T) {
 	d := &Decoder{}
 	d.Init()
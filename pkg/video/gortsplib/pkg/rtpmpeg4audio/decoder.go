@@ -62,6 +62,13 @@ func (e AUsizeToBigError) Error() string {
 		e.AUsize, mpeg4audio.MaxAccessUnitSize)
 }
 
+// Reset discards pending fragmented packets. It must be called when
+// packets are lost, otherwise fragments of different AUs could be merged together.
+func (d *Decoder) Reset() {
+	d.fragments = d.fragments[:0]
+	d.fragmentedSize = 0
+}
+
 // Decode decodes AUs from a RTP/MPEG4-audio packet.
 // It returns the AUs and the PTS of the first AU.
 // The PTS of subsequent AUs can be calculated by adding time.Second*mpeg4audio.SamplesPerAccessUnit/clockRate.
@@ -3,6 +3,7 @@ package gortsplib
 import (
 	"context"
 	"crypto/rand"
+	"crypto/tls"
 	"encoding/binary"
 	"errors"
 	"net"
@@ -20,9 +21,9 @@ type ServerHandler interface {
 	OnConnClose(*ServerConn, error)
 	OnSessionOpen(*ServerSession, *ServerConn, string)
 	OnSessionClose(*ServerSession, error)
-	OnDescribe(pathName string) (*base.Response, *ServerStream, error)
-	OnAnnounce(*ServerSession, string, Tracks) (*base.Response, error)
-	OnSetup(*ServerSession, string, int) (*base.Response, *ServerStream, error)
+	OnDescribe(sc *ServerConn, req *base.Request, pathName string) (*base.Response, *ServerStream, error)
+	OnAnnounce(*ServerSession, *base.Request, string, Tracks) (*base.Response, error)
+	OnSetup(*ServerSession, *base.Request, string, int) (*base.Response, *ServerStream, error)
 	OnPlay(*ServerSession) (*base.Response, error)
 	OnRecord(*ServerSession) (*base.Response, error)
 	OnPacketRTP(*ServerSession, int, *rtp.Packet)
@@ -81,6 +82,14 @@ type Server struct {
 	// It allows to queue packets before sending them.
 	writeBufferCount int
 
+	// If set, connections are encrypted with TLS (RTSPS).
+	tlsConfig *tls.Config
+
+	// Range of UDP ports that are allocated in pairs to the tracks
+	// of sessions that are read with the UDP transport.
+	// The UDP transport is disabled if zero.
+	udpPortRange [2]int
+
 	handler ServerHandler
 
 	// Function used to initialize the TCP listener.
@@ -94,6 +103,7 @@ type Server struct {
 	ctxCancel   func()
 	wg          sync.WaitGroup
 	tcpListener net.Listener
+	udpPorts    *udpPortAllocator
 	sessions    map[string]*ServerSession
 	conns       map[*ServerConn]struct{}
 	closeError  error
@@ -112,6 +122,8 @@ func NewServer(
 	readBufferCount int,
 	writeBufferCount int,
 	address string,
+	tlsConfig *tls.Config,
+	udpPortRange [2]int,
 ) *Server {
 	return &Server{
 		handler:          handler,
@@ -120,6 +132,8 @@ func NewServer(
 		readBufferCount:  readBufferCount,
 		writeBufferCount: writeBufferCount,
 		rtspAddress:      address,
+		tlsConfig:        tlsConfig,
+		udpPortRange:     udpPortRange,
 	}
 }
 
@@ -165,11 +179,30 @@ func (s *Server) Start() error {
 		return ErrServerMissingRTSPaddress
 	}
 
+	if s.udpPortRange != [2]int{} {
+		if s.tlsConfig != nil {
+			return ErrServerUDPPortsWithTLS
+		}
+
+		host, _, err := net.SplitHostPort(s.rtspAddress)
+		if err != nil {
+			return err
+		}
+
+		s.udpPorts, err = newUDPPortAllocator(host, s.udpPortRange)
+		if err != nil {
+			return err
+		}
+	}
+
 	var err error
 	s.tcpListener, err = s.listen("tcp", s.rtspAddress)
 	if err != nil {
 		return err
 	}
+	if s.tlsConfig != nil {
+		s.tcpListener = tls.NewListener(s.tcpListener, s.tlsConfig)
+	}
 
 	s.ctx, s.ctxCancel = context.WithCancel(context.Background())
 
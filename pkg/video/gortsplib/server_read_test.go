@@ -8,6 +8,7 @@ import (
 	"nvr/pkg/video/gortsplib/pkg/base"
 	"nvr/pkg/video/gortsplib/pkg/conn"
 	"nvr/pkg/video/gortsplib/pkg/headers"
+	"nvr/pkg/video/gortsplib/pkg/liberrors"
 
 	"github.com/stretchr/testify/require"
 )
@@ -435,3 +436,305 @@ func TestServerReadWithoutTeardown(t *testing.T) {
 	<-sessionClosed
 	<-connClosed
 }
+
+func TestServerReadUDP(t *testing.T) {
+	track := &TrackH264{
+		PayloadType: 96,
+		SPS:         []byte{0x01, 0x02, 0x03, 0x04},
+		PPS:         []byte{0x01, 0x02, 0x03, 0x04},
+	}
+
+	stream := NewServerStream(Tracks{track})
+	defer stream.Close()
+
+	sessionClosed := make(chan error, 1)
+
+	s := &Server{
+		handler: &testServerHandler{
+			onSessionClose: func(_ *ServerSession, err error) {
+				sessionClosed <- err
+			},
+			onSetup: func(*ServerSession, string, int) (*base.Response, *ServerStream, error) {
+				return &base.Response{StatusCode: base.StatusOK}, stream, nil
+			},
+			onPlay: func(*ServerSession) (*base.Response, error) {
+				go func() {
+					time.Sleep(50 * time.Millisecond)
+					stream.WritePacketRTP(0, &testRTPPacket)
+				}()
+				return &base.Response{StatusCode: base.StatusOK}, nil
+			},
+		},
+		sessionTimeout:    1 * time.Second,
+		checkStreamPeriod: 100 * time.Millisecond,
+		rtspAddress:       "localhost:8554",
+		udpPortRange:      [2]int{35000, 35009},
+	}
+
+	err := s.Start()
+	require.NoError(t, err)
+	defer s.Close()
+
+	rtpConn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer rtpConn.Close()
+	rtcpConn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer rtcpConn.Close()
+
+	nconn, err := net.Dial("tcp", "localhost:8554")
+	require.NoError(t, err)
+	defer nconn.Close()
+	conn := conn.NewConn(nconn)
+
+	clientPorts := [2]int{
+		rtpConn.LocalAddr().(*net.UDPAddr).Port,
+		rtcpConn.LocalAddr().(*net.UDPAddr).Port,
+	}
+
+	res, err := writeReqReadRes(conn, base.Request{
+		Method: base.Setup,
+		URL:    mustParseURL("rtsp://localhost:8554/teststream/trackID=0"),
+		Header: base.Header{
+			"CSeq": base.HeaderValue{"1"},
+			"Transport": headers.Transport{
+				Protocol:    headers.TransportProtocolUDP,
+				ClientPorts: &clientPorts,
+				Mode: func() *headers.TransportMode {
+					v := headers.TransportModePlay
+					return &v
+				}(),
+			}.Marshal(),
+		},
+	})
+	require.NoError(t, err)
+	require.Equal(t, base.StatusOK, res.StatusCode)
+
+	var th headers.Transport
+	err = th.Unmarshal(res.Header["Transport"])
+	require.NoError(t, err)
+	require.Equal(t, headers.TransportProtocolUDP, th.Protocol)
+	require.Equal(t, &clientPorts, th.ClientPorts)
+	require.Equal(t, &[2]int{35000, 35001}, th.ServerPorts)
+
+	var sx headers.Session
+	err = sx.Unmarshal(res.Header["Session"])
+	require.NoError(t, err)
+	require.NotNil(t, sx.Timeout)
+	require.Equal(t, uint(1), *sx.Timeout)
+
+	res, err = writeReqReadRes(conn, base.Request{
+		Method: base.Play,
+		URL:    mustParseURL("rtsp://localhost:8554/teststream"),
+		Header: base.Header{
+			"CSeq":    base.HeaderValue{"2"},
+			"Session": base.HeaderValue{sx.Session},
+		},
+	})
+	require.NoError(t, err)
+	require.Equal(t, base.StatusOK, res.StatusCode)
+
+	buf := make([]byte, 2048)
+	rtpConn.SetReadDeadline(time.Now().Add(2 * time.Second)) //nolint:errcheck
+	n, addr, err := rtpConn.ReadFrom(buf)
+	require.NoError(t, err)
+	require.Equal(t, testRTPPacketMarshaled, buf[:n])
+	require.Equal(t, 35000, addr.(*net.UDPAddr).Port)
+
+	// The session outlives the connection until it times out.
+	nconn.Close()
+	select {
+	case err := <-sessionClosed:
+		t.Fatalf("session closed with the connection: %v", err)
+	case <-time.After(300 * time.Millisecond):
+	}
+
+	select {
+	case err := <-sessionClosed:
+		require.ErrorIs(t, err, liberrors.ErrServerNoUDPPacketsInAWhile)
+	case <-time.After(5 * time.Second):
+		t.Fatal("session didn't time out")
+	}
+}
+
+func TestServerReadUDPDisabled(t *testing.T) {
+	s := &Server{
+		handler: &testServerHandler{
+			onSetup: func(*ServerSession, string, int) (*base.Response, *ServerStream, error) {
+				t.Fatal("OnSetup called")
+				return nil, nil, nil
+			},
+		},
+		rtspAddress: "localhost:8554",
+	}
+
+	err := s.Start()
+	require.NoError(t, err)
+	defer s.Close()
+
+	nconn, err := net.Dial("tcp", "localhost:8554")
+	require.NoError(t, err)
+	defer nconn.Close()
+	conn := conn.NewConn(nconn)
+
+	res, err := writeReqReadRes(conn, base.Request{
+		Method: base.Setup,
+		URL:    mustParseURL("rtsp://localhost:8554/teststream/trackID=0"),
+		Header: base.Header{
+			"CSeq": base.HeaderValue{"1"},
+			"Transport": headers.Transport{
+				Protocol:    headers.TransportProtocolUDP,
+				ClientPorts: &[2]int{35100, 35101},
+			}.Marshal(),
+		},
+	})
+	require.NoError(t, err)
+	require.Equal(t, base.StatusUnsupportedTransport, res.StatusCode)
+}
+
+func loopbackInterface(t *testing.T) *net.Interface {
+	intfs, err := net.Interfaces()
+	require.NoError(t, err)
+
+	for _, intf := range intfs {
+		if intf.Flags&net.FlagLoopback != 0 {
+			return &intf
+		}
+	}
+	t.Skip("no loopback interface")
+	return nil
+}
+
+func TestServerReadMulticast(t *testing.T) {
+	track := &TrackH264{
+		PayloadType: 96,
+		SPS:         []byte{0x01, 0x02, 0x03, 0x04},
+		PPS:         []byte{0x01, 0x02, 0x03, 0x04},
+	}
+
+	group := net.ParseIP("239.255.0.1")
+	stream := NewServerStream(Tracks{track})
+	stream.EnableMulticast(group, 35200)
+	defer stream.Close()
+
+	s := &Server{
+		handler: &testServerHandler{
+			onSetup: func(*ServerSession, string, int) (*base.Response, *ServerStream, error) {
+				return &base.Response{StatusCode: base.StatusOK}, stream, nil
+			},
+			onPlay: func(*ServerSession) (*base.Response, error) {
+				return &base.Response{StatusCode: base.StatusOK}, nil
+			},
+		},
+		rtspAddress: "localhost:8554",
+	}
+
+	err := s.Start()
+	require.NoError(t, err)
+	defer s.Close()
+
+	receiver, err := net.ListenMulticastUDP("udp4", loopbackInterface(t),
+		&net.UDPAddr{IP: group, Port: 35200})
+	require.NoError(t, err)
+	defer receiver.Close()
+
+	// Two readers share the same group.
+	for i := 0; i < 2; i++ {
+		nconn, err := net.Dial("tcp", "localhost:8554")
+		require.NoError(t, err)
+		defer nconn.Close()
+		conn := conn.NewConn(nconn)
+
+		res, err := writeReqReadRes(conn, base.Request{
+			Method: base.Setup,
+			URL:    mustParseURL("rtsp://localhost:8554/teststream/trackID=0"),
+			Header: base.Header{
+				"CSeq": base.HeaderValue{"1"},
+				"Transport": headers.Transport{
+					Protocol:  headers.TransportProtocolUDP,
+					Multicast: true,
+				}.Marshal(),
+			},
+		})
+		require.NoError(t, err)
+		require.Equal(t, base.StatusOK, res.StatusCode)
+
+		var th headers.Transport
+		err = th.Unmarshal(res.Header["Transport"])
+		require.NoError(t, err)
+		require.True(t, th.Multicast)
+		require.True(t, group.Equal(*th.Destination))
+		require.Equal(t, &[2]int{35200, 35201}, th.Ports)
+
+		var sx headers.Session
+		err = sx.Unmarshal(res.Header["Session"])
+		require.NoError(t, err)
+
+		res, err = writeReqReadRes(conn, base.Request{
+			Method: base.Play,
+			URL:    mustParseURL("rtsp://localhost:8554/teststream"),
+			Header: base.Header{
+				"CSeq":    base.HeaderValue{"2"},
+				"Session": base.HeaderValue{sx.Session},
+			},
+		})
+		require.NoError(t, err)
+		require.Equal(t, base.StatusOK, res.StatusCode)
+	}
+
+	stream.WritePacketRTP(0, &testRTPPacket)
+
+	buf := make([]byte, 2048)
+	receiver.SetReadDeadline(time.Now().Add(2 * time.Second)) //nolint:errcheck
+	n, _, err := receiver.ReadFrom(buf)
+	require.NoError(t, err)
+	require.Equal(t, testRTPPacketMarshaled, buf[:n])
+
+	// The packet is only sent once.
+	receiver.SetReadDeadline(time.Now().Add(200 * time.Millisecond)) //nolint:errcheck
+	_, _, err = receiver.ReadFrom(buf)
+	require.Error(t, err)
+}
+
+func TestServerReadMulticastDisabled(t *testing.T) {
+	track := &TrackH264{
+		PayloadType: 96,
+		SPS:         []byte{0x01, 0x02, 0x03, 0x04},
+		PPS:         []byte{0x01, 0x02, 0x03, 0x04},
+	}
+
+	stream := NewServerStream(Tracks{track})
+	defer stream.Close()
+
+	s := &Server{
+		handler: &testServerHandler{
+			onSetup: func(*ServerSession, string, int) (*base.Response, *ServerStream, error) {
+				return &base.Response{StatusCode: base.StatusOK}, stream, nil
+			},
+		},
+		rtspAddress: "localhost:8554",
+	}
+
+	err := s.Start()
+	require.NoError(t, err)
+	defer s.Close()
+
+	nconn, err := net.Dial("tcp", "localhost:8554")
+	require.NoError(t, err)
+	defer nconn.Close()
+	conn := conn.NewConn(nconn)
+
+	res, err := writeReqReadRes(conn, base.Request{
+		Method: base.Setup,
+		URL:    mustParseURL("rtsp://localhost:8554/teststream/trackID=0"),
+		Header: base.Header{
+			"CSeq": base.HeaderValue{"1"},
+			"Transport": headers.Transport{
+				Protocol:  headers.TransportProtocolUDP,
+				Multicast: true,
+			}.Marshal(),
+		},
+	})
+	require.NoError(t, err)
+	require.Equal(t, base.StatusUnsupportedTransport, res.StatusCode)
+}
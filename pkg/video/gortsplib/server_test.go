@@ -57,6 +57,8 @@ func (sh *testServerHandler) OnSessionClose(session *ServerSession, err error) {
 }
 
 func (sh *testServerHandler) OnDescribe(
+	sc *ServerConn,
+	req *base.Request,
 	pathName string,
 ) (*base.Response, *ServerStream, error) {
 	return nil, nil, fmt.Errorf("unimplemented")
@@ -64,6 +66,7 @@ func (sh *testServerHandler) OnDescribe(
 
 func (sh *testServerHandler) OnAnnounce(
 	session *ServerSession,
+	req *base.Request,
 	path string,
 	tracks Tracks,
 ) (*base.Response, error) {
@@ -75,6 +78,7 @@ func (sh *testServerHandler) OnAnnounce(
 
 func (sh *testServerHandler) OnSetup(
 	session *ServerSession,
+	req *base.Request,
 	path string,
 	trackID int,
 ) (*base.Response, *ServerStream, error) {
@@ -0,0 +1,184 @@
+package gortsplib
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"nvr/pkg/video/gortsplib/pkg/base"
+	"nvr/pkg/video/gortsplib/pkg/conn"
+	"nvr/pkg/video/gortsplib/pkg/headers"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newTestTLSConfig(t *testing.T) *tls.Config {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{"localhost"},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+		MinVersion:   tls.VersionTLS12,
+	}
+}
+
+func TestServerReadTLS(t *testing.T) {
+	track := &TrackH264{
+		PayloadType: 96,
+		SPS:         []byte{0x01, 0x02, 0x03, 0x04},
+		PPS:         []byte{0x01, 0x02, 0x03, 0x04},
+	}
+
+	stream := NewServerStream(Tracks{track})
+	defer stream.Close()
+
+	s := &Server{
+		rtspAddress: "localhost:8555",
+		tlsConfig:   newTestTLSConfig(t),
+		handler: &testServerHandler{
+			onSetup: func(*ServerSession, string, int) (*base.Response, *ServerStream, error) {
+				return &base.Response{
+					StatusCode: base.StatusOK,
+				}, stream, nil
+			},
+			onPlay: func(*ServerSession) (*base.Response, error) {
+				go func() {
+					time.Sleep(50 * time.Millisecond)
+					stream.WritePacketRTP(0, &testRTPPacket)
+				}()
+				return &base.Response{
+					StatusCode: base.StatusOK,
+				}, nil
+			},
+		},
+	}
+
+	err := s.Start()
+	require.NoError(t, err)
+	defer s.Close()
+
+	t.Run("plain", func(t *testing.T) {
+		nconn, err := net.Dial("tcp", "localhost:8555")
+		require.NoError(t, err)
+		defer nconn.Close()
+		conn := conn.NewConn(nconn)
+
+		_, err = writeReqReadRes(conn, base.Request{
+			Method: base.Options,
+			URL:    mustParseURL("rtsp://localhost:8555/"),
+			Header: base.Header{
+				"CSeq": base.HeaderValue{"1"},
+			},
+		})
+		require.Error(t, err)
+	})
+
+	nconn, err := tls.Dial("tcp", "localhost:8555", &tls.Config{
+		InsecureSkipVerify: true, //nolint:gosec
+	})
+	require.NoError(t, err)
+	defer nconn.Close()
+	conn := conn.NewConn(nconn)
+
+	res, err := writeReqReadRes(conn, base.Request{
+		Method: base.Setup,
+		URL:    mustParseURL("rtsps://localhost:8555/teststream/trackID=0"),
+		Header: base.Header{
+			"CSeq": base.HeaderValue{"1"},
+			"Transport": headers.Transport{
+				Mode: func() *headers.TransportMode {
+					v := headers.TransportModePlay
+					return &v
+				}(),
+				InterleavedIDs: &[2]int{0, 1},
+			}.Marshal(),
+		},
+	})
+	require.NoError(t, err)
+	require.Equal(t, base.StatusOK, res.StatusCode)
+
+	var sx headers.Session
+	err = sx.Unmarshal(res.Header["Session"])
+	require.NoError(t, err)
+
+	res, err = writeReqReadRes(conn, base.Request{
+		Method: base.Play,
+		URL:    mustParseURL("rtsps://localhost:8555/teststream"),
+		Header: base.Header{
+			"CSeq":    base.HeaderValue{"2"},
+			"Session": base.HeaderValue{sx.Session},
+		},
+	})
+	require.NoError(t, err)
+	require.Equal(t, base.StatusOK, res.StatusCode)
+
+	frame, err := conn.ReadInterleavedFrame()
+	require.NoError(t, err)
+	require.Equal(t, 0, frame.Channel)
+	require.Equal(t, testRTPPacketMarshaled, frame.Payload)
+}
+
+func TestServerReadMulticastTLS(t *testing.T) {
+	track := &TrackH264{
+		PayloadType: 96,
+		SPS:         []byte{0x01, 0x02, 0x03, 0x04},
+		PPS:         []byte{0x01, 0x02, 0x03, 0x04},
+	}
+
+	stream := NewServerStream(Tracks{track})
+	stream.EnableMulticast(net.ParseIP("239.255.0.1"), 35200)
+	defer stream.Close()
+
+	s := &Server{
+		rtspAddress: "localhost:8555",
+		tlsConfig:   newTestTLSConfig(t),
+		handler: &testServerHandler{
+			onSetup: func(*ServerSession, string, int) (*base.Response, *ServerStream, error) {
+				return &base.Response{StatusCode: base.StatusOK}, stream, nil
+			},
+		},
+	}
+
+	err := s.Start()
+	require.NoError(t, err)
+	defer s.Close()
+
+	nconn, err := tls.Dial("tcp", "localhost:8555", &tls.Config{
+		InsecureSkipVerify: true, //nolint:gosec
+	})
+	require.NoError(t, err)
+	defer nconn.Close()
+	conn := conn.NewConn(nconn)
+
+	res, err := writeReqReadRes(conn, base.Request{
+		Method: base.Setup,
+		URL:    mustParseURL("rtsps://localhost:8555/teststream/trackID=0"),
+		Header: base.Header{
+			"CSeq": base.HeaderValue{"1"},
+			"Transport": headers.Transport{
+				Protocol:  headers.TransportProtocolUDP,
+				Multicast: true,
+			}.Marshal(),
+		},
+	})
+	require.NoError(t, err)
+	require.Equal(t, base.StatusUnsupportedTransport, res.StatusCode)
+}
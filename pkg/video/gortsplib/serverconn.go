@@ -295,7 +295,7 @@ func (sc *ServerConn) handleRequest(req *base.Request) (*base.Response, error) {
 		}
 
 		h := sc.s.handler
-		res, stream, err := h.OnDescribe(path)
+		res, stream, err := h.OnDescribe(sc, req, path)
 
 		if res.StatusCode == base.StatusOK {
 			if res.Header == nil {
@@ -0,0 +1,81 @@
+package gortsplib
+
+import (
+	"errors"
+	"net"
+	"nvr/pkg/video/gortsplib/pkg/ringbuffer"
+	"time"
+)
+
+// Errors.
+var (
+	ErrServerMulticastDisabled = errors.New("multicast is not enabled for the stream")
+	ErrServerMulticastWithTLS  = errors.New("multicast transport can't be used with TLS")
+)
+
+// serverMulticastWriter writes the packets of a track to a multicast group.
+// Packets are written once regardless of the number of readers.
+type serverMulticastWriter struct {
+	pc           net.PacketConn
+	rtpAddr      *net.UDPAddr
+	writeTimeout time.Duration
+	writeBuffer  *ringbuffer.RingBuffer
+	done         chan struct{}
+}
+
+// newServerMulticastWriter binds the writer to host, the address of
+// the RTSP listener, to select the interface the group is reached on.
+func newServerMulticastWriter(
+	host string,
+	group net.IP,
+	rtpPort int,
+	writeTimeout time.Duration,
+	writeBufferCount int,
+) (*serverMulticastWriter, error) {
+	pc, err := net.ListenPacket("udp4", net.JoinHostPort(host, "0"))
+	if err != nil {
+		return nil, err
+	}
+
+	writeBuffer, err := ringbuffer.New(uint64(writeBufferCount))
+	if err != nil {
+		pc.Close()
+		return nil, err
+	}
+
+	w := &serverMulticastWriter{
+		pc:           pc,
+		rtpAddr:      &net.UDPAddr{IP: group, Port: rtpPort},
+		writeTimeout: writeTimeout,
+		writeBuffer:  writeBuffer,
+		done:         make(chan struct{}),
+	}
+
+	go w.run()
+
+	return w, nil
+}
+
+func (w *serverMulticastWriter) close() {
+	w.writeBuffer.Close()
+	<-w.done
+	w.pc.Close()
+}
+
+func (w *serverMulticastWriter) run() {
+	defer close(w.done)
+
+	for {
+		tmp, ok := w.writeBuffer.Pull()
+		if !ok {
+			return
+		}
+
+		w.pc.SetWriteDeadline(time.Now().Add(w.writeTimeout)) //nolint:errcheck
+		w.pc.WriteTo(tmp.([]byte), w.rtpAddr)                 //nolint:errcheck,forcetypeassert
+	}
+}
+
+func (w *serverMulticastWriter) writePacketRTP(payload []byte) {
+	w.writeBuffer.Push(payload)
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net"
 	"nvr/pkg/video/gortsplib/pkg/base"
 	"nvr/pkg/video/gortsplib/pkg/headers"
 	"nvr/pkg/video/gortsplib/pkg/liberrors"
@@ -12,6 +13,7 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/pion/rtp"
@@ -140,6 +142,11 @@ func (s ServerSessionState) String() string {
 type ServerSessionSetuppedTrack struct {
 	id         int
 	tcpChannel int
+
+	// UDP only.
+	udpPorts    *udpPortPair
+	udpRTPAddr  *net.UDPAddr
+	udpRTCPAddr *net.UDPAddr
 }
 
 // ServerSessionAnnouncedTrack is an announced track of a ServerSession.
@@ -159,6 +166,8 @@ type ServerSession struct {
 	state              ServerSessionState
 	setuppedTracks     map[int]*ServerSessionSetuppedTrack
 	tcpTracksByChannel map[int]*ServerSessionSetuppedTrack
+	setuppedTransport  *headers.TransportProtocol
+	setuppedMulticast  bool
 	IsTransportSetup   bool
 	setuppedBaseURL    *url.URL      // publish
 	setuppedStream     *ServerStream // read
@@ -168,6 +177,10 @@ type ServerSession struct {
 	announcedTracks    []*ServerSessionAnnouncedTrack // publish
 	writerRunning      bool
 	writeBuffer        *ringbuffer.RingBuffer
+	// Unix seconds, written by the UDP readers. Multicast readers
+	// never send packets to the server, it's refreshed by their
+	// RTSP requests instead.
+	udpLastPacketTime *int64
 
 	// writer channels
 	writerDone chan struct{}
@@ -194,9 +207,13 @@ func newServerSession(
 		ctxCancel:       ctxCancel,
 		conns:           make(map[*ServerConn]struct{}),
 		lastRequestTime: time.Now(),
-		request:         make(chan sessionRequestReq),
-		connRemove:      make(chan *ServerConn),
-		startWriter:     make(chan struct{}),
+		udpLastPacketTime: func() *int64 {
+			v := time.Now().Unix()
+			return &v
+		}(),
+		request:     make(chan sessionRequestReq),
+		connRemove:  make(chan *ServerConn),
+		startWriter: make(chan struct{}),
 	}
 
 	s.wg.Add(1)
@@ -226,6 +243,11 @@ func (ss *ServerSession) AnnouncedTracks() []*ServerSessionAnnouncedTrack {
 	return ss.announcedTracks
 }
 
+func (ss *ServerSession) isUDP() bool {
+	return ss.setuppedTransport != nil &&
+		*ss.setuppedTransport == headers.TransportProtocolUDP
+}
+
 func (ss *ServerSession) checkState(allowed map[ServerSessionState]struct{}) error {
 	if _, ok := allowed[ss.state]; ok {
 		return nil
@@ -261,6 +283,12 @@ func (ss *ServerSession) run(name string) {
 		<-ss.writerDone
 	}
 
+	for _, sst := range ss.setuppedTracks {
+		if sst.udpPorts != nil {
+			ss.s.udpPorts.release(sst.udpPorts)
+		}
+	}
+
 	// close all associated connections except for the ones that called TEARDOWN
 	// (that are detached from the session just after the request)
 	for sc := range ss.conns {
@@ -284,11 +312,17 @@ func (ss *ServerSession) run(name string) {
 	ss.s.handler.OnSessionClose(ss, err)
 }
 
-func (ss *ServerSession) runInner() error { //nolint:gocognit
+func (ss *ServerSession) runInner() error { //nolint:gocognit,funlen
+	checkTimeoutTicker := time.NewTicker(ss.s.checkStreamPeriod)
+	defer checkTimeoutTicker.Stop()
+
 	for {
 		select {
 		case req := <-ss.request:
 			ss.lastRequestTime = time.Now()
+			if ss.setuppedMulticast {
+				atomic.StoreInt64(ss.udpLastPacketTime, ss.lastRequestTime.Unix())
+			}
 
 			if _, ok := ss.conns[req.sc]; !ok {
 				ss.conns[req.sc] = struct{}{}
@@ -306,9 +340,7 @@ func (ss *ServerSession) runInner() error { //nolint:gocognit
 						res.Header = make(base.Header)
 					}
 
-					res.Header["Session"] = headers.Session{
-						Session: ss.secretID,
-					}.Marshal()
+					res.Header["Session"] = ss.sessionHeader()
 				}
 
 				// After a TEARDOWN, session must be unpaired with the connection.
@@ -333,10 +365,22 @@ func (ss *ServerSession) runInner() error { //nolint:gocognit
 		case sc := <-ss.connRemove:
 			delete(ss.conns, sc)
 
-			if len(ss.conns) == 0 {
+			// UDP readers can close the connection after PLAY,
+			// the session is kept alive by RTCP packets instead.
+			if len(ss.conns) == 0 && !(ss.isUDP() && ss.state == ServerSessionStatePlay) {
 				return context.Canceled
 			}
 
+		case <-checkTimeoutTicker.C:
+			if ss.isUDP() && ss.state == ServerSessionStatePlay {
+				now := time.Now()
+				lastPacket := time.Unix(atomic.LoadInt64(ss.udpLastPacketTime), 0)
+				if now.Sub(lastPacket) >= ss.s.sessionTimeout &&
+					now.Sub(ss.lastRequestTime) >= ss.s.sessionTimeout {
+					return liberrors.ErrServerNoUDPPacketsInAWhile
+				}
+			}
+
 		case <-ss.startWriter:
 			if !ss.writerRunning && (ss.state == ServerSessionStateRecord ||
 				ss.state == ServerSessionStatePlay) &&
@@ -352,6 +396,18 @@ func (ss *ServerSession) runInner() error { //nolint:gocognit
 	}
 }
 
+// sessionHeader returns the Session header. UDP readers don't have
+// a connection to keep the session alive, the timeout tells
+// them how often they must send keepalives.
+func (ss *ServerSession) sessionHeader() base.HeaderValue {
+	h := headers.Session{Session: ss.secretID}
+	if ss.isUDP() {
+		v := uint(ss.s.sessionTimeout / time.Second)
+		h.Timeout = &v
+	}
+	return h.Marshal()
+}
+
 func (ss *ServerSession) handleRequest( //nolint:funlen
 	sc *ServerConn,
 	req *base.Request,
@@ -397,7 +453,8 @@ func (ss *ServerSession) handleRequest( //nolint:funlen
 
 	case base.Teardown:
 		var err error
-		if ss.state == ServerSessionStatePlay || ss.state == ServerSessionStateRecord {
+		if ss.tcpConn != nil &&
+			(ss.state == ServerSessionStatePlay || ss.state == ServerSessionStateRecord) {
 			ss.tcpConn.readFunc = ss.tcpConn.readFuncStandard
 			err = errSwitchReadFunc
 		}
@@ -496,7 +553,7 @@ func (ss *ServerSession) handleAnnounce( //nolint:funlen
 		}
 	}
 
-	res, err := ss.s.handler.OnAnnounce(ss, path, tracks)
+	res, err := ss.s.handler.OnAnnounce(ss, req, path, tracks)
 
 	if res.StatusCode != base.StatusOK {
 		return res, err
@@ -555,23 +612,15 @@ func (ss *ServerSession) handleSetup(req *base.Request) (*base.Response, error)
 		}, liberrors.ServerTrackAlreadySetupError{TrackID: trackID}
 	}
 
-	if inTH.InterleavedIDs == nil {
+	if ss.setuppedTransport != nil &&
+		(*ss.setuppedTransport != inTH.Protocol || ss.setuppedMulticast != inTH.Multicast) {
 		return &base.Response{
 			StatusCode: base.StatusBadRequest,
-		}, liberrors.ErrServerTransportHeaderNoInterleavedIDs
-	}
-
-	if (inTH.InterleavedIDs[0]%2) != 0 ||
-		(inTH.InterleavedIDs[0]+1) != inTH.InterleavedIDs[1] {
-		return &base.Response{
-			StatusCode: base.StatusBadRequest,
-		}, liberrors.ErrServerTransportHeaderInvalidInterleavedIDs
+		}, liberrors.ErrServerTracksDifferentProtocols
 	}
 
-	if _, ok := ss.tcpTracksByChannel[inTH.InterleavedIDs[0]]; ok {
-		return &base.Response{
-			StatusCode: base.StatusBadRequest,
-		}, liberrors.ErrServerTransportHeaderInterleavedIDsAlreadyUsed
+	if res, err := ss.checkTransport(&inTH); err != nil || res != nil {
+		return res, err
 	}
 
 	switch ss.state {
@@ -590,7 +639,7 @@ func (ss *ServerSession) handleSetup(req *base.Request) (*base.Response, error)
 		}
 	}
 
-	res, stream, err := ss.s.handler.OnSetup(ss, path, trackID)
+	res, stream, err := ss.s.handler.OnSetup(ss, req, path, trackID)
 
 	// workaround to prevent a bug in rtspclientsink
 	// that makes impossible for the client to receive the response
@@ -608,8 +657,47 @@ func (ss *ServerSession) handleSetup(req *base.Request) (*base.Response, error)
 		return res, err
 	}
 
+	sst := &ServerSessionSetuppedTrack{id: trackID}
+
+	if inTH.Multicast {
+		if err := stream.multicastReaderAdd(ss); err != nil {
+			if errors.Is(err, ErrServerMulticastDisabled) {
+				return &base.Response{
+					StatusCode: base.StatusUnsupportedTransport,
+				}, nil
+			}
+			return &base.Response{
+				StatusCode: base.StatusInternalServerError,
+			}, err
+		}
+	}
+
+	// Ports are allocated after OnSetup() to not allocate
+	// them for requests that are rejected by the handler.
+	if inTH.Protocol == headers.TransportProtocolUDP && !inTH.Multicast {
+		sst.udpPorts, err = ss.s.udpPorts.allocate()
+		if err != nil {
+			return &base.Response{
+				StatusCode: base.StatusServiceUnavailable,
+			}, err
+		}
+		sst.udpRTPAddr = &net.UDPAddr{
+			IP:   ss.author.ip(),
+			Zone: ss.author.zone(),
+			Port: inTH.ClientPorts[0],
+		}
+		sst.udpRTCPAddr = &net.UDPAddr{
+			IP:   ss.author.ip(),
+			Zone: ss.author.zone(),
+			Port: inTH.ClientPorts[1],
+		}
+	}
+
 	if ss.state == ServerSessionStateInitial {
 		if err := stream.readerAdd(ss); err != nil {
+			if sst.udpPorts != nil {
+				ss.s.udpPorts.release(sst.udpPorts)
+			}
 			return &base.Response{
 				StatusCode: base.StatusBadRequest,
 			}, err
@@ -635,15 +723,32 @@ func (ss *ServerSession) handleSetup(req *base.Request) (*base.Response, error)
 		res.Header = make(base.Header)
 	}
 
-	sst := &ServerSessionSetuppedTrack{id: trackID}
-
-	if ss.tcpTracksByChannel == nil {
-		ss.tcpTracksByChannel = make(map[int]*ServerSessionSetuppedTrack)
-	}
+	ss.setuppedTransport = &inTH.Protocol
+	ss.setuppedMulticast = inTH.Multicast
+
+	switch {
+	case inTH.Multicast:
+		group, ports := stream.multicastAddr(trackID)
+		th.Protocol = headers.TransportProtocolUDP
+		th.Multicast = true
+		th.Destination = &group
+		th.Ports = &ports
+
+	case inTH.Protocol == headers.TransportProtocolUDP:
+		th.Protocol = headers.TransportProtocolUDP
+		th.ClientPorts = inTH.ClientPorts
+		th.ServerPorts = &[2]int{sst.udpPorts.port, sst.udpPorts.port + 1}
+
+	default:
+		if ss.tcpTracksByChannel == nil {
+			ss.tcpTracksByChannel = make(map[int]*ServerSessionSetuppedTrack)
+		}
 
-	ss.tcpTracksByChannel[inTH.InterleavedIDs[0]] = sst
+		sst.tcpChannel = inTH.InterleavedIDs[0]
+		ss.tcpTracksByChannel[inTH.InterleavedIDs[0]] = sst
 
-	th.InterleavedIDs = inTH.InterleavedIDs
+		th.InterleavedIDs = inTH.InterleavedIDs
+	}
 
 	if ss.setuppedTracks == nil {
 		ss.setuppedTracks = make(map[int]*ServerSessionSetuppedTrack)
@@ -655,6 +760,69 @@ func (ss *ServerSession) handleSetup(req *base.Request) (*base.Response, error)
 	return res, err
 }
 
+// checkTransport validates the transport of a SETUP request.
+// It returns a response if the transport isn't supported.
+func (ss *ServerSession) checkTransport(inTH *headers.Transport) (*base.Response, error) {
+	if inTH.Protocol == headers.TransportProtocolUDP {
+		// UDP is only supported for reading.
+		if ss.state == ServerSessionStatePreRecord {
+			return &base.Response{
+				StatusCode: base.StatusUnsupportedTransport,
+			}, nil
+		}
+
+		// Multicast packets are sent in the clear, like UDP ports.
+		// Otherwise it's checked by the stream after OnSetup().
+		if inTH.Multicast {
+			if ss.s.tlsConfig != nil {
+				return &base.Response{
+					StatusCode: base.StatusUnsupportedTransport,
+				}, ErrServerMulticastWithTLS
+			}
+			return nil, nil
+		}
+
+		if ss.s.udpPorts == nil {
+			return &base.Response{
+				StatusCode: base.StatusUnsupportedTransport,
+			}, nil
+		}
+
+		if inTH.ClientPorts == nil {
+			return &base.Response{
+				StatusCode: base.StatusBadRequest,
+			}, liberrors.ErrServerTransportHeaderNoClientPorts
+		}
+
+		if inTH.ClientPorts[0] <= 0 || inTH.ClientPorts[1] <= 0 {
+			return &base.Response{
+				StatusCode: base.StatusBadRequest,
+			}, liberrors.ErrServerTransportHeaderInvalidClientPorts
+		}
+		return nil, nil
+	}
+
+	if inTH.InterleavedIDs == nil {
+		return &base.Response{
+			StatusCode: base.StatusBadRequest,
+		}, liberrors.ErrServerTransportHeaderNoInterleavedIDs
+	}
+
+	if (inTH.InterleavedIDs[0]%2) != 0 ||
+		(inTH.InterleavedIDs[0]+1) != inTH.InterleavedIDs[1] {
+		return &base.Response{
+			StatusCode: base.StatusBadRequest,
+		}, liberrors.ErrServerTransportHeaderInvalidInterleavedIDs
+	}
+
+	if _, ok := ss.tcpTracksByChannel[inTH.InterleavedIDs[0]]; ok {
+		return &base.Response{
+			StatusCode: base.StatusBadRequest,
+		}, liberrors.ErrServerTransportHeaderInterleavedIDsAlreadyUsed
+	}
+	return nil, nil
+}
+
 func (ss *ServerSession) handlePlay( //nolint:funlen
 	sc *ServerConn,
 	req *base.Request,
@@ -700,14 +868,37 @@ func (ss *ServerSession) handlePlay( //nolint:funlen
 
 	ss.state = ServerSessionStatePlay
 
-	ss.tcpConn = sc
-	ss.tcpConn.readFunc = ss.tcpConn.readFuncTCP
-	err = errSwitchReadFunc
-
 	ss.writeBuffer, _ = ringbuffer.New(uint64(ss.s.readBufferCount))
-	// runWriter() is called by ServerConn after the response has been sent
 
-	ss.setuppedStream.readerSetActive(ss)
+	switch {
+	case ss.setuppedMulticast:
+		// Packets are written to the group by the stream.
+		atomic.StoreInt64(ss.udpLastPacketTime, time.Now().Unix())
+
+	case ss.isUDP():
+		// The connection keeps reading requests,
+		// there is no response to wait for before writing.
+		atomic.StoreInt64(ss.udpLastPacketTime, time.Now().Unix())
+		for _, sst := range ss.setuppedTracks {
+			ss.s.wg.Add(2)
+			go ss.runUDPReader(sst.udpPorts.rtp)
+			go ss.runUDPReader(sst.udpPorts.rtcp)
+		}
+
+		ss.writerRunning = true
+		ss.writerDone = make(chan struct{})
+		go ss.runWriter()
+
+	default:
+		ss.tcpConn = sc
+		ss.tcpConn.readFunc = ss.tcpConn.readFuncTCP
+		err = errSwitchReadFunc
+		// runWriter() is called by ServerConn after the response has been sent
+	}
+
+	if !ss.setuppedMulticast {
+		ss.setuppedStream.readerSetActive(ss)
+	}
 
 	var trackIDs []int
 	for trackID := range ss.setuppedTracks {
@@ -800,20 +991,30 @@ func (ss *ServerSession) handleRecord(
 func (ss *ServerSession) runWriter() {
 	defer close(ss.writerDone)
 
-	rtpFrames := make(map[int]*base.InterleavedFrame, len(ss.setuppedTracks))
+	var writeFunc func(trackID int, payload []byte)
 
-	for trackID, sst := range ss.setuppedTracks {
-		rtpFrames[trackID] = &base.InterleavedFrame{Channel: sst.tcpChannel}
-	}
+	if ss.isUDP() {
+		writeFunc = func(trackID int, payload []byte) {
+			sst := ss.setuppedTracks[trackID]
+			sst.udpPorts.rtp.SetWriteDeadline(time.Now().Add(ss.s.writeTimeout)) //nolint:errcheck
+			sst.udpPorts.rtp.WriteTo(payload, sst.udpRTPAddr)                    //nolint:errcheck
+		}
+	} else {
+		rtpFrames := make(map[int]*base.InterleavedFrame, len(ss.setuppedTracks))
+
+		for trackID, sst := range ss.setuppedTracks {
+			rtpFrames[trackID] = &base.InterleavedFrame{Channel: sst.tcpChannel}
+		}
 
-	buf := make([]byte, maxPacketSize+4)
+		buf := make([]byte, maxPacketSize+4)
 
-	writeFunc := func(trackID int, payload []byte) {
-		fr := rtpFrames[trackID]
-		fr.Payload = payload
+		writeFunc = func(trackID int, payload []byte) {
+			fr := rtpFrames[trackID]
+			fr.Payload = payload
 
-		ss.tcpConn.nconn.SetWriteDeadline(time.Now().Add(ss.s.writeTimeout)) //nolint:errcheck
-		ss.tcpConn.conn.WriteInterleavedFrame(fr, buf)                       //nolint:errcheck
+			ss.tcpConn.nconn.SetWriteDeadline(time.Now().Add(ss.s.writeTimeout)) //nolint:errcheck
+			ss.tcpConn.conn.WriteInterleavedFrame(fr, buf)                       //nolint:errcheck
+		}
 	}
 
 	for {
@@ -827,6 +1028,28 @@ func (ss *ServerSession) runWriter() {
 	}
 }
 
+// runUDPReader reads the packets that the client sends to a server port,
+// usually RTCP receiver reports, and uses them as keepalives.
+// It returns when the port is released.
+func (ss *ServerSession) runUDPReader(pc net.PacketConn) {
+	defer ss.s.wg.Done()
+
+	buf := make([]byte, maxPacketSize+1)
+	for {
+		_, addr, err := pc.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+
+		uaddr, ok := addr.(*net.UDPAddr)
+		if !ok || !uaddr.IP.Equal(ss.author.ip()) {
+			continue
+		}
+
+		atomic.StoreInt64(ss.udpLastPacketTime, time.Now().Unix())
+	}
+}
+
 func (ss *ServerSession) writePacketRTP(trackID int, byts []byte) {
 	if _, ok := ss.setuppedTracks[trackID]; !ok {
 		return
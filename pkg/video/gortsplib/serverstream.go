@@ -2,6 +2,7 @@ package gortsplib
 
 import (
 	"errors"
+	"net"
 	"sync"
 	"time"
 
@@ -34,6 +35,13 @@ type ServerStream struct {
 	readers        map[*ServerSession]struct{}
 	streamTracks   []*serverStreamTrack
 	closed         bool
+
+	// Multicast is disabled if multicastGroup is nil. The writers
+	// are only running while there are multicast readers.
+	multicastGroup   net.IP
+	multicastPort    int
+	readersMulticast map[*ServerSession]struct{}
+	multicastWriters []*serverMulticastWriter
 }
 
 // NewServerStream allocates a ServerStream.
@@ -42,9 +50,10 @@ func NewServerStream(tracks Tracks) *ServerStream {
 	tracks.setControls()
 
 	st := &ServerStream{
-		tracks:         tracks,
-		readersUnicast: make(map[*ServerSession]struct{}),
-		readers:        make(map[*ServerSession]struct{}),
+		tracks:           tracks,
+		readersUnicast:   make(map[*ServerSession]struct{}),
+		readers:          make(map[*ServerSession]struct{}),
+		readersMulticast: make(map[*ServerSession]struct{}),
 	}
 
 	st.streamTracks = make([]*serverStreamTrack, len(tracks))
@@ -55,10 +64,19 @@ func NewServerStream(tracks Tracks) *ServerStream {
 	return st
 }
 
+// EnableMulticast allows readers to read the stream from a multicast group.
+// The RTP and RTCP ports of track N are port+2N and port+2N+1.
+// Must be called before the stream is read.
+func (st *ServerStream) EnableMulticast(group net.IP, port int) {
+	st.multicastGroup = group
+	st.multicastPort = port
+}
+
 // Close closes a ServerStream.
 func (st *ServerStream) Close() error {
 	st.mutex.Lock()
 	st.closed = true
+	st.closeMulticastWriters()
 	st.mutex.Unlock()
 
 	for ss := range st.readers {
@@ -136,6 +154,66 @@ func (st *ServerStream) readerRemove(ss *ServerSession) {
 	}
 
 	delete(st.readers, ss)
+
+	if _, ok := st.readersMulticast[ss]; ok {
+		delete(st.readersMulticast, ss)
+		if len(st.readersMulticast) == 0 {
+			st.closeMulticastWriters()
+		}
+	}
+}
+
+// multicastAddr returns the group and the RTP and RTCP ports of a track.
+func (st *ServerStream) multicastAddr(trackID int) (net.IP, [2]int) {
+	port := st.multicastPort + trackID*2
+	return st.multicastGroup, [2]int{port, port + 1}
+}
+
+// multicastReaderAdd starts the multicast writers if
+// ss is the first multicast reader of the stream.
+func (st *ServerStream) multicastReaderAdd(ss *ServerSession) error {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+
+	if st.closed {
+		return ErrClosedStream
+	}
+
+	if st.multicastGroup == nil {
+		return ErrServerMulticastDisabled
+	}
+
+	if st.multicastWriters == nil {
+		host, _, err := net.SplitHostPort(ss.s.rtspAddress)
+		if err != nil {
+			return err
+		}
+
+		writers := make([]*serverMulticastWriter, len(st.tracks))
+		for trackID := range st.tracks {
+			group, ports := st.multicastAddr(trackID)
+			w, err := newServerMulticastWriter(
+				host, group, ports[0], ss.s.writeTimeout, ss.s.writeBufferCount)
+			if err != nil {
+				for _, w := range writers[:trackID] {
+					w.close()
+				}
+				return err
+			}
+			writers[trackID] = w
+		}
+		st.multicastWriters = writers
+	}
+
+	st.readersMulticast[ss] = struct{}{}
+	return nil
+}
+
+func (st *ServerStream) closeMulticastWriters() {
+	for _, w := range st.multicastWriters {
+		w.close()
+	}
+	st.multicastWriters = nil
 }
 
 func (st *ServerStream) readerSetActive(ss *ServerSession) {
@@ -199,4 +277,9 @@ func (st *ServerStream) WritePacketRTPWithNTP(trackID int, pkt *rtp.Packet, ntp
 	for r := range st.readersUnicast {
 		r.writePacketRTP(trackID, byts)
 	}
+
+	// send multicast
+	if st.multicastWriters != nil {
+		st.multicastWriters[trackID].writePacketRTP(byts)
+	}
 }
@@ -0,0 +1,109 @@
+package gortsplib
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+)
+
+// Errors.
+var (
+	ErrServerNoUDPPorts      = errors.New("no free UDP port pair")
+	ErrServerInvalidUDPPorts = errors.New("invalid UDP port range")
+	ErrServerUDPPortsWithTLS = errors.New("UDP transport can't be used with TLS")
+)
+
+// udpPortPair is a RTP and RTCP listener pair of a setupped track.
+// The RTP port is always even and the RTCP port is the next odd port.
+type udpPortPair struct {
+	port int
+	rtp  net.PacketConn
+	rtcp net.PacketConn
+}
+
+func (p *udpPortPair) close() {
+	p.rtp.Close()
+	p.rtcp.Close()
+}
+
+// udpPortAllocator allocates a port pair to each track
+// of the sessions that are read with the UDP transport.
+type udpPortAllocator struct {
+	host string
+	min  int
+	max  int
+
+	mu   sync.Mutex
+	next int
+	used map[int]struct{}
+}
+
+func newUDPPortAllocator(host string, ports [2]int) (*udpPortAllocator, error) {
+	min, max := ports[0], ports[1]
+
+	// RTP ports must be even.
+	if min%2 != 0 {
+		min++
+	}
+	if min <= 0 || max > 65535 || max < min+1 {
+		return nil, fmt.Errorf("%w: %d-%d", ErrServerInvalidUDPPorts, ports[0], ports[1])
+	}
+
+	return &udpPortAllocator{
+		host: host,
+		min:  min,
+		max:  max,
+		next: min,
+		used: make(map[int]struct{}),
+	}, nil
+}
+
+// allocate returns the next free port pair. Ports are allocated
+// round-robin to avoid reusing a port that a client just stopped reading.
+// Ports that are used by other processes are skipped.
+func (a *udpPortAllocator) allocate() (*udpPortPair, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	count := (a.max - a.min + 1) / 2
+	for i := 0; i < count; i++ {
+		port := a.next
+		a.next += 2
+		if a.next+1 > a.max {
+			a.next = a.min
+		}
+
+		if _, ok := a.used[port]; ok {
+			continue
+		}
+
+		rtp, err := net.ListenPacket("udp", net.JoinHostPort(a.host, strconv.Itoa(port)))
+		if err != nil {
+			continue
+		}
+		rtcp, err := net.ListenPacket("udp", net.JoinHostPort(a.host, strconv.Itoa(port+1)))
+		if err != nil {
+			rtp.Close()
+			continue
+		}
+
+		a.used[port] = struct{}{}
+		return &udpPortPair{
+			port: port,
+			rtp:  rtp,
+			rtcp: rtcp,
+		}, nil
+	}
+	return nil, ErrServerNoUDPPorts
+}
+
+// release closes the listeners and frees the port pair.
+func (a *udpPortAllocator) release(p *udpPortPair) {
+	p.close()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.used, p.port)
+}
@@ -0,0 +1,52 @@
+package gortsplib
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUDPPortAllocator(t *testing.T) {
+	t.Run("allocate", func(t *testing.T) {
+		a, err := newUDPPortAllocator("127.0.0.1", [2]int{35101, 35105})
+		require.NoError(t, err)
+
+		p1, err := a.allocate()
+		require.NoError(t, err)
+		require.Equal(t, 35102, p1.port)
+		require.Equal(t, "127.0.0.1:35103", p1.rtcp.LocalAddr().String())
+
+		p2, err := a.allocate()
+		require.NoError(t, err)
+		require.Equal(t, 35104, p2.port)
+
+		_, err = a.allocate()
+		require.ErrorIs(t, err, ErrServerNoUDPPorts)
+
+		a.release(p1)
+		p3, err := a.allocate()
+		require.NoError(t, err)
+		require.Equal(t, 35102, p3.port)
+
+		a.release(p2)
+		a.release(p3)
+	})
+	t.Run("portInUse", func(t *testing.T) {
+		pc, err := net.ListenPacket("udp", "127.0.0.1:35111")
+		require.NoError(t, err)
+		defer pc.Close()
+
+		a, err := newUDPPortAllocator("127.0.0.1", [2]int{35110, 35113})
+		require.NoError(t, err)
+
+		p, err := a.allocate()
+		require.NoError(t, err)
+		require.Equal(t, 35112, p.port)
+		a.release(p)
+	})
+	t.Run("invalidRange", func(t *testing.T) {
+		_, err := newUDPPortAllocator("", [2]int{35001, 35002})
+		require.ErrorIs(t, err, ErrServerInvalidUDPPorts)
+	})
+}
@@ -0,0 +1,393 @@
+package hls
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"nvr/pkg/log"
+	"nvr/pkg/video/gortsplib"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	dvrPlaylistName  = "dvr.m3u8"
+	dvrSegmentPrefix = "dvr_"
+
+	// Segments are dropped if the spool falls behind.
+	dvrQueueSize = 16
+)
+
+// DVRConfig disk space limits of the DVR spools.
+type DVRConfig struct {
+	// Directory of the spools.
+	Dir string `yaml:"dir"`
+
+	// Maximum size of the spool of each input in megabytes.
+	MaxSize uint64 `yaml:"maxSize"`
+
+	// Maximum combined size of all spools in megabytes.
+	TotalSize uint64 `yaml:"totalSize"`
+}
+
+// FillMissing sets default values for unset size fields.
+func (c *DVRConfig) FillMissing() {
+	if c.MaxSize == 0 {
+		c.MaxSize = 1000
+	}
+	if c.TotalSize == 0 {
+		c.TotalSize = 10000
+	}
+}
+
+// DVRSpool is the disk space that is shared by the DVR spools of all muxers.
+type DVRSpool struct {
+	dir       string
+	maxSize   uint64 // Bytes.
+	totalSize uint64 // Bytes.
+
+	mu   sync.Mutex
+	used uint64
+}
+
+// NewDVRSpool creates a spool with the limits of the config.
+func NewDVRSpool(c DVRConfig) *DVRSpool {
+	const mb = 1000000
+	return newDVRSpool(c.Dir, c.MaxSize*mb, c.TotalSize*mb)
+}
+
+func newDVRSpool(dir string, maxSize uint64, totalSize uint64) *DVRSpool {
+	return &DVRSpool{
+		dir:       dir,
+		maxSize:   maxSize,
+		totalSize: totalSize,
+	}
+}
+
+func (s *DVRSpool) add(size uint64) {
+	s.mu.Lock()
+	s.used += size
+	s.mu.Unlock()
+}
+
+func (s *DVRSpool) remove(size uint64) {
+	s.mu.Lock()
+	s.used -= size
+	s.mu.Unlock()
+}
+
+// full returns true if the spool of a single muxer that uses
+// size bytes, or all spools combined, exceed the limits.
+func (s *DVRSpool) full(size uint64) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return size > s.maxSize || s.used > s.totalSize
+}
+
+// dvr spools finalized segments to disk for the DVR playlist, a sliding
+// window that lets players pause and rewind the live stream. The spool
+// is bounded by the window duration and the limits of the DVRSpool. Each distinct
+// init segment is spooled next to the segments that use it.
+type dvr struct {
+	dir          string
+	window       time.Duration
+	spool        *DVRSpool
+	logf         log.Func
+	generateInit func(videoParams [][]byte) ([]byte, error)
+
+	queue chan *Segment
+
+	// Only accessed by run.
+	lastParams [][]byte
+	lastInit   string
+	nextInitID int
+
+	mu          sync.Mutex
+	segments    []dvrSegment
+	inits       map[string]struct{}
+	size        uint64
+	deleteCount int
+
+	// Number of discontinuities that were pruned.
+	discontinuitySeq int
+
+	// Set when a segment is dropped, the next segment
+	// is then marked as a discontinuity.
+	dropped bool
+}
+
+type dvrSegment struct {
+	name      string
+	startTime time.Time
+	duration  time.Duration
+	size      uint64
+	init      string
+
+	// The segment doesn't follow the previous one, either
+	// the video parameters changed or segments were dropped.
+	discontinuity bool
+}
+
+func newDVR(
+	dir string,
+	window time.Duration,
+	spool *DVRSpool,
+	logf log.Func,
+	audioTrack *gortsplib.TrackMPEG4Audio,
+) *dvr {
+	return &dvr{
+		dir:    dir,
+		window: window,
+		spool:  spool,
+		logf:   logf,
+		generateInit: func(videoParams [][]byte) ([]byte, error) {
+			videoTrack := &gortsplib.TrackH264{SPS: videoParams[0], PPS: videoParams[1]}
+			return generateInit(videoTrack, audioTrack)
+		},
+		queue: make(chan *Segment, dvrQueueSize),
+		inits: make(map[string]struct{}),
+	}
+}
+
+// run writes queued segments to disk until ctx
+// is canceled, the spool is then deleted.
+func (d *dvr) run(ctx context.Context) {
+	defer func() {
+		os.RemoveAll(d.dir)
+		d.mu.Lock()
+		d.spool.remove(d.size)
+		d.size = 0
+		d.mu.Unlock()
+	}()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case seg := <-d.queue:
+			if err := d.add(seg); err != nil {
+				d.logf(log.LevelError, "dvr: %v", err)
+			}
+		}
+	}
+}
+
+// onSegmentFinalized queues the segment, it never blocks.
+func (d *dvr) onSegmentFinalized(seg *Segment) {
+	select {
+	case d.queue <- seg:
+	default:
+		d.logf(log.LevelWarning, "dvr: queue full, dropping segment %v", seg.name)
+		d.drop()
+	}
+}
+
+func (d *dvr) drop() {
+	d.mu.Lock()
+	d.dropped = true
+	d.mu.Unlock()
+}
+
+func (d *dvr) add(seg *Segment) error {
+	if seg.RenderedDuration <= 0 {
+		return nil
+	}
+	prevInit := d.lastInit
+	initName, err := d.init(seg.videoParams)
+	if err != nil {
+		d.drop()
+		return fmt.Errorf("init: %w", err)
+	}
+
+	n, err := d.writeFile(seg.name, seg.reader())
+	if err != nil {
+		d.drop()
+		return fmt.Errorf("write segment: %w", err)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.segments = append(d.segments, dvrSegment{
+		name:          seg.name,
+		startTime:     seg.StartTime,
+		duration:      seg.RenderedDuration,
+		size:          uint64(n),
+		init:          initName,
+		discontinuity: d.dropped || (prevInit != "" && initName != prevInit),
+	})
+	d.dropped = false
+	d.size += uint64(n)
+	d.spool.add(uint64(n))
+	d.prune()
+	return nil
+}
+
+// init returns the name of the init segment of the video
+// parameters. It's spooled if the parameters changed.
+func (d *dvr) init(videoParams [][]byte) (string, error) {
+	if d.lastInit != "" && videoParamsEqual(d.lastParams, videoParams) {
+		return d.lastInit, nil
+	}
+	content, err := d.generateInit(videoParams)
+	if err != nil {
+		return "", err
+	}
+
+	d.nextInitID++
+	name := "init" + strconv.Itoa(d.nextInitID)
+	if _, err := d.writeFile(name, bytes.NewReader(content)); err != nil {
+		return "", err
+	}
+
+	d.mu.Lock()
+	d.inits[name] = struct{}{}
+	d.mu.Unlock()
+
+	d.lastParams = videoParams
+	d.lastInit = name
+	return name, nil
+}
+
+func (d *dvr) writeFile(name string, r io.Reader) (int64, error) {
+	file, err := os.OpenFile(d.path(name), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(file, r)
+	file.Close()
+	if err != nil {
+		os.Remove(d.path(name))
+		return 0, err
+	}
+	return n, nil
+}
+
+// prune deletes the oldest segments until the spool fits within the
+// window and the spool limits. The newest segment is always kept.
+// Init segments are deleted when they're no longer used.
+func (d *dvr) prune() {
+	var duration time.Duration
+	for _, seg := range d.segments {
+		duration += seg.duration
+	}
+	for len(d.segments) > 1 && (duration > d.window || d.spool.full(d.size)) {
+		oldest := d.segments[0]
+		os.Remove(d.path(oldest.name))
+		duration -= oldest.duration
+		d.size -= oldest.size
+		d.spool.remove(oldest.size)
+		d.segments = d.segments[1:]
+		d.deleteCount++
+		if oldest.discontinuity {
+			d.discontinuitySeq++
+		}
+	}
+
+	for name := range d.inits {
+		if name != d.lastInit && !d.initUsed(name) {
+			os.Remove(d.path(name))
+			delete(d.inits, name)
+		}
+	}
+}
+
+func (d *dvr) initUsed(name string) bool {
+	for _, seg := range d.segments {
+		if seg.init == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *dvr) path(name string) string {
+	return filepath.Join(d.dir, name+".mp4")
+}
+
+// playlist returns the DVR media playlist.
+func (d *dvr) playlist() *MuxerFileResponse {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if len(d.segments) == 0 {
+		return &MuxerFileResponse{Status: http.StatusNotFound}
+	}
+
+	var targetDuration float64
+	for _, seg := range d.segments {
+		targetDuration = math.Max(targetDuration, math.Round(seg.duration.Seconds()))
+	}
+
+	cnt := "#EXTM3U\n"
+	cnt += "#EXT-X-VERSION:9\n"
+	cnt += "#EXT-X-TARGETDURATION:" + strconv.FormatFloat(targetDuration, 'f', 0, 64) + "\n"
+	cnt += "#EXT-X-MEDIA-SEQUENCE:" + strconv.Itoa(d.deleteCount) + "\n"
+	if d.discontinuitySeq != 0 {
+		cnt += "#EXT-X-DISCONTINUITY-SEQUENCE:" + strconv.Itoa(d.discontinuitySeq) + "\n"
+	}
+	cnt += "#EXT-X-INDEPENDENT-SEGMENTS\n"
+
+	init := ""
+	for _, seg := range d.segments {
+		if seg.discontinuity {
+			cnt += "#EXT-X-DISCONTINUITY\n"
+		}
+		if seg.init != init {
+			cnt += "#EXT-X-MAP:URI=\"" + dvrSegmentPrefix + seg.init + ".mp4\"\n"
+			init = seg.init
+		}
+		cnt += "#EXT-X-PROGRAM-DATE-TIME:" + seg.startTime.Format("2006-01-02T15:04:05.999Z07:00") + "\n" +
+			"#EXTINF:" + strconv.FormatFloat(seg.duration.Seconds(), 'f', 5, 64) + ",\n" +
+			dvrSegmentPrefix + seg.name + ".mp4\n"
+	}
+
+	return &MuxerFileResponse{
+		Status: http.StatusOK,
+		Header: map[string]string{
+			"Content-Type": `audio/mpegURL`,
+		},
+		Body: bytes.NewReader([]byte(cnt)),
+	}
+}
+
+// file returns a spooled segment or init segment, name is "dvr_<name>.mp4".
+// The file is read without holding the lock, it may be pruned meanwhile.
+func (d *dvr) file(name string) *MuxerFileResponse {
+	name = strings.TrimSuffix(strings.TrimPrefix(name, dvrSegmentPrefix), ".mp4")
+
+	d.mu.Lock()
+	_, exists := d.inits[name]
+	for _, seg := range d.segments {
+		if seg.name == name {
+			exists = true
+			break
+		}
+	}
+	d.mu.Unlock()
+	if !exists {
+		return &MuxerFileResponse{Status: http.StatusNotFound}
+	}
+
+	buf, err := os.ReadFile(d.path(name))
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			d.logf(log.LevelError, "dvr: %v", err)
+		}
+		return &MuxerFileResponse{Status: http.StatusNotFound}
+	}
+	return &MuxerFileResponse{
+		Status: http.StatusOK,
+		Header: map[string]string{
+			"Content-Type": "video/mp4",
+		},
+		Body: bytes.NewReader(buf),
+	}
+}
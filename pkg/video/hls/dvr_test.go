@@ -0,0 +1,218 @@
+package hls
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"nvr/pkg/log"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func nopLogf(log.Level, string, ...interface{}) {}
+
+func newTestDVR(dir string, window time.Duration, maxSize uint64) *dvr {
+	d := newDVR(dir, window, newDVRSpool(dir, maxSize, 1000), nopLogf, nil)
+	d.generateInit = func(videoParams [][]byte) ([]byte, error) {
+		return bytes.Join(videoParams, nil), nil
+	}
+	return d
+}
+
+func newTestDVRSegment(id uint64, content string) *Segment {
+	return &Segment{
+		ID:               id,
+		StartTime:        time.Unix(1, 0).UTC().Add(time.Duration(id) * time.Second),
+		name:             "seg" + strconv.FormatUint(id, 10),
+		Parts:            []*MuxerPart{{renderedContent: []byte(content)}},
+		RenderedDuration: time.Second,
+		videoParams:      [][]byte{[]byte("sps"), []byte("pps")},
+	}
+}
+
+func readDVRFile(t *testing.T, res *MuxerFileResponse) string {
+	t.Helper()
+	require.Equal(t, http.StatusOK, res.Status)
+	buf, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	return string(buf)
+}
+
+func TestDVR(t *testing.T) {
+	t.Run("empty", func(t *testing.T) {
+		d := newTestDVR(t.TempDir(), time.Minute, 1000)
+		require.Equal(t, http.StatusNotFound, d.playlist().Status)
+	})
+	t.Run("playlist", func(t *testing.T) {
+		d := newTestDVR(t.TempDir(), time.Minute, 1000)
+		require.NoError(t, d.add(newTestDVRSegment(1, "a")))
+		require.NoError(t, d.add(newTestDVRSegment(2, "b")))
+
+		expected := "#EXTM3U\n" +
+			"#EXT-X-VERSION:9\n" +
+			"#EXT-X-TARGETDURATION:1\n" +
+			"#EXT-X-MEDIA-SEQUENCE:0\n" +
+			"#EXT-X-INDEPENDENT-SEGMENTS\n" +
+			"#EXT-X-MAP:URI=\"dvr_init1.mp4\"\n" +
+			"#EXT-X-PROGRAM-DATE-TIME:1970-01-01T00:00:02Z\n" +
+			"#EXTINF:1.00000,\n" +
+			"dvr_seg1.mp4\n" +
+			"#EXT-X-PROGRAM-DATE-TIME:1970-01-01T00:00:03Z\n" +
+			"#EXTINF:1.00000,\n" +
+			"dvr_seg2.mp4\n"
+		require.Equal(t, expected, readDVRFile(t, d.playlist()))
+
+		require.Equal(t, "spspps", readDVRFile(t, d.file("dvr_init1.mp4")))
+		require.Equal(t, "a", readDVRFile(t, d.file("dvr_seg1.mp4")))
+		require.Equal(t, "b", readDVRFile(t, d.file("dvr_seg2.mp4")))
+		require.Equal(t, http.StatusNotFound, d.file("dvr_seg3.mp4").Status)
+	})
+	t.Run("window", func(t *testing.T) {
+		dir := t.TempDir()
+		d := newTestDVR(dir, 2*time.Second, 1000)
+		for i := uint64(1); i <= 4; i++ {
+			require.NoError(t, d.add(newTestDVRSegment(i, "x")))
+		}
+		require.Len(t, d.segments, 2)
+		require.Equal(t, "seg3", d.segments[0].name)
+		require.Equal(t, 2, d.deleteCount)
+		require.Equal(t, http.StatusNotFound, d.file("dvr_seg1.mp4").Status)
+
+		_, err := os.Stat(d.path("seg1"))
+		require.ErrorIs(t, err, os.ErrNotExist)
+		require.Contains(t, readDVRFile(t, d.playlist()), "#EXT-X-MEDIA-SEQUENCE:2\n")
+	})
+	t.Run("maxSize", func(t *testing.T) {
+		d := newTestDVR(t.TempDir(), time.Hour, 5)
+		require.NoError(t, d.add(newTestDVRSegment(1, "aaa")))
+		require.NoError(t, d.add(newTestDVRSegment(2, "bbb")))
+		require.Len(t, d.segments, 1)
+		require.Equal(t, uint64(3), d.size)
+
+		// The newest segment is kept even if it's too large.
+		require.NoError(t, d.add(newTestDVRSegment(3, "cccccc")))
+		require.Len(t, d.segments, 1)
+		require.Equal(t, "seg3", d.segments[0].name)
+	})
+	t.Run("totalSize", func(t *testing.T) {
+		dir := t.TempDir()
+		spool := newDVRSpool(dir, 1000, 5)
+		d1 := newDVR(t.TempDir(), time.Hour, spool, nopLogf, nil)
+		d2 := newDVR(t.TempDir(), time.Hour, spool, nopLogf, nil)
+		d1.generateInit = newTestDVR(dir, 0, 0).generateInit
+		d2.generateInit = d1.generateInit
+
+		require.NoError(t, d1.add(newTestDVRSegment(1, "aa")))
+		require.NoError(t, d1.add(newTestDVRSegment(2, "bb")))
+		require.Len(t, d1.segments, 2)
+
+		// The newest segment is kept even if the total is exceeded.
+		require.NoError(t, d2.add(newTestDVRSegment(1, "cc")))
+		require.Len(t, d2.segments, 1)
+		require.Equal(t, uint64(6), spool.used)
+
+		require.NoError(t, d1.add(newTestDVRSegment(3, "dd")))
+		require.Len(t, d1.segments, 1)
+		require.Equal(t, uint64(4), spool.used)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		d1.run(ctx)
+		require.Equal(t, uint64(2), spool.used)
+	})
+	t.Run("paramsChanged", func(t *testing.T) {
+		d := newTestDVR(t.TempDir(), 3*time.Second, 1000)
+		require.NoError(t, d.add(newTestDVRSegment(1, "a")))
+		seg2 := newTestDVRSegment(2, "b")
+		seg2.videoParams = [][]byte{[]byte("sps2"), []byte("pps")}
+		require.NoError(t, d.add(seg2))
+		seg3 := newTestDVRSegment(3, "c")
+		seg3.videoParams = seg2.videoParams
+		require.NoError(t, d.add(seg3))
+
+		expected := "#EXTM3U\n" +
+			"#EXT-X-VERSION:9\n" +
+			"#EXT-X-TARGETDURATION:1\n" +
+			"#EXT-X-MEDIA-SEQUENCE:0\n" +
+			"#EXT-X-INDEPENDENT-SEGMENTS\n" +
+			"#EXT-X-MAP:URI=\"dvr_init1.mp4\"\n" +
+			"#EXT-X-PROGRAM-DATE-TIME:1970-01-01T00:00:02Z\n" +
+			"#EXTINF:1.00000,\n" +
+			"dvr_seg1.mp4\n" +
+			"#EXT-X-DISCONTINUITY\n" +
+			"#EXT-X-MAP:URI=\"dvr_init2.mp4\"\n" +
+			"#EXT-X-PROGRAM-DATE-TIME:1970-01-01T00:00:03Z\n" +
+			"#EXTINF:1.00000,\n" +
+			"dvr_seg2.mp4\n" +
+			"#EXT-X-PROGRAM-DATE-TIME:1970-01-01T00:00:04Z\n" +
+			"#EXTINF:1.00000,\n" +
+			"dvr_seg3.mp4\n"
+		require.Equal(t, expected, readDVRFile(t, d.playlist()))
+		require.Equal(t, "sps2pps", readDVRFile(t, d.file("dvr_init2.mp4")))
+
+		// The first init segment is deleted with its segment.
+		require.NoError(t, d.add(newTestDVRSegment(4, "d")))
+		require.NoError(t, d.add(newTestDVRSegment(5, "e")))
+		require.Equal(t, http.StatusNotFound, d.file("dvr_init1.mp4").Status)
+		_, err := os.Stat(d.path("init1"))
+		require.ErrorIs(t, err, os.ErrNotExist)
+
+		// Segment 2 was pruned.
+		playlist := readDVRFile(t, d.playlist())
+		require.Contains(t, playlist, "#EXT-X-DISCONTINUITY-SEQUENCE:1\n")
+		require.Contains(t, playlist, "#EXT-X-MAP:URI=\"dvr_init3.mp4\"\n")
+	})
+	t.Run("dropped", func(t *testing.T) {
+		d := newTestDVR(t.TempDir(), time.Minute, 1000)
+		require.NoError(t, d.add(newTestDVRSegment(1, "a")))
+		for i := 0; i <= dvrQueueSize; i++ {
+			d.onSegmentFinalized(newTestDVRSegment(2, "b"))
+		}
+		require.NoError(t, d.add(newTestDVRSegment(3, "c")))
+		require.NoError(t, d.add(newTestDVRSegment(4, "d")))
+		require.Equal(t, []bool{false, true, false}, discontinuities(d))
+
+		// Write errors.
+		d.generateInit = func([][]byte) ([]byte, error) { return nil, errors.New("x") }
+		seg5 := newTestDVRSegment(5, "e")
+		seg5.videoParams = [][]byte{[]byte("sps2"), []byte("pps")}
+		require.Error(t, d.add(seg5))
+		d.generateInit = func(p [][]byte) ([]byte, error) { return bytes.Join(p, nil), nil }
+		require.NoError(t, d.add(newTestDVRSegment(6, "f")))
+		require.Equal(t, []bool{false, true, false, true}, discontinuities(d))
+	})
+	t.Run("removeDir", func(t *testing.T) {
+		dir, err := os.MkdirTemp(t.TempDir(), "")
+		require.NoError(t, err)
+		d := newTestDVR(dir, time.Minute, 1000)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			d.run(ctx)
+			close(done)
+		}()
+		d.onSegmentFinalized(newTestDVRSegment(1, "a"))
+		cancel()
+		<-done
+
+		_, err = os.Stat(dir)
+		require.ErrorIs(t, err, os.ErrNotExist)
+	})
+}
+
+func discontinuities(d *dvr) []bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var ret []bool
+	for _, seg := range d.segments {
+		ret = append(ret, seg.discontinuity)
+	}
+	return ret
+}
@@ -4,10 +4,13 @@ import (
 	"bytes"
 	"context"
 	"errors"
+	"fmt"
 	"io"
 	"net/http"
 	"nvr/pkg/log"
 	"nvr/pkg/video/gortsplib"
+	"os"
+	"strings"
 	"sync"
 	"time"
 )
@@ -21,6 +24,7 @@ type MuxerFileResponse struct {
 
 // Muxer is a HLS muxer.
 type Muxer struct {
+	ctx        context.Context
 	playlist   *playlist
 	segmenter  *segmenter
 	logf       log.Func
@@ -31,6 +35,7 @@ type Muxer struct {
 	videoLastSPS []byte
 	videoLastPPS []byte
 	initContent  []byte
+	dvr          *dvr
 }
 
 // ErrTrackInvalid invalid H264 track: SPS or PPS not provided into the SDP.
@@ -51,6 +56,7 @@ func NewMuxer(
 	go playlist.start()
 
 	m := &Muxer{
+		ctx:        ctx,
 		playlist:   playlist,
 		logf:       logf,
 		videoTrack: videoTrack,
@@ -63,12 +69,45 @@ func NewMuxer(
 		segmentMaxSize,
 		videoTrack,
 		audioTrack,
-		m.playlist.onSegmentFinalized,
+		m.onSegmentFinalized,
 		m.playlist.partFinalized,
 	)
 	return m
 }
 
+// EnableDVR spools finalized segments to a temporary directory inside
+// the spool directory and serves them in the "dvr.m3u8" playlist. The
+// spool is limited to the most recent segments within the window and
+// the limits of the spool. The directory is removed when the muxer is closed.
+func (m *Muxer) EnableDVR(spool *DVRSpool, window time.Duration) error {
+	if err := os.MkdirAll(spool.dir, 0o700); err != nil {
+		return fmt.Errorf("create dvr dir: %w", err)
+	}
+	spoolDir, err := os.MkdirTemp(spool.dir, "")
+	if err != nil {
+		return fmt.Errorf("create dvr spool: %w", err)
+	}
+
+	d := newDVR(spoolDir, window, spool, m.logf, m.audioTrack)
+	go d.run(m.ctx)
+
+	m.mutex.Lock()
+	m.dvr = d
+	m.mutex.Unlock()
+	return nil
+}
+
+func (m *Muxer) onSegmentFinalized(segment *Segment) {
+	m.playlist.onSegmentFinalized(segment)
+
+	m.mutex.Lock()
+	d := m.dvr
+	m.mutex.Unlock()
+	if d != nil {
+		d.onSegmentFinalized(segment)
+	}
+}
+
 // OnSegmentFinalizedFunc is injected by core.
 type OnSegmentFinalizedFunc func([]SegmentOrGap)
 
@@ -90,7 +129,7 @@ func (m *Muxer) File(
 	skip string,
 ) *MuxerFileResponse {
 	if name == "index.m3u8" {
-		return primaryPlaylist(m.videoTrack, m.audioTrack)
+		return PrimaryPlaylist(m.Variant("stream.m3u8"))
 	}
 
 	if name == "init.mp4" {
@@ -121,6 +160,19 @@ func (m *Muxer) File(
 		}
 	}
 
+	if name == dvrPlaylistName || strings.HasPrefix(name, dvrSegmentPrefix) {
+		m.mutex.Lock()
+		d := m.dvr
+		m.mutex.Unlock()
+		if d == nil {
+			return &MuxerFileResponse{Status: http.StatusNotFound}
+		}
+		if name == dvrPlaylistName {
+			return d.playlist()
+		}
+		return d.file(name)
+	}
+
 	return m.playlist.file(name, msn, part, skip)
 }
 
@@ -134,6 +186,19 @@ func (m *Muxer) AudioTrack() *gortsplib.TrackMPEG4Audio {
 	return m.audioTrack
 }
 
+// Variant returns the variant stream of the muxer. The bandwidth
+// is measured from the segments that are in the playlist.
+func (m *Muxer) Variant(uri string) Variant {
+	peak, average := m.playlist.bandwidth()
+	return Variant{
+		URI:              uri,
+		VideoTrack:       m.videoTrack,
+		AudioTrack:       m.audioTrack,
+		Bandwidth:        peak,
+		AverageBandwidth: average,
+	}
+}
+
 // WaitForSegFinalized blocks until a new segment has been finalized.
 func (m *Muxer) WaitForSegFinalized() {
 	m.playlist.waitForSegFinalized()
@@ -145,6 +210,12 @@ func (m *Muxer) NextSegment(prevID uint64) (*Segment, error) {
 	return m.playlist.nextSegment(prevID)
 }
 
+// LatestSegment returns the most recently finalized segment.
+// Returns ErrNoSegments if no segment has been finalized yet.
+func (m *Muxer) LatestSegment() (*Segment, error) {
+	return m.playlist.latestSegment()
+}
+
 // VideoTimescale the number of time units that pass per second.
 const VideoTimescale = 90000
 
@@ -4,12 +4,14 @@ import (
 	"bytes"
 	"context"
 	"encoding/hex"
-	"io"
+	"errors"
 	"math"
 	"net/http"
 	"nvr/pkg/video/gortsplib"
+	"nvr/pkg/video/gortsplib/pkg/h264"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -95,6 +97,11 @@ type playlist struct {
 	chBlockingPart     chan blockingPartRequest
 	chWaitForSegFinal  chan chan struct{}
 	chNextSegment      chan nextSegmentRequest
+	chLatestSegment    chan chan *Segment
+
+	bandwidthMu      sync.Mutex
+	peakBandwidth    int64
+	averageBandwidth int64
 }
 
 func newPlaylist(ctx context.Context, segmentCount int) *playlist {
@@ -117,6 +124,7 @@ func newPlaylist(ctx context.Context, segmentCount int) *playlist {
 		chBlockingPart:     make(chan blockingPartRequest),
 		chWaitForSegFinal:  make(chan chan struct{}),
 		chNextSegment:      make(chan nextSegmentRequest),
+		chLatestSegment:    make(chan chan *Segment),
 	}
 }
 
@@ -235,6 +243,15 @@ func (p *playlist) start() { //nolint:funlen,gocognit
 			} else {
 				p.nextSegmentsOnHold[req] = struct{}{}
 			}
+
+		case res := <-p.chLatestSegment:
+			var latest *Segment
+			for _, s := range p.segments {
+				if seg, ok := s.(*Segment); ok {
+					latest = seg
+				}
+			}
+			res <- latest
 		}
 	}
 }
@@ -416,39 +433,79 @@ func (p *playlist) playlistReader(msn, part, skip string) *MuxerFileResponse {
 	}
 }
 
-func primaryPlaylist(
-	videoTrack *gortsplib.TrackH264,
-	audioTrack *gortsplib.TrackMPEG4Audio,
-) *MuxerFileResponse {
+// Variant is a variant stream of a primary playlist.
+type Variant struct {
+	// URI of the media playlist, relative to the primary playlist.
+	URI        string
+	VideoTrack *gortsplib.TrackH264
+	AudioTrack *gortsplib.TrackMPEG4Audio
+
+	// Peak and average bits per second, zero if unknown.
+	Bandwidth        int64
+	AverageBandwidth int64
+}
+
+// Used if the bandwidth hasn't been measured yet.
+const defaultBandwidth = 200000
+
+// PrimaryPlaylist returns a primary playlist with the variant streams.
+func PrimaryPlaylist(variants ...Variant) *MuxerFileResponse {
+	cnt := "#EXTM3U\n" +
+		"#EXT-X-VERSION:9\n" +
+		"#EXT-X-INDEPENDENT-SEGMENTS\n"
+
+	for _, v := range variants {
+		cnt += "\n#EXT-X-STREAM-INF:" + strings.Join(v.attributes(), ",") + "\n"
+		cnt += v.URI + "\n"
+	}
+
 	return &MuxerFileResponse{
 		Status: http.StatusOK,
 		Header: map[string]string{
 			"Content-Type": `audio/mpegURL`,
 		},
-		Body: func() io.Reader {
-			var codecs []string
+		Body: bytes.NewReader([]byte(cnt)),
+	}
+}
 
-			sps := videoTrack.SPS
-			if len(sps) >= 4 {
-				codecs = append(codecs, "avc1."+hex.EncodeToString(sps[1:4]))
-			}
+func (v Variant) attributes() []string {
+	bandwidth := v.Bandwidth
+	if bandwidth == 0 {
+		bandwidth = defaultBandwidth
+	}
+	attrs := []string{"BANDWIDTH=" + strconv.FormatInt(bandwidth, 10)}
+	if v.AverageBandwidth != 0 {
+		attrs = append(attrs, "AVERAGE-BANDWIDTH="+strconv.FormatInt(v.AverageBandwidth, 10))
+	}
 
-			// https://developer.mozilla.org/en-US/docs/Web/Media/Formats/codecs_parameter
-			if audioTrack != nil {
-				codecs = append(
-					codecs,
-					"mp4a.40."+strconv.FormatInt(int64(audioTrack.Config.Type), 10),
-				)
-			}
+	var codecs []string
+	var sps h264.SPS
+	spsValid := false
+	if v.VideoTrack != nil {
+		if len(v.VideoTrack.SPS) >= 4 {
+			codecs = append(codecs, "avc1."+hex.EncodeToString(v.VideoTrack.SPS[1:4]))
+		}
+		spsValid = sps.Unmarshal(v.VideoTrack.SPS) == nil
+	}
 
-			return bytes.NewReader([]byte("#EXTM3U\n" +
-				"#EXT-X-VERSION:9\n" +
-				"#EXT-X-INDEPENDENT-SEGMENTS\n" +
-				"\n" +
-				"#EXT-X-STREAM-INF:BANDWIDTH=200000,CODECS=\"" + strings.Join(codecs, ",") + "\"\n" +
-				"stream.m3u8\n"))
-		}(),
+	// https://developer.mozilla.org/en-US/docs/Web/Media/Formats/codecs_parameter
+	if v.AudioTrack != nil {
+		codecs = append(
+			codecs,
+			"mp4a.40."+strconv.FormatInt(int64(v.AudioTrack.Config.Type), 10),
+		)
 	}
+	if len(codecs) != 0 {
+		attrs = append(attrs, `CODECS="`+strings.Join(codecs, ",")+`"`)
+	}
+
+	if spsValid {
+		attrs = append(attrs, "RESOLUTION="+strconv.Itoa(sps.Width())+"x"+strconv.Itoa(sps.Height()))
+		if fps := sps.FPS(); fps > 0 {
+			attrs = append(attrs, "FRAME-RATE="+strconv.FormatFloat(fps, 'f', 3, 64))
+		}
+	}
+	return attrs
 }
 
 func (p *playlist) fullPlaylist(isDeltaUpdate bool) []byte { //nolint:funlen
@@ -645,6 +702,7 @@ func (p *playlist) segmentFinalized(segment *Segment) {
 		p.segments = p.segments[1:]
 		p.segmentDeleteCount++
 	}
+	p.updateBandwidth()
 
 	for done := range p.segFinalOnHold {
 		close(done)
@@ -660,6 +718,41 @@ func (p *playlist) segmentFinalized(segment *Segment) {
 	p.checkPending()
 }
 
+// updateBandwidth calculates the peak and
+// average bitrate of the segments in the playlist.
+func (p *playlist) updateBandwidth() {
+	var peak int64
+	var bits uint64
+	var duration time.Duration
+	for _, s := range p.segments {
+		seg, ok := s.(*Segment)
+		if !ok || seg.RenderedDuration <= 0 {
+			continue
+		}
+		bitrate := int64(float64(seg.size*8) / seg.RenderedDuration.Seconds())
+		if bitrate > peak {
+			peak = bitrate
+		}
+		bits += seg.size * 8
+		duration += seg.RenderedDuration
+	}
+	if duration == 0 {
+		return
+	}
+
+	p.bandwidthMu.Lock()
+	defer p.bandwidthMu.Unlock()
+	p.peakBandwidth = peak
+	p.averageBandwidth = int64(float64(bits) / duration.Seconds())
+}
+
+// bandwidth returns the peak and average bits per second.
+func (p *playlist) bandwidth() (int64, int64) {
+	p.bandwidthMu.Lock()
+	defer p.bandwidthMu.Unlock()
+	return p.peakBandwidth, p.averageBandwidth
+}
+
 type partFinalizedRequest struct {
 	part *MuxerPart
 	done chan struct{}
@@ -709,3 +802,20 @@ func (p *playlist) nextSegment(prevID uint64) (*Segment, error) {
 		return res, nil
 	}
 }
+
+// ErrNoSegments no segment has been finalized.
+var ErrNoSegments = errors.New("no segments")
+
+func (p *playlist) latestSegment() (*Segment, error) {
+	res := make(chan *Segment)
+	select {
+	case <-p.ctx.Done():
+		return nil, context.Canceled
+	case p.chLatestSegment <- res:
+		seg := <-res
+		if seg == nil {
+			return nil, ErrNoSegments
+		}
+		return seg, nil
+	}
+}
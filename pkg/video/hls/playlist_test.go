@@ -2,7 +2,11 @@ package hls
 
 import (
 	"context"
+	"io"
+	"nvr/pkg/video/gortsplib"
+	"nvr/pkg/video/gortsplib/pkg/mpeg4audio"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
@@ -52,3 +56,83 @@ func TestNextSegment(t *testing.T) {
 		<-done
 	})
 }
+
+func TestLatestSegment(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	playlist := newPlaylist(ctx, 3)
+	go playlist.start()
+
+	_, err := playlist.latestSegment()
+	require.ErrorIs(t, err, ErrNoSegments)
+
+	seg5 := &Segment{ID: 5}
+	seg6 := &Segment{ID: 6}
+	playlist.onSegmentFinalized(seg5)
+	playlist.onSegmentFinalized(seg6)
+
+	seg, err := playlist.latestSegment()
+	require.NoError(t, err)
+	require.Equal(t, seg6, seg)
+
+	cancel()
+	_, err = playlist.latestSegment()
+	require.ErrorIs(t, err, context.Canceled)
+}
+
+func TestPrimaryPlaylist(t *testing.T) {
+	sps := []byte{
+		103, 100, 0, 22, 172, 217, 64, 164,
+		59, 228, 136, 192, 68, 0, 0, 3,
+		0, 4, 0, 0, 3, 0, 96, 60,
+		88, 182, 88,
+	}
+	res := PrimaryPlaylist(
+		Variant{
+			URI:              "stream.m3u8",
+			VideoTrack:       &gortsplib.TrackH264{SPS: sps},
+			AudioTrack:       &gortsplib.TrackMPEG4Audio{Config: &mpeg4audio.Config{Type: 2}},
+			Bandwidth:        3000000,
+			AverageBandwidth: 2000000,
+		},
+		Variant{
+			URI:        "../x_sub/stream.m3u8",
+			VideoTrack: &gortsplib.TrackH264{SPS: []byte{103, 0, 0}},
+		},
+	)
+	require.Equal(t, 200, res.Status)
+
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	expected := "#EXTM3U\n" +
+		"#EXT-X-VERSION:9\n" +
+		"#EXT-X-INDEPENDENT-SEGMENTS\n" +
+		"\n" +
+		"#EXT-X-STREAM-INF:BANDWIDTH=3000000,AVERAGE-BANDWIDTH=2000000," +
+		"CODECS=\"avc1.640016,mp4a.40.2\",RESOLUTION=650x450,FRAME-RATE=12.000\n" +
+		"stream.m3u8\n" +
+		"\n" +
+		"#EXT-X-STREAM-INF:BANDWIDTH=200000\n" +
+		"../x_sub/stream.m3u8\n"
+	require.Equal(t, expected, string(body))
+}
+
+func TestPlaylistBandwidth(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	playlist := newPlaylist(ctx, 9)
+	go playlist.start()
+
+	peak, average := playlist.bandwidth()
+	require.Equal(t, int64(0), peak)
+	require.Equal(t, int64(0), average)
+
+	playlist.onSegmentFinalized(&Segment{ID: 1, size: 1000, RenderedDuration: time.Second})
+	playlist.onSegmentFinalized(&Segment{ID: 2, size: 4000, RenderedDuration: time.Second})
+
+	peak, average = playlist.bandwidth()
+	require.Equal(t, int64(32000), peak)
+	require.Equal(t, int64(20000), average)
+}
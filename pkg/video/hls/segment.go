@@ -54,6 +54,9 @@ type Segment struct {
 	Parts            []*MuxerPart
 	currentPart      *MuxerPart
 	RenderedDuration time.Duration
+
+	// SPS and PPS of the video track when the segment was created.
+	videoParams [][]byte
 }
 
 func newSegment(
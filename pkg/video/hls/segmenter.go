@@ -221,6 +221,8 @@ func (m *segmenter) writeH264Entry( //nolint:funlen
 			m.genPartID,
 			m.onPartFinalized,
 		)
+		m.lastVideoParams = extractVideoParams(m.videoTrack)
+		m.currentSegment.videoParams = m.lastVideoParams
 	}
 
 	m.adjustPartDuration(sample.Duration)
@@ -263,6 +265,7 @@ func (m *segmenter) writeH264Entry( //nolint:funlen
 				// reset adjusted part duration
 				m.sampleDurations = make(map[time.Duration]struct{})
 			}
+			m.currentSegment.videoParams = m.lastVideoParams
 		}
 	}
 
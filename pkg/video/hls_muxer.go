@@ -20,6 +20,7 @@ type muxerCloseFunc func(*HLSMuxer)
 type HLSMuxer struct {
 	wg              *sync.WaitGroup
 	readBufferCount int
+	dvrSpool        *hls.DVRSpool
 	path            *path
 	pathConf        PathConf
 	muxerClose      muxerCloseFunc
@@ -36,6 +37,7 @@ type HLSMuxer struct {
 func newHLSMuxer(
 	parentCtx context.Context,
 	readBufferCount int,
+	dvrSpool *hls.DVRSpool,
 	wg *sync.WaitGroup,
 	path *path,
 	muxerClose muxerCloseFunc,
@@ -44,6 +46,7 @@ func newHLSMuxer(
 
 	return &HLSMuxer{
 		readBufferCount: readBufferCount,
+		dvrSpool:        dvrSpool,
 		wg:              wg,
 		path:            path,
 		pathConf:        *path.conf,
@@ -188,7 +191,7 @@ func (m *HLSMuxer) createMuxer(
 		m.path.logf(level, "HLS: "+format, a...)
 	}
 
-	return hls.NewMuxer(
+	muxer := hls.NewMuxer(
 		m.ctx,
 		hlsSegmentCount,
 		hlsSegmentDuration,
@@ -198,6 +201,14 @@ func (m *HLSMuxer) createMuxer(
 		videoTrack,
 		audioTrack,
 	)
+
+	if m.pathConf.DVRWindow > 0 {
+		err := muxer.EnableDVR(m.dvrSpool, m.pathConf.DVRWindow)
+		if err != nil {
+			m.logf("enable dvr: %v", err)
+		}
+	}
+	return muxer
 }
 
 // Errors.
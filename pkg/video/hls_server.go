@@ -2,6 +2,9 @@ package video
 
 import (
 	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
@@ -18,11 +21,15 @@ import (
 
 type hlsServer struct {
 	readBufferCount int
+	dvrSpool        *hls.DVRSpool
+	tlsConfig       *tls.Config
 	logger          *log.Logger
+	auth            AuthFunc
 
-	ctx    context.Context
-	wg     *sync.WaitGroup
-	muxers map[string]*HLSMuxer
+	ctx     context.Context
+	wg      *sync.WaitGroup
+	muxers  map[string]*HLSMuxer
+	clients *hlsClients
 
 	// in
 	chPathSourceReady    chan pathSourceReadyRequest
@@ -35,13 +42,18 @@ type hlsServer struct {
 func newHLSServer(
 	wg *sync.WaitGroup,
 	readBufferCount int,
+	dvrSpool *hls.DVRSpool,
+	tlsConfig *tls.Config,
 	logger *log.Logger,
 ) *hlsServer {
 	return &hlsServer{
 		readBufferCount:      readBufferCount,
+		dvrSpool:             dvrSpool,
+		tlsConfig:            tlsConfig,
 		logger:               logger,
 		wg:                   wg,
 		muxers:               make(map[string]*HLSMuxer),
+		clients:              newHLSClients(),
 		chPathSourceReady:    make(chan pathSourceReadyRequest),
 		chPathSourceNotReady: make(chan string),
 		chRequest:            make(chan *hlsMuxerRequest),
@@ -57,6 +69,9 @@ func (s *hlsServer) start(ctx context.Context, address string) error {
 	if err != nil {
 		return err
 	}
+	if s.tlsConfig != nil {
+		ln = tls.NewListener(ln, s.tlsConfig)
+	}
 	s.logger.Log(log.Entry{
 		Level: log.LevelInfo,
 		Src:   "app",
@@ -78,7 +93,7 @@ func (s *hlsServer) start(ctx context.Context, address string) error {
 
 func (s *hlsServer) startServer(ln net.Listener) {
 	mux := http.NewServeMux()
-	mux.Handle("/hls/", s.HandleRequest())
+	mux.Handle("/hls/", s.authenticate(s.HandleRequest()))
 	server := http.Server{Handler: mux}
 
 	go func() {
@@ -105,6 +120,24 @@ func (s *hlsServer) startServer(ln net.Listener) {
 	}()
 }
 
+// authenticate blocks remote clients that aren't allowed to read the
+// path. The main app router has its own authentication.
+func (s *hlsServer) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.auth == nil || r.Method == http.MethodOptions || isLoopback(r.RemoteAddr) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		pathName, _, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/hls/"), "/")
+		if !s.auth(r, pathName) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="NVR"`)
+			http.Error(w, "Unauthorized.", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
 // ErrMuxerAleadyExists muxer already exists.
 var ErrMuxerAleadyExists = errors.New("muxer already exists")
 
@@ -123,6 +156,7 @@ func (s *hlsServer) run() {
 			m := newHLSMuxer(
 				s.ctx,
 				s.readBufferCount,
+				s.dvrSpool,
 				s.wg,
 				req.path,
 				s.muxerClose,
@@ -145,6 +179,13 @@ func (s *hlsServer) run() {
 
 		case req := <-s.chRequest:
 			m, exist := s.muxers[req.path]
+			if exist {
+				s.clients.seen(req.path, req.req, time.Now())
+			}
+			if exist && req.file == hlsABRPlaylist {
+				req.res <- s.abrPlaylist(m)
+				continue
+			}
 			if exist {
 				m.onRequest(req)
 				continue
@@ -238,6 +279,28 @@ func (s *hlsServer) HandleRequest() http.HandlerFunc { //nolint:funlen
 	}
 }
 
+// Primary playlist of the main and sub stream of a monitor.
+const hlsABRPlaylist = "abr.m3u8"
+
+// abrPlaylist returns a primary playlist that lists the main stream
+// and the sub stream if it exists. Players can then switch between
+// them based on the available bandwidth. The streams are segmented
+// independently on their own keyframes, segment boundaries aren't
+// aligned and a switch can skip or repeat a fraction of a segment.
+func (s *hlsServer) abrPlaylist(main *HLSMuxer) *hls.MuxerFileResponse {
+	if main.pathConf.IsSub {
+		return &hls.MuxerFileResponse{Status: http.StatusNotFound}
+	}
+
+	variants := []hls.Variant{main.muxer.Variant("stream.m3u8")}
+	for _, m := range s.muxers {
+		if m.pathConf.IsSub && m.pathConf.MonitorID == main.pathConf.MonitorID {
+			variants = append(variants, m.muxer.Variant("../"+m.path.name+"/stream.m3u8"))
+		}
+	}
+	return hls.PrimaryPlaylist(variants...)
+}
+
 type pathSourceReadyRequest struct {
 	path   *path
 	tracks gortsplib.Tracks
@@ -307,3 +370,65 @@ func (s *hlsServer) MuxerByPathName(ctx context.Context, pathName string) (*hls.
 		return res.muxer, nil
 	}
 }
+
+// A HLS client is considered to have stopped reading
+// if it hasn't made a request within this duration.
+const hlsClientTimeout = 10 * time.Second
+
+// hlsClients keeps track of the clients reading each path.
+type hlsClients struct {
+	paths map[string]map[string]time.Time // Last request by client key.
+	mu    sync.Mutex
+}
+
+func newHLSClients() *hlsClients {
+	return &hlsClients{paths: make(map[string]map[string]time.Time)}
+}
+
+func (c *hlsClients) seen(pathName string, r *http.Request, now time.Time) {
+	key := hlsClientKey(r)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, exist := c.paths[pathName]; !exist {
+		c.paths[pathName] = make(map[string]time.Time)
+	}
+	c.paths[pathName][key] = now
+}
+
+// hlsClientKey identifies a client by its host, credentials, cookies and
+// user agent. HLS requests aren't tied to a connection, viewers behind the
+// same NAT or proxy would otherwise be counted as one. Viewers that share
+// all of these, like two tabs of the same browser, are still counted once.
+func hlsClientKey(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	// The headers are hashed to avoid keeping the credentials in memory.
+	h := sha256.New()
+	for _, name := range []string{"Authorization", "Cookie", "User-Agent"} {
+		h.Write([]byte(r.Header.Get(name)))
+		h.Write([]byte{0})
+	}
+	return host + " " + hex.EncodeToString(h.Sum(nil)[:8])
+}
+
+// count returns the number of active clients and prunes inactive ones.
+func (c *hlsClients) count(pathName string, now time.Time) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	clients := c.paths[pathName]
+	for key, lastSeen := range clients {
+		if now.Sub(lastSeen) > hlsClientTimeout {
+			delete(clients, key)
+		}
+	}
+	if len(clients) == 0 {
+		delete(c.paths, pathName)
+	}
+	return len(clients)
+}
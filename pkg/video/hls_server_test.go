@@ -0,0 +1,96 @@
+package video
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"nvr/pkg/video/gortsplib"
+	"nvr/pkg/video/hls"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHLSServerAuthenticate(t *testing.T) {
+	var pathName string
+	s := &hlsServer{
+		auth: func(r *http.Request, name string) bool {
+			pathName = name
+			user, _, _ := r.BasicAuth()
+			return user == "admin"
+		},
+	}
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	serve := func(remoteAddr string, user string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/hls/m1_sub/index.m3u8", nil)
+		r.RemoteAddr = remoteAddr
+		if user != "" {
+			r.SetBasicAuth(user, "pass")
+		}
+		w := httptest.NewRecorder()
+		s.authenticate(ok).ServeHTTP(w, r)
+		return w
+	}
+
+	w := serve("192.168.1.2:1234", "")
+	require.Equal(t, http.StatusUnauthorized, w.Code)
+	require.Equal(t, `Basic realm="NVR"`, w.Header().Get("WWW-Authenticate"))
+	require.Equal(t, "m1_sub", pathName)
+
+	require.Equal(t, http.StatusOK, serve("192.168.1.2:1234", "admin").Code)
+	require.Equal(t, http.StatusUnauthorized, serve("192.168.1.2:1234", "user").Code)
+
+	// Local clients are trusted.
+	require.Equal(t, http.StatusOK, serve("127.0.0.1:1234", "").Code)
+	require.Equal(t, http.StatusOK, serve("[::1]:1234", "").Code)
+}
+
+func TestHLSServerABRPlaylist(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	newMuxer := func(name string, conf PathConf) *HLSMuxer {
+		return &HLSMuxer{
+			path:     &path{name: name},
+			pathConf: conf,
+			muxer: hls.NewMuxer(ctx, 3, 0, 0, 0, nil,
+				&gortsplib.TrackH264{SPS: []byte{103, 100, 0, 22}}, nil),
+		}
+	}
+	s := &hlsServer{muxers: map[string]*HLSMuxer{
+		"m1":     newMuxer("m1", PathConf{MonitorID: "m1"}),
+		"m1_sub": newMuxer("m1_sub", PathConf{MonitorID: "m1", IsSub: true}),
+		"m2":     newMuxer("m2", PathConf{MonitorID: "m2"}),
+	}}
+
+	playlist := func(name string) (int, string) {
+		res := s.abrPlaylist(s.muxers[name])
+		if res.Body == nil {
+			return res.Status, ""
+		}
+		body, err := io.ReadAll(res.Body)
+		require.NoError(t, err)
+		return res.Status, string(body)
+	}
+
+	status, body := playlist("m1")
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, "#EXTM3U\n"+
+		"#EXT-X-VERSION:9\n"+
+		"#EXT-X-INDEPENDENT-SEGMENTS\n"+
+		"\n"+
+		"#EXT-X-STREAM-INF:BANDWIDTH=200000,CODECS=\"avc1.640016\"\n"+
+		"stream.m3u8\n"+
+		"\n"+
+		"#EXT-X-STREAM-INF:BANDWIDTH=200000,CODECS=\"avc1.640016\"\n"+
+		"../m1_sub/stream.m3u8\n", body)
+
+	// Without sub stream.
+	status, body = playlist("m2")
+	require.Equal(t, http.StatusOK, status)
+	require.NotContains(t, body, "_sub")
+
+	status, _ = playlist("m1_sub")
+	require.Equal(t, http.StatusNotFound, status)
+}
@@ -4,10 +4,12 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net"
 	"nvr/pkg/log"
 	"nvr/pkg/video/gortsplib"
 	"regexp"
 	"sync"
+	"time"
 )
 
 type pathHLSServer interface {
@@ -153,6 +155,9 @@ func (pa *path) publisherStart(tracks gortsplib.Tracks) (*stream, error) {
 	}
 
 	pa.stream = newStream(tracks, hlsMuxer)
+	if pa.conf.MulticastGroup != nil {
+		pa.stream.rtspStream.EnableMulticast(pa.conf.MulticastGroup, pa.conf.MulticastPort)
+	}
 	pa.sourceReady = true
 
 	return pa.stream, err
@@ -196,6 +201,23 @@ func (pa *path) readerStart(session *rtspSession) {
 	pa.readers[session] = struct{}{}
 }
 
+// readerCount returns the number of rtsp readers.
+func (pa *path) readerCount() int {
+	pa.mu.Lock()
+	defer pa.mu.Unlock()
+	return len(pa.readers)
+}
+
+// webrtcReaderCount returns the number of webrtc readers.
+func (pa *path) webrtcReaderCount() int {
+	pa.mu.Lock()
+	defer pa.mu.Unlock()
+	if pa.stream == nil {
+		return 0
+	}
+	return pa.stream.webrtcReaderCount()
+}
+
 // Errors.
 var (
 	ErrEmptyName    = errors.New("name can not be empty")
@@ -231,6 +253,14 @@ func isValidPathName(name string) error {
 type PathConf struct {
 	MonitorID string
 	IsSub     bool
+
+	// Optional multicast group of the restream.
+	// Track N is sent to MulticastPort+2N.
+	MulticastGroup net.IP
+	MulticastPort  int
+
+	// Optional length of the DVR playlist, see hls.Muxer.EnableDVR.
+	DVRWindow time.Duration
 }
 
 // Errors.
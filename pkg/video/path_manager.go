@@ -7,6 +7,7 @@ import (
 	"nvr/pkg/video/gortsplib"
 	"nvr/pkg/video/gortsplib/pkg/base"
 	"nvr/pkg/video/hls"
+	"sort"
 	"sync"
 )
 
@@ -170,6 +171,18 @@ func (pm *pathManager) readerAdd(
 	return path.readerAdd(session)
 }
 
+// streamGet is called by the WebRTC server.
+func (pm *pathManager) streamGet(name string) (*stream, error) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	path, exist := pm.paths[name]
+	if !exist {
+		return nil, ErrPathNotExist
+	}
+	return path.streamGet()
+}
+
 func (pm *pathManager) pathLogfByName(name string) log.Func {
 	pm.mu.Lock()
 	defer pm.mu.Unlock()
@@ -180,3 +193,30 @@ func (pm *pathManager) pathLogfByName(name string) log.Func {
 	}
 	return nil
 }
+
+// pathStats path statistics.
+type pathStats struct {
+	name          string
+	conf          PathConf
+	rtspReaders   int
+	webrtcReaders int
+}
+
+func (pm *pathManager) stats() []pathStats {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	stats := make([]pathStats, 0, len(pm.paths))
+	for name, path := range pm.paths {
+		stats = append(stats, pathStats{
+			name:          name,
+			conf:          *path.conf,
+			rtspReaders:   path.readerCount(),
+			webrtcReaders: path.webrtcReaderCount(),
+		})
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].name < stats[j].name
+	})
+	return stats
+}
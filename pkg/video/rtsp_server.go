@@ -3,8 +3,12 @@ package video
 import (
 	"context"
 	"crypto/rand"
+	"crypto/tls"
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"net/http"
+	"net/url"
 	"nvr/pkg/log"
 	"nvr/pkg/video/gortsplib"
 	"nvr/pkg/video/gortsplib/pkg/base"
@@ -17,13 +21,16 @@ import (
 
 type rtspServer struct {
 	address     string
+	tlsAddress  string
 	readTimeout time.Duration
 	pathManager *pathManager
 	logger      *log.Logger
+	auth        AuthFunc
 
 	ctx      context.Context
 	wg       *sync.WaitGroup
 	srv      *gortsplib.Server
+	srvTLS   *gortsplib.Server // Optional RTSPS listener.
 	mu       sync.RWMutex
 	sessions map[*gortsplib.ServerSession]*rtspSession
 }
@@ -33,9 +40,15 @@ const (
 	writeTimeout = 10 * time.Second
 )
 
+// newRTSPServer creates the RTSP server. A RTSPS listener
+// is also started on tlsAddress if tlsConfig isn't nil.
+// The UDP transport is only available on the plain listener.
 func newRTSPServer(
 	wg *sync.WaitGroup,
 	address string,
+	tlsAddress string,
+	tlsConfig *tls.Config,
+	udpPorts [2]int,
 	readBufferCount int,
 	pathManager *pathManager,
 	logger *log.Logger,
@@ -43,6 +56,7 @@ func newRTSPServer(
 	s := &rtspServer{
 		wg:          wg,
 		address:     address,
+		tlsAddress:  tlsAddress,
 		readTimeout: readTimeout,
 		pathManager: pathManager,
 		logger:      logger,
@@ -56,7 +70,21 @@ func newRTSPServer(
 		readBufferCount,
 		readBufferCount,
 		address,
+		nil,
+		udpPorts,
 	)
+	if tlsConfig != nil {
+		s.srvTLS = gortsplib.NewServer(
+			s,
+			readTimeout,
+			writeTimeout,
+			readBufferCount,
+			readBufferCount,
+			tlsAddress,
+			tlsConfig,
+			[2]int{},
+		)
+	}
 
 	return s
 }
@@ -84,17 +112,31 @@ func (s *rtspServer) start(ctx context.Context) error {
 		Msg:   fmt.Sprintf("RTSP: listener opened on %v", s.address),
 	})
 	s.wg.Add(1)
-	go s.run()
+	go s.run(s.srv, "RTSP")
+
+	if s.srvTLS == nil {
+		return nil
+	}
+	if err := s.srvTLS.Start(); err != nil {
+		return fmt.Errorf("rtsps: %w", err)
+	}
+	s.logger.Log(log.Entry{
+		Level: log.LevelInfo,
+		Src:   "app",
+		Msg:   fmt.Sprintf("RTSPS: listener opened on %v", s.tlsAddress),
+	})
+	s.wg.Add(1)
+	go s.run(s.srvTLS, "RTSPS")
 
 	return nil
 }
 
-func (s *rtspServer) run() {
+func (s *rtspServer) run(srv *gortsplib.Server, name string) {
 	defer s.wg.Done()
 
 	serverErr := make(chan error)
 	go func() {
-		serverErr <- s.srv.Wait()
+		serverErr <- srv.Wait()
 	}()
 
 	select {
@@ -102,12 +144,12 @@ func (s *rtspServer) run() {
 		s.logger.Log(log.Entry{
 			Level: log.LevelError,
 			Src:   "app",
-			Msg:   fmt.Sprintf("RTSP: server error: %s", err),
+			Msg:   fmt.Sprintf("%v: server error: %s", name, err),
 		})
 		return
 
 	case <-s.ctx.Done():
-		s.srv.Close()
+		srv.Close()
 		<-serverErr
 		return
 	}
@@ -192,34 +234,96 @@ func (s *rtspServer) OnSessionClose(session *gortsplib.ServerSession, err error)
 	}
 }
 
+// Errors.
+var (
+	ErrRTSPAuthFailed        = errors.New("authentication failed")
+	ErrRTSPPublishNotAllowed = errors.New("publishing is only allowed from localhost")
+)
+
+// authenticate returns a non-nil response if the client isn't allowed to
+// read the path. Clients without credentials are asked to authenticate.
+func (s *rtspServer) authenticate(
+	sc *gortsplib.ServerConn,
+	req *base.Request,
+	pathName string,
+) (*base.Response, error) {
+	remoteAddr := sc.NetConn().RemoteAddr().String()
+	if s.auth == nil || isLoopback(remoteAddr) {
+		return nil, nil
+	}
+
+	r := &http.Request{
+		Method:     http.MethodGet,
+		URL:        &url.URL{Path: "/" + pathName},
+		Header:     make(http.Header),
+		RemoteAddr: remoteAddr,
+	}
+	authorization := req.Header["Authorization"]
+	if len(authorization) == 1 {
+		r.Header.Set("Authorization", authorization[0])
+	}
+	if s.auth(r, pathName) {
+		return nil, nil
+	}
+
+	res := &base.Response{
+		StatusCode: base.StatusUnauthorized,
+		Header: base.Header{
+			"WWW-Authenticate": base.HeaderValue{`Basic realm="NVR"`},
+		},
+	}
+	if len(authorization) == 0 {
+		return res, nil
+	}
+	return res, fmt.Errorf("%w: %v %v", ErrRTSPAuthFailed, remoteAddr, pathName)
+}
+
 // OnDescribe implements gortsplib.ServerHandler.
 func (s *rtspServer) OnDescribe(
+	sc *gortsplib.ServerConn,
+	req *base.Request,
 	pathName string,
 ) (*base.Response, *gortsplib.ServerStream, error) {
+	if res, err := s.authenticate(sc, req, pathName); res != nil {
+		return res, nil, err
+	}
 	return s.pathManager.onDescribe(pathName)
 }
 
 // OnAnnounce implements gortsplib.ServerHandler.
 func (s *rtspServer) OnAnnounce(
 	session *gortsplib.ServerSession,
+	req *base.Request,
 	path string,
 	tracks gortsplib.Tracks,
 ) (*base.Response, error) {
 	s.mu.RLock()
 	se := s.sessions[session]
 	s.mu.RUnlock()
+
+	if s.auth != nil && !isLoopback(se.author.NetConn().RemoteAddr().String()) {
+		return &base.Response{StatusCode: base.StatusForbidden}, ErrRTSPPublishNotAllowed
+	}
 	return se.onAnnounce(path, tracks)
 }
 
 // OnSetup implements gortsplib.ServerHandler.
 func (s *rtspServer) OnSetup(
 	session *gortsplib.ServerSession,
+	req *base.Request,
 	path string,
 	trackID int,
 ) (*base.Response, *gortsplib.ServerStream, error) {
 	s.mu.RLock()
 	se := s.sessions[session]
 	s.mu.RUnlock()
+
+	// Publishers were checked in OnAnnounce.
+	if session.State() != gortsplib.ServerSessionStatePreRecord {
+		if res, err := s.authenticate(se.author, req, path); res != nil {
+			return res, nil, err
+		}
+	}
 	return se.onSetup(path, trackID)
 }
 
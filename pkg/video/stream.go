@@ -8,6 +8,7 @@ import (
 	"nvr/pkg/video/gortsplib/pkg/h264"
 	"nvr/pkg/video/gortsplib/pkg/rtph264"
 	"nvr/pkg/video/gortsplib/pkg/rtpmpeg4audio"
+	"sync"
 
 	"github.com/pion/rtp"
 )
@@ -33,12 +34,17 @@ type stream struct {
 	rtspStream   *gortsplib.ServerStream
 	hlsMuxer     *HLSMuxer
 	streamTracks []streamTrack
+
+	webrtcReaders map[*webrtcReader]struct{}
+	webrtcMu      sync.Mutex
+	closed        bool
 }
 
 func newStream(tracks gortsplib.Tracks, hlsMuxer *HLSMuxer) *stream {
 	s := &stream{
-		rtspStream: gortsplib.NewServerStream(tracks),
-		hlsMuxer:   hlsMuxer,
+		rtspStream:    gortsplib.NewServerStream(tracks),
+		hlsMuxer:      hlsMuxer,
+		webrtcReaders: make(map[*webrtcReader]struct{}),
 	}
 
 	s.streamTracks = make([]streamTrack, len(s.rtspStream.Tracks()))
@@ -52,6 +58,40 @@ func newStream(tracks gortsplib.Tracks, hlsMuxer *HLSMuxer) *stream {
 
 func (s *stream) close() {
 	s.rtspStream.Close()
+
+	s.webrtcMu.Lock()
+	defer s.webrtcMu.Unlock()
+	s.closed = true
+	for r := range s.webrtcReaders {
+		r.session.Close()
+	}
+}
+
+// ErrStreamClosed stream is closed.
+var ErrStreamClosed = errors.New("stream closed")
+
+// webrtcReaderAdd is called by the WebRTC server.
+func (s *stream) webrtcReaderAdd(r *webrtcReader) error {
+	s.webrtcMu.Lock()
+	defer s.webrtcMu.Unlock()
+	if s.closed {
+		return ErrStreamClosed
+	}
+	s.webrtcReaders[r] = struct{}{}
+	return nil
+}
+
+// webrtcReaderRemove is called by the WebRTC server.
+func (s *stream) webrtcReaderRemove(r *webrtcReader) {
+	s.webrtcMu.Lock()
+	defer s.webrtcMu.Unlock()
+	delete(s.webrtcReaders, r)
+}
+
+func (s *stream) webrtcReaderCount() int {
+	s.webrtcMu.Lock()
+	defer s.webrtcMu.Unlock()
+	return len(s.webrtcReaders)
 }
 
 func (s *stream) tracks() gortsplib.Tracks {
@@ -72,6 +112,13 @@ func (s *stream) writeData(data data) error {
 	// Forward to hls muxer.
 	s.hlsMuxer.readerData(data)
 
+	// Forward to webrtc sessions.
+	s.webrtcMu.Lock()
+	for r := range s.webrtcReaders {
+		r.onData(data)
+	}
+	s.webrtcMu.Unlock()
+
 	return nil
 }
 
@@ -80,6 +127,34 @@ const (
 	maxPacketSize = 1472
 )
 
+// Late packets that are at most this far behind are discarded,
+// larger jumps backwards are treated as a sequence number reset.
+const rtpMaxMisorder = 100
+
+// rtpLossDetector detects lost packets from gaps in the sequence numbers.
+type rtpLossDetector struct {
+	initialized bool
+	expected    uint16
+}
+
+// process returns true if packets were lost before pkt. The
+// second return value is false if pkt is late or a duplicate.
+func (d *rtpLossDetector) process(pkt *rtp.Packet) (bool, bool) {
+	if !d.initialized {
+		d.initialized = true
+		d.expected = pkt.SequenceNumber + 1
+		return false, true
+	}
+
+	diff := int16(pkt.SequenceNumber - d.expected)
+	if diff < 0 && diff > -rtpMaxMisorder {
+		return false, false
+	}
+
+	d.expected = pkt.SequenceNumber + 1
+	return diff != 0, true
+}
+
 func rtpH264ExtractSPSPPS(pkt *rtp.Packet) ([]byte, []byte) {
 	if len(pkt.Payload) == 0 {
 		return nil, nil
@@ -136,6 +211,7 @@ type streamTrackH264 struct {
 	track   *gortsplib.TrackH264
 	encoder *rtph264.Encoder
 	decoder *rtph264.Decoder
+	loss    rtpLossDetector
 }
 
 func newStreamTrackH264(track *gortsplib.TrackH264) *streamTrackH264 {
@@ -250,6 +326,16 @@ func (t *streamTrackH264) onData(dat data) error {
 	}
 
 	pkt := tdata.rtpPackets[0]
+
+	lost, ok := t.loss.process(pkt)
+	if !ok {
+		tdata.rtpPackets = nil
+		return nil
+	}
+	if lost {
+		t.decoder.Reset()
+	}
+
 	t.updateTrackParametersFromRTPPacket(pkt)
 
 	if t.encoder == nil {
@@ -275,6 +361,11 @@ func (t *streamTrackH264) onData(dat data) error {
 
 	nalus, pts, err := t.decoder.Decode(pkt)
 	if err != nil {
+		// Packets are re-encoded from whole NALUs,
+		// the original packets must not be routed.
+		if t.encoder != nil {
+			tdata.rtpPackets = nil
+		}
 		if errors.Is(err, rtph264.ErrNonStartingPacketAndNoPrevious) ||
 			errors.Is(err, rtph264.ErrMorePacketsNeeded) {
 			return nil
@@ -299,6 +390,16 @@ type streamTrackMPEG4Audio struct {
 	track   *gortsplib.TrackMPEG4Audio
 	encoder *rtpmpeg4audio.Encoder
 	decoder *rtpmpeg4audio.Decoder
+	loss    rtpLossDetector
+
+	// Fragments of an AU share the same timestamp.
+	lastTimestamp uint32
+	lastMarker    bool
+
+	// The remaining fragments of an AU that was interrupted
+	// by packet loss are discarded.
+	discarding   bool
+	discardingTS uint32
 }
 
 func newStreamTrackMPEG4Audio(track *gortsplib.TrackMPEG4Audio) *streamTrackMPEG4Audio {
@@ -346,6 +447,28 @@ func (t *streamTrackMPEG4Audio) onData(dat data) error {
 		return PayloadTooBigError{size: pkt.MarshalSize()}
 	}
 
+	lost, ok := t.loss.process(pkt)
+	if !ok {
+		tdata.rtpPackets = nil
+		return nil
+	}
+	if lost {
+		t.decoder.Reset()
+		if !t.lastMarker && pkt.Timestamp == t.lastTimestamp {
+			t.discarding = true
+			t.discardingTS = pkt.Timestamp
+		}
+	}
+	t.lastTimestamp = pkt.Timestamp
+	t.lastMarker = pkt.Marker
+
+	if t.discarding {
+		if pkt.Timestamp == t.discardingTS {
+			return nil
+		}
+		t.discarding = false
+	}
+
 	aus, pts, err := t.decoder.Decode(pkt)
 	if err != nil {
 		if errors.Is(err, rtpmpeg4audio.ErrMorePacketsNeeded) {
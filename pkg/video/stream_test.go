@@ -0,0 +1,151 @@
+package video
+
+import (
+	"bytes"
+	"testing"
+
+	"nvr/pkg/video/gortsplib"
+	"nvr/pkg/video/gortsplib/pkg/mpeg4audio"
+
+	"github.com/pion/rtp"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRTPLossDetector(t *testing.T) {
+	cases := map[string]struct {
+		seqs     []uint16
+		expected [][2]bool
+	}{
+		"inOrder":   {[]uint16{1, 2, 3}, [][2]bool{{false, true}, {false, true}, {false, true}}},
+		"gap":       {[]uint16{1, 3, 4}, [][2]bool{{false, true}, {true, true}, {false, true}}},
+		"late":      {[]uint16{1, 3, 2}, [][2]bool{{false, true}, {true, true}, {false, false}}},
+		"duplicate": {[]uint16{1, 2, 2}, [][2]bool{{false, true}, {false, true}, {false, false}}},
+		"wrap":      {[]uint16{65535, 0, 1}, [][2]bool{{false, true}, {false, true}, {false, true}}},
+		"reset":     {[]uint16{5000, 10, 11}, [][2]bool{{false, true}, {true, true}, {false, true}}},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			var d rtpLossDetector
+			for i, seq := range tc.seqs {
+				lost, ok := d.process(&rtp.Packet{Header: rtp.Header{SequenceNumber: seq}})
+				require.Equal(t, tc.expected[i], [2]bool{lost, ok}, "packet %d", i)
+			}
+		})
+	}
+}
+
+func newTestTrackH264() *gortsplib.TrackH264 {
+	return &gortsplib.TrackH264{
+		PayloadType: 96,
+		SPS:         []byte{0x67, 0x01, 0x02},
+		PPS:         []byte{0x68, 0x01},
+	}
+}
+
+func fuaPacket(seq uint16, start bool, end bool, payload []byte) *rtp.Packet {
+	header := byte(0x05) // IDR.
+	if start {
+		header |= 0x80
+	}
+	if end {
+		header |= 0x40
+	}
+	return &rtp.Packet{
+		Header: rtp.Header{
+			Version:        2,
+			Marker:         end,
+			PayloadType:    96,
+			SequenceNumber: seq,
+			Timestamp:      1000,
+			SSRC:           1,
+		},
+		Payload: append([]byte{0x7c, header}, payload...),
+	}
+}
+
+func TestStreamTrackH264(t *testing.T) {
+	t.Run("packetLoss", func(t *testing.T) {
+		track := newStreamTrackH264(newTestTrackH264())
+
+		data1 := &dataH264{rtpPackets: []*rtp.Packet{fuaPacket(1, true, false, []byte{1})}}
+		require.NoError(t, track.onData(data1))
+		require.Nil(t, data1.nalus)
+
+		// Packet 2 is lost, the NALU must not be decoded.
+		data3 := &dataH264{rtpPackets: []*rtp.Packet{fuaPacket(3, false, true, []byte{3})}}
+		require.NoError(t, track.onData(data3))
+		require.Nil(t, data3.nalus)
+		require.Len(t, data3.rtpPackets, 1)
+
+		data4 := &dataH264{rtpPackets: []*rtp.Packet{fuaPacket(4, true, false, []byte{4})}}
+		require.NoError(t, track.onData(data4))
+		data5 := &dataH264{rtpPackets: []*rtp.Packet{fuaPacket(5, false, true, []byte{5})}}
+		require.NoError(t, track.onData(data5))
+		require.Equal(t, [][]byte{{0x67, 0x01, 0x02}, {0x68, 0x01}, {0x65, 4, 5}}, data5.nalus)
+
+		// Late packets are discarded.
+		data2 := &dataH264{rtpPackets: []*rtp.Packet{fuaPacket(2, false, false, []byte{2})}}
+		require.NoError(t, track.onData(data2))
+		require.Nil(t, data2.rtpPackets)
+	})
+	t.Run("reencodeOversized", func(t *testing.T) {
+		track := newStreamTrackH264(newTestTrackH264())
+
+		big := bytes.Repeat([]byte{0xaa}, maxPacketSize)
+		data1 := &dataH264{rtpPackets: []*rtp.Packet{fuaPacket(1, true, false, big)}}
+		require.NoError(t, track.onData(data1))
+		require.NotNil(t, track.encoder)
+		require.Nil(t, data1.rtpPackets, "oversized packet was routed")
+
+		data2 := &dataH264{rtpPackets: []*rtp.Packet{fuaPacket(2, false, true, big)}}
+		require.NoError(t, track.onData(data2))
+		require.NotEmpty(t, data2.rtpPackets)
+		for _, pkt := range data2.rtpPackets {
+			require.LessOrEqual(t, pkt.MarshalSize(), maxPacketSize)
+		}
+	})
+}
+
+func aacPacket(seq uint16, ts uint32, marker bool, au []byte) *rtp.Packet {
+	// AU-headers-length: 16 bits, AU-size: 13 bits, AU-index: 3 bits.
+	payload := []byte{0x00, 0x10, byte(len(au) >> 5), byte(len(au) << 3)}
+	return &rtp.Packet{
+		Header: rtp.Header{
+			Version:        2,
+			Marker:         marker,
+			PayloadType:    96,
+			SequenceNumber: seq,
+			Timestamp:      ts,
+			SSRC:           1,
+		},
+		Payload: append(payload, au...),
+	}
+}
+
+func TestStreamTrackMPEG4AudioPacketLoss(t *testing.T) {
+	track := newStreamTrackMPEG4Audio(&gortsplib.TrackMPEG4Audio{
+		PayloadType: 96,
+		Config: &mpeg4audio.Config{
+			Type:         mpeg4audio.ObjectTypeAACLC,
+			SampleRate:   48000,
+			ChannelCount: 2,
+		},
+		SizeLength:       13,
+		IndexLength:      3,
+		IndexDeltaLength: 3,
+	})
+
+	onData := func(pkt *rtp.Packet) *dataMPEG4Audio {
+		data := &dataMPEG4Audio{rtpPackets: []*rtp.Packet{pkt}}
+		require.NoError(t, track.onData(data))
+		return data
+	}
+
+	require.Nil(t, onData(aacPacket(1, 1000, false, []byte{1, 1})).aus)
+
+	// Packet 2 is lost, the rest of the AU is discarded.
+	require.Nil(t, onData(aacPacket(3, 1000, false, []byte{3, 3})).aus)
+	require.Nil(t, onData(aacPacket(4, 1000, true, []byte{4, 4})).aus)
+
+	require.Equal(t, [][]byte{{5, 5}}, onData(aacPacket(5, 2024, true, []byte{5, 5})).aus)
+}
@@ -0,0 +1,47 @@
+package webrtc
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"time"
+
+	pwebrtc "github.com/pion/webrtc/v3"
+)
+
+// Certificate is the self-signed DTLS certificate of the server. Browsers
+// don't validate it against a CA, the fingerprint in the SDP answer is
+// what authenticates the server.
+type Certificate struct {
+	cert *pwebrtc.Certificate
+}
+
+// GenerateCertificate generates a ECDSA P-256 certificate.
+func GenerateCertificate() (*Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("generate key: %w", err)
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 64))
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	template := x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: "nvr"},
+		NotBefore:    now.Add(-24 * time.Hour),
+		NotAfter:     now.Add(365 * 24 * time.Hour),
+	}
+
+	cert, err := pwebrtc.NewCertificate(key, template)
+	if err != nil {
+		return nil, fmt.Errorf("create certificate: %w", err)
+	}
+	return &Certificate{cert: cert}, nil
+}
@@ -0,0 +1,78 @@
+package webrtc
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pion/sdp/v3"
+)
+
+// Offer errors.
+var (
+	ErrOfferNoVideo = errors.New("offer has no receiving video section")
+	ErrOfferNoH264  = errors.New("offer doesn't support H264 with packetization mode 1")
+)
+
+// parseOffer returns the H264 payload type and format parameters of the
+// first receiving video section. The rest of the offer is validated when
+// it's set as the remote description.
+func parseOffer(raw []byte) (uint8, string, error) {
+	var desc sdp.SessionDescription
+	if err := desc.Unmarshal(raw); err != nil {
+		return 0, "", fmt.Errorf("unmarshal offer: %w", err)
+	}
+
+	for _, md := range desc.MediaDescriptions {
+		if md.MediaName.Media != "video" || md.MediaName.Port.Value == 0 {
+			continue
+		}
+		if _, exist := md.Attribute("sendonly"); exist {
+			continue
+		}
+		if _, exist := md.Attribute("inactive"); exist {
+			continue
+		}
+
+		pt, fmtp, found := findH264(md)
+		if !found {
+			return 0, "", ErrOfferNoH264
+		}
+		return pt, fmtp, nil
+	}
+	return 0, "", ErrOfferNoVideo
+}
+
+// findH264 returns the first H264 payload type with
+// packetization mode 1, the only mode that is sent.
+func findH264(md *sdp.MediaDescription) (uint8, string, bool) {
+	fmtps := make(map[string]string)
+	for _, attr := range md.Attributes {
+		if attr.Key != "fmtp" {
+			continue
+		}
+		pt, params, _ := strings.Cut(attr.Value, " ")
+		fmtps[pt] = params
+	}
+
+	for _, attr := range md.Attributes {
+		if attr.Key != "rtpmap" {
+			continue
+		}
+		pt, encoding, _ := strings.Cut(attr.Value, " ")
+		if !strings.EqualFold(encoding, "H264/90000") {
+			continue
+		}
+		fmtp := fmtps[pt]
+		if !strings.Contains(fmtp, "packetization-mode=1") {
+			continue
+		}
+		v, err := strconv.ParseUint(pt, 10, 8)
+		if err != nil {
+			continue
+		}
+		return uint8(v), fmtp, true
+	}
+	return 0, "", false
+}
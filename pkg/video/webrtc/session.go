@@ -0,0 +1,241 @@
+// Package webrtc sends a single H264 video track to WebRTC
+// peers. The server is a ICE-lite peer built on pion/webrtc.
+package webrtc
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/pion/ice/v2"
+	"github.com/pion/rtp"
+	pwebrtc "github.com/pion/webrtc/v3"
+)
+
+const (
+	// Packets are dropped if the writer falls behind.
+	packetBufferSize = 512
+
+	readBufferSize = 1500
+)
+
+// Session errors.
+var (
+	ErrConnectionFailed = errors.New("connection failed")
+	ErrSessionClosed    = errors.New("session closed")
+)
+
+// Session sends a H264 video track to a WebRTC peer.
+type Session struct {
+	pc    *pwebrtc.PeerConnection
+	track *pwebrtc.TrackLocalStaticRTP
+
+	packets   chan *rtp.Packet
+	ready     chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+	err       error
+}
+
+// NewSession creates a session from a SDP offer and returns the
+// answer. Host candidates are only gathered for the IPs in ips.
+func NewSession(rawOffer []byte, cert *Certificate, ips []net.IP) (*Session, []byte, error) {
+	payloadType, fmtp, err := parseOffer(rawOffer)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Only the offered H264 format is registered,
+	// the other sections of the offer are rejected.
+	codec := pwebrtc.RTPCodecCapability{
+		MimeType:    pwebrtc.MimeTypeH264,
+		ClockRate:   90000,
+		SDPFmtpLine: fmtp,
+	}
+	m := &pwebrtc.MediaEngine{}
+	err = m.RegisterCodec(pwebrtc.RTPCodecParameters{
+		RTPCodecCapability: codec,
+		PayloadType:        pwebrtc.PayloadType(payloadType),
+	}, pwebrtc.RTPCodecTypeVideo)
+	if err != nil {
+		return nil, nil, fmt.Errorf("register codec: %w", err)
+	}
+
+	api := pwebrtc.NewAPI(
+		pwebrtc.WithMediaEngine(m),
+		pwebrtc.WithSettingEngine(settingEngine(ips)),
+	)
+	pc, err := api.NewPeerConnection(pwebrtc.Configuration{
+		Certificates: []pwebrtc.Certificate{*cert.cert},
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("peer connection: %w", err)
+	}
+
+	s := &Session{
+		pc:      pc,
+		packets: make(chan *rtp.Packet, packetBufferSize),
+		ready:   make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	answer, sender, err := s.negotiate(rawOffer, codec)
+	if err != nil {
+		pc.Close()
+		return nil, nil, err
+	}
+
+	pc.OnConnectionStateChange(func(state pwebrtc.PeerConnectionState) {
+		switch state { //nolint:exhaustive
+		case pwebrtc.PeerConnectionStateFailed:
+			s.close(ErrConnectionFailed)
+		case pwebrtc.PeerConnectionStateClosed:
+			s.close(ErrSessionClosed)
+		}
+	})
+
+	go s.readLoop(sender)
+	go s.writeLoop()
+
+	return s, answer, nil
+}
+
+func settingEngine(ips []net.IP) pwebrtc.SettingEngine {
+	includeLoopback := false
+	for _, ip := range ips {
+		if ip.IsLoopback() {
+			includeLoopback = true
+		}
+	}
+
+	var se pwebrtc.SettingEngine
+	se.SetLite(true)
+	se.SetICEMulticastDNSMode(ice.MulticastDNSModeDisabled)
+	se.SetNetworkTypes([]pwebrtc.NetworkType{
+		pwebrtc.NetworkTypeUDP4,
+		pwebrtc.NetworkTypeUDP6,
+	})
+	se.SetIncludeLoopbackCandidate(includeLoopback)
+	se.SetIPFilter(func(ip net.IP) bool {
+		for _, ip2 := range ips {
+			if ip.Equal(ip2) {
+				return true
+			}
+		}
+		return false
+	})
+	return se
+}
+
+// negotiate returns the answer once all the candidates are gathered,
+// trickle ICE isn't supported.
+func (s *Session) negotiate(
+	rawOffer []byte,
+	codec pwebrtc.RTPCodecCapability,
+) ([]byte, *pwebrtc.RTPSender, error) {
+	track, err := pwebrtc.NewTrackLocalStaticRTP(codec, "video", "nvr")
+	if err != nil {
+		return nil, nil, fmt.Errorf("track: %w", err)
+	}
+	s.track = track
+
+	sender, err := s.pc.AddTrack(track)
+	if err != nil {
+		return nil, nil, fmt.Errorf("add track: %w", err)
+	}
+
+	err = s.pc.SetRemoteDescription(pwebrtc.SessionDescription{
+		Type: pwebrtc.SDPTypeOffer,
+		SDP:  string(rawOffer),
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("set offer: %w", err)
+	}
+
+	answer, err := s.pc.CreateAnswer(nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("create answer: %w", err)
+	}
+
+	gatherComplete := pwebrtc.GatheringCompletePromise(s.pc)
+	if err := s.pc.SetLocalDescription(answer); err != nil {
+		return nil, nil, fmt.Errorf("set answer: %w", err)
+	}
+	<-gatherComplete
+
+	return []byte(s.pc.LocalDescription().SDP), sender, nil
+}
+
+// WritePacketRTP queues a packet, it never blocks. The
+// packet is shared with other readers and isn't modified.
+func (s *Session) WritePacketRTP(pkt *rtp.Packet) {
+	select {
+	case s.packets <- pkt:
+	default:
+	}
+}
+
+// Ready returns true once the DTLS handshake is done
+// and written packets are sent to the peer.
+func (s *Session) Ready() bool {
+	select {
+	case <-s.ready:
+		return true
+	default:
+		return false
+	}
+}
+
+// Done is closed when the session is closed.
+func (s *Session) Done() <-chan struct{} {
+	return s.done
+}
+
+// Err returns the reason the session was closed.
+func (s *Session) Err() error {
+	<-s.done
+	return s.err
+}
+
+// Close closes the session.
+func (s *Session) Close() {
+	s.close(ErrSessionClosed)
+}
+
+func (s *Session) close(err error) {
+	s.closeOnce.Do(func() {
+		s.err = err
+		close(s.done)
+		s.pc.Close() //nolint:errcheck
+	})
+}
+
+// readLoop marks the session as ready and discards RTCP feedback.
+func (s *Session) readLoop(sender *pwebrtc.RTPSender) {
+	// Blocks until the SRTP session is started, packets written
+	// before then are dropped by the sender.
+	if err := sender.SetReadDeadline(time.Time{}); err != nil {
+		return
+	}
+	close(s.ready)
+
+	buf := make([]byte, readBufferSize)
+	for {
+		if _, _, err := sender.Read(buf); err != nil {
+			return
+		}
+	}
+}
+
+func (s *Session) writeLoop() {
+	for {
+		select {
+		case <-s.done:
+			return
+		case pkt := <-s.packets:
+			// The track sets the payload type and SSRC of a copy.
+			s.track.WriteRTP(pkt) //nolint:errcheck
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package webrtc
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/pion/sdp/v3"
+	pwebrtc "github.com/pion/webrtc/v3"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestPeer returns a browser-like peer that receives
+// audio and video, and its offer.
+func newTestPeer(t *testing.T) (*pwebrtc.PeerConnection, []byte) {
+	t.Helper()
+	m := &pwebrtc.MediaEngine{}
+	require.NoError(t, m.RegisterDefaultCodecs())
+
+	var se pwebrtc.SettingEngine
+	se.SetIncludeLoopbackCandidate(true)
+	se.SetNetworkTypes([]pwebrtc.NetworkType{pwebrtc.NetworkTypeUDP4})
+
+	api := pwebrtc.NewAPI(pwebrtc.WithMediaEngine(m), pwebrtc.WithSettingEngine(se))
+	pc, err := api.NewPeerConnection(pwebrtc.Configuration{})
+	require.NoError(t, err)
+	t.Cleanup(func() { pc.Close() })
+
+	recvOnly := pwebrtc.RTPTransceiverInit{Direction: pwebrtc.RTPTransceiverDirectionRecvonly}
+	_, err = pc.AddTransceiverFromKind(pwebrtc.RTPCodecTypeAudio, recvOnly)
+	require.NoError(t, err)
+	_, err = pc.AddTransceiverFromKind(pwebrtc.RTPCodecTypeVideo, recvOnly)
+	require.NoError(t, err)
+
+	offer, err := pc.CreateOffer(nil)
+	require.NoError(t, err)
+	gatherComplete := pwebrtc.GatheringCompletePromise(pc)
+	require.NoError(t, pc.SetLocalDescription(offer))
+	<-gatherComplete
+
+	return pc, []byte(pc.LocalDescription().SDP)
+}
+
+func TestSession(t *testing.T) {
+	cert, err := GenerateCertificate()
+	require.NoError(t, err)
+	localhost := []net.IP{net.IPv4(127, 0, 0, 1)}
+
+	t.Run("ok", func(t *testing.T) {
+		peer, offer := newTestPeer(t)
+		session, answer, err := NewSession(offer, cert, localhost)
+		require.NoError(t, err)
+		defer session.Close()
+
+		var desc sdp.SessionDescription
+		require.NoError(t, desc.Unmarshal(answer))
+		require.Len(t, desc.MediaDescriptions, 2)
+		require.Equal(t, 0, desc.MediaDescriptions[0].MediaName.Port.Value)
+		_, sendOnly := desc.MediaDescriptions[1].Attribute("sendonly")
+		require.True(t, sendOnly)
+
+		tracks := make(chan *pwebrtc.TrackRemote, 1)
+		peer.OnTrack(func(track *pwebrtc.TrackRemote, _ *pwebrtc.RTPReceiver) {
+			tracks <- track
+		})
+		require.NoError(t, peer.SetRemoteDescription(pwebrtc.SessionDescription{
+			Type: pwebrtc.SDPTypeAnswer,
+			SDP:  string(answer),
+		}))
+
+		require.Eventually(t, session.Ready, 5*time.Second, 10*time.Millisecond)
+
+		pkt := &rtp.Packet{
+			Header: rtp.Header{
+				Version:        2,
+				PayloadType:    96,
+				SequenceNumber: 1000,
+				Timestamp:      3000,
+				SSRC:           1234,
+				Marker:         true,
+			},
+			Payload: []byte{0x65, 1, 2, 3},
+		}
+		session.WritePacketRTP(pkt)
+
+		var track *pwebrtc.TrackRemote
+		select {
+		case track = <-tracks:
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout")
+		}
+		require.Equal(t, pwebrtc.MimeTypeH264, track.Codec().MimeType)
+
+		received, _, err := track.ReadRTP()
+		require.NoError(t, err)
+		require.Equal(t, uint8(track.PayloadType()), received.PayloadType)
+		require.NotEqual(t, uint32(1234), received.SSRC)
+		require.Equal(t, uint16(1000), received.SequenceNumber)
+		require.Equal(t, uint32(3000), received.Timestamp)
+		require.Equal(t, []byte{0x65, 1, 2, 3}, received.Payload)
+
+		// The shared packet isn't modified.
+		require.Equal(t, uint32(1234), pkt.SSRC)
+
+		session.Close()
+		require.ErrorIs(t, session.Err(), ErrSessionClosed)
+	})
+	t.Run("noH264", func(t *testing.T) {
+		offer := []byte("v=0\r\n" +
+			"o=- 1 2 IN IP4 127.0.0.1\r\n" +
+			"s=-\r\n" +
+			"t=0 0\r\n" +
+			"m=video 9 UDP/TLS/RTP/SAVPF 96\r\n" +
+			"c=IN IP4 0.0.0.0\r\n" +
+			"a=recvonly\r\n" +
+			"a=rtpmap:96 VP8/90000\r\n")
+		_, _, err := NewSession(offer, cert, localhost)
+		require.ErrorIs(t, err, ErrOfferNoH264)
+	})
+}
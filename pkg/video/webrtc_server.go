@@ -0,0 +1,299 @@
+package video
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"nvr/pkg/log"
+	"nvr/pkg/video/gortsplib"
+	"nvr/pkg/video/gortsplib/pkg/h264"
+	"nvr/pkg/video/webrtc"
+	"strings"
+	"sync"
+)
+
+const (
+	whepPrefix       = "/api/webrtc/whep/"
+	whepMaxOfferSize = 64 * 1024
+)
+
+// webrtcServer serves the H264 track of paths over WebRTC. Sessions are
+// created with WHEP, draft-ietf-wish-whep. The answer has all the host
+// candidates, trickle ICE isn't supported.
+type webrtcServer struct {
+	pathManager *pathManager
+
+	cert     *webrtc.Certificate
+	sessions map[string]*webrtcReader
+	mu       sync.Mutex
+}
+
+func newWebRTCServer(pathManager *pathManager) *webrtcServer {
+	return &webrtcServer{
+		pathManager: pathManager,
+		sessions:    make(map[string]*webrtcReader),
+	}
+}
+
+func (s *webrtcServer) start(ctx context.Context) error {
+	cert, err := webrtc.GenerateCertificate()
+	if err != nil {
+		return fmt.Errorf("webrtc certificate: %w", err)
+	}
+	s.mu.Lock()
+	s.cert = cert
+	s.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for _, reader := range s.sessions {
+			reader.session.Close()
+		}
+	}()
+	return nil
+}
+
+// HandleWHEP handles "POST /api/webrtc/whep/<path>" requests that create
+// sessions and "DELETE /api/webrtc/whep/<path>/<session>" requests.
+func (s *webrtcServer) HandleWHEP() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		resource := strings.TrimPrefix(r.URL.Path, whepPrefix)
+		if resource == "" || resource == r.URL.Path {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodPost:
+			s.createSession(w, r, resource)
+
+		case http.MethodDelete:
+			i := strings.LastIndex(resource, "/")
+			if i == -1 {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			s.deleteSession(w, resource[:i], resource[i+1:])
+
+		default:
+			// Trickle ICE and ICE restarts aren't supported.
+			w.Header().Set("Allow", "POST, DELETE")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// ErrNoH264Track path doesn't have a H264 track.
+var ErrNoH264Track = errors.New("path doesn't have a H264 track")
+
+func (s *webrtcServer) createSession(w http.ResponseWriter, r *http.Request, pathName string) {
+	contentType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if contentType != "application/sdp" {
+		http.Error(w, "Content-Type must be application/sdp", http.StatusUnsupportedMediaType)
+		return
+	}
+	offer, err := io.ReadAll(io.LimitReader(r.Body, whepMaxOfferSize))
+	if err != nil {
+		http.Error(w, "could not read offer", http.StatusBadRequest)
+		return
+	}
+
+	stream, err := s.pathManager.streamGet(pathName)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	trackID := h264TrackID(stream.tracks())
+	if trackID == -1 {
+		http.Error(w, ErrNoH264Track.Error(), http.StatusNotFound)
+		return
+	}
+
+	s.mu.Lock()
+	cert := s.cert
+	s.mu.Unlock()
+	if cert == nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	session, answer, err := webrtc.NewSession(offer, cert, webrtcCandidateIPs(r))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid offer: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	id, err := randomSessionID()
+	if err != nil {
+		session.Close()
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	reader := &webrtcReader{
+		pathName: pathName,
+		session:  session,
+		trackID:  trackID,
+	}
+	if err := stream.webrtcReaderAdd(reader); err != nil {
+		session.Close()
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	s.mu.Lock()
+	s.sessions[id] = reader
+	s.mu.Unlock()
+
+	go func() {
+		<-session.Done()
+		stream.webrtcReaderRemove(reader)
+
+		s.mu.Lock()
+		delete(s.sessions, id)
+		s.mu.Unlock()
+
+		if logf := s.pathManager.pathLogfByName(pathName); logf != nil {
+			logf(log.LevelDebug, "webrtc: session closed: %v", session.Err())
+		}
+	}()
+
+	w.Header().Set("Content-Type", "application/sdp")
+	w.Header().Set("Location", whepPrefix+pathName+"/"+id)
+	w.WriteHeader(http.StatusCreated)
+	w.Write(answer) //nolint:errcheck
+}
+
+func (s *webrtcServer) deleteSession(w http.ResponseWriter, pathName string, id string) {
+	s.mu.Lock()
+	reader, exist := s.sessions[id]
+	s.mu.Unlock()
+
+	if !exist || reader.pathName != pathName {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	reader.session.Close()
+	w.WriteHeader(http.StatusOK)
+}
+
+func h264TrackID(tracks gortsplib.Tracks) int {
+	for i, track := range tracks {
+		if _, ok := track.(*gortsplib.TrackH264); ok {
+			return i
+		}
+	}
+	return -1
+}
+
+func randomSessionID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// webrtcCandidateIPs returns the address that the request was sent to
+// followed by the addresses of the network interfaces. Loopback addresses
+// are only included for local clients.
+func webrtcCandidateIPs(r *http.Request) []net.IP {
+	var ips []net.IP
+	add := func(ip net.IP) {
+		if ip == nil || ip.IsUnspecified() || ip.IsLinkLocalUnicast() {
+			return
+		}
+		if ip.IsLoopback() && !isLoopback(r.RemoteAddr) {
+			return
+		}
+		for _, ip2 := range ips {
+			if ip.Equal(ip2) {
+				return
+			}
+		}
+		ips = append(ips, ip)
+	}
+
+	if addr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+		if tcpAddr, ok := addr.(*net.TCPAddr); ok {
+			add(tcpAddr.IP)
+		}
+	}
+
+	addrs, _ := net.InterfaceAddrs()
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok {
+			add(ipNet.IP)
+		}
+	}
+	return ips
+}
+
+// webrtcReader forwards the H264 track of a stream to a WebRTC session.
+type webrtcReader struct {
+	pathName string
+	session  *webrtc.Session
+	trackID  int
+
+	// Packets are forwarded from the first random access
+	// point after the session is ready.
+	started bool
+}
+
+// onData is called by stream.
+func (r *webrtcReader) onData(dat data) {
+	if dat.getTrackID() != r.trackID {
+		return
+	}
+	for _, pkt := range dat.getRTPPackets() {
+		if !r.started {
+			// Packets written before the handshake is done are dropped.
+			if !r.session.Ready() || !h264RandomAccess(pkt.Payload) {
+				continue
+			}
+			r.started = true
+		}
+		r.session.WritePacketRTP(pkt)
+	}
+}
+
+// h264RandomAccess returns true if the RTP payload starts
+// with a SPS or IDR NALU, packetization mode 1.
+func h264RandomAccess(payload []byte) bool {
+	isRandomAccess := func(typ h264.NALUType) bool {
+		return typ == h264.NALUTypeSPS || typ == h264.NALUTypeIDR
+	}
+	if len(payload) < 2 {
+		return false
+	}
+
+	switch typ := payload[0] & 0x1F; typ {
+	case 24: // STAP-A
+		payload = payload[1:]
+		for len(payload) > 2 {
+			size := int(payload[0])<<8 | int(payload[1])
+			if size == 0 || size > len(payload)-2 {
+				return false
+			}
+			if isRandomAccess(h264.NALUType(payload[2] & 0x1F)) {
+				return true
+			}
+			payload = payload[2+size:]
+		}
+		return false
+
+	case 28: // FU-A
+		start := payload[1]&0x80 != 0
+		return start && isRandomAccess(h264.NALUType(payload[1]&0x1F))
+
+	default:
+		return isRandomAccess(h264.NALUType(typ))
+	}
+}
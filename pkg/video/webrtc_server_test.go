@@ -0,0 +1,105 @@
+package video
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"nvr/pkg/video/webrtc"
+	"strings"
+	"testing"
+
+	"github.com/pion/rtp"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebRTCServerHandleWHEP(t *testing.T) {
+	s, cancel := newTestServer(t)
+	defer cancel()
+
+	ctx, cancel2 := context.WithCancel(context.Background())
+	defer cancel2()
+	_, err := s.NewPath(ctx, "x", PathConf{MonitorID: "x"})
+	require.NoError(t, err)
+
+	w := newWebRTCServer(s.pathManager)
+	require.NoError(t, w.start(ctx))
+
+	serve := func(method string, path string, contentType string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, strings.NewReader("v=0\r\n"))
+		r.Header.Set("Content-Type", contentType)
+		rec := httptest.NewRecorder()
+		w.HandleWHEP().ServeHTTP(rec, r)
+		return rec
+	}
+
+	require.Equal(t, http.StatusBadRequest,
+		serve(http.MethodPost, "/api/webrtc/whep/", "application/sdp").Code)
+	require.Equal(t, http.StatusUnsupportedMediaType,
+		serve(http.MethodPost, "/api/webrtc/whep/x", "text/plain").Code)
+	require.Equal(t, http.StatusNotFound,
+		serve(http.MethodPost, "/api/webrtc/whep/nil", "application/sdp").Code)
+
+	// No one is publishing.
+	require.Equal(t, http.StatusNotFound,
+		serve(http.MethodPost, "/api/webrtc/whep/x", "application/sdp").Code)
+
+	require.Equal(t, http.StatusNotFound,
+		serve(http.MethodDelete, "/api/webrtc/whep/x/abc", "").Code)
+
+	res := serve(http.MethodPatch, "/api/webrtc/whep/x/abc", "application/trickle-ice-sdpfrag")
+	require.Equal(t, http.StatusMethodNotAllowed, res.Code)
+	require.Equal(t, "POST, DELETE", res.Header().Get("Allow"))
+}
+
+func TestH264RandomAccess(t *testing.T) {
+	cases := []struct {
+		name     string
+		payload  []byte
+		expected bool
+	}{
+		{"sps", []byte{0x67, 1}, true},
+		{"idr", []byte{0x65, 1}, true},
+		{"nonIDR", []byte{0x41, 1}, false},
+		{"stapA", []byte{0x18, 0, 2, 0x09, 0, 0, 2, 0x67, 1}, true},
+		{"stapANonIDR", []byte{0x18, 0, 2, 0x41, 1}, false},
+		{"stapAInvalid", []byte{0x18, 0, 9, 0x67, 1}, false},
+		{"fuAStart", []byte{0x7c, 0x85, 1}, true},
+		{"fuAMiddle", []byte{0x7c, 0x05, 1}, false},
+		{"fuANonIDR", []byte{0x7c, 0x81, 1}, false},
+		{"empty", nil, false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.expected, h264RandomAccess(tc.payload))
+		})
+	}
+}
+
+func TestWebRTCReaderNotReady(t *testing.T) {
+	cert, err := webrtc.GenerateCertificate()
+	require.NoError(t, err)
+	offer := "v=0\r\n" +
+		"o=- 1 2 IN IP4 127.0.0.1\r\n" +
+		"s=-\r\n" +
+		"t=0 0\r\n" +
+		"a=fingerprint:sha-256 " + strings.TrimSuffix(strings.Repeat("AA:", 32), ":") + "\r\n" +
+		"m=video 9 UDP/TLS/RTP/SAVPF 102\r\n" +
+		"c=IN IP4 0.0.0.0\r\n" +
+		"a=mid:0\r\n" +
+		"a=ice-ufrag:peer\r\n" +
+		"a=ice-pwd:peerpassword0123456789\r\n" +
+		"a=setup:actpass\r\n" +
+		"a=recvonly\r\n" +
+		"a=rtcp-mux\r\n" +
+		"a=rtpmap:102 H264/90000\r\n" +
+		"a=fmtp:102 packetization-mode=1;profile-level-id=42001f\r\n"
+	session, _, err := webrtc.NewSession([]byte(offer), cert, []net.IP{net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	defer session.Close()
+
+	// The keyframe would be dropped before the handshake is done.
+	r := &webrtcReader{session: session}
+	r.onData(&dataH264{rtpPackets: []*rtp.Packet{{Payload: []byte{0x65, 1}}}})
+	require.False(t, r.started)
+}
@@ -0,0 +1,283 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+	"nvr/pkg/group"
+	"nvr/pkg/monitor"
+	"nvr/pkg/web/auth"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ACL restricts users to the monitors they are allowed to access.
+type ACL struct {
+	auth   auth.Authenticator
+	groups func() map[string]group.Config
+}
+
+// NewACL creates a new access control list.
+func NewACL(a auth.Authenticator, groups func() map[string]group.Config) *ACL {
+	return &ACL{
+		auth:   a,
+		groups: groups,
+	}
+}
+
+// MonitorAccess set of monitors that a user can access.
+type MonitorAccess struct {
+	all      bool
+	monitors map[string]struct{}
+	groups   map[string]struct{}
+}
+
+// Allowed returns true if the monitor can be accessed.
+func (m MonitorAccess) Allowed(monitorID string) bool {
+	if m.all {
+		return true
+	}
+	_, exists := m.monitors[monitorID]
+	return exists
+}
+
+// Filter returns the monitor IDs that can be accessed.
+// All accessible IDs are returned if ids is empty.
+func (m MonitorAccess) Filter(ids []string) []string {
+	var filtered []string
+	if len(ids) == 0 {
+		for id := range m.monitors {
+			filtered = append(filtered, id)
+		}
+		sort.Strings(filtered)
+		return filtered
+	}
+	for _, id := range ids {
+		if m.Allowed(id) {
+			filtered = append(filtered, id)
+		}
+	}
+	return filtered
+}
+
+// GroupAllowed returns true if the group can be accessed.
+func (m MonitorAccess) GroupAllowed(groupID string) bool {
+	if m.all {
+		return true
+	}
+	_, exists := m.groups[groupID]
+	return exists
+}
+
+// Access returns the monitors that the user can access.
+func (acl *ACL) Access(user auth.Account) MonitorAccess {
+	if user.IsAdmin || !user.Access.Restricted {
+		return MonitorAccess{all: true}
+	}
+
+	access := MonitorAccess{
+		monitors: make(map[string]struct{}),
+		groups:   make(map[string]struct{}),
+	}
+	for _, id := range user.Access.Monitors {
+		access.monitors[id] = struct{}{}
+	}
+
+	groups := acl.groups()
+	for _, id := range user.Access.Groups {
+		g, exists := groups[id]
+		if !exists {
+			continue
+		}
+		access.groups[id] = struct{}{}
+
+		var monitors []string
+		json.Unmarshal([]byte(g["monitors"]), &monitors) //nolint:errcheck
+		for _, monitorID := range monitors {
+			access.monitors[monitorID] = struct{}{}
+		}
+	}
+	return access
+}
+
+// RequestAccess returns the user and monitor access of the request.
+func (acl *ACL) RequestAccess(r *http.Request) (auth.Account, MonitorAccess) {
+	user := acl.auth.ValidateRequest(r).User
+	return user, acl.Access(user)
+}
+
+// FilterMonitors removes the monitors that the user cannot access.
+func (acl *ACL) FilterMonitors(user auth.Account, monitors monitor.RawConfigs) monitor.RawConfigs {
+	access := acl.Access(user)
+	filtered := make(monitor.RawConfigs)
+	for id, m := range monitors {
+		if access.Allowed(id) {
+			filtered[id] = m
+		}
+	}
+	return filtered
+}
+
+// FilterGroups removes the groups that the user cannot access.
+func (acl *ACL) FilterGroups(user auth.Account, groups map[string]group.Config) map[string]group.Config {
+	access := acl.Access(user)
+	filtered := make(map[string]group.Config)
+	for id, g := range groups {
+		if access.GroupAllowed(id) {
+			filtered[id] = g
+		}
+	}
+	return filtered
+}
+
+// Stream returns true if the request is allowed to read the stream of
+// the path, "<monitorID>" or "<monitorID>_sub". Used by the standalone
+// RTSP and HLS servers. Most RTSP clients only support basic auth,
+// an API key can therefore also be used as the password. Authenticators
+// that don't accept basic auth can check it through auth.BasicLogin.
+func (acl *ACL) Stream(r *http.Request, pathName string) bool {
+	if _, password, ok := r.BasicAuth(); ok && auth.IsAPIKey(password) {
+		r = r.Clone(r.Context())
+		r.Header.Set("Authorization", "Bearer "+password)
+	}
+	res := acl.auth.ValidateRequest(r)
+	if !res.IsValid {
+		res = auth.ValidateBasicAuth(acl.auth, r)
+	}
+	if !res.IsValid {
+		return false
+	}
+	return acl.Access(res.User).Allowed(strings.TrimSuffix(pathName, "_sub"))
+}
+
+// MonitorIDFunc returns the monitor ID of a request.
+type MonitorIDFunc func(r *http.Request) string
+
+// Monitor blocks requests for monitors that the user cannot access.
+func (acl *ACL) Monitor(monitorID MonitorIDFunc, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, access := acl.RequestAccess(r); !access.Allowed(monitorID(r)) {
+			http.Error(w, "access denied", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Recording blocks requests for recordings of monitors that the
+// user cannot access. The recording ID follows the prefix.
+func (acl *ACL) Recording(prefix string, next http.Handler) http.Handler {
+	return acl.Monitor(RecordingMonitorID(prefix), next)
+}
+
+// playbackRangeSize is the largest range that users without
+// the export permission can request at a time.
+const playbackRangeSize = 4 * 1024 * 1024
+
+// RecordingVideo blocks downloads from users that cannot export.
+// These users can only request ranges of up to playbackRangeSize
+// bytes, which is enough for the player but never the full file.
+func (acl *ACL) RecordingVideo(prefix string, next http.Handler) http.Handler {
+	return acl.Recording(prefix, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if acl.auth.ValidateRequest(r).User.CanExport() {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if r.URL.Query().Get("download") == "true" {
+			http.Error(w, "export not allowed", http.StatusForbidden)
+			return
+		}
+		rangeHeader, ok := limitRange(r.Header.Get("Range"), playbackRangeSize)
+		if !ok {
+			http.Error(w, "export not allowed, range request required",
+				http.StatusForbidden)
+			return
+		}
+		r2 := r.Clone(r.Context())
+		r2.Header.Set("Range", rangeHeader)
+		// A failed If-Range would serve the full file.
+		r2.Header.Del("If-Range")
+		next.ServeHTTP(w, r2)
+	}))
+}
+
+// limitRange returns a single byte range limited to max bytes.
+// Returns false if the header isn't a single byte range.
+func limitRange(s string, max int64) (string, bool) {
+	if !strings.HasPrefix(s, "bytes=") || strings.Contains(s, ",") {
+		return "", false
+	}
+	spec := strings.TrimPrefix(s, "bytes=")
+	startStr, endStr, ok := strings.Cut(strings.TrimSpace(spec), "-")
+	if !ok {
+		return "", false
+	}
+	if startStr == "" {
+		// Suffix range "-n".
+		n, err := strconv.ParseInt(endStr, 10, 64)
+		if err != nil || n < 0 {
+			return "", false
+		}
+		if n > max {
+			n = max
+		}
+		return "bytes=-" + strconv.FormatInt(n, 10), true
+	}
+
+	start, err := strconv.ParseInt(startStr, 10, 64)
+	if err != nil || start < 0 {
+		return "", false
+	}
+	end := start + max - 1
+	if endStr != "" {
+		e, err := strconv.ParseInt(endStr, 10, 64)
+		if err != nil || e < start {
+			return "", false
+		}
+		if e < end {
+			end = e
+		}
+	}
+	return "bytes=" + strconv.FormatInt(start, 10) + "-" + strconv.FormatInt(end, 10), true
+}
+
+// RecordingDelete blocks requests from users that cannot delete.
+func (acl *ACL) RecordingDelete(prefix string, next http.Handler) http.Handler {
+	return acl.Recording(prefix, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !acl.auth.ValidateRequest(r).User.CanDelete() {
+			http.Error(w, "delete not allowed", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}))
+}
+
+// RecordingMonitorID returns the monitor ID of the recording after prefix.
+func RecordingMonitorID(prefix string) MonitorIDFunc {
+	return func(r *http.Request) string {
+		return recordingMonitorID(strings.TrimPrefix(r.URL.Path, prefix))
+	}
+}
+
+// QueryMonitorID returns the "id" query parameter.
+func QueryMonitorID(r *http.Request) string {
+	return r.URL.Query().Get("id")
+}
+
+// HLSMonitorID returns the monitor ID of a "/hls/<id>/..." or
+// "/hls/<id>_sub/..." request.
+func HLSMonitorID(r *http.Request) string {
+	path := strings.TrimPrefix(r.URL.Path, "/hls/")
+	name, _, _ := strings.Cut(path, "/")
+	return strings.TrimSuffix(name, "_sub")
+}
+
+// WHEPMonitorID returns the monitor ID of a "/api/webrtc/whep/<id>" or
+// "/api/webrtc/whep/<id>_sub/<session>" request.
+func WHEPMonitorID(r *http.Request) string {
+	path := strings.TrimPrefix(r.URL.Path, "/api/webrtc/whep/")
+	name, _, _ := strings.Cut(path, "/")
+	return strings.TrimSuffix(name, "_sub")
+}
@@ -0,0 +1,301 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"nvr/pkg/group"
+	"nvr/pkg/monitor"
+	"nvr/pkg/web/auth"
+
+	"github.com/stretchr/testify/require"
+)
+
+// stubAuth authenticates every request as user.
+type stubAuth struct {
+	auth.Authenticator
+	user auth.Account
+}
+
+func (a stubAuth) ValidateRequest(*http.Request) auth.ValidateResponse {
+	return auth.ValidateResponse{IsValid: true, User: a.user}
+}
+
+func newTestACL(user auth.Account) *ACL {
+	groups := func() map[string]group.Config {
+		return map[string]group.Config{
+			"g1": {"id": "g1", "monitors": `["m2","m3"]`},
+			"g2": {"id": "g2", "monitors": `["m4"]`},
+		}
+	}
+	return NewACL(stubAuth{user: user}, groups)
+}
+
+var restrictedUser = auth.Account{
+	ID: "1",
+	Access: auth.Access{
+		Restricted: true,
+		Monitors:   []string{"m1"},
+		Groups:     []string{"g1", "missing"},
+	},
+}
+
+func TestACLAccess(t *testing.T) {
+	t.Run("restricted", func(t *testing.T) {
+		access := newTestACL(restrictedUser).Access(restrictedUser)
+		require.True(t, access.Allowed("m1"))
+		require.True(t, access.Allowed("m2"))
+		require.True(t, access.Allowed("m3"))
+		require.False(t, access.Allowed("m4"))
+		require.True(t, access.GroupAllowed("g1"))
+		require.False(t, access.GroupAllowed("g2"))
+		require.False(t, access.GroupAllowed("missing"))
+
+		require.Equal(t, []string{"m1", "m2", "m3"}, access.Filter(nil))
+		require.Equal(t, []string{"m3"}, access.Filter([]string{"m3", "m4"}))
+	})
+	t.Run("unrestricted", func(t *testing.T) {
+		user := auth.Account{ID: "1"}
+		access := newTestACL(user).Access(user)
+		require.True(t, access.Allowed("m4"))
+		require.True(t, access.GroupAllowed("g2"))
+	})
+	t.Run("admin", func(t *testing.T) {
+		user := restrictedUser
+		user.IsAdmin = true
+		access := newTestACL(user).Access(user)
+		require.True(t, access.Allowed("m4"))
+	})
+	t.Run("filterMonitors", func(t *testing.T) {
+		monitors := monitor.RawConfigs{"m1": {}, "m4": {}}
+		filtered := newTestACL(restrictedUser).FilterMonitors(restrictedUser, monitors)
+		require.Equal(t, monitor.RawConfigs{"m1": {}}, filtered)
+	})
+}
+
+func TestACLHandlers(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	cases := []struct {
+		name     string
+		user     auth.Account
+		handler  func(*ACL) http.Handler
+		url      string
+		expected int
+	}{
+		{
+			"hlsOk",
+			restrictedUser,
+			func(acl *ACL) http.Handler { return acl.Monitor(HLSMonitorID, ok) },
+			"/hls/m2_sub/stream.m3u8",
+			http.StatusOK,
+		},
+		{
+			"hlsDenied",
+			restrictedUser,
+			func(acl *ACL) http.Handler { return acl.Monitor(HLSMonitorID, ok) },
+			"/hls/m4/stream.m3u8",
+			http.StatusForbidden,
+		},
+		{
+			"whepOk",
+			restrictedUser,
+			func(acl *ACL) http.Handler { return acl.Monitor(WHEPMonitorID, ok) },
+			"/api/webrtc/whep/m2_sub/abc",
+			http.StatusOK,
+		},
+		{
+			"whepDenied",
+			restrictedUser,
+			func(acl *ACL) http.Handler { return acl.Monitor(WHEPMonitorID, ok) },
+			"/api/webrtc/whep/m4",
+			http.StatusForbidden,
+		},
+		{
+			"queryOk",
+			restrictedUser,
+			func(acl *ACL) http.Handler { return acl.Monitor(QueryMonitorID, ok) },
+			"/api/monitor/mjpeg?id=m2",
+			http.StatusOK,
+		},
+		{
+			"queryDenied",
+			restrictedUser,
+			func(acl *ACL) http.Handler { return acl.Monitor(QueryMonitorID, ok) },
+			"/api/monitor/mjpeg?id=m4",
+			http.StatusForbidden,
+		},
+		{
+			"recordingOk",
+			restrictedUser,
+			func(acl *ACL) http.Handler { return acl.Recording("/rec/", ok) },
+			"/rec/2006-01-02_15-04-05_m1",
+			http.StatusOK,
+		},
+		{
+			"recordingDenied",
+			restrictedUser,
+			func(acl *ACL) http.Handler { return acl.Recording("/rec/", ok) },
+			"/rec/2006-01-02_15-04-05_m4",
+			http.StatusForbidden,
+		},
+		{
+			"viewFullFileDenied",
+			restrictedUser,
+			func(acl *ACL) http.Handler { return acl.RecordingVideo("/rec/", ok) },
+			"/rec/2006-01-02_15-04-05_m1",
+			http.StatusForbidden,
+		},
+		{
+			"exportDenied",
+			restrictedUser,
+			func(acl *ACL) http.Handler { return acl.RecordingVideo("/rec/", ok) },
+			"/rec/2006-01-02_15-04-05_m1?download=true",
+			http.StatusForbidden,
+		},
+		{
+			"exportUnrestricted",
+			auth.Account{},
+			func(acl *ACL) http.Handler { return acl.RecordingVideo("/rec/", ok) },
+			"/rec/2006-01-02_15-04-05_m1?download=true",
+			http.StatusOK,
+		},
+		{
+			"deleteDenied",
+			auth.Account{},
+			func(acl *ACL) http.Handler { return acl.RecordingDelete("/rec/", ok) },
+			"/rec/2006-01-02_15-04-05_m1",
+			http.StatusForbidden,
+		},
+		{
+			"deleteAllowed",
+			auth.Account{Access: auth.Access{Delete: true}},
+			func(acl *ACL) http.Handler { return acl.RecordingDelete("/rec/", ok) },
+			"/rec/2006-01-02_15-04-05_m1",
+			http.StatusOK,
+		},
+		{
+			"deleteAdmin",
+			auth.Account{IsAdmin: true},
+			func(acl *ACL) http.Handler { return acl.RecordingDelete("/rec/", ok) },
+			"/rec/2006-01-02_15-04-05_m1",
+			http.StatusOK,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, tc.url, nil)
+			tc.handler(newTestACL(tc.user)).ServeHTTP(w, r)
+			require.Equal(t, tc.expected, w.Code)
+		})
+	}
+}
+
+func TestACLRecordingVideoRange(t *testing.T) {
+	var gotRange, gotIfRange string
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotRange = r.Header.Get("Range")
+		gotIfRange = r.Header.Get("If-Range")
+	})
+	h := newTestACL(restrictedUser).RecordingVideo("/rec/", next)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/rec/2006-01-02_15-04-05_m1", nil)
+	r.Header.Set("Range", "bytes=0-")
+	r.Header.Set("If-Range", `"x"`)
+	h.ServeHTTP(w, r)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "bytes=0-4194303", gotRange)
+	require.Equal(t, "", gotIfRange)
+	require.Equal(t, "bytes=0-", r.Header.Get("Range"))
+}
+
+func TestLimitRange(t *testing.T) {
+	cases := []struct {
+		input    string
+		expected string
+		ok       bool
+	}{
+		{"", "", false},
+		{"bytes=0-", "bytes=0-9", true},
+		{"bytes=5-7", "bytes=5-7", true},
+		{"bytes=5-100", "bytes=5-14", true},
+		{"bytes=-5", "bytes=-5", true},
+		{"bytes=-100", "bytes=-10", true},
+		{"bytes=0-1,5-6", "", false},
+		{"bytes=7-5", "", false},
+		{"bytes=x-", "", false},
+		{"items=0-", "", false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.input, func(t *testing.T) {
+			got, ok := limitRange(tc.input, 10)
+			require.Equal(t, tc.ok, ok)
+			require.Equal(t, tc.expected, got)
+		})
+	}
+}
+
+// keyAuth only accepts the "nvr_key" API key.
+type keyAuth struct {
+	auth.Authenticator
+}
+
+func (keyAuth) ValidateRequest(r *http.Request) auth.ValidateResponse {
+	if r.Header.Get("Authorization") != "Bearer nvr_key" {
+		return auth.ValidateResponse{}
+	}
+	return auth.ValidateResponse{IsValid: true, User: restrictedUser}
+}
+
+// sessionAuth only accepts basic auth through auth.BasicLogin.
+type sessionAuth struct {
+	auth.Authenticator
+}
+
+func (sessionAuth) ValidateRequest(*http.Request) auth.ValidateResponse {
+	return auth.ValidateResponse{}
+}
+
+func (sessionAuth) ValidateBasicAuth(r *http.Request) auth.ValidateResponse {
+	if username, password, _ := r.BasicAuth(); username != "a" || password != "b" {
+		return auth.ValidateResponse{}
+	}
+	return auth.ValidateResponse{IsValid: true, User: restrictedUser}
+}
+
+func TestACLStream(t *testing.T) {
+	acl := newTestACL(restrictedUser)
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	require.True(t, acl.Stream(r, "m1"))
+	require.True(t, acl.Stream(r, "m1_sub"))
+	require.True(t, acl.Stream(r, "m2"))
+	require.False(t, acl.Stream(r, "m4_sub"))
+
+	t.Run("apiKeyAsPassword", func(t *testing.T) {
+		acl := NewACL(keyAuth{}, newTestACL(auth.Account{}).groups)
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		require.False(t, acl.Stream(r, "m1"))
+
+		r.SetBasicAuth("x", "nvr_key")
+		require.True(t, acl.Stream(r, "m1_sub"))
+		require.False(t, acl.Stream(r, "m4"))
+
+		// The original request is unchanged.
+		_, password, _ := r.BasicAuth()
+		require.Equal(t, "nvr_key", password)
+	})
+	t.Run("basicLogin", func(t *testing.T) {
+		acl := NewACL(sessionAuth{}, newTestACL(auth.Account{}).groups)
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		require.False(t, acl.Stream(r, "m1"))
+
+		r.SetBasicAuth("a", "x")
+		require.False(t, acl.Stream(r, "m1"))
+
+		r.SetBasicAuth("a", "b")
+		require.True(t, acl.Stream(r, "m1"))
+		require.False(t, acl.Stream(r, "m4"))
+	})
+}
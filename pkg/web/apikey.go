@@ -0,0 +1,109 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"nvr/pkg/web/auth"
+)
+
+// APIKeys lists the API keys of the requesting user.
+// Admins can list the keys of all users with "all=true".
+func APIKeys(keys *auth.APIKeys, a auth.Authenticator) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
+			return
+		}
+
+		user := a.ValidateRequest(r).User
+		userID := user.ID
+		if r.URL.Query().Get("all") == "true" {
+			if !user.IsAdmin {
+				http.Error(w, "admin required", http.StatusForbidden)
+				return
+			}
+			userID = ""
+		}
+
+		w.Header().Set("Content-Type", jsonContentType)
+		err := json.NewEncoder(w).Encode(keys.List(userID))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	})
+}
+
+type apiKeyCreateResponse struct {
+	Key  string      `json:"key"`
+	Info auth.APIKey `json:"info"`
+}
+
+// APIKeyCreate creates an API key for the requesting user.
+func APIKeyCreate(keys *auth.APIKeys, a auth.Authenticator) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req auth.CreateAPIKeyRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "unmarshal error: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		key, info, err := keys.Create(a.ValidateRequest(r).User, req)
+		if err != nil {
+			if errors.Is(err, auth.ErrAPIKeyNameMissing) ||
+				errors.Is(err, auth.ErrInvalidScope) ||
+				errors.Is(err, auth.ErrAPIKeyExpired) ||
+				errors.Is(err, auth.ErrScopeRequiresAdmin) {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", jsonContentType)
+		err = json.NewEncoder(w).Encode(apiKeyCreateResponse{Key: key, Info: info})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	})
+}
+
+// APIKeyDelete revokes an API key. Users can only
+// revoke their own keys, admins can revoke any key.
+func APIKeyDelete(keys *auth.APIKeys, a auth.Authenticator) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete {
+			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
+			return
+		}
+
+		id := r.URL.Query().Get("id")
+		key, err := keys.Get(id)
+		user := a.ValidateRequest(r).User
+		if errors.Is(err, auth.ErrAPIKeyNotExist) ||
+			(err == nil && key.UserID != user.ID && !user.IsAdmin) {
+			http.Error(w, "api key does not exist", http.StatusNotFound)
+			return
+		}
+
+		err = keys.Delete(id)
+		if errors.Is(err, auth.ErrAPIKeyNotExist) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	})
+}
@@ -0,0 +1,160 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package web
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+	"nvr/pkg/log"
+	"nvr/pkg/web/auth"
+	"strings"
+)
+
+// Auditor logs user actions to the audit log source.
+type Auditor struct {
+	logger   log.ILogger
+	username func(*http.Request) string
+}
+
+// NewAuditor creates a new auditor.
+func NewAuditor(logger log.ILogger, username func(*http.Request) string) *Auditor {
+	return &Auditor{
+		logger:   logger,
+		username: username,
+	}
+}
+
+// Log logs a user action.
+func (a *Auditor) Log(r *http.Request, action, target, monitorID string) {
+	auth.LogAudit(a.logger, r, auth.Audit{
+		User:      a.username(r),
+		Action:    action,
+		Target:    target,
+		MonitorID: monitorID,
+	})
+}
+
+// AuditTargetFunc returns the target and monitor ID of a request.
+// The request isn't logged if ok is false.
+type AuditTargetFunc func(r *http.Request) (target string, monitorID string, ok bool)
+
+// Handler logs the action if the request succeeded. Websocket
+// requests are logged when the connection is established.
+func (a *Auditor) Handler(action string, target AuditTargetFunc, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t, monitorID, ok := target(r)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		log := func() { a.Log(r, action, t, monitorID) }
+		sw := &statusWriter{ResponseWriter: w, onHijack: log}
+		next.ServeHTTP(sw, r)
+		if sw.hijacked || sw.status >= http.StatusBadRequest {
+			return
+		}
+		log()
+	})
+}
+
+// RecordingVideo logs "recording-download" if the "download"
+// query is true, otherwise "recording-view". Only the first
+// request of a video is logged, the browser requests the
+// rest of the video using range requests.
+func (a *Auditor) RecordingVideo(prefix string, next http.Handler) http.Handler {
+	view := a.Handler("recording-view", auditRecordingVideo(prefix), next)
+	download := a.Handler("recording-download", auditRecordingVideo(prefix), next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("download") == "true" {
+			download.ServeHTTP(w, r)
+			return
+		}
+		view.ServeHTTP(w, r)
+	})
+}
+
+// Recording IDs end with the monitor ID, "2006-01-02_15-04-05_id".
+func recordingMonitorID(recID string) string {
+	if len(recID) <= 20 {
+		return ""
+	}
+	return recID[20:]
+}
+
+// AuditQueryID uses the "id" query as the target.
+func AuditQueryID(r *http.Request) (string, string, bool) {
+	id := r.URL.Query().Get("id")
+	return id, id, true
+}
+
+// AuditQueryObject uses the "object" and "revision" queries as the target.
+func AuditQueryObject(r *http.Request) (string, string, bool) {
+	query := r.URL.Query()
+	return query.Get("object") + "@" + query.Get("revision"), "", true
+}
+
+// AuditNoTarget logs the request without a target.
+func AuditNoTarget(*http.Request) (string, string, bool) {
+	return "", "", true
+}
+
+// AuditRecording uses the recording ID after prefix as the target.
+func AuditRecording(prefix string) AuditTargetFunc {
+	return func(r *http.Request) (string, string, bool) {
+		recID := strings.TrimPrefix(r.URL.Path, prefix)
+		return recID, recordingMonitorID(recID), true
+	}
+}
+
+func auditRecordingVideo(prefix string) AuditTargetFunc {
+	target := AuditRecording(prefix)
+	return func(r *http.Request) (string, string, bool) {
+		rangeHeader := r.Header.Get("Range")
+		if rangeHeader != "" && !strings.HasPrefix(rangeHeader, "bytes=0-") {
+			return "", "", false
+		}
+		return target(r)
+	}
+}
+
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+
+	onHijack func()
+	hijacked bool
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+var errHijackNotSupported = errors.New("hijack not supported")
+
+// Hijack is required by websockets.
+func (w *statusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errHijackNotSupported
+	}
+	conn, rw, err := h.Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	w.hijacked = true
+	w.onHijack()
+	return conn, rw, nil
+}
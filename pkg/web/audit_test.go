@@ -0,0 +1,104 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"nvr/pkg/log"
+
+	"github.com/stretchr/testify/require"
+)
+
+type auditLogger []log.Entry
+
+func (l *auditLogger) Log(e log.Entry) {
+	*l = append(*l, e)
+}
+
+func newTestAuditor() (*Auditor, *auditLogger) {
+	logger := &auditLogger{}
+	username := func(*http.Request) string { return "admin" }
+	return NewAuditor(logger, username), logger
+}
+
+func TestAuditorHandler(t *testing.T) {
+	handler := func(status int) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(status)
+		})
+	}
+	t.Run("ok", func(t *testing.T) {
+		a, logs := newTestAuditor()
+		h := a.Handler("monitor-restart", AuditQueryID, handler(http.StatusOK))
+
+		r := httptest.NewRequest(http.MethodPost, "/api/monitor/restart?id=x", nil)
+		r.RemoteAddr = "1.2.3.4:5678"
+		h.ServeHTTP(httptest.NewRecorder(), r)
+
+		expected := []log.Entry{{
+			Level:     log.LevelInfo,
+			Src:       log.SrcAudit,
+			MonitorID: "x",
+			Msg:       `action=monitor-restart user="admin" ip="addr:1.2.3.4:5678" target="x"`,
+		}}
+		require.Equal(t, expected, []log.Entry(*logs))
+	})
+	t.Run("failed", func(t *testing.T) {
+		a, logs := newTestAuditor()
+		h := a.Handler("monitor-restart", AuditQueryID, handler(http.StatusNotFound))
+
+		r := httptest.NewRequest(http.MethodPost, "/api/monitor/restart?id=x", nil)
+		h.ServeHTTP(httptest.NewRecorder(), r)
+		require.Empty(t, *logs)
+	})
+}
+
+func TestAuditorRecordingVideo(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	cases := []struct {
+		name     string
+		url      string
+		rangeHdr string
+		expected string
+	}{
+		{
+			"view",
+			"/api/recording/video/2006-01-02_15-04-05_x",
+			"",
+			`action=recording-view user="admin" ip="addr:192.0.2.1:1234" target="2006-01-02_15-04-05_x"`,
+		},
+		{
+			"download",
+			"/api/recording/video/2006-01-02_15-04-05_x?download=true",
+			"bytes=0-",
+			`action=recording-download user="admin" ip="addr:192.0.2.1:1234" target="2006-01-02_15-04-05_x"`,
+		},
+		{
+			"rangeRequest",
+			"/api/recording/video/2006-01-02_15-04-05_x",
+			"bytes=100-",
+			"",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			a, logs := newTestAuditor()
+			h := a.RecordingVideo("/api/recording/video/", ok)
+
+			r := httptest.NewRequest(http.MethodGet, tc.url, nil)
+			if tc.rangeHdr != "" {
+				r.Header.Set("Range", tc.rangeHdr)
+			}
+			h.ServeHTTP(httptest.NewRecorder(), r)
+
+			if tc.expected == "" {
+				require.Empty(t, *logs)
+				return
+			}
+			require.Len(t, *logs, 1)
+			require.Equal(t, "x", (*logs)[0].MonitorID)
+			require.Equal(t, tc.expected, (*logs)[0].Msg)
+		})
+	}
+}
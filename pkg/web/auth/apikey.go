@@ -0,0 +1,385 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"nvr/pkg/configstore"
+	"nvr/pkg/log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Scope limits what an API key can be used for.
+type Scope string
+
+// Scopes.
+const (
+	// ScopeReadOnly allows GET and HEAD requests to any user endpoint
+	// that the owner of the key has access to, and requests to
+	// endpoints that are wrapped by AllowScope. Admin endpoints
+	// must opt in with AllowScope.
+	ScopeReadOnly Scope = "read-only"
+
+	// ScopeEventsIn only allows requests to
+	// endpoints that are wrapped by AllowScope.
+	ScopeEventsIn Scope = "events-in"
+
+	// ScopeAdmin allows the same requests as the owner of the key.
+	ScopeAdmin Scope = "admin"
+)
+
+func (s Scope) valid() bool {
+	switch s {
+	case ScopeReadOnly, ScopeEventsIn, ScopeAdmin:
+		return true
+	}
+	return false
+}
+
+// APIKey stored API key, the key itself is only stored hashed.
+type APIKey struct {
+	ID      string    `json:"id"`
+	UserID  string    `json:"userId"`
+	Name    string    `json:"name"`
+	Scope   Scope     `json:"scope"`
+	Hash    []byte    `json:"hash,omitempty"` // SHA-256 hash of the secret.
+	Created time.Time `json:"created"`
+	Expires time.Time `json:"expires"` // Zero if the key never expires.
+}
+
+func (k APIKey) expired(now time.Time) bool {
+	return !k.Expires.IsZero() && now.After(k.Expires)
+}
+
+// Keys have the format "nvr_<id>_<secret>".
+const apiKeyPrefix = "nvr_"
+
+// IsAPIKey returns true if s has the format of an API key.
+func IsAPIKey(s string) bool {
+	return strings.HasPrefix(s, apiKeyPrefix)
+}
+
+// Errors.
+var (
+	ErrAPIKeyNotExist     = errors.New("api key does not exist")
+	ErrInvalidScope       = errors.New("invalid scope")
+	ErrAPIKeyNameMissing  = errors.New("missing name")
+	ErrAPIKeyExpired      = errors.New("expiry time is in the past")
+	ErrScopeRequiresAdmin = errors.New("scope requires admin")
+)
+
+// APIKeys stores API keys in a JSON file.
+type APIKeys struct {
+	path string
+	keys map[string]APIKey
+	mu   sync.Mutex
+}
+
+// NewAPIKeys reads the API keys from path.
+func NewAPIKeys(path string) (*APIKeys, error) {
+	keys := make(map[string]APIKey)
+	err := configstore.ReadJSON(path, &keys)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("read api keys: %w", err)
+	}
+	return &APIKeys{path: path, keys: keys}, nil
+}
+
+// CreateAPIKeyRequest create API key request.
+type CreateAPIKeyRequest struct {
+	Name    string    `json:"name"`
+	Scope   Scope     `json:"scope"`
+	Expires time.Time `json:"expires"`
+}
+
+// Create creates a new key for user and returns the key. The key
+// can't be recovered later, only the hash of the secret is stored.
+func (k *APIKeys) Create(user Account, req CreateAPIKeyRequest) (string, APIKey, error) {
+	now := time.Now().UTC()
+	switch {
+	case req.Name == "":
+		return "", APIKey{}, ErrAPIKeyNameMissing
+	case !req.Scope.valid():
+		return "", APIKey{}, fmt.Errorf("%w: %q", ErrInvalidScope, req.Scope)
+	case req.Scope == ScopeAdmin && !user.IsAdmin:
+		return "", APIKey{}, ErrScopeRequiresAdmin
+	case !req.Expires.IsZero() && req.Expires.Before(now):
+		return "", APIKey{}, ErrAPIKeyExpired
+	}
+
+	id := randomHex(8)
+	secret := randomHex(32)
+	hash := sha256.Sum256([]byte(secret))
+	key := APIKey{
+		ID:      id,
+		UserID:  user.ID,
+		Name:    req.Name,
+		Scope:   req.Scope,
+		Hash:    hash[:],
+		Created: now,
+		Expires: req.Expires,
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.keys[id] = key
+	if err := configstore.WriteJSON(k.path, k.keys); err != nil {
+		delete(k.keys, id)
+		return "", APIKey{}, fmt.Errorf("save api keys: %w", err)
+	}
+
+	key.Hash = nil
+	return apiKeyPrefix + id + "_" + secret, key, nil
+}
+
+// List returns the keys of a user sorted by creation time,
+// or the keys of all users if userID is empty.
+func (k *APIKeys) List(userID string) []APIKey {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	keys := []APIKey{}
+	for _, key := range k.keys {
+		if userID != "" && key.UserID != userID {
+			continue
+		}
+		key.Hash = nil
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Created.Equal(keys[j].Created) {
+			return keys[i].ID < keys[j].ID
+		}
+		return keys[i].Created.Before(keys[j].Created)
+	})
+	return keys
+}
+
+// Get returns a key by id.
+func (k *APIKeys) Get(id string) (APIKey, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	key, exists := k.keys[id]
+	if !exists {
+		return APIKey{}, ErrAPIKeyNotExist
+	}
+	key.Hash = nil
+	return key, nil
+}
+
+// Delete revokes a key.
+func (k *APIKeys) Delete(id string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if _, exists := k.keys[id]; !exists {
+		return ErrAPIKeyNotExist
+	}
+	delete(k.keys, id)
+	return configstore.WriteJSON(k.path, k.keys)
+}
+
+// DeleteUser revokes all keys of a user.
+func (k *APIKeys) DeleteUser(userID string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	deleted := false
+	for id, key := range k.keys {
+		if key.UserID == userID {
+			delete(k.keys, id)
+			deleted = true
+		}
+	}
+	if !deleted {
+		return nil
+	}
+	return configstore.WriteJSON(k.path, k.keys)
+}
+
+// validate returns the key if token is a valid and unexpired key.
+func (k *APIKeys) validate(token string, now time.Time) (APIKey, bool) {
+	if !strings.HasPrefix(token, apiKeyPrefix) {
+		return APIKey{}, false
+	}
+	id, secret, found := strings.Cut(token[len(apiKeyPrefix):], "_")
+	if !found {
+		return APIKey{}, false
+	}
+
+	k.mu.Lock()
+	key, exists := k.keys[id]
+	k.mu.Unlock()
+
+	hash := sha256.Sum256([]byte(secret))
+	if !exists || subtle.ConstantTimeCompare(hash[:], key.Hash) != 1 {
+		return APIKey{}, false
+	}
+	if key.expired(now) {
+		return APIKey{}, false
+	}
+	return key, true
+}
+
+// apiKeyID returns the id part of a key.
+func apiKeyID(token string) string {
+	id, _, _ := strings.Cut(strings.TrimPrefix(token, apiKeyPrefix), "_")
+	return id
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("failed to generate random bytes: %v", err))
+	}
+	return hex.EncodeToString(b)
+}
+
+// bearerToken returns the token from the "Authorization: Bearer" header.
+func bearerToken(r *http.Request) (string, bool) {
+	const prefix = "Bearer "
+	header := r.Header.Get("Authorization")
+	if len(header) < len(prefix) || !strings.EqualFold(header[:len(prefix)], prefix) {
+		return "", false
+	}
+	return header[len(prefix):], true
+}
+
+type scopeKey struct{}
+
+// AllowScope allows keys with scope to access the handler.
+// Must be the outermost wrapper.
+func AllowScope(scope Scope, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), scopeKey{}, scope)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func scopeAllowed(key APIKey, r *http.Request, admin bool) bool {
+	switch key.Scope {
+	case ScopeAdmin:
+		return true
+	case ScopeReadOnly:
+		if !admin && (r.Method == http.MethodGet || r.Method == http.MethodHead) {
+			return true
+		}
+	}
+	allowed, _ := r.Context().Value(scopeKey{}).(Scope)
+	return allowed == key.Scope
+}
+
+// WithAPIKeys returns an authenticator that accepts API keys in the
+// "Authorization: Bearer" header in addition to the requests accepted
+// by a. Key authenticated requests are exempt from CSRF checks.
+func WithAPIKeys(a Authenticator, keys *APIKeys, logger log.ILogger) Authenticator {
+	return &keyAuthenticator{Authenticator: a, keys: keys, logger: logger}
+}
+
+type keyAuthenticator struct {
+	Authenticator
+	keys   *APIKeys
+	logger log.ILogger
+}
+
+// usesKey returns true if the request should be authenticated by API key.
+func (a *keyAuthenticator) usesKey(r *http.Request) (string, bool) {
+	if a.AuthDisabled() {
+		return "", false
+	}
+	return bearerToken(r)
+}
+
+// ValidateRequest validates the API key if the request has a bearer token.
+func (a *keyAuthenticator) ValidateRequest(r *http.Request) ValidateResponse {
+	token, isBearer := a.usesKey(r)
+	if !isBearer {
+		return a.Authenticator.ValidateRequest(r)
+	}
+	return a.validateKey(r, token, false)
+}
+
+// ValidateBasicAuth implements BasicLogin.
+func (a *keyAuthenticator) ValidateBasicAuth(r *http.Request) ValidateResponse {
+	return ValidateBasicAuth(a.Authenticator, r)
+}
+
+// validateKey validates the key for a user or admin endpoint.
+func (a *keyAuthenticator) validateKey(r *http.Request, token string, admin bool) ValidateResponse {
+	key, valid := a.keys.validate(token, time.Now())
+	if !valid || !scopeAllowed(key, r, admin) {
+		return ValidateResponse{}
+	}
+	owner, exists := a.UsersList()[key.UserID]
+	if !exists {
+		return ValidateResponse{}
+	}
+	return ValidateResponse{
+		IsValid: true,
+		User: Account{
+			ID:       owner.ID,
+			Username: owner.Username,
+			IsAdmin:  owner.IsAdmin,
+			Access:   owner.Access,
+		},
+	}
+}
+
+// UserDelete also revokes the keys of the user.
+func (a *keyAuthenticator) UserDelete(id string) error {
+	if err := a.Authenticator.UserDelete(id); err != nil {
+		return err
+	}
+	return a.keys.DeleteUser(id)
+}
+
+func (a *keyAuthenticator) User(next http.Handler) http.Handler {
+	return a.wrap(false, next, a.Authenticator.User(next))
+}
+
+func (a *keyAuthenticator) Admin(next http.Handler) http.Handler {
+	return a.wrap(true, next, a.Authenticator.Admin(next))
+}
+
+func (a *keyAuthenticator) CSRF(next http.Handler) http.Handler {
+	csrf := a.Authenticator.CSRF(next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, isBearer := a.usesKey(r); isBearer && a.ValidateRequest(r).IsValid {
+			next.ServeHTTP(w, r)
+			return
+		}
+		csrf.ServeHTTP(w, r)
+	})
+}
+
+func (a *keyAuthenticator) wrap(admin bool, next http.Handler, fallback http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, isBearer := a.usesKey(r)
+		if !isBearer {
+			fallback.ServeHTTP(w, r)
+			return
+		}
+		res := a.validateKey(r, token, admin)
+		if !res.IsValid || (admin && !res.User.IsAdmin) {
+			LogAudit(a.logger, r, Audit{
+				Level:  log.LevelWarning,
+				User:   res.User.Username,
+				Action: "login-failed",
+				Target: "api-key:" + apiKeyID(token),
+			})
+			w.Header().Set("WWW-Authenticate", `Bearer realm="NVR"`)
+			http.Error(w, "Unauthorized.", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
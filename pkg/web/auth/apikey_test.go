@@ -0,0 +1,210 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"nvr/pkg/log"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newTestAPIKeys(t *testing.T) *APIKeys {
+	t.Helper()
+	keys, err := NewAPIKeys(filepath.Join(t.TempDir(), "apikeys.json"))
+	require.NoError(t, err)
+	return keys
+}
+
+var (
+	testAdmin = Account{ID: "1", Username: "admin", IsAdmin: true}
+	testUser  = Account{
+		ID:       "2",
+		Username: "user",
+		Access:   Access{Restricted: true, Monitors: []string{"m1"}},
+	}
+)
+
+func TestAPIKeys(t *testing.T) {
+	t.Run("createAndValidate", func(t *testing.T) {
+		keys := newTestAPIKeys(t)
+		key, info, err := keys.Create(testAdmin, CreateAPIKeyRequest{
+			Name:  "a",
+			Scope: ScopeAdmin,
+		})
+		require.NoError(t, err)
+		require.Nil(t, info.Hash)
+
+		validated, valid := keys.validate(key, time.Now())
+		require.True(t, valid)
+		require.Equal(t, info.ID, validated.ID)
+
+		_, valid = keys.validate(key+"x", time.Now())
+		require.False(t, valid)
+		_, valid = keys.validate("nvr_"+info.ID, time.Now())
+		require.False(t, valid)
+	})
+	t.Run("persist", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "apikeys.json")
+		keys, err := NewAPIKeys(path)
+		require.NoError(t, err)
+		key, _, err := keys.Create(testUser, CreateAPIKeyRequest{
+			Name:  "a",
+			Scope: ScopeReadOnly,
+		})
+		require.NoError(t, err)
+
+		keys2, err := NewAPIKeys(path)
+		require.NoError(t, err)
+		_, valid := keys2.validate(key, time.Now())
+		require.True(t, valid)
+	})
+	t.Run("expired", func(t *testing.T) {
+		keys := newTestAPIKeys(t)
+		expires := time.Now().Add(time.Hour)
+		key, _, err := keys.Create(testUser, CreateAPIKeyRequest{
+			Name:    "a",
+			Scope:   ScopeReadOnly,
+			Expires: expires,
+		})
+		require.NoError(t, err)
+
+		_, valid := keys.validate(key, expires.Add(-time.Minute))
+		require.True(t, valid)
+		_, valid = keys.validate(key, expires.Add(time.Minute))
+		require.False(t, valid)
+	})
+	t.Run("createErrors", func(t *testing.T) {
+		keys := newTestAPIKeys(t)
+		cases := []struct {
+			user Account
+			req  CreateAPIKeyRequest
+			err  error
+		}{
+			{testUser, CreateAPIKeyRequest{Scope: ScopeReadOnly}, ErrAPIKeyNameMissing},
+			{testUser, CreateAPIKeyRequest{Name: "a", Scope: "x"}, ErrInvalidScope},
+			{testUser, CreateAPIKeyRequest{Name: "a", Scope: ScopeAdmin}, ErrScopeRequiresAdmin},
+			{testUser, CreateAPIKeyRequest{
+				Name: "a", Scope: ScopeReadOnly, Expires: time.Now().Add(-time.Hour),
+			}, ErrAPIKeyExpired},
+		}
+		for _, tc := range cases {
+			_, _, err := keys.Create(tc.user, tc.req)
+			require.ErrorIs(t, err, tc.err)
+		}
+	})
+	t.Run("listAndDelete", func(t *testing.T) {
+		keys := newTestAPIKeys(t)
+		_, a, err := keys.Create(testAdmin, CreateAPIKeyRequest{Name: "a", Scope: ScopeAdmin})
+		require.NoError(t, err)
+		_, b, err := keys.Create(testUser, CreateAPIKeyRequest{Name: "b", Scope: ScopeReadOnly})
+		require.NoError(t, err)
+
+		require.Equal(t, []APIKey{b}, keys.List(testUser.ID))
+		require.Len(t, keys.List(""), 2)
+
+		require.NoError(t, keys.Delete(b.ID))
+		require.ErrorIs(t, keys.Delete(b.ID), ErrAPIKeyNotExist)
+		require.Equal(t, []APIKey{a}, keys.List(""))
+
+		require.NoError(t, keys.DeleteUser(testAdmin.ID))
+		require.Empty(t, keys.List(""))
+	})
+}
+
+type stubAuthenticator struct {
+	Authenticator
+}
+
+func (stubAuthenticator) AuthDisabled() bool { return false }
+
+func (stubAuthenticator) ValidateRequest(*http.Request) ValidateResponse {
+	return ValidateResponse{}
+}
+
+func (stubAuthenticator) UsersList() map[string]AccountObfuscated {
+	return map[string]AccountObfuscated{
+		testAdmin.ID: {ID: testAdmin.ID, Username: testAdmin.Username, IsAdmin: true},
+		testUser.ID:  {ID: testUser.ID, Username: testUser.Username, Access: testUser.Access},
+	}
+}
+
+func (stubAuthenticator) User(http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	})
+}
+
+func (stubAuthenticator) Admin(http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	})
+}
+
+func (stubAuthenticator) CSRF(http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	})
+}
+
+func TestWithAPIKeys(t *testing.T) {
+	keys := newTestAPIKeys(t)
+	a := WithAPIKeys(stubAuthenticator{}, keys, log.NewDummyLogger())
+
+	create := func(user Account, scope Scope) string {
+		key, _, err := keys.Create(user, CreateAPIKeyRequest{Name: "x", Scope: scope})
+		require.NoError(t, err)
+		return key
+	}
+	adminKey := create(testAdmin, ScopeAdmin)
+	readOnlyKey := create(testAdmin, ScopeReadOnly)
+	eventsKey := create(testAdmin, ScopeEventsIn)
+	userKey := create(testUser, ScopeReadOnly)
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	admin := a.Admin(a.CSRF(ok))
+	events := AllowScope(ScopeEventsIn, admin)
+	readOnly := AllowScope(ScopeReadOnly, admin)
+
+	cases := []struct {
+		name     string
+		handler  http.Handler
+		method   string
+		key      string
+		expected int
+	}{
+		{"admin", admin, http.MethodPost, adminKey, http.StatusOK},
+		{"readOnlyGet", a.User(ok), http.MethodGet, readOnlyKey, http.StatusOK},
+		{"readOnlyPost", a.User(ok), http.MethodPost, readOnlyKey, http.StatusUnauthorized},
+		{"readOnlyAdminGet", admin, http.MethodGet, readOnlyKey, http.StatusUnauthorized},
+		{"readOnlyAllowed", readOnly, http.MethodPost, readOnlyKey, http.StatusOK},
+		{"eventsInReadOnly", readOnly, http.MethodPost, eventsKey, http.StatusUnauthorized},
+		{"eventsIn", events, http.MethodPost, eventsKey, http.StatusOK},
+		{"eventsInNotAllowed", admin, http.MethodPost, eventsKey, http.StatusUnauthorized},
+		{"notAdmin", admin, http.MethodGet, userKey, http.StatusUnauthorized},
+		{"user", a.User(ok), http.MethodGet, userKey, http.StatusOK},
+		{"invalidKey", admin, http.MethodGet, "nvr_x_y", http.StatusUnauthorized},
+		{"noKey", admin, http.MethodGet, "", http.StatusUnauthorized},
+	}
+	t.Run("access", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Authorization", "Bearer "+userKey)
+		require.Equal(t, testUser.Access, a.ValidateRequest(r).User.Access)
+	})
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(tc.method, "/", nil)
+			if tc.key != "" {
+				r.Header.Set("Authorization", "Bearer "+tc.key)
+			}
+			w := httptest.NewRecorder()
+			tc.handler.ServeHTTP(w, r)
+			require.Equal(t, tc.expected, w.Code)
+		})
+	}
+}
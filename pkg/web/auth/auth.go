@@ -9,6 +9,7 @@ import (
 	"net/http"
 	"nvr/pkg/log"
 	"nvr/pkg/storage"
+	"strings"
 
 	stdLog "log"
 )
@@ -19,14 +20,44 @@ type Account struct {
 	Username string `json:"username"`
 	Password []byte `json:"password"` // Hashed password.
 	IsAdmin  bool   `json:"isAdmin"`
+	Access   Access `json:"access"`
 	Token    string `json:"-"` // CSRF token.
 }
 
+// Access restricts what a non-admin user can see and do. Admins
+// can access everything. Users without access restrictions can
+// view and export the recordings of all monitors.
+type Access struct {
+	// Restricted limits the user to the listed monitors and
+	// the monitors of the listed groups.
+	Restricted bool     `json:"restricted"`
+	Monitors   []string `json:"monitors,omitempty"`
+	Groups     []string `json:"groups,omitempty"`
+
+	// Delete allows the user to delete recordings.
+	Delete bool `json:"delete"`
+	// Export allows restricted users to download recordings. Users
+	// without it can only fetch the small ranges used by the player.
+	Export bool `json:"export"`
+}
+
+// CanDelete returns true if the user can delete recordings.
+func (a Account) CanDelete() bool {
+	return a.IsAdmin || a.Access.Delete
+}
+
+// CanExport returns true if the user can download recordings.
+func (a Account) CanExport() bool {
+	return a.IsAdmin || !a.Access.Restricted || a.Access.Export
+}
+
 // AccountObfuscated Account without sensitive information.
 type AccountObfuscated struct {
 	ID       string `json:"id"`
 	Username string `json:"username"`
 	IsAdmin  bool   `json:"isAdmin"`
+	Access   Access `json:"access"`
+	TOTP     bool   `json:"totp"` // Two-factor authentication enabled.
 }
 
 // ValidateResponse ValidateRequest response.
@@ -41,6 +72,7 @@ type SetUserRequest struct {
 	Username      string `json:"username"`
 	PlainPassword string `json:"plainPassword,omitempty"`
 	IsAdmin       bool   `json:"isAdmin"`
+	Access        Access `json:"access"`
 }
 
 // NewAuthenticatorFunc function to create authenticator.
@@ -76,8 +108,27 @@ type Authenticator interface {
 	Logout() http.Handler
 }
 
-// LogFailedLogin finds and logs the ip.
-func LogFailedLogin(logger *log.Logger, r *http.Request, username string) {
+// BasicLogin is implemented by authenticators that don't accept basic
+// auth in ValidateRequest, but can check the username and password.
+// Used by the RTSP and HLS servers since most of their clients only
+// support basic auth.
+type BasicLogin interface {
+	ValidateBasicAuth(*http.Request) ValidateResponse
+}
+
+// ValidateBasicAuth validates the basic auth credentials of the
+// request if a implements BasicLogin, otherwise the request is invalid.
+func ValidateBasicAuth(a Authenticator, r *http.Request) ValidateResponse {
+	login, ok := a.(BasicLogin)
+	if !ok {
+		return ValidateResponse{}
+	}
+	return login.ValidateBasicAuth(r)
+}
+
+// ClientIP returns the address of the client
+// including the addresses set by proxies.
+func ClientIP(r *http.Request) string {
 	ip := ""
 	realIP := r.Header.Get("X-Real-Ip")
 	if realIP != "" {
@@ -91,11 +142,43 @@ func LogFailedLogin(logger *log.Logger, r *http.Request, username string) {
 	if remoteAddr != "" && remoteAddr != forwarded {
 		ip += "addr:" + remoteAddr
 	}
+	return strings.TrimSpace(ip)
+}
+
+// Audit user action.
+type Audit struct {
+	Level     log.Level // Defaults to info.
+	User      string
+	Action    string
+	Target    string
+	MonitorID string
+}
 
+// LogAudit logs a user action to the audit log source.
+func LogAudit(logger log.ILogger, r *http.Request, a Audit) {
+	if a.Level == 0 {
+		a.Level = log.LevelInfo
+	}
 	logger.Log(log.Entry{
-		Level: log.LevelInfo,
-		Src:   "auth",
-		Msg:   fmt.Sprintf("failed login: username: %v %v\n", username, ip),
+		Level:     a.Level,
+		Src:       log.SrcAudit,
+		MonitorID: a.MonitorID,
+		Msg: fmt.Sprintf("action=%v user=%q ip=%q target=%q",
+			a.Action, a.User, ClientIP(r), a.Target),
+	})
+}
+
+// LogLogin logs successful logins.
+func LogLogin(logger log.ILogger, r *http.Request, username string) {
+	LogAudit(logger, r, Audit{User: username, Action: "login"})
+}
+
+// LogFailedLogin finds and logs the ip.
+func LogFailedLogin(logger log.ILogger, r *http.Request, username string) {
+	LogAudit(logger, r, Audit{
+		Level:  log.LevelWarning,
+		User:   username,
+		Action: "login-failed",
 	})
 }
 
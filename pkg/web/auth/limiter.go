@@ -0,0 +1,189 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package auth
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"nvr/pkg/log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Login limiter defaults.
+const (
+	DefaultMaxUserFailures = 5
+	DefaultMaxIPFailures   = 20
+	DefaultFailureWindow   = 15 * time.Minute
+	DefaultLockoutDuration = 15 * time.Minute
+)
+
+// LoginLimiter temporarily locks out usernames and client
+// addresses after too many failed login attempts.
+type LoginLimiter struct {
+	// Failures allowed within the window before locking out.
+	MaxUserFailures int
+	MaxIPFailures   int
+	Window          time.Duration
+	Lockout         time.Duration
+
+	// Requests from these networks are keyed on the
+	// address in the X-Forwarded-For header instead.
+	TrustedProxies []*net.IPNet
+
+	logger   log.ILogger
+	now      func() time.Time
+	users    map[string]*loginFailures
+	ips      map[string]*loginFailures
+	mu       sync.Mutex
+	maxCache int
+}
+
+type loginFailures struct {
+	count       int
+	windowStart time.Time
+	lockedUntil time.Time
+}
+
+// NewLoginLimiter creates a login limiter with the default limits.
+func NewLoginLimiter(logger log.ILogger) *LoginLimiter {
+	return &LoginLimiter{
+		MaxUserFailures: DefaultMaxUserFailures,
+		MaxIPFailures:   DefaultMaxIPFailures,
+		Window:          DefaultFailureWindow,
+		Lockout:         DefaultLockoutDuration,
+		TrustedProxies:  loopbackNets,
+
+		logger:   logger,
+		now:      time.Now,
+		users:    make(map[string]*loginFailures),
+		ips:      make(map[string]*loginFailures),
+		maxCache: 10000,
+	}
+}
+
+var loopbackNets = []*net.IPNet{
+	{IP: net.IPv4(127, 0, 0, 0), Mask: net.CIDRMask(8, 32)},
+	{IP: net.IPv6loopback, Mask: net.CIDRMask(128, 128)},
+}
+
+// clientIP returns the IP of the client. If the connection comes from
+// a trusted proxy, the right-most untrusted address in X-Forwarded-For
+// is used. Addresses left of it can be set by the client and are ignored.
+func (l *LoginLimiter) clientIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	if !l.trusted(ip) {
+		return ip
+	}
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		addr := strings.TrimSpace(forwarded[i])
+		if net.ParseIP(addr) == nil {
+			break
+		}
+		ip = addr
+		if !l.trusted(addr) {
+			break
+		}
+	}
+	return ip
+}
+
+func (l *LoginLimiter) trusted(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, ipNet := range l.TrustedProxies {
+		if ipNet.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// Allowed returns false if the username or client is locked out.
+func (l *LoginLimiter) Allowed(r *http.Request, username string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	return !l.locked(l.users, strings.ToLower(username), now) &&
+		!l.locked(l.ips, l.clientIP(r), now)
+}
+
+func (l *LoginLimiter) locked(m map[string]*loginFailures, key string, now time.Time) bool {
+	f, exists := m[key]
+	return exists && now.Before(f.lockedUntil)
+}
+
+// Failed records a failed login attempt and locks out the
+// username or client if there are too many failures.
+func (l *LoginLimiter) Failed(r *http.Request, username string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	username = strings.ToLower(username)
+	ip := l.clientIP(r)
+
+	if username != "" && l.fail(l.users, username, l.MaxUserFailures, now) {
+		l.logLockout(r, username, "user:"+username)
+	}
+	if l.fail(l.ips, ip, l.MaxIPFailures, now) {
+		l.logLockout(r, username, "ip:"+ip)
+	}
+}
+
+// fail returns true if the key was locked out.
+func (l *LoginLimiter) fail(
+	m map[string]*loginFailures,
+	key string,
+	max int,
+	now time.Time,
+) bool {
+	if len(m) >= l.maxCache {
+		l.prune(m, now)
+	}
+	f, exists := m[key]
+	if !exists || now.Sub(f.windowStart) > l.Window {
+		f = &loginFailures{windowStart: now}
+		m[key] = f
+	}
+	f.count++
+	if f.count < max || now.Before(f.lockedUntil) {
+		return false
+	}
+	f.lockedUntil = now.Add(l.Lockout)
+	f.count = 0
+	f.windowStart = now
+	return true
+}
+
+// prune removes entries that are neither locked nor in a window.
+func (l *LoginLimiter) prune(m map[string]*loginFailures, now time.Time) {
+	for key, f := range m {
+		if now.After(f.lockedUntil) && now.Sub(f.windowStart) > l.Window {
+			delete(m, key)
+		}
+	}
+}
+
+func (l *LoginLimiter) logLockout(r *http.Request, username string, target string) {
+	LogAudit(l.logger, r, Audit{
+		Level:  log.LevelWarning,
+		User:   username,
+		Action: "login-lockout",
+		Target: fmt.Sprintf("%v for %v", target, l.Lockout),
+	})
+}
+
+// Succeeded resets the failures of the username.
+func (l *LoginLimiter) Succeeded(username string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.users, strings.ToLower(username))
+}
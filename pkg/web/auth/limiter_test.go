@@ -0,0 +1,117 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"nvr/pkg/log"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoginLimiter(t *testing.T) {
+	newTestLimiter := func() (*LoginLimiter, *time.Time) {
+		l := NewLoginLimiter(log.NewDummyLogger())
+		l.MaxUserFailures = 2
+		l.MaxIPFailures = 3
+		now := time.Unix(1000, 0)
+		l.now = func() time.Time { return now }
+		return l, &now
+	}
+	newRequest := func(addr string) *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "/", nil)
+		r.RemoteAddr = addr
+		return r
+	}
+
+	t.Run("user", func(t *testing.T) {
+		l, now := newTestLimiter()
+		r := newRequest("1.1.1.1:1")
+		require.True(t, l.Allowed(r, "a"))
+
+		l.Failed(r, "a")
+		require.True(t, l.Allowed(r, "a"))
+		l.Failed(r, "A")
+		require.False(t, l.Allowed(r, "a"))
+
+		// Other users and addresses aren't affected.
+		require.True(t, l.Allowed(r, "b"))
+		require.False(t, l.Allowed(newRequest("2.2.2.2:1"), "a"))
+
+		*now = now.Add(l.Lockout + time.Second)
+		require.True(t, l.Allowed(r, "a"))
+	})
+	t.Run("ip", func(t *testing.T) {
+		l, _ := newTestLimiter()
+		r := newRequest("1.1.1.1:1")
+		l.Failed(r, "a")
+		l.Failed(r, "b")
+		l.Failed(r, "c")
+		require.False(t, l.Allowed(r, "d"))
+		require.False(t, l.Allowed(newRequest("1.1.1.1:2"), "d"))
+		require.True(t, l.Allowed(newRequest("2.2.2.2:1"), "d"))
+	})
+	t.Run("proxy", func(t *testing.T) {
+		l, _ := newTestLimiter()
+		newProxied := func(forwarded string) *http.Request {
+			r := newRequest("127.0.0.1:1")
+			r.Header.Set("X-Forwarded-For", forwarded)
+			return r
+		}
+		r := newProxied("1.1.1.1")
+		l.Failed(r, "a")
+		l.Failed(r, "b")
+		l.Failed(r, "c")
+		require.False(t, l.Allowed(r, "d"))
+
+		// Other clients behind the proxy aren't affected.
+		require.True(t, l.Allowed(newProxied("2.2.2.2"), "d"))
+
+		// Only the right-most untrusted address is used.
+		require.False(t, l.Allowed(newProxied("2.2.2.2, 1.1.1.1"), "d"))
+		require.False(t, l.Allowed(newProxied("1.1.1.1, ::1"), "d"))
+		require.True(t, l.Allowed(newProxied("1.1.1.1, 2.2.2.2"), "d"))
+
+		// The header is ignored from untrusted addresses.
+		untrusted := newRequest("3.3.3.3:1")
+		untrusted.Header.Set("X-Forwarded-For", "1.1.1.1")
+		require.True(t, l.Allowed(untrusted, "d"))
+	})
+	t.Run("window", func(t *testing.T) {
+		l, now := newTestLimiter()
+		r := newRequest("1.1.1.1:1")
+		l.Failed(r, "a")
+		*now = now.Add(l.Window + time.Second)
+		l.Failed(r, "a")
+		require.True(t, l.Allowed(r, "a"))
+	})
+	t.Run("succeeded", func(t *testing.T) {
+		l, _ := newTestLimiter()
+		r := newRequest("1.1.1.1:1")
+		l.Failed(r, "a")
+		l.Succeeded("a")
+		l.Failed(r, "a")
+		require.True(t, l.Allowed(r, "a"))
+	})
+	t.Run("logLockout", func(t *testing.T) {
+		l, _ := newTestLimiter()
+		logger := &entryLogger{}
+		l.logger = logger
+		r := newRequest("1.1.1.1:1")
+		l.Failed(r, "a")
+		l.Failed(r, "a")
+		require.Len(t, *logger, 1)
+		require.Equal(t, log.LevelWarning, (*logger)[0].Level)
+		require.Contains(t, (*logger)[0].Msg, "action=login-lockout")
+	})
+}
+
+type entryLogger []log.Entry
+
+func (l *entryLogger) Log(entry log.Entry) {
+	*l = append(*l, entry)
+}
@@ -0,0 +1,372 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1" //nolint:gosec
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"nvr/pkg/configstore"
+	"nvr/pkg/log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RFC 6238 parameters, the defaults that all authenticator apps support.
+const (
+	totpDigits = 6
+	totpPeriod = 30 * time.Second
+	totpSkew   = 1 // Accepted time steps before and after the current step.
+
+	totpIssuer        = "OS-NVR"
+	recoveryCodeCount = 10
+)
+
+// Errors.
+var (
+	ErrTOTPEnabled     = errors.New("two-factor authentication is already enabled")
+	ErrTOTPNotEnabled  = errors.New("two-factor authentication is not enabled")
+	ErrTOTPNotEnrolled = errors.New("two-factor authentication enrollment not started")
+	ErrInvalidTOTP     = errors.New("invalid two-factor code")
+	ErrTOTPUnsupported = errors.New(
+		"two-factor authentication isn't supported by the current authentication method")
+)
+
+type totpEntry struct {
+	Secret  string `json:"secret"` // Base32 encoded.
+	Enabled bool   `json:"enabled"`
+
+	// SHA-256 hashes of the unused recovery codes.
+	RecoveryCodes [][]byte `json:"recoveryCodes,omitempty"`
+
+	// Last accepted time step, codes can't be reused.
+	LastStep int64 `json:"lastStep"`
+}
+
+// TOTP stores the time-based one-time password secrets of users.
+type TOTP struct {
+	path    string
+	entries map[string]*totpEntry // Indexed by user ID.
+	now     func() time.Time
+
+	// Set by WithTOTP if the authenticator can't verify codes.
+	unsupported bool
+
+	mu sync.Mutex
+}
+
+// NewTOTP reads the TOTP secrets from path.
+func NewTOTP(path string) (*TOTP, error) {
+	entries := make(map[string]*totpEntry)
+	err := configstore.ReadJSON(path, &entries)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("read totp secrets: %w", err)
+	}
+	return &TOTP{path: path, entries: entries, now: time.Now}, nil
+}
+
+// TOTPEnrollment secret that should be added to an authenticator app.
+type TOTPEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"` // otpauth:// URI, usually shown as a QR code.
+}
+
+// Enroll generates a new secret for the user. Two-factor
+// authentication isn't enabled until the secret is confirmed.
+func (t *TOTP) Enroll(user Account) (TOTPEnrollment, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.unsupported {
+		return TOTPEnrollment{}, ErrTOTPUnsupported
+	}
+	if entry, exists := t.entries[user.ID]; exists && entry.Enabled {
+		return TOTPEnrollment{}, ErrTOTPEnabled
+	}
+
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return TOTPEnrollment{}, fmt.Errorf("generate secret: %w", err)
+	}
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b)
+
+	t.entries[user.ID] = &totpEntry{Secret: secret}
+	if err := t.save(); err != nil {
+		return TOTPEnrollment{}, err
+	}
+	return TOTPEnrollment{Secret: secret, URI: totpURI(user.Username, secret)}, nil
+}
+
+func totpURI(username, secret string) string {
+	query := url.Values{
+		"secret":    {secret},
+		"issuer":    {totpIssuer},
+		"algorithm": {"SHA1"},
+		"digits":    {fmt.Sprint(totpDigits)},
+		"period":    {fmt.Sprint(int(totpPeriod.Seconds()))},
+	}
+	label := url.PathEscape(totpIssuer + ":" + username)
+	return "otpauth://totp/" + label + "?" + query.Encode()
+}
+
+// Confirm enables two-factor authentication if the code matches the
+// enrolled secret. Returns the recovery codes, they're only stored hashed.
+func (t *TOTP) Confirm(userID string, code string) ([]string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.unsupported {
+		return nil, ErrTOTPUnsupported
+	}
+	entry, exists := t.entries[userID]
+	if !exists {
+		return nil, ErrTOTPNotEnrolled
+	}
+	if entry.Enabled {
+		return nil, ErrTOTPEnabled
+	}
+	if !t.validateCode(entry, code) {
+		return nil, ErrInvalidTOTP
+	}
+
+	codes := make([]string, recoveryCodeCount)
+	entry.RecoveryCodes = make([][]byte, recoveryCodeCount)
+	for i := range codes {
+		codes[i] = randomHex(5)
+		hash := sha256.Sum256([]byte(codes[i]))
+		entry.RecoveryCodes[i] = hash[:]
+	}
+	entry.Enabled = true
+
+	if err := t.save(); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// Enabled returns true if two-factor authentication is enabled for the user.
+func (t *TOTP) Enabled(userID string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	entry, exists := t.entries[userID]
+	return exists && entry.Enabled
+}
+
+// RecoveryCodesLeft returns the number of unused recovery codes.
+func (t *TOTP) RecoveryCodesLeft(userID string) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	entry, exists := t.entries[userID]
+	if !exists {
+		return 0
+	}
+	return len(entry.RecoveryCodes)
+}
+
+// Verify checks a code or a recovery code. Recovery codes can only be used once.
+func (t *TOTP) Verify(userID string, code string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	entry, exists := t.entries[userID]
+	if !exists || !entry.Enabled {
+		return ErrTOTPNotEnabled
+	}
+	if t.validateCode(entry, code) {
+		return t.save()
+	}
+
+	hash := sha256.Sum256([]byte(strings.ToLower(strings.TrimSpace(code))))
+	for i, recoveryCode := range entry.RecoveryCodes {
+		if subtle.ConstantTimeCompare(hash[:], recoveryCode) == 1 {
+			entry.RecoveryCodes = append(entry.RecoveryCodes[:i], entry.RecoveryCodes[i+1:]...)
+			return t.save()
+		}
+	}
+	return ErrInvalidTOTP
+}
+
+// Disable removes the secret of the user.
+func (t *TOTP) Disable(userID string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, exists := t.entries[userID]; !exists {
+		return nil
+	}
+	delete(t.entries, userID)
+	return t.save()
+}
+
+// validateCode checks the code against the current time step
+// and the adjacent steps. Accepted steps can't be used again.
+func (t *TOTP) validateCode(entry *totpEntry, code string) bool {
+	code = strings.TrimSpace(code)
+	if len(code) != totpDigits {
+		return false
+	}
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(entry.Secret)
+	if err != nil {
+		return false
+	}
+
+	current := t.now().Unix() / int64(totpPeriod.Seconds())
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if step <= entry.LastStep {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(hotp(key, step)), []byte(code)) == 1 {
+			entry.LastStep = step
+			return true
+		}
+	}
+	return false
+}
+
+// hotp generates a RFC 4226 one-time password.
+func hotp(key []byte, counter int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(counter))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for i := 0; i < totpDigits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", totpDigits, value%mod)
+}
+
+func (t *TOTP) save() error {
+	if err := configstore.WriteJSON(t.path, t.entries); err != nil {
+		return fmt.Errorf("save totp secrets: %w", err)
+	}
+	return nil
+}
+
+// Supported returns false if the authenticator can't verify codes,
+// two-factor authentication can't be enabled in that case.
+func (t *TOTP) Supported() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return !t.unsupported
+}
+
+// TOTPLogin is implemented by authenticators that
+// verify the TOTP code when the user logs in.
+type TOTPLogin interface {
+	SetTOTP(*TOTP)
+}
+
+// WithTOTP returns an authenticator that enforces two-factor authentication.
+// If a verifies codes at login, the TOTP store is passed to it. Otherwise
+// requests from users with two-factor authentication enabled are rejected,
+// since a cannot verify the second factor. API keys should be added after
+// this wrapper, they are exempt. Enrolling is only possible if a
+// verifies codes at login.
+func WithTOTP(a Authenticator, totp *TOTP, logger log.ILogger) Authenticator {
+	login, verifiesAtLogin := a.(TOTPLogin)
+	if verifiesAtLogin {
+		login.SetTOTP(totp)
+	}
+	totp.mu.Lock()
+	totp.unsupported = !verifiesAtLogin
+	totp.mu.Unlock()
+	return &totpAuthenticator{
+		Authenticator:   a,
+		totp:            totp,
+		logger:          logger,
+		verifiesAtLogin: verifiesAtLogin,
+	}
+}
+
+type totpAuthenticator struct {
+	Authenticator
+	totp            *TOTP
+	logger          log.ILogger
+	verifiesAtLogin bool
+}
+
+// blocked returns true if the user requires a second
+// factor that the authenticator can't verify.
+func (a *totpAuthenticator) blocked(res ValidateResponse) bool {
+	return !a.verifiesAtLogin && res.IsValid && a.totp.Enabled(res.User.ID)
+}
+
+func (a *totpAuthenticator) ValidateRequest(r *http.Request) ValidateResponse {
+	res := a.Authenticator.ValidateRequest(r)
+	if a.blocked(res) {
+		return ValidateResponse{}
+	}
+	return res
+}
+
+// ValidateBasicAuth implements BasicLogin.
+func (a *totpAuthenticator) ValidateBasicAuth(r *http.Request) ValidateResponse {
+	res := ValidateBasicAuth(a.Authenticator, r)
+	if a.blocked(res) {
+		return ValidateResponse{}
+	}
+	return res
+}
+
+// UsersList also reports if two-factor authentication is enabled.
+func (a *totpAuthenticator) UsersList() map[string]AccountObfuscated {
+	users := a.Authenticator.UsersList()
+	for id, user := range users {
+		user.TOTP = a.totp.Enabled(id)
+		users[id] = user
+	}
+	return users
+}
+
+// UserDelete also removes the TOTP secret of the user.
+func (a *totpAuthenticator) UserDelete(id string) error {
+	if err := a.Authenticator.UserDelete(id); err != nil {
+		return err
+	}
+	return a.totp.Disable(id)
+}
+
+func (a *totpAuthenticator) User(next http.Handler) http.Handler {
+	return a.wrap(a.Authenticator.User(next))
+}
+
+func (a *totpAuthenticator) Admin(next http.Handler) http.Handler {
+	return a.wrap(a.Authenticator.Admin(next))
+}
+
+func (a *totpAuthenticator) CSRF(next http.Handler) http.Handler {
+	return a.wrap(a.Authenticator.CSRF(next))
+}
+
+func (a *totpAuthenticator) wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		res := a.Authenticator.ValidateRequest(r)
+		if a.blocked(res) {
+			LogAudit(a.logger, r, Audit{
+				Level:  log.LevelWarning,
+				User:   res.User.Username,
+				Action: "login-failed",
+				Target: "totp-required",
+			})
+			http.Error(w,
+				"Two-factor authentication is enabled for this account"+
+					" and isn't supported by the current authentication method.",
+				http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,218 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package auth
+
+import (
+	"encoding/base32"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"nvr/pkg/log"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHOTP(t *testing.T) {
+	// RFC 6238 appendix B, SHA1, truncated to 6 digits.
+	key := []byte("12345678901234567890")
+	cases := map[int64]string{
+		59:          "287082",
+		1111111109:  "081804",
+		1111111111:  "050471",
+		1234567890:  "005924",
+		2000000000:  "279037",
+		20000000000: "353130",
+	}
+	for unix, expected := range cases {
+		require.Equal(t, expected, hotp(key, unix/30), unix)
+	}
+}
+
+func newTestTOTP(t *testing.T) (*TOTP, *time.Time) {
+	t.Helper()
+	totp, err := NewTOTP(filepath.Join(t.TempDir(), "totp.json"))
+	require.NoError(t, err)
+	now := time.Unix(1000000000, 0)
+	totp.now = func() time.Time { return now }
+	return totp, &now
+}
+
+// codeAt returns the code of the enrolled secret at time.
+func codeAt(t *testing.T, secret string, at time.Time) string {
+	t.Helper()
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+	require.NoError(t, err)
+	return hotp(key, at.Unix()/30)
+}
+
+func TestTOTP(t *testing.T) {
+	t.Run("enroll", func(t *testing.T) {
+		totp, now := newTestTOTP(t)
+		enrollment, err := totp.Enroll(testUser)
+		require.NoError(t, err)
+		require.True(t, strings.HasPrefix(enrollment.URI, "otpauth://totp/OS-NVR:user?"))
+		require.Contains(t, enrollment.URI, "secret="+enrollment.Secret)
+		require.False(t, totp.Enabled(testUser.ID))
+
+		_, err = totp.Confirm(testUser.ID, "000000")
+		require.ErrorIs(t, err, ErrInvalidTOTP)
+
+		codes, err := totp.Confirm(testUser.ID, codeAt(t, enrollment.Secret, *now))
+		require.NoError(t, err)
+		require.Len(t, codes, recoveryCodeCount)
+		require.True(t, totp.Enabled(testUser.ID))
+
+		_, err = totp.Enroll(testUser)
+		require.ErrorIs(t, err, ErrTOTPEnabled)
+	})
+	t.Run("verify", func(t *testing.T) {
+		totp, now := newTestTOTP(t)
+		enrollment, err := totp.Enroll(testUser)
+		require.NoError(t, err)
+		_, err = totp.Confirm(testUser.ID, codeAt(t, enrollment.Secret, *now))
+		require.NoError(t, err)
+
+		// Codes can't be reused.
+		require.ErrorIs(t, totp.Verify(testUser.ID, codeAt(t, enrollment.Secret, *now)), ErrInvalidTOTP)
+
+		*now = now.Add(30 * time.Second)
+		require.NoError(t, totp.Verify(testUser.ID, codeAt(t, enrollment.Secret, *now)))
+
+		// Clock skew of one step.
+		*now = now.Add(60 * time.Second)
+		ahead := now.Add(30 * time.Second)
+		require.NoError(t, totp.Verify(testUser.ID, codeAt(t, enrollment.Secret, ahead)))
+
+		*now = now.Add(time.Hour)
+		expired := now.Add(-2 * time.Minute)
+		require.ErrorIs(t, totp.Verify(testUser.ID, codeAt(t, enrollment.Secret, expired)), ErrInvalidTOTP)
+	})
+	t.Run("recoveryCodes", func(t *testing.T) {
+		totp, now := newTestTOTP(t)
+		enrollment, err := totp.Enroll(testUser)
+		require.NoError(t, err)
+		codes, err := totp.Confirm(testUser.ID, codeAt(t, enrollment.Secret, *now))
+		require.NoError(t, err)
+
+		require.NoError(t, totp.Verify(testUser.ID, strings.ToUpper(codes[3])))
+		require.Equal(t, recoveryCodeCount-1, totp.RecoveryCodesLeft(testUser.ID))
+		require.ErrorIs(t, totp.Verify(testUser.ID, codes[3]), ErrInvalidTOTP)
+	})
+	t.Run("persistAndDisable", func(t *testing.T) {
+		totp, now := newTestTOTP(t)
+		enrollment, err := totp.Enroll(testUser)
+		require.NoError(t, err)
+		_, err = totp.Confirm(testUser.ID, codeAt(t, enrollment.Secret, *now))
+		require.NoError(t, err)
+
+		totp2, err := NewTOTP(totp.path)
+		require.NoError(t, err)
+		require.True(t, totp2.Enabled(testUser.ID))
+
+		require.NoError(t, totp2.Disable(testUser.ID))
+		require.ErrorIs(t, totp2.Verify(testUser.ID, "000000"), ErrTOTPNotEnabled)
+	})
+}
+
+// validAuthenticator accepts all requests as testUser.
+type validAuthenticator struct {
+	stubAuthenticator
+}
+
+func (validAuthenticator) ValidateRequest(*http.Request) ValidateResponse {
+	return ValidateResponse{IsValid: true, User: testUser}
+}
+
+func (validAuthenticator) User(next http.Handler) http.Handler { return next }
+
+type loginAuthenticator struct {
+	validAuthenticator
+	totp *TOTP
+}
+
+func (a *loginAuthenticator) SetTOTP(totp *TOTP) { a.totp = totp }
+
+func TestWithTOTP(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	serve := func(a Authenticator) int {
+		w := httptest.NewRecorder()
+		a.User(ok).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		return w.Code
+	}
+
+	t.Run("blocked", func(t *testing.T) {
+		totp, now := newTestTOTP(t)
+		require.Equal(t, http.StatusOK, serve(
+			WithTOTP(validAuthenticator{}, totp, log.NewDummyLogger())))
+
+		// Enabled before switching to an authenticator without TOTP support.
+		totp, now = newTestTOTP(t)
+		enrollment, err := totp.Enroll(testUser)
+		require.NoError(t, err)
+		_, err = totp.Confirm(testUser.ID, codeAt(t, enrollment.Secret, *now))
+		require.NoError(t, err)
+		a := WithTOTP(validAuthenticator{}, totp, log.NewDummyLogger())
+
+		require.Equal(t, http.StatusUnauthorized, serve(a))
+		require.False(t, a.ValidateRequest(httptest.NewRequest(http.MethodGet, "/", nil)).IsValid)
+		require.True(t, a.UsersList()[testUser.ID].TOTP)
+	})
+	t.Run("verifiesAtLogin", func(t *testing.T) {
+		totp, now := newTestTOTP(t)
+		inner := &loginAuthenticator{}
+		a := WithTOTP(inner, totp, log.NewDummyLogger())
+		require.Equal(t, totp, inner.totp)
+
+		enrollment, err := totp.Enroll(testUser)
+		require.NoError(t, err)
+		_, err = totp.Confirm(testUser.ID, codeAt(t, enrollment.Secret, *now))
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, serve(a))
+		require.True(t, totp.Supported())
+	})
+	t.Run("unsupported", func(t *testing.T) {
+		totp, _ := newTestTOTP(t)
+		WithTOTP(validAuthenticator{}, totp, log.NewDummyLogger())
+		require.False(t, totp.Supported())
+
+		_, err := totp.Enroll(testUser)
+		require.ErrorIs(t, err, ErrTOTPUnsupported)
+		_, err = totp.Confirm(testUser.ID, "123456")
+		require.ErrorIs(t, err, ErrTOTPUnsupported)
+	})
+}
+
+// basicAuthenticator accepts all requests through BasicLogin.
+type basicAuthenticator struct {
+	stubAuthenticator
+}
+
+func (basicAuthenticator) ValidateBasicAuth(*http.Request) ValidateResponse {
+	return ValidateResponse{IsValid: true, User: testUser}
+}
+
+func TestValidateBasicAuth(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	require.False(t, ValidateBasicAuth(stubAuthenticator{}, r).IsValid)
+
+	// Forwarded by the wrappers.
+	newAuth := func(totp *TOTP) Authenticator {
+		a := WithTOTP(basicAuthenticator{}, totp, log.NewDummyLogger())
+		return WithAPIKeys(a, newTestAPIKeys(t), log.NewDummyLogger())
+	}
+	totp, now := newTestTOTP(t)
+	require.True(t, ValidateBasicAuth(newAuth(totp), r).IsValid)
+
+	// Enabled before switching to an authenticator without TOTP support.
+	totp, now = newTestTOTP(t)
+	WithTOTP(&loginAuthenticator{}, totp, log.NewDummyLogger())
+	enrollment, err := totp.Enroll(testUser)
+	require.NoError(t, err)
+	_, err = totp.Confirm(testUser.ID, codeAt(t, enrollment.Secret, *now))
+	require.NoError(t, err)
+	require.False(t, ValidateBasicAuth(newAuth(totp), r).IsValid)
+}
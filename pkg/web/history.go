@@ -0,0 +1,117 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"nvr/pkg/history"
+	"nvr/pkg/web/auth"
+	"strconv"
+)
+
+// RecordFunc records a config revision made by the request.
+// newConf is nil if the object was deleted.
+type RecordFunc func(r *http.Request, object string, oldConf, newConf map[string]string)
+
+// RollbackFunc applies a previous config revision to the object with id.
+// conf is nil if the object should be deleted.
+type RollbackFunc func(id string, conf map[string]string) error
+
+// userConfig returns the user config without sensitive information
+// or nil if the user doesn't exist. Passwords are not tracked.
+func userConfig(a auth.Authenticator, id string) map[string]string {
+	user, exists := a.UsersList()[id]
+	if !exists {
+		return nil
+	}
+	access, _ := json.Marshal(user.Access)
+	return map[string]string{
+		"id":       user.ID,
+		"username": user.Username,
+		"isAdmin":  strconv.FormatBool(user.IsAdmin),
+		"access":   string(access),
+	}
+}
+
+// History returns the revisions of an object.
+func History(store *history.Store) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
+			return
+		}
+
+		revisions, err := store.Revisions(r.URL.Query().Get("object"))
+		if errors.Is(err, history.ErrInvalidObject) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", jsonContentType)
+		err = json.NewEncoder(w).Encode(revisions)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	})
+}
+
+// HistoryRollback handler to roll back an object to a previous revision.
+// rollbackFuncs are indexed by object kind.
+func HistoryRollback(
+	store *history.Store,
+	username func(*http.Request) string,
+	rollbackFuncs map[string]RollbackFunc,
+) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
+			return
+		}
+
+		query := r.URL.Query()
+		object := query.Get("object")
+		kind, id, err := history.SplitObject(object)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		rollback, exists := rollbackFuncs[kind]
+		if !exists {
+			http.Error(w, "unknown object kind", http.StatusBadRequest)
+			return
+		}
+
+		revisionID, err := strconv.Atoi(query.Get("revision"))
+		if err != nil {
+			http.Error(w, "invalid revision", http.StatusBadRequest)
+			return
+		}
+		revision, err := store.Revision(object, revisionID)
+		if errors.Is(err, history.ErrRevisionNotExist) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if err := rollback(id, revision.Config); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		err = store.RecordRollback(object, username(r), revision.ID, revision.Config)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	})
+}
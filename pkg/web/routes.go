@@ -3,21 +3,31 @@
 package web
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"mime/multipart"
 	"net/http"
+	"net/textproto"
 	"net/url"
+	"nvr/pkg/backup"
+	"nvr/pkg/ffmpeg"
 	"nvr/pkg/group"
+	"nvr/pkg/history"
 	"nvr/pkg/log"
+	"nvr/pkg/metrics"
 	"nvr/pkg/monitor"
 	"nvr/pkg/storage"
+	"nvr/pkg/video/hls"
 	"nvr/pkg/web/auth"
 	"nvr/web/static"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 	"unicode"
 
 	"github.com/gorilla/websocket"
@@ -74,7 +84,7 @@ func General(general *storage.ConfigGeneral) http.Handler {
 }
 
 // GeneralSet handler to set general configuration.
-func GeneralSet(general *storage.ConfigGeneral) http.Handler {
+func GeneralSet(general *storage.ConfigGeneral, record RecordFunc) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPut {
 			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
@@ -93,11 +103,13 @@ func GeneralSet(general *storage.ConfigGeneral) http.Handler {
 			return
 		}
 
+		oldConfig := general.Get()
 		err = general.Set(config)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		record(r, history.Object("general", ""), oldConfig, config)
 	})
 }
 
@@ -119,7 +131,7 @@ func Users(a auth.Authenticator) http.Handler {
 }
 
 // UserSet handler to set user details.
-func UserSet(a auth.Authenticator) http.Handler {
+func UserSet(a auth.Authenticator, record RecordFunc) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPut {
 			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
@@ -143,16 +155,18 @@ func UserSet(a auth.Authenticator) http.Handler {
 			}
 		}
 
+		oldUser := userConfig(a, req.ID)
 		err = a.UserSet(req)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
+		record(r, history.Object("user", req.ID), oldUser, userConfig(a, req.ID))
 	})
 }
 
 // UserDelete handler to delete user.
-func UserDelete(a auth.Authenticator) http.Handler {
+func UserDelete(a auth.Authenticator, record RecordFunc) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodDelete {
 			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
@@ -165,24 +179,27 @@ func UserDelete(a auth.Authenticator) http.Handler {
 			return
 		}
 
+		oldUser := userConfig(a, name)
 		err := a.UserDelete(name)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		record(r, history.Object("user", name), oldUser, nil)
 	})
 }
 
-// MonitorList returns a censored monitor list.
-func MonitorList(monitorInfo func() monitor.RawConfigs) http.Handler {
+// MonitorList returns a censored list of the monitors the user can access.
+func MonitorList(monitorInfo func() monitor.RawConfigs, acl *ACL) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
 			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
 			return
 		}
 
+		user := acl.auth.ValidateRequest(r).User
 		w.Header().Set("Content-Type", jsonContentType)
-		err := json.NewEncoder(w).Encode(monitorInfo())
+		err := json.NewEncoder(w).Encode(acl.FilterMonitors(user, monitorInfo()))
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
@@ -190,6 +207,259 @@ func MonitorList(monitorInfo func() monitor.RawConfigs) http.Handler {
 	})
 }
 
+// Metrics serves metrics in the Prometheus text format.
+func Metrics(collectors []metrics.Collector) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var b bytes.Buffer
+		e := metrics.NewEncoder(&b)
+		for _, collect := range collectors {
+			collect(e)
+		}
+		if err := e.Err(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		b.WriteTo(w) //nolint:errcheck
+	})
+}
+
+// MonitorStatus returns the health status of the monitors the user can access.
+func MonitorStatus(statusFunc func() monitor.StatusMap, acl *ACL) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
+			return
+		}
+
+		_, access := acl.RequestAccess(r)
+		w.Header().Set("Content-Type", jsonContentType)
+		err := json.NewEncoder(w).Encode(filterStatus(statusFunc(), access))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	})
+}
+
+func filterStatus(status monitor.StatusMap, access MonitorAccess) monitor.StatusMap {
+	filtered := make(monitor.StatusMap)
+	for id, s := range status {
+		if access.Allowed(id) {
+			filtered[id] = s
+		}
+	}
+	return filtered
+}
+
+// MonitorStatusFeed opens a websocket that sends the
+// status of the monitors the user can access every interval.
+func MonitorStatusFeed(
+	statusFunc func() monitor.StatusMap,
+	acl *ACL,
+	interval time.Duration,
+) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
+			return
+		}
+
+		upgrader := websocket.Upgrader{}
+		c, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer c.Close()
+
+		// Detect when the client closes the connection.
+		closed := make(chan struct{})
+		go func() {
+			for {
+				if _, _, err := c.NextReader(); err != nil {
+					close(closed)
+					return
+				}
+			}
+		}()
+
+		for {
+			// Validate auth and access before each message.
+			if !acl.auth.ValidateRequest(r).IsValid {
+				return
+			}
+			_, access := acl.RequestAccess(r)
+			if err := c.WriteJSON(filterStatus(statusFunc(), access)); err != nil {
+				return
+			}
+
+			select {
+			case <-closed:
+				return
+			case <-time.After(interval):
+			}
+		}
+	})
+}
+
+// MJPEGSubscribeFunc subscribes to the JPEG frames of a monitor.
+type MJPEGSubscribeFunc func(string, monitor.MJPEGOptions) (<-chan []byte, func(), error)
+
+const mjpegMaxFPS = 30
+
+// MonitorMJPEG streams the main input of a monitor as "multipart/x-mixed-replace"
+// JPEG frames for clients that can't play HLS. Defaults to 2 fps at full scale.
+// Auth and access are validated again every interval, the stream ends if the
+// session expired or the user lost access to the monitor.
+func MonitorMJPEG(subscribe MJPEGSubscribeFunc, acl *ACL, interval time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
+			return
+		}
+
+		query := r.URL.Query()
+		id := query.Get("id")
+		if id == "" {
+			http.Error(w, "id missing", http.StatusBadRequest)
+			return
+		}
+
+		opts := monitor.MJPEGOptions{FPS: 2, Scale: "1"}
+		if rawFPS := query.Get("fps"); rawFPS != "" {
+			fps, err := strconv.Atoi(rawFPS)
+			if err != nil || fps < 1 || fps > mjpegMaxFPS {
+				http.Error(w, fmt.Sprintf("fps must be between 1 and %d", mjpegMaxFPS),
+					http.StatusBadRequest)
+				return
+			}
+			opts.FPS = fps
+		}
+		if rawScale := query.Get("scale"); rawScale != "" {
+			opts.Scale = ffmpeg.ParseScaleString(rawScale)
+			if opts.Scale == "" {
+				http.Error(w, "invalid scale", http.StatusBadRequest)
+				return
+			}
+		}
+
+		frames, unsubscribe, err := subscribe(id, opts)
+		switch {
+		case errors.Is(err, monitor.ErrMonitorNotExist):
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		case errors.Is(err, monitor.ErrMonitorDisabled):
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer unsubscribe()
+
+		mw := multipart.NewWriter(w)
+		w.Header().Set("Content-Type", "multipart/x-mixed-replace; boundary="+mw.Boundary())
+		w.Header().Set("Cache-Control", "no-store")
+		flusher, _ := w.(http.Flusher)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-ticker.C:
+				res := acl.auth.ValidateRequest(r)
+				if !res.IsValid || !acl.Access(res.User).Allowed(id) {
+					return
+				}
+			case frame, ok := <-frames:
+				if !ok {
+					// Monitor stopped.
+					mw.Close()
+					return
+				}
+				part, err := mw.CreatePart(textproto.MIMEHeader{
+					"Content-Type":   {"image/jpeg"},
+					"Content-Length": {strconv.Itoa(len(frame))},
+				})
+				if err != nil {
+					return
+				}
+				if _, err := part.Write(frame); err != nil {
+					return
+				}
+				if flusher != nil {
+					flusher.Flush()
+				}
+			}
+		}
+	})
+}
+
+// SnapshotFunc returns a JPEG of the current frame of a monitor.
+type SnapshotFunc func(context.Context, string, monitor.SnapshotOptions) ([]byte, error)
+
+// MonitorSnapshot returns a JPEG of the latest keyframe of a monitor.
+func MonitorSnapshot(snapshot SnapshotFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
+			return
+		}
+
+		query := r.URL.Query()
+		id := query.Get("id")
+		if id == "" {
+			http.Error(w, "id missing", http.StatusBadRequest)
+			return
+		}
+
+		opts := monitor.SnapshotOptions{
+			Sub:   query.Get("sub") == "true",
+			Scale: "1",
+		}
+		if rawScale := query.Get("scale"); rawScale != "" {
+			opts.Scale = ffmpeg.ParseScaleString(rawScale)
+			if opts.Scale == "" {
+				http.Error(w, "invalid scale", http.StatusBadRequest)
+				return
+			}
+		}
+
+		jpeg, err := snapshot(r.Context(), id, opts)
+		switch {
+		case errors.Is(err, monitor.ErrMonitorNotExist),
+			errors.Is(err, monitor.ErrSubInputDisabled):
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		case errors.Is(err, monitor.ErrMonitorDisabled):
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		case errors.Is(err, monitor.ErrInputNotStarted),
+			errors.Is(err, hls.ErrNoSegments):
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		case err != nil:
+			http.Error(w, fmt.Sprintf("could not generate snapshot: %v", err),
+				http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Header().Set("Cache-Control", "no-store")
+		w.Write(jpeg) //nolint:errcheck
+	})
+}
+
 // MonitorConfigs returns monitor configurations in json format.
 func MonitorConfigs(c *monitor.Manager) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -207,6 +477,107 @@ func MonitorConfigs(c *monitor.Manager) http.Handler {
 	})
 }
 
+// MonitorConfig handler to get or patch the config of a single monitor.
+// Patch requests are passed through csrf.
+func MonitorConfig(
+	m *monitor.Manager,
+	csrf func(http.Handler) http.Handler,
+	record RecordFunc,
+) http.Handler {
+	patch := csrf(monitorPatch(m, record))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			monitorGet(m, w, r)
+		case http.MethodPatch:
+			patch.ServeHTTP(w, r)
+		default:
+			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+func monitorGet(m *monitor.Manager, w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		http.Error(w, "id missing", http.StatusBadRequest)
+		return
+	}
+
+	c, etag, err := m.MonitorConfig(id)
+	if errors.Is(err, monitor.ErrNotExist) {
+		http.Error(w, "monitor does not exist", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("ETag", etag)
+	if monitor.MatchETag(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", jsonContentType)
+	err = json.NewEncoder(w).Encode(c)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+func monitorPatch(m *monitor.Manager, record RecordFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.URL.Query().Get("id")
+		if id == "" {
+			http.Error(w, "id missing", http.StatusBadRequest)
+			return
+		}
+
+		var patch map[string]json.RawMessage
+		err := json.NewDecoder(r.Body).Decode(&patch)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		oldConf, _, _ := m.MonitorConfig(id)
+		action, etag, err := m.MonitorPatch(id, r.Header.Get("If-Match"), patch)
+		var fieldErrs monitor.FieldErrors
+		switch {
+		case errors.Is(err, monitor.ErrNotExist):
+			http.Error(w, "monitor does not exist", http.StatusNotFound)
+			return
+		case errors.Is(err, monitor.ErrConfigChanged):
+			http.Error(w, err.Error(), http.StatusPreconditionFailed)
+			return
+		case errors.As(err, &fieldErrs):
+			writeFieldErrors(w, fieldErrs)
+			return
+		case errors.Is(err, monitor.ErrInvalidPatch):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		newConf, _, _ := m.MonitorConfig(id)
+		record(r, history.Object("monitor", id), oldConf, newConf)
+
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Content-Type", jsonContentType)
+		err = json.NewEncoder(w).Encode(struct {
+			Action monitor.ApplyAction `json:"action"`
+		}{action})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	})
+}
+
 // MonitorRestart handler to restart monitor.
 func MonitorRestart(m *monitor.Manager) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -229,8 +600,50 @@ func MonitorRestart(m *monitor.Manager) http.Handler {
 	})
 }
 
+// MonitorEvent handler to send an event to a monitor. The time
+// defaults to now, the event is recorded for its duration.
+func MonitorEvent(m *monitor.Manager) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
+			return
+		}
+
+		id := r.URL.Query().Get("id")
+		if id == "" {
+			http.Error(w, "id missing", http.StatusBadRequest)
+			return
+		}
+
+		var event storage.Event
+		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+			http.Error(w, "unmarshal error: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if event.Duration <= 0 {
+			http.Error(w, "duration missing", http.StatusBadRequest)
+			return
+		}
+		if event.Time.IsZero() {
+			event.Time = time.Now()
+		}
+		event.RecDuration = event.Duration
+
+		err := m.SendEvent(id, event)
+		switch {
+		case errors.Is(err, monitor.ErrMonitorNotExist):
+			http.Error(w, err.Error(), http.StatusNotFound)
+		case errors.Is(err, monitor.ErrMonitorDisabled):
+			http.Error(w, err.Error(), http.StatusConflict)
+		case err != nil:
+			http.Error(w, fmt.Sprintf("could not send event: %v", err),
+				http.StatusInternalServerError)
+		}
+	})
+}
+
 // MonitorSet handler to set monitor configuration.
-func MonitorSet(m *monitor.Manager) http.Handler {
+func MonitorSet(m *monitor.Manager, record RecordFunc) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPut {
 			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
@@ -244,12 +657,53 @@ func MonitorSet(m *monitor.Manager) http.Handler {
 			return
 		}
 
-		if err := checkIDandName(c); err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+		if errs := m.ValidateConfig(c); len(errs) != 0 {
+			writeFieldErrors(w, errs)
+			return
+		}
+
+		oldConf, _, _ := m.MonitorConfig(c["id"])
+		action, etag, err := m.MonitorSetIfMatch(c["id"], r.Header.Get("If-Match"), c)
+		if errors.Is(err, monitor.ErrConfigChanged) {
+			http.Error(w, err.Error(), http.StatusPreconditionFailed)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		record(r, history.Object("monitor", c["id"]), oldConf, c)
 
-		err = m.MonitorSet(c["id"], c)
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Content-Type", jsonContentType)
+		err = json.NewEncoder(w).Encode(struct {
+			Action monitor.ApplyAction `json:"action"`
+		}{action})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	})
+}
+
+func writeFieldErrors(w http.ResponseWriter, errs monitor.FieldErrors) {
+	w.Header().Set("Content-Type", jsonContentType)
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(struct { //nolint:errcheck
+		Errors monitor.FieldErrors `json:"errors"`
+	}{errs})
+}
+
+// MonitorSchema returns the monitor config schema.
+func MonitorSchema(m *monitor.Manager) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", jsonContentType)
+		err := json.NewEncoder(w).Encode(m.Schema())
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
@@ -258,7 +712,7 @@ func MonitorSet(m *monitor.Manager) http.Handler {
 }
 
 // MonitorDelete handler to delete monitor.
-func MonitorDelete(m *monitor.Manager) http.Handler {
+func MonitorDelete(m *monitor.Manager, record RecordFunc) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodDelete {
 			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
@@ -271,24 +725,27 @@ func MonitorDelete(m *monitor.Manager) http.Handler {
 			return
 		}
 
+		oldConf, _, _ := m.MonitorConfig(id)
 		err := m.MonitorDelete(id)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		record(r, history.Object("monitor", id), oldConf, nil)
 	})
 }
 
 // GroupConfigs returns group configurations in json format.
-func GroupConfigs(m *group.Manager) http.Handler {
+func GroupConfigs(m *group.Manager, acl *ACL) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
 			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
 			return
 		}
 
+		user := acl.auth.ValidateRequest(r).User
 		w.Header().Set("Content-Type", jsonContentType)
-		err := json.NewEncoder(w).Encode(m.Configs())
+		err := json.NewEncoder(w).Encode(acl.FilterGroups(user, m.Configs()))
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
@@ -297,7 +754,7 @@ func GroupConfigs(m *group.Manager) http.Handler {
 }
 
 // GroupSet handler to set group configuration.
-func GroupSet(m *group.Manager) http.Handler {
+func GroupSet(m *group.Manager, record RecordFunc) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPut {
 			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
@@ -316,10 +773,12 @@ func GroupSet(m *group.Manager) http.Handler {
 			return
 		}
 
+		oldConfig := m.Configs()[g["id"]]
 		if err = m.GroupSet(g["id"], g); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		record(r, history.Object("group", g["id"]), oldConfig, g)
 	})
 }
 
@@ -327,26 +786,8 @@ func GroupSet(m *group.Manager) http.Handler {
 var (
 	ErrEmptyValue     = errors.New("value cannot be empty")
 	ErrContainsSpaces = errors.New("value cannot contain spaces")
-	ErrIDTooLong      = errors.New("id cannot be longer than 24 bytes")
 )
 
-func checkIDandName(c monitor.RawConfig) error {
-	switch {
-	case c["id"] == "":
-		return fmt.Errorf("id: %w", ErrEmptyValue)
-	case containsSpaces(c["id"]):
-		return fmt.Errorf("id: %w", ErrContainsSpaces)
-	case c["name"] == "":
-		return fmt.Errorf("name: %w", ErrEmptyValue)
-	case containsSpaces(c["name"]):
-		return fmt.Errorf("name: %w", ErrContainsSpaces)
-	case len(c["id"]) > 24:
-		return ErrIDTooLong
-	default:
-		return nil
-	}
-}
-
 func checkIDandNameGroup(input map[string]string) error {
 	switch {
 	case input["id"] == "":
@@ -363,7 +804,7 @@ func checkIDandNameGroup(input map[string]string) error {
 }
 
 // GroupDelete handler to delete group.
-func GroupDelete(m *group.Manager) http.Handler {
+func GroupDelete(m *group.Manager, record RecordFunc) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodDelete {
 			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
@@ -376,11 +817,13 @@ func GroupDelete(m *group.Manager) http.Handler {
 			return
 		}
 
+		oldConfig := m.Configs()[id]
 		err := m.GroupDelete(id)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		record(r, history.Object("group", id), oldConfig, nil)
 	})
 }
 
@@ -495,8 +938,9 @@ func containsDotDot(v string) bool {
 
 func isSlashRune(r rune) bool { return r == '/' || r == '\\' }
 
-// RecordingQuery handles recording query.
-func RecordingQuery(crawler *storage.Crawler, logger *log.Logger) http.Handler { //nolint:funlen
+// RecordingQuery handles recording query. Only recordings
+// of monitors that the user can access are returned.
+func RecordingQuery(crawler *storage.Crawler, logger *log.Logger, acl *ACL) http.Handler { //nolint:funlen
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
 			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
@@ -533,6 +977,15 @@ func RecordingQuery(crawler *storage.Crawler, logger *log.Logger) http.Handler {
 			monitors = strings.Split(monitorsCSV, ",")
 		}
 
+		if _, access := acl.RequestAccess(r); !access.all {
+			monitors = access.Filter(monitors)
+			if len(monitors) == 0 {
+				w.Header().Set("Content-Type", jsonContentType)
+				w.Write([]byte("[]")) //nolint:errcheck
+				return
+			}
+		}
+
 		var data bool
 		if query.Get("data") == "true" {
 			data = true
@@ -639,7 +1092,7 @@ func LogFeed(logger *log.Logger, a auth.Authenticator) http.Handler { //nolint:f
 }
 
 // LogQuery handles log queries.
-func LogQuery(logStore *log.Store) http.Handler {
+func LogQuery(logStores ...*log.Store) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
 			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
@@ -691,7 +1144,7 @@ func LogQuery(logStore *log.Store) http.Handler {
 			Limit:    limitInt,
 		}
 
-		logs, err := logStore.Query(q)
+		logs, err := log.QueryStores(q, logStores...)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
@@ -735,3 +1188,78 @@ func LogSources(l *log.Logger) http.Handler {
 func containsSpaces(s string) bool {
 	return strings.Contains(s, " ")
 }
+
+// Backup serves an archive of the config directory.
+func Backup(configDir string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var buf bytes.Buffer
+		if err := backup.Create(&buf, configDir); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		name := backup.FileName(time.Now())
+		w.Header().Set("Content-Type", "application/gzip")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+		w.Write(buf.Bytes()) //nolint:errcheck
+	})
+}
+
+const maxBackupSize = 64 * 1000 * 1000
+
+// BackupRestore schedules the config directory to be restored from an
+// archive on the next start, env.yaml isn't restored. Only the changes
+// are reported if the "dryRun" query is true.
+func BackupRestore(configDir string, migrate backup.MigrateFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
+			return
+		}
+
+		files, err := backup.Read(http.MaxBytesReader(w, r.Body, maxBackupSize))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := files.Validate(migrate); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		files = files.WithoutEnv()
+
+		changes, err := files.Diff(configDir)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		dryRun := r.URL.Query().Get("dryRun") == "true"
+		restartRequired := !dryRun && len(changes) != 0
+		if restartRequired {
+			if err := files.Schedule(configDir); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+
+		if changes == nil {
+			changes = []backup.Change{}
+		}
+		w.Header().Set("Content-Type", jsonContentType)
+		err = json.NewEncoder(w).Encode(struct {
+			DryRun          bool            `json:"dryRun"`
+			RestartRequired bool            `json:"restartRequired"`
+			Changes         []backup.Change `json:"changes"`
+		}{dryRun, restartRequired, changes})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	})
+}
@@ -1,8 +1,24 @@
 package web
 
 import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
+	"nvr/pkg/backup"
+	"nvr/pkg/log"
+	"nvr/pkg/monitor"
+	"nvr/pkg/video/hls"
+	"nvr/pkg/web/auth"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
@@ -24,3 +40,214 @@ func TestParseCSVParam(t *testing.T) {
 		})
 	}
 }
+
+func TestMonitorMJPEG(t *testing.T) {
+	t.Run("ok", func(t *testing.T) {
+		var gotID string
+		var gotOpts monitor.MJPEGOptions
+		unsubscribed := false
+		subscribe := func(id string, opts monitor.MJPEGOptions) (<-chan []byte, func(), error) {
+			gotID, gotOpts = id, opts
+			frames := make(chan []byte, 2)
+			frames <- []byte("a")
+			frames <- []byte("bc")
+			close(frames)
+			return frames, func() { unsubscribed = true }, nil
+		}
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/?id=x&fps=5&scale=half", nil)
+		MonitorMJPEG(subscribe, newTestACL(auth.Account{}), time.Hour).ServeHTTP(w, r)
+
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, "x", gotID)
+		require.Equal(t, monitor.MJPEGOptions{FPS: 5, Scale: "2"}, gotOpts)
+		require.True(t, unsubscribed)
+
+		mediaType, params, err := mime.ParseMediaType(w.Header().Get("Content-Type"))
+		require.NoError(t, err)
+		require.Equal(t, "multipart/x-mixed-replace", mediaType)
+
+		mr := multipart.NewReader(w.Body, params["boundary"])
+		for _, want := range []string{"a", "bc"} {
+			part, err := mr.NextPart()
+			require.NoError(t, err)
+			require.Equal(t, "image/jpeg", part.Header.Get("Content-Type"))
+			frame, err := io.ReadAll(part)
+			require.NoError(t, err)
+			require.Equal(t, want, string(frame))
+		}
+	})
+	t.Run("authRevoked", func(t *testing.T) {
+		frames := make(chan []byte)
+		unsubscribed := make(chan struct{})
+		subscribe := func(string, monitor.MJPEGOptions) (<-chan []byte, func(), error) {
+			return frames, func() { close(unsubscribed) }, nil
+		}
+
+		acl := NewACL(keyAuth{}, newTestACL(auth.Account{}).groups)
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/?id=m1", nil)
+		MonitorMJPEG(subscribe, acl, time.Millisecond).ServeHTTP(w, r)
+
+		// The stream ends at the first check.
+		<-unsubscribed
+		require.Equal(t, http.StatusOK, w.Code)
+	})
+	t.Run("accessRevoked", func(t *testing.T) {
+		frames := make(chan []byte)
+		subscribe := func(string, monitor.MJPEGOptions) (<-chan []byte, func(), error) {
+			return frames, func() {}, nil
+		}
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/?id=m4", nil)
+		MonitorMJPEG(subscribe, newTestACL(restrictedUser), time.Millisecond).ServeHTTP(w, r)
+		require.Equal(t, http.StatusOK, w.Code)
+	})
+	t.Run("errors", func(t *testing.T) {
+		subscribe := func(id string, _ monitor.MJPEGOptions) (<-chan []byte, func(), error) {
+			if id == "disabled" {
+				return nil, nil, monitor.ErrMonitorDisabled
+			}
+			return nil, nil, monitor.ErrMonitorNotExist
+		}
+		cases := map[string]int{
+			"/":                        http.StatusBadRequest,
+			"/?id=x&fps=0":             http.StatusBadRequest,
+			"/?id=x&fps=31":            http.StatusBadRequest,
+			"/?id=x&scale=nil":         http.StatusBadRequest,
+			"/?id=x":                   http.StatusNotFound,
+			"/?id=disabled&fps=1":      http.StatusConflict,
+			"/?id=disabled&scale=FULL": http.StatusConflict,
+		}
+		for url, want := range cases {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, url, nil)
+			MonitorMJPEG(subscribe, newTestACL(auth.Account{}), time.Hour).ServeHTTP(w, r)
+			require.Equal(t, want, w.Code, url)
+		}
+	})
+}
+
+func TestMonitorSnapshot(t *testing.T) {
+	snapshot := func(_ context.Context, id string, opts monitor.SnapshotOptions) ([]byte, error) {
+		switch id {
+		case "ok":
+			return []byte(fmt.Sprintf("%v %v", opts.Sub, opts.Scale)), nil
+		case "disabled":
+			return nil, monitor.ErrMonitorDisabled
+		case "starting":
+			return nil, fmt.Errorf("latest segment: %w", hls.ErrNoSegments)
+		}
+		return nil, monitor.ErrMonitorNotExist
+	}
+	cases := []struct {
+		url          string
+		expectedCode int
+		expectedBody string
+	}{
+		{"/?id=ok", http.StatusOK, "false 1"},
+		{"/?id=ok&sub=true&scale=quarter", http.StatusOK, "true 4"},
+		{"/", http.StatusBadRequest, ""},
+		{"/?id=ok&scale=x", http.StatusBadRequest, ""},
+		{"/?id=x", http.StatusNotFound, ""},
+		{"/?id=disabled", http.StatusConflict, ""},
+		{"/?id=starting", http.StatusServiceUnavailable, ""},
+	}
+	for _, tc := range cases {
+		t.Run(tc.url, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, tc.url, nil)
+			MonitorSnapshot(snapshot).ServeHTTP(w, r)
+			require.Equal(t, tc.expectedCode, w.Code)
+			if tc.expectedCode == http.StatusOK {
+				require.Equal(t, "image/jpeg", w.Header().Get("Content-Type"))
+				require.Equal(t, tc.expectedBody, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestBackupRestore(t *testing.T) {
+	configDir := t.TempDir()
+	envPath := filepath.Join(configDir, "env.yaml")
+	require.NoError(t, os.WriteFile(envPath, []byte("goBin: /usr/bin/go\n"), 0o600))
+
+	var archive bytes.Buffer
+	files := backup.Files{
+		"env.yaml":        []byte("goBin: /tmp/x\n"),
+		"monitors/1.json": []byte(`{"id":"1"}`),
+	}
+	archiveDir := t.TempDir()
+	for p, file := range files {
+		path := filepath.Join(archiveDir, p)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o700))
+		require.NoError(t, os.WriteFile(path, file, 0o600))
+	}
+	require.NoError(t, backup.Create(&archive, archiveDir))
+
+	noop := func(map[string]string) error { return nil }
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/", &archive)
+	BackupRestore(configDir, noop).ServeHTTP(w, r)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	expected := `{"dryRun":false,"restartRequired":true,` +
+		`"changes":[{"path":"monitors/1.json","action":"add"}]}` + "\n"
+	require.Equal(t, expected, w.Body.String())
+
+	// Nothing is restored until the next start.
+	require.NoFileExists(t, filepath.Join(configDir, "monitors", "1.json"))
+	restored, err := backup.RestorePending(configDir)
+	require.NoError(t, err)
+	require.True(t, restored)
+	require.FileExists(t, filepath.Join(configDir, "monitors", "1.json"))
+
+	env, err := os.ReadFile(envPath)
+	require.NoError(t, err)
+	require.Equal(t, "goBin: /usr/bin/go\n", string(env))
+}
+
+type stubAuthenticator struct {
+	auth.Authenticator
+	users map[string]auth.AccountObfuscated
+}
+
+func (a stubAuthenticator) AuthDisabled() bool { return false }
+
+func (a stubAuthenticator) UsersList() map[string]auth.AccountObfuscated { return a.users }
+
+func (a stubAuthenticator) Admin(http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	})
+}
+
+func TestBackupAPIKeyScope(t *testing.T) {
+	admin := auth.Account{ID: "1", Username: "admin", IsAdmin: true}
+	keys, err := auth.NewAPIKeys(filepath.Join(t.TempDir(), "apikeys.json"))
+	require.NoError(t, err)
+	a := auth.WithAPIKeys(stubAuthenticator{
+		users: map[string]auth.AccountObfuscated{
+			admin.ID: {ID: admin.ID, Username: admin.Username, IsAdmin: true},
+		},
+	}, keys, log.NewDummyLogger())
+
+	configDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(configDir, "users.json"), []byte("{}"), 0o600))
+	handler := a.Admin(Backup(configDir))
+
+	get := func(scope auth.Scope) int {
+		key, _, err := keys.Create(admin, auth.CreateAPIKeyRequest{Name: "x", Scope: scope})
+		require.NoError(t, err)
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/api/backup", nil)
+		r.Header.Set("Authorization", "Bearer "+key)
+		handler.ServeHTTP(w, r)
+		return w.Code
+	}
+	require.Equal(t, http.StatusOK, get(auth.ScopeAdmin))
+	require.Equal(t, http.StatusUnauthorized, get(auth.ScopeReadOnly))
+	require.Equal(t, http.StatusUnauthorized, get(auth.ScopeEventsIn))
+}
@@ -0,0 +1,169 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"nvr/pkg/web/auth"
+)
+
+type totpStatus struct {
+	Supported         bool `json:"supported"`
+	Enabled           bool `json:"enabled"`
+	RecoveryCodesLeft int  `json:"recoveryCodesLeft"`
+}
+
+// TOTPStatus returns the two-factor status of the requesting user.
+func TOTPStatus(totp *auth.TOTP, a auth.Authenticator) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
+			return
+		}
+
+		userID := a.ValidateRequest(r).User.ID
+		status := totpStatus{
+			Supported:         totp.Supported(),
+			Enabled:           totp.Enabled(userID),
+			RecoveryCodesLeft: totp.RecoveryCodesLeft(userID),
+		}
+
+		w.Header().Set("Content-Type", jsonContentType)
+		if err := json.NewEncoder(w).Encode(status); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	})
+}
+
+// TOTPEnroll generates a new secret for the requesting user.
+func TOTPEnroll(totp *auth.TOTP, a auth.Authenticator) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
+			return
+		}
+
+		enrollment, err := totp.Enroll(a.ValidateRequest(r).User)
+		if errors.Is(err, auth.ErrTOTPEnabled) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if errors.Is(err, auth.ErrTOTPUnsupported) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", jsonContentType)
+		if err := json.NewEncoder(w).Encode(enrollment); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	})
+}
+
+type totpCodeRequest struct {
+	Code string `json:"code"`
+}
+
+type totpConfirmResponse struct {
+	RecoveryCodes []string `json:"recoveryCodes"`
+}
+
+// TOTPConfirm enables two-factor authentication for the requesting
+// user if the code is valid and returns the recovery codes.
+func TOTPConfirm(totp *auth.TOTP, a auth.Authenticator) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req totpCodeRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "unmarshal error: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		codes, err := totp.Confirm(a.ValidateRequest(r).User.ID, req.Code)
+		if err != nil {
+			if errors.Is(err, auth.ErrInvalidTOTP) ||
+				errors.Is(err, auth.ErrTOTPNotEnrolled) ||
+				errors.Is(err, auth.ErrTOTPEnabled) ||
+				errors.Is(err, auth.ErrTOTPUnsupported) {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", jsonContentType)
+		err = json.NewEncoder(w).Encode(totpConfirmResponse{RecoveryCodes: codes})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	})
+}
+
+// TOTPDisable disables two-factor authentication for the requesting
+// user. Requires a valid code or recovery code.
+func TOTPDisable(totp *auth.TOTP, a auth.Authenticator) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req totpCodeRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "unmarshal error: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		userID := a.ValidateRequest(r).User.ID
+		err := totp.Verify(userID, req.Code)
+		if errors.Is(err, auth.ErrInvalidTOTP) || errors.Is(err, auth.ErrTOTPNotEnabled) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if err := totp.Disable(userID); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	})
+}
+
+// TOTPReset disables two-factor authentication for any user.
+// Used by admins when a user has lost their device.
+func TOTPReset(totp *auth.TOTP) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete {
+			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
+			return
+		}
+
+		id := r.URL.Query().Get("id")
+		if id == "" {
+			http.Error(w, "id missing", http.StatusBadRequest)
+			return
+		}
+
+		if err := totp.Disable(id); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	})
+}
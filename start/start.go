@@ -303,6 +303,13 @@ addons: # Uncomment to enable.
   # Basic Auth.
   #- nvr/addons/auth/basic
   #
+  # Login page with session cookies.
+  #- nvr/addons/auth/session
+  #
+  # Trusted reverse proxy headers, for single sign-on.
+  # Documentation ../addons/auth/proxy/README.md
+  #- nvr/addons/auth/proxy
+  #
   # No authentication.
   #- nvr/addons/auth/none
 
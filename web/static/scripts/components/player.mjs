@@ -3,7 +3,7 @@
 import { fromUTC } from "../libs/time.mjs";
 import { fetchDelete } from "../libs/common.mjs";
 
-function newPlayer(data, isAdmin, token) {
+function newPlayer(data, canDelete, token, canExport = true) {
 	const d = data;
 
 	const elementID = "rec" + d.id;
@@ -72,16 +72,21 @@ function newPlayer(data, isAdmin, token) {
 			</button>
 			<div class="js-popup player-options-popup">
 				${
-					isAdmin
+					canDelete
 						? `
 				<button class="js-delete player-options-btn">
 					<img src="static/icons/feather/trash-2.svg">
 				</button>`
 						: ""
 				}
-				<a download href="${d.videoPath}" class="player-options-btn">
+				${
+					canExport
+						? `
+				<a download href="${d.videoPath}?download=true" class="player-options-btn">
 					<img src="static/icons/feather/download.svg">
-				</a>
+				</a>`
+						: ""
+				}
 				<button class="js-fullscreen player-options-btn">
 					<img src="${iconMaximizePath}">
 				</button>
@@ -175,7 +180,7 @@ function newPlayer(data, isAdmin, token) {
 		});
 
 		// Delete
-		if (isAdmin) {
+		if (canDelete) {
 			const $delete = element.querySelector(".js-delete");
 			$delete.addEventListener("click", (event) => {
 				event.stopPropagation();
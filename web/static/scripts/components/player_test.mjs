@@ -102,7 +102,7 @@ describe("newPlayer", () => {
 							</div>
 						</button>
 						<div class="js-popup player-options-popup">
-							<a download="" href="C"class="player-options-btn">
+							<a download="" href="C?download=true"class="player-options-btn">
 								<img src="static/icons/feather/download.svg">
 							</a>
 							<button class="js-fullscreen player-options-btn">
@@ -165,7 +165,7 @@ describe("newPlayer", () => {
 			<button class="js-delete player-options-btn">
 				<img src="static/icons/feather/trash-2.svg">
 			</button>
-			<a download="" href="C"class="player-options-btn">
+			<a download="" href="C?download=true"class="player-options-btn">
 				<img src="static/icons/feather/download.svg">
 			</a>
 			<button class="js-fullscreen player-options-btn">
@@ -29,12 +29,13 @@ async function fetchPost(url, data, token, msg) {
 	return true;
 }
 
-async function fetchPut(url, data, token, msg) {
+async function fetchPut(url, data, token, msg, headers = {}) {
 	const response = await fetch(url, {
 		body: JSON.stringify(data),
 		headers: {
 			"Content-Type": "application/json",
 			"X-CSRF-TOKEN": token,
+			...headers,
 		},
 		method: "put",
 	});
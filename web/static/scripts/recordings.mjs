@@ -4,7 +4,14 @@ import { fetchGet, newMonitorNameByID, getHashParam } from "./libs/common.mjs";
 import { newPlayer } from "./components/player.mjs";
 import { newOptionsMenu, newOptionsBtn } from "./components/optionsMenu.mjs";
 
-async function newViewer(monitorNameByID, $parent, timeZone, isAdmin, token) {
+async function newViewer(
+	monitorNameByID,
+	$parent,
+	timeZone,
+	canDelete,
+	token,
+	canExport,
+) {
 	let selectedMonitors = [];
 	let maxPlayingVideos = 2;
 
@@ -37,7 +44,7 @@ async function newViewer(monitorNameByID, $parent, timeZone, isAdmin, token) {
 				d.start = Date.parse(idToISOstring(d.id));
 			}
 
-			const player = newPlayer(d, isAdmin, token);
+			const player = newPlayer(d, canDelete, token, canExport);
 			players.push(player);
 
 			current = rec.id;
@@ -159,13 +166,21 @@ async function init() {
 	const timeZone = TZ; // eslint-disable-line no-undef
 	const groups = Groups; // eslint-disable-line no-undef
 	const monitors = Monitors; // eslint-disable-line no-undef
-	const isAdmin = IsAdmin; // eslint-disable-line no-undef
+	const canDelete = CanDelete; // eslint-disable-line no-undef
+	const canExport = CanExport; // eslint-disable-line no-undef
 	const csrfToken = CSRFToken; // eslint-disable-line no-undef
 
 	const monitorNameByID = newMonitorNameByID(monitors);
 
 	const $grid = document.querySelector("#content-grid");
-	const viewer = await newViewer(monitorNameByID, $grid, timeZone, isAdmin, csrfToken);
+	const viewer = await newViewer(
+		monitorNameByID,
+		$grid,
+		timeZone,
+		canDelete,
+		csrfToken,
+		canExport,
+	);
 	if (hashMonitors) {
 		viewer.setMonitors(hashMonitors);
 	}
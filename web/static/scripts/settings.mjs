@@ -2,7 +2,6 @@
 
 import {
 	fetchGet,
-	fetchPost,
 	fetchPut,
 	fetchDelete,
 	sortByName,
@@ -338,7 +337,11 @@ function newMonitor(token, fields) {
 	form.addButton("delete");
 	category.setForm(form);
 
-	const monitorLoad = (navElement, monitors) => {
+	// ETag of the loaded monitor config, prevents
+	// overwriting changes made by someone else.
+	let etag;
+
+	const monitorLoad = async (navElement, monitors) => {
 		form.reset();
 		const id = navElement.attributes.data.value;
 		const $monitorID = fields["id"].element();
@@ -347,12 +350,23 @@ function newMonitor(token, fields) {
 		let monitor = {},
 			title;
 
+		etag = undefined;
 		if (id === "") {
 			monitor["id"] = randomString(5);
 			title = "Add";
 			$monitorIDinput.disabled = false;
 		} else {
-			monitor = monitors[id];
+			const params = new URLSearchParams({ id: id });
+			const response = await fetch("api/monitor/config?" + params, {
+				method: "get",
+			});
+			if (response.status !== 200) {
+				alert(`could not fetch monitor config: ${response.status}`);
+				return;
+			}
+			monitor = await response.json();
+			monitors[id] = monitor;
+			etag = response.headers.get("ETag");
 			title = monitor.name;
 			$monitorIDinput.disabled = true;
 		}
@@ -419,23 +433,18 @@ function newMonitor(token, fields) {
 			monitor[key] = form.fields[key].value();
 		}
 
+		const headers = etag ? { "If-Match": etag } : {};
 		const ok = await fetchPut(
 			"api/monitor/set",
 			monitor,
 			token,
 			"could not save monitor",
+			headers,
 		);
 		if (!ok) {
 			return;
 		}
 
-		fetchPost(
-			"api/monitor/restart?id=" + id,
-			monitor,
-			token,
-			"could not restart monitor",
-		);
-
 		load();
 	};
 
@@ -647,6 +656,7 @@ function newUser(token, fields) {
 
 		let id = navElement.attributes.data.value;
 		let username, isAdmin, title;
+		let access = {};
 
 		if (id === "") {
 			id = randomString(16);
@@ -656,6 +666,7 @@ function newUser(token, fields) {
 		} else {
 			username = users[id]["username"];
 			isAdmin = String(users[id]["isAdmin"]);
+			access = users[id]["access"] || {};
 			title = username;
 		}
 
@@ -663,6 +674,14 @@ function newUser(token, fields) {
 		form.fields.id.value = id;
 		form.fields.username.set(username);
 		form.fields.isAdmin.set(isAdmin);
+		form.fields.restricted.set(String(access.restricted === true));
+		form.fields.monitors.set(JSON.stringify(access.monitors || []));
+		form.fields.groups.set((access.groups || []).join(","));
+		form.fields.allowDelete.set(String(access.delete === true));
+		form.fields.allowExport.set(String(access.export === true));
+		if (users[id]) {
+			form.fields.totp.set({ id: id, enabled: users[id]["totp"] === true });
+		}
 	};
 
 	const renderUserList = (users) => {
@@ -708,6 +727,16 @@ function newUser(token, fields) {
 			id: form.fields.id.value,
 			username: form.fields.username.value(),
 			isAdmin: form.fields.isAdmin.value() === "true",
+			access: {
+				restricted: form.fields.restricted.value() === "true",
+				monitors: JSON.parse(form.fields.monitors.value()),
+				groups: form.fields.groups
+					.value()
+					.split(",")
+					.filter((g) => g !== ""),
+				delete: form.fields.allowDelete.value() === "true",
+				export: form.fields.allowExport.value() === "true",
+			},
 			plainPassword: form.fields.password.value(),
 		};
 
@@ -889,4 +918,59 @@ function newSelectMonitor(id) {
 	};
 }
 
-export { newRenderer, newGeneral, newMonitor, newGroup, newUser, newSelectMonitor };
+// Shows if two-factor authentication is enabled for
+// the user and allows admins to reset it.
+function newTOTPField(token) {
+	const id = "settings-user-totp";
+	let userID, $status, $reset;
+	const render = (enabled) => {
+		$status.textContent = enabled ? "enabled" : "disabled";
+		$reset.disabled = !enabled;
+	};
+	return {
+		html: `
+			<li id="${id}" class="form-field-flex">
+				<label class="form-field-label">Two-factor</label>
+				<span class="js-status"></span>
+				<button class="js-reset form-field-edit-btn color3">Reset</button>
+			</li>`,
+		init($parent) {
+			const element = $parent.querySelector(`#${id}`);
+			$status = element.querySelector(".js-status");
+			$reset = element.querySelector(".js-reset");
+			$reset.addEventListener("click", async () => {
+				if (!confirm("reset two-factor authentication?")) {
+					return;
+				}
+				const params = new URLSearchParams({ id: userID });
+				const ok = await fetchDelete(
+					"api/totp/reset?" + params,
+					token,
+					"could not reset two-factor authentication",
+				);
+				if (ok) {
+					render(false);
+				}
+			});
+		},
+		set(input) {
+			if (!input) {
+				userID = "";
+				render(false);
+				return;
+			}
+			userID = input.id;
+			render(input.enabled);
+		},
+	};
+}
+
+export {
+	newRenderer,
+	newGeneral,
+	newMonitor,
+	newGroup,
+	newUser,
+	newSelectMonitor,
+	newTOTPField,
+};
@@ -18,6 +18,8 @@
 		const Monitors = JSON.parse("{{ .monitors }}");
 		const LogSources = {{ .logSources }};
 		const IsAdmin = "{{ .user.IsAdmin }}" === "true";
+		const CanDelete = "{{ .user.CanDelete }}" === "true";
+		const CanExport = "{{ .user.CanExport }}" === "true";
 		const CSRFToken = "{{ .user.Token }}";
 	</script>
 {{ end }}
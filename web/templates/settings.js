@@ -20,6 +20,7 @@ import {
 	newGroup,
 	newUser,
 	newSelectMonitor,
+	newTOTPField,
 } from "./static/scripts/settings.mjs";
 
 // Globals.
@@ -106,6 +107,17 @@ if (isAdmin) {
 		alwaysRecord: fieldTemplate.toggle("Always record", "false"),
 		videoLength: fieldTemplate.text("Video length (min)", "15", "15"),
 		timestampOffset: fieldTemplate.integer("Timestamp offset (ms)", "500", "500"),
+		multicast: newField(
+			[],
+			{
+				input: "text",
+			},
+			{
+				label: "Multicast group",
+				placeholder: "239.0.0.1:5000 (optional)",
+			},
+		),
+		dvrWindow: fieldTemplate.integer("DVR window (min)", "0", "0"),
 		logLevel: fieldTemplate.select(
 			"Log level",
 			["quiet", "fatal", "error", "warning", "info", "debug"],
@@ -151,6 +163,22 @@ if (isAdmin) {
 			},
 		),
 		isAdmin: fieldTemplate.toggle("Admin"),
+		restricted: fieldTemplate.toggle("Restricted", "false"),
+		monitors: newSelectMonitor("settings-user-monitors"),
+		groups: newField(
+			[inputRules.noSpaces],
+			{
+				input: "text",
+			},
+			{
+				label: "Groups",
+				placeholder: "group IDs, comma separated",
+				initial: "",
+			},
+		),
+		allowDelete: fieldTemplate.toggle("Allow delete", "false"),
+		allowExport: fieldTemplate.toggle("Allow export", "false"),
+		totp: newTOTPField(csrfToken),
 		password: newPasswordField(),
 	};
 	const user = newUser(csrfToken, userFields);